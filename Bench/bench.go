@@ -0,0 +1,396 @@
+// Package bench drives a ChatServer with many virtual clients and measures
+// how long joins take, how long broadcasts take to reach every client, how
+// many messages the server gets through and how many are lost on the way.
+package bench
+
+import (
+	client "Chitty-Chat/Client"
+	proto "Chitty-Chat/GRPC"
+	server "Chitty-Chat/Server"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+const messagePrefix = "bench-"
+
+type Config struct {
+	// Address of the server to load. When empty, a server configured with
+	// Server is started on a loopback port for the run.
+	Address string
+	Server  server.ServerConfig
+
+	Clients int
+	// Rate is the number of messages per second sent by all clients
+	// together, each MessageSize characters long.
+	Rate        float64
+	MessageSize int
+	Duration    time.Duration
+	// JoinConcurrency limits how many clients join at the same time.
+	JoinConcurrency int
+	// DrainTimeout is how long to wait for outstanding deliveries after the
+	// last message was sent; whatever has not arrived by then is dropped.
+	DrainTimeout time.Duration
+
+	DialOptions []grpc.DialOption
+}
+
+func (config Config) withDefaults() Config {
+	if config.Clients == 0 {
+		config.Clients = 10
+	}
+	if config.Rate == 0 {
+		config.Rate = 100
+	}
+	if config.MessageSize == 0 {
+		config.MessageSize = 64
+	}
+	if config.Duration == 0 {
+		config.Duration = 10 * time.Second
+	}
+	if config.JoinConcurrency == 0 {
+		config.JoinConcurrency = 50
+	}
+	if config.DrainTimeout == 0 {
+		config.DrainTimeout = 5 * time.Second
+	}
+
+	return config
+}
+
+// Latencies summarizes a set of measured durations.
+type Latencies struct {
+	Count int
+	Mean  time.Duration
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+func summarize(durations []time.Duration) Latencies {
+	if len(durations) == 0 {
+		return Latencies{}
+	}
+
+	slices.Sort(durations)
+	var total time.Duration
+	for _, duration := range durations {
+		total += duration
+	}
+	percentile := func(fraction float64) time.Duration {
+		return durations[int(fraction*float64(len(durations)-1))]
+	}
+
+	return Latencies{
+		Count: len(durations),
+		Mean:  total / time.Duration(len(durations)),
+		P50:   percentile(0.50),
+		P90:   percentile(0.90),
+		P99:   percentile(0.99),
+		Max:   durations[len(durations)-1],
+	}
+}
+
+func (latencies Latencies) String() string {
+	return fmt.Sprintf("n=%d mean=%v p50=%v p90=%v p99=%v max=%v", latencies.Count,
+		latencies.Mean.Round(time.Microsecond), latencies.P50.Round(time.Microsecond), latencies.P90.Round(time.Microsecond),
+		latencies.P99.Round(time.Microsecond), latencies.Max.Round(time.Microsecond))
+}
+
+type Result struct {
+	Config Config
+
+	Joined      int
+	JoinFailed  int
+	JoinLatency Latencies
+
+	Sent       int
+	SendFailed int
+	// Expected counts one delivery per sent message and joined client,
+	// since every client receives every broadcast including its own.
+	Expected        int
+	Delivered       int
+	Duplicated      int
+	Dropped         int
+	DeliveryLatency Latencies
+	// Elapsed is how long the clients spent sending.
+	Elapsed time.Duration
+}
+
+func (result *Result) SendThroughput() float64 {
+	if result.Elapsed == 0 {
+		return 0
+	}
+
+	return float64(result.Sent) / result.Elapsed.Seconds()
+}
+
+func (result *Result) DeliveryThroughput() float64 {
+	if result.Elapsed == 0 {
+		return 0
+	}
+
+	return float64(result.Delivered) / result.Elapsed.Seconds()
+}
+
+func (result *Result) String() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "Clients:    %d joined, %d failed\n", result.Joined, result.JoinFailed)
+	fmt.Fprintf(&builder, "Join:       %v\n", result.JoinLatency)
+	fmt.Fprintf(&builder, "Sent:       %d in %v (%.1f msg/s), %d failed\n", result.Sent, result.Elapsed.Round(time.Millisecond), result.SendThroughput(), result.SendFailed)
+	fmt.Fprintf(&builder, "Delivered:  %d of %d (%.1f msg/s), %d dropped, %d duplicated\n", result.Delivered, result.Expected, result.DeliveryThroughput(), result.Dropped, result.Duplicated)
+	fmt.Fprintf(&builder, "Delivery:   %v\n", result.DeliveryLatency)
+
+	return builder.String()
+}
+
+// Run joins the clients, has them send at the configured rate for the
+// configured duration, waits for the deliveries and disconnects everyone.
+func Run(config Config) (*Result, error) {
+	config = config.withDefaults()
+	if config.Rate < 0 || config.Clients < 0 {
+		return nil, fmt.Errorf("rate and clients must not be negative")
+	}
+
+	address := config.Address
+	dialOptions := config.DialOptions
+	if address == "" {
+		listener, listenErr := net.Listen("tcp", "127.0.0.1:0")
+		if listenErr != nil {
+			return nil, fmt.Errorf("listening on a loopback port: %w", listenErr)
+		}
+
+		if config.Server.ShutdownTimeout == 0 {
+			config.Server.ShutdownTimeout = time.Second
+		}
+		chatServer, serverErr := server.NewChatServer(config.Server)
+		if serverErr != nil {
+			listener.Close()
+			return nil, fmt.Errorf("creating server: %w", serverErr)
+		}
+		go chatServer.Serve(listener)
+		defer chatServer.Shutdown()
+
+		address = listener.Addr().String()
+	}
+
+	run := &run{config: config, sentAt: make(map[string]time.Time), seen: make(map[string]bool)}
+	clients := run.join(address, dialOptions)
+	defer func() {
+		for _, chatClient := range clients {
+			chatClient.Close()
+		}
+	}()
+
+	run.send(clients)
+	run.drain()
+
+	return run.result(), nil
+}
+
+type run struct {
+	config Config
+
+	mutex            sync.Mutex
+	joined           int
+	joinFailed       int
+	joinLatencies    []time.Duration
+	sent             int
+	sendFailed       int
+	sentAt           map[string]time.Time
+	seen             map[string]bool
+	delivered        int
+	duplicated       int
+	deliveryLatency  []time.Duration
+	elapsed          time.Duration
+	deliveredChanged chan struct{}
+}
+
+func (run *run) join(address string, dialOptions []grpc.DialOption) []*client.ChatClient {
+	clients := make([]*client.ChatClient, run.config.Clients)
+	slots := make(chan struct{}, run.config.JoinConcurrency)
+	var joining sync.WaitGroup
+
+	for index := range clients {
+		joining.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer joining.Done()
+			defer func() { <-slots }()
+
+			username := messagePrefix + strconv.Itoa(index)
+			chatClient, clientErr := client.NewChatClient(client.ClientConfig{
+				Address:     address,
+				Username:    username,
+				Output:      io.Discard,
+				DialOptions: dialOptions,
+				OnMessage: func(message *proto.Chat, _ int64) {
+					run.receive(username, message)
+				},
+			})
+			if clientErr != nil {
+				run.recordJoin(0, clientErr)
+				return
+			}
+
+			started := time.Now()
+			joinErr := chatClient.Join()
+			run.recordJoin(time.Since(started), joinErr)
+			if joinErr != nil {
+				chatClient.Close()
+				return
+			}
+			clients[index] = chatClient
+		}()
+	}
+	joining.Wait()
+
+	return slices.DeleteFunc(clients, func(chatClient *client.ChatClient) bool { return chatClient == nil })
+}
+
+func (run *run) recordJoin(latency time.Duration, joinErr error) {
+	run.mutex.Lock()
+	defer run.mutex.Unlock()
+
+	if joinErr != nil {
+		run.joinFailed++
+		return
+	}
+	run.joined++
+	run.joinLatencies = append(run.joinLatencies, latency)
+}
+
+// send has every client post at its share of the rate until the duration
+// is over. Clients start at evenly spread offsets so the load is smooth.
+func (run *run) send(clients []*client.ChatClient) {
+	if len(clients) == 0 || run.config.Rate == 0 {
+		return
+	}
+
+	interval := time.Duration(float64(len(clients)) / run.config.Rate * float64(time.Second))
+	started := time.Now()
+	deadline := started.Add(run.config.Duration)
+	var sending sync.WaitGroup
+
+	for index, chatClient := range clients {
+		sending.Add(1)
+		go func() {
+			defer sending.Done()
+
+			time.Sleep(interval * time.Duration(index) / time.Duration(len(clients)))
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for sequence := 0; time.Now().Before(deadline); sequence++ {
+				run.post(chatClient, index, sequence)
+				<-ticker.C
+			}
+		}()
+	}
+	sending.Wait()
+
+	run.mutex.Lock()
+	run.elapsed = time.Since(started)
+	run.mutex.Unlock()
+}
+
+func (run *run) post(chatClient *client.ChatClient, index int, sequence int) {
+	key := fmt.Sprintf("%s%d-%d", messagePrefix, index, sequence)
+	text := key
+	if padding := run.config.MessageSize - len(key) - 1; padding > 0 {
+		text += " " + strings.Repeat("x", padding)
+	}
+
+	run.mutex.Lock()
+	run.sentAt[key] = time.Now()
+	run.mutex.Unlock()
+
+	sendErr := chatClient.Send(text)
+
+	run.mutex.Lock()
+	defer run.mutex.Unlock()
+	if sendErr != nil {
+		delete(run.sentAt, key)
+		run.sendFailed++
+		return
+	}
+	run.sent++
+}
+
+func (run *run) receive(username string, message *proto.Chat) {
+	if message.Kind != proto.ChatKind_MESSAGE || !strings.HasPrefix(message.Message, messagePrefix) {
+		return
+	}
+	received := time.Now()
+	key, _, _ := strings.Cut(message.Message, " ")
+
+	run.mutex.Lock()
+	defer run.mutex.Unlock()
+
+	sentAt, isKnown := run.sentAt[key]
+	if !isKnown {
+		return
+	}
+	if run.seen[username+"/"+key] {
+		run.duplicated++
+		return
+	}
+	run.seen[username+"/"+key] = true
+	run.delivered++
+	run.deliveryLatency = append(run.deliveryLatency, received.Sub(sentAt))
+	if run.deliveredChanged != nil {
+		close(run.deliveredChanged)
+		run.deliveredChanged = nil
+	}
+}
+
+// drain waits until every sent message has reached every client or the
+// drain timeout has passed.
+func (run *run) drain() {
+	timeout := time.After(run.config.DrainTimeout)
+	for {
+		run.mutex.Lock()
+		if run.delivered >= run.sent*run.joined {
+			run.mutex.Unlock()
+			return
+		}
+		changed := make(chan struct{})
+		run.deliveredChanged = changed
+		run.mutex.Unlock()
+
+		select {
+		case <-changed:
+		case <-timeout:
+			return
+		}
+	}
+}
+
+func (run *run) result() *Result {
+	run.mutex.Lock()
+	defer run.mutex.Unlock()
+
+	expected := run.sent * run.joined
+	return &Result{
+		Config:          run.config,
+		Joined:          run.joined,
+		JoinFailed:      run.joinFailed,
+		JoinLatency:     summarize(run.joinLatencies),
+		Sent:            run.sent,
+		SendFailed:      run.sendFailed,
+		Expected:        expected,
+		Delivered:       run.delivered,
+		Duplicated:      run.duplicated,
+		Dropped:         max(expected-run.delivered, 0),
+		DeliveryLatency: summarize(run.deliveryLatency),
+		Elapsed:         run.elapsed,
+	}
+}
@@ -0,0 +1,62 @@
+package bench
+
+import (
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func TestRunDeliversEveryMessage(t *testing.T) {
+	result, runErr := Run(Config{Clients: 5, Rate: 100, MessageSize: 32, Duration: 300 * time.Millisecond})
+	if runErr != nil {
+		t.Fatalf("Run failed | %v", runErr)
+	}
+
+	if result.Joined != 5 || result.JoinLatency.Count != 5 {
+		t.Fatalf("%d clients joined with %d join latencies, want 5", result.Joined, result.JoinLatency.Count)
+	}
+	if result.Sent == 0 || result.SendFailed != 0 {
+		t.Fatalf("Sent %d message(s) with %d failure(s)", result.Sent, result.SendFailed)
+	}
+	if result.Expected != result.Sent*5 || result.Delivered != result.Expected || result.Dropped != 0 {
+		t.Fatalf("Delivered %d of %d with %d dropped, want all of %d", result.Delivered, result.Expected, result.Dropped, result.Sent*5)
+	}
+	if result.DeliveryLatency.Count != result.Delivered || result.DeliveryLatency.Max < result.DeliveryLatency.P50 {
+		t.Fatalf("Delivery latencies %v do not match %d deliveries", result.DeliveryLatency, result.Delivered)
+	}
+}
+
+func TestRunCountsRejectedMessages(t *testing.T) {
+	config := Config{Clients: 2, Rate: 50, MessageSize: 200, Duration: 100 * time.Millisecond, DrainTimeout: 100 * time.Millisecond}
+	config.Server.MaxMessageLength = 128
+	result, runErr := Run(config)
+	if runErr != nil {
+		t.Fatalf("Run failed | %v", runErr)
+	}
+
+	if result.Sent != 0 || result.SendFailed == 0 || result.Expected != 0 {
+		t.Fatalf("Sent %d and failed %d message(s) longer than the limit, want only failures", result.Sent, result.SendFailed)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	var durations []time.Duration
+	for i := 100; i >= 1; i-- {
+		durations = append(durations, time.Duration(i)*time.Millisecond)
+	}
+
+	latencies := summarize(durations)
+	if latencies.Count != 100 || latencies.P50 != 50*time.Millisecond || latencies.P99 != 99*time.Millisecond || latencies.Max != 100*time.Millisecond {
+		t.Fatalf("Got %v", latencies)
+	}
+	if latencies.Mean != 50500*time.Microsecond {
+		t.Fatalf("Mean is %v, want 50.5ms", latencies.Mean)
+	}
+}
@@ -0,0 +1,214 @@
+// Package chaos injects faults into gRPC calls: latency, failed RPCs, lost
+// and duplicated messages and severed streams. The same Injector provides
+// interceptors for the server and the client, and its configuration can be
+// changed while it runs, e.g. through the ChaosService control RPC.
+package chaos
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const chatServicePrefix = "/ChatService/"
+
+type Config struct {
+	// Latency is added to every call and every streamed message, plus a
+	// random extra delay of up to Jitter.
+	Latency time.Duration
+	Jitter  time.Duration
+	// FailRate is the probability that a call fails with FailCode
+	// (Unavailable by default) before it is handled.
+	FailRate float64
+	FailCode codes.Code
+	// DropRate is the probability that a streamed message or the response
+	// of a handled call is lost, and DuplicateRate the probability that a
+	// streamed message or a call is delivered twice.
+	DropRate      float64
+	DuplicateRate float64
+	// Streams are severed after SeverAfter messages, or with probability
+	// SeverRate at every message.
+	SeverAfter int
+	SeverRate  float64
+	// Methods limits the faults to these ChatService methods, e.g.
+	// "BroadcastMessage". All of them are affected when it is empty.
+	Methods []string
+
+	Seed uint64
+}
+
+func (config Config) Enabled() bool {
+	return config.Latency > 0 || config.Jitter > 0 || config.FailRate > 0 || config.DropRate > 0 ||
+		config.DuplicateRate > 0 || config.SeverAfter > 0 || config.SeverRate > 0
+}
+
+func (config Config) String() string {
+	if !config.Enabled() {
+		return "no faults"
+	}
+
+	var parts []string
+	if config.Latency > 0 || config.Jitter > 0 {
+		parts = append(parts, fmt.Sprintf("latency %v+%v", config.Latency, config.Jitter))
+	}
+	if config.FailRate > 0 {
+		parts = append(parts, fmt.Sprintf("fail %.0f%% with %v", config.FailRate*100, config.failCode()))
+	}
+	if config.DropRate > 0 {
+		parts = append(parts, fmt.Sprintf("drop %.0f%%", config.DropRate*100))
+	}
+	if config.DuplicateRate > 0 {
+		parts = append(parts, fmt.Sprintf("duplicate %.0f%%", config.DuplicateRate*100))
+	}
+	if config.SeverAfter > 0 {
+		parts = append(parts, fmt.Sprintf("sever after %d messages", config.SeverAfter))
+	}
+	if config.SeverRate > 0 {
+		parts = append(parts, fmt.Sprintf("sever %.0f%%", config.SeverRate*100))
+	}
+	if len(config.Methods) > 0 {
+		parts = append(parts, "on "+strings.Join(config.Methods, ","))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func (config Config) failCode() codes.Code {
+	if config.FailCode == codes.OK {
+		return codes.Unavailable
+	}
+
+	return config.FailCode
+}
+
+func (config Config) validate() error {
+	for _, rate := range []float64{config.FailRate, config.DropRate, config.DuplicateRate, config.SeverRate} {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("rate %v is not between 0 and 1", rate)
+		}
+	}
+	if config.Latency < 0 || config.Jitter < 0 || config.SeverAfter < 0 {
+		return fmt.Errorf("latency, jitter and sever-after must not be negative")
+	}
+
+	return nil
+}
+
+type Injector struct {
+	mutex  sync.Mutex
+	config Config
+	random *rand.Rand
+}
+
+// NewInjector creates an injector for config. A zero seed picks a random
+// one.
+func NewInjector(config Config) (*Injector, error) {
+	validateErr := config.validate()
+	if validateErr != nil {
+		return nil, validateErr
+	}
+
+	seed := config.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+
+	return &Injector{config: config, random: rand.New(rand.NewPCG(seed, seed))}, nil
+}
+
+func (injector *Injector) Config() Config {
+	injector.mutex.Lock()
+	defer injector.mutex.Unlock()
+
+	return injector.config
+}
+
+func (injector *Injector) SetConfig(config Config) error {
+	validateErr := config.validate()
+	if validateErr != nil {
+		return validateErr
+	}
+
+	injector.mutex.Lock()
+	defer injector.mutex.Unlock()
+
+	injector.config = config
+	log.Printf("Chaos | Injecting %v", config)
+
+	return nil
+}
+
+// active returns the configuration for a call of fullMethod and whether
+// any faults apply to it. The ChaosService itself is never affected.
+func (injector *Injector) active(fullMethod string) (Config, bool) {
+	injector.mutex.Lock()
+	defer injector.mutex.Unlock()
+
+	config := injector.config
+	if !strings.HasPrefix(fullMethod, chatServicePrefix) || !config.Enabled() {
+		return config, false
+	}
+	if len(config.Methods) > 0 && !slices.Contains(config.Methods, path.Base(fullMethod)) {
+		return config, false
+	}
+
+	return config, true
+}
+
+func (injector *Injector) chance(probability float64) bool {
+	if probability <= 0 {
+		return false
+	}
+
+	injector.mutex.Lock()
+	defer injector.mutex.Unlock()
+
+	return injector.random.Float64() < probability
+}
+
+func (injector *Injector) delay(config Config) {
+	delay := config.Latency
+	if config.Jitter > 0 {
+		injector.mutex.Lock()
+		delay += time.Duration(injector.random.Int64N(int64(config.Jitter) + 1))
+		injector.mutex.Unlock()
+	}
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+func (injector *Injector) fail(config Config, fullMethod string) error {
+	if !injector.chance(config.FailRate) {
+		return nil
+	}
+
+	log.Printf("Chaos | Failing %s with %v", fullMethod, config.failCode())
+	return status.Errorf(config.failCode(), "%s failed by fault injection", path.Base(fullMethod))
+}
+
+// sever reports whether a stream that has carried count messages is cut.
+func (injector *Injector) sever(config Config, count int) bool {
+	if config.SeverAfter > 0 && count >= config.SeverAfter {
+		return true
+	}
+
+	return injector.chance(config.SeverRate)
+}
+
+func droppedError(fullMethod string) error {
+	return status.Errorf(codes.Unavailable, "response to %s was dropped by fault injection", path.Base(fullMethod))
+}
+
+func severedError(fullMethod string) error {
+	return status.Errorf(codes.Unavailable, "%s stream was severed by fault injection", path.Base(fullMethod))
+}
@@ -0,0 +1,195 @@
+package chaos_test
+
+import (
+	chaos "Chitty-Chat/Chaos"
+	client "Chitty-Chat/Client"
+	proto "Chitty-Chat/GRPC"
+	harness "Chitty-Chat/Harness"
+	server "Chitty-Chat/Server"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func newInjector(t *testing.T, config chaos.Config) *chaos.Injector {
+	t.Helper()
+
+	config.Seed = 1
+	injector, injectorErr := chaos.NewInjector(config)
+	if injectorErr != nil {
+		t.Fatalf("Failed to create injector | %v", injectorErr)
+	}
+
+	return injector
+}
+
+func TestInjectedFailureUsesFaultCode(t *testing.T) {
+	injector := newInjector(t, chaos.Config{FailRate: 1, FailCode: codes.PermissionDenied, Methods: []string{"BroadcastMessage"}})
+	chat := harness.Start(t, server.ServerConfig{Chaos: injector})
+	alice := chat.Join("alice")
+	alice.WaitForJoin("alice")
+
+	sendErr := alice.Send("hello")
+	if status.Code(sendErr) != codes.PermissionDenied {
+		t.Fatalf("Send returned %v, want PermissionDenied", sendErr)
+	}
+}
+
+func TestLatencyDelaysCalls(t *testing.T) {
+	injector := newInjector(t, chaos.Config{Latency: 100 * time.Millisecond, Methods: []string{"BroadcastMessage"}})
+	chat := harness.Start(t, server.ServerConfig{Chaos: injector})
+	alice := chat.Join("alice")
+	alice.WaitForJoin("alice")
+
+	started := time.Now()
+	sendErr := alice.Send("hello")
+	if sendErr != nil {
+		t.Fatalf("Send failed | %v", sendErr)
+	}
+	if elapsed := time.Since(started); elapsed < 100*time.Millisecond {
+		t.Fatalf("Send took %v, want at least 100ms", elapsed)
+	}
+}
+
+func TestDuplicatedBroadcastIsDeliveredTwice(t *testing.T) {
+	injector := newInjector(t, chaos.Config{DuplicateRate: 1, Methods: []string{"BroadcastMessage"}})
+	chat := harness.Start(t, server.ServerConfig{Chaos: injector})
+	clients := chat.JoinAll("alice", "bob")
+
+	sendErr := clients[0].Send("hello")
+	if sendErr != nil {
+		t.Fatalf("Send failed | %v", sendErr)
+	}
+
+	messages := clients[1].WaitForCount(2)
+	if messages[0].Message.Id == messages[1].Message.Id {
+		t.Fatalf("Duplicated broadcast reused message id %d", messages[0].Message.Id)
+	}
+	if messages[1].Message.Lamport <= messages[0].Message.Lamport {
+		t.Fatalf("Duplicated broadcast has timestamp %d after %d", messages[1].Message.Lamport, messages[0].Message.Lamport)
+	}
+}
+
+func TestClientReceivesDuplicatedMessages(t *testing.T) {
+	chat := harness.Start(t, server.ServerConfig{})
+	injector := newInjector(t, chaos.Config{DuplicateRate: 1, Methods: []string{"JoinChat"}})
+	alice := chat.NewClient("alice", client.ClientConfig{DialOptions: []grpc.DialOption{
+		grpc.WithChainStreamInterceptor(injector.StreamClientInterceptor),
+	}})
+	joinErr := alice.Join()
+	if joinErr != nil {
+		t.Fatalf("alice could not join | %v", joinErr)
+	}
+	alice.WaitForJoin("alice")
+
+	sendErr := alice.Send("hello")
+	if sendErr != nil {
+		t.Fatalf("Send failed | %v", sendErr)
+	}
+
+	messages := alice.WaitForCount(2)
+	if messages[0].Message.Id != messages[1].Message.Id {
+		t.Fatalf("Got messages %d and %d, want the same message twice", messages[0].Message.Id, messages[1].Message.Id)
+	}
+	if messages[1].Timestamp <= messages[0].Timestamp {
+		t.Fatalf("Clock went from %d to %d on the duplicate", messages[0].Timestamp, messages[1].Timestamp)
+	}
+}
+
+func TestSeveredStreamIsRejoined(t *testing.T) {
+	injector := newInjector(t, chaos.Config{SeverAfter: 1, Methods: []string{"JoinChat"}})
+	chat := harness.Start(t, server.ServerConfig{Chaos: injector})
+
+	var mutex sync.Mutex
+	var notices []string
+	_, connectErr := chat.Server.Connect(context.Background(), &proto.UserRequest{Username: "observer"}, func(message *proto.Chat) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		notices = append(notices, message.Message)
+	})
+	if connectErr != nil {
+		t.Fatalf("Observer could not connect | %v", connectErr)
+	}
+
+	alice := chat.NewClient("alice", client.ClientConfig{Reconnect: true})
+	joinErr := alice.Join()
+	if joinErr != nil {
+		t.Fatalf("alice could not join | %v", joinErr)
+	}
+	alice.WaitForJoin("alice")
+
+	sendErr := alice.Send("hello")
+	if sendErr != nil {
+		t.Fatalf("Send failed | %v", sendErr)
+	}
+
+	// A rejoined stream is sent the messages alice missed, so it may be
+	// severed again in turn. The first notices must still be join, leave
+	// and join again.
+	var aliceNotices []string
+	deadline := time.Now().Add(harness.WaitTimeout)
+	for len(aliceNotices) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		mutex.Lock()
+		aliceNotices = nil
+		for _, notice := range notices {
+			if strings.HasPrefix(notice, "User alice join request") {
+				aliceNotices = append(aliceNotices, "join")
+			}
+			if strings.HasPrefix(notice, "User alice leave request") {
+				aliceNotices = append(aliceNotices, "leave")
+			}
+		}
+		mutex.Unlock()
+	}
+	if len(aliceNotices) < 3 || strings.Join(aliceNotices[:3], ",") != "join,leave,join" {
+		t.Fatalf("Observer saw alice %v, want join, leave and join again", aliceNotices)
+	}
+}
+
+func TestFaultsCanBeChangedAtRuntime(t *testing.T) {
+	chat := harness.Start(t, server.ServerConfig{EnableChaosControl: true})
+	alice := chat.Join("alice")
+	alice.WaitForJoin("alice")
+
+	dialOptions := append(chat.DialOptions(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	connection, dialErr := grpc.NewClient("passthrough:///bufconn", dialOptions...)
+	if dialErr != nil {
+		t.Fatalf("Failed to dial | %v", dialErr)
+	}
+	defer connection.Close()
+	chaosClient := proto.NewChaosServiceClient(connection)
+
+	faults, getErr := chaosClient.GetFaults(context.Background(), &proto.Empty{})
+	if getErr != nil {
+		t.Fatalf("GetFaults failed | %v", getErr)
+	}
+	if faults.FailRate != 0 || faults.LatencyMs != 0 {
+		t.Fatalf("Server starts with faults %v", faults)
+	}
+
+	faults, setErr := chaosClient.SetFaults(context.Background(), &proto.FaultConfig{FailRate: 1, FailCode: "PERMISSION_DENIED", Methods: []string{"BroadcastMessage"}})
+	if setErr != nil {
+		t.Fatalf("SetFaults failed | %v", setErr)
+	}
+	if faults.FailCode != "PERMISSION_DENIED" {
+		t.Fatalf("SetFaults returned fail code %q", faults.FailCode)
+	}
+
+	sendErr := alice.Send("hello")
+	if status.Code(sendErr) != codes.PermissionDenied {
+		t.Fatalf("Send returned %v, want PermissionDenied", sendErr)
+	}
+
+	_, invalidErr := chaosClient.SetFaults(context.Background(), &proto.FaultConfig{DropRate: 2})
+	if status.Code(invalidErr) != codes.InvalidArgument {
+		t.Fatalf("SetFaults with drop rate 2 returned %v, want InvalidArgument", invalidErr)
+	}
+}
@@ -0,0 +1,140 @@
+package chaos
+
+import (
+	proto "Chitty-Chat/GRPC"
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ControlServer implements the ChaosService for an injector.
+type ControlServer struct {
+	proto.UnimplementedChaosServiceServer
+	injector *Injector
+}
+
+func NewControlServer(injector *Injector) *ControlServer {
+	return &ControlServer{injector: injector}
+}
+
+func (control *ControlServer) GetFaults(ctx context.Context, empty *proto.Empty) (*proto.FaultConfig, error) {
+	return ToProto(control.injector.Config()), nil
+}
+
+func (control *ControlServer) SetFaults(ctx context.Context, faults *proto.FaultConfig) (*proto.FaultConfig, error) {
+	config, convertErr := FromProto(faults)
+	if convertErr != nil {
+		return nil, status.Error(codes.InvalidArgument, convertErr.Error())
+	}
+
+	setErr := control.injector.SetConfig(config)
+	if setErr != nil {
+		return nil, status.Error(codes.InvalidArgument, setErr.Error())
+	}
+
+	return ToProto(control.injector.Config()), nil
+}
+
+func ToProto(config Config) *proto.FaultConfig {
+	faults := &proto.FaultConfig{
+		LatencyMs:     config.Latency.Milliseconds(),
+		JitterMs:      config.Jitter.Milliseconds(),
+		FailRate:      config.FailRate,
+		DropRate:      config.DropRate,
+		DuplicateRate: config.DuplicateRate,
+		SeverAfter:    int32(config.SeverAfter),
+		SeverRate:     config.SeverRate,
+		Methods:       config.Methods,
+	}
+	if config.FailCode != codes.OK {
+		faults.FailCode = codeName(config.FailCode)
+	}
+
+	return faults
+}
+
+func FromProto(faults *proto.FaultConfig) (Config, error) {
+	failCode, codeErr := parseCode(faults.FailCode)
+	if codeErr != nil {
+		return Config{}, codeErr
+	}
+
+	return Config{
+		Latency:       time.Duration(faults.LatencyMs) * time.Millisecond,
+		Jitter:        time.Duration(faults.JitterMs) * time.Millisecond,
+		FailRate:      faults.FailRate,
+		FailCode:      failCode,
+		DropRate:      faults.DropRate,
+		DuplicateRate: faults.DuplicateRate,
+		SeverAfter:    int(faults.SeverAfter),
+		SeverRate:     faults.SeverRate,
+		Methods:       faults.Methods,
+	}, nil
+}
+
+// codeName spells code the way the gRPC docs do, e.g. "PERMISSION_DENIED".
+func codeName(code codes.Code) string {
+	var name strings.Builder
+	for i, letter := range code.String() {
+		if i > 0 && unicode.IsUpper(letter) && unicode.IsLower(rune(code.String()[i-1])) {
+			name.WriteByte('_')
+		}
+		name.WriteRune(unicode.ToUpper(letter))
+	}
+
+	return name.String()
+}
+
+// parseCode accepts status code names like "UNAVAILABLE" or
+// "PermissionDenied" as well as their numbers.
+func parseCode(name string) (codes.Code, error) {
+	if name == "" {
+		return codes.OK, nil
+	}
+
+	normalized := strings.ToUpper(strings.ReplaceAll(name, "_", ""))
+	for code := codes.OK; code <= codes.Unauthenticated; code++ {
+		if strings.ToUpper(code.String()) == normalized {
+			return code, nil
+		}
+	}
+
+	number, numberErr := strconv.Atoi(name)
+	if numberErr == nil && number >= 0 && number <= int(codes.Unauthenticated) {
+		return codes.Code(number), nil
+	}
+
+	return codes.OK, fmt.Errorf("unknown status code %q", name)
+}
+
+// RegisterFlags defines the -chaos-* flags on flagSet. The returned
+// configuration is filled in when the flags are parsed.
+func RegisterFlags(flagSet *flag.FlagSet) *Config {
+	config := &Config{}
+	flagSet.DurationVar(&config.Latency, "chaos-latency", 0, "latency added to every call and streamed message")
+	flagSet.DurationVar(&config.Jitter, "chaos-jitter", 0, "random extra latency of up to this much")
+	flagSet.Float64Var(&config.FailRate, "chaos-fail-rate", 0, "probability that a call fails before it is handled")
+	flagSet.Func("chaos-fail-code", "status code of injected failures (default UNAVAILABLE)", func(name string) error {
+		code, codeErr := parseCode(name)
+		config.FailCode = code
+		return codeErr
+	})
+	flagSet.Float64Var(&config.DropRate, "chaos-drop-rate", 0, "probability that a streamed message or a response is lost")
+	flagSet.Float64Var(&config.DuplicateRate, "chaos-duplicate-rate", 0, "probability that a streamed message or a call is delivered twice")
+	flagSet.IntVar(&config.SeverAfter, "chaos-sever-after", 0, "sever streams after this many messages (0 never)")
+	flagSet.Float64Var(&config.SeverRate, "chaos-sever-rate", 0, "probability that a stream is severed at each message")
+	flagSet.Func("chaos-methods", "comma-separated ChatService methods to inject faults into (default all)", func(methods string) error {
+		config.Methods = strings.Split(methods, ",")
+		return nil
+	})
+	flagSet.Uint64Var(&config.Seed, "chaos-seed", 0, "seed for the fault decisions (random when 0)")
+
+	return config
+}
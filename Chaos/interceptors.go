@@ -0,0 +1,227 @@
+package chaos
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// UnaryServerInterceptor delays calls, fails them before they are handled,
+// handles them twice or loses their response.
+func (injector *Injector) UnaryServerInterceptor(ctx context.Context, request any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	config, isActive := injector.active(info.FullMethod)
+	if !isActive {
+		return handler(ctx, request)
+	}
+
+	injector.delay(config)
+	failErr := injector.fail(config, info.FullMethod)
+	if failErr != nil {
+		return nil, failErr
+	}
+
+	// A duplicate arrives as its own copy of the request, as it would when
+	// retransmitted, since handlers may change the request they are given.
+	duplicate := request
+	if requestMessage, isMessage := request.(proto.Message); isMessage {
+		duplicate = proto.Clone(requestMessage)
+	}
+
+	response, handlerErr := handler(ctx, request)
+	if injector.chance(config.DuplicateRate) {
+		log.Printf("Chaos | Handling %s twice", info.FullMethod)
+		handler(ctx, duplicate)
+	}
+	if injector.chance(config.DropRate) {
+		log.Printf("Chaos | Dropping the response to %s", info.FullMethod)
+		return nil, droppedError(info.FullMethod)
+	}
+
+	return response, handlerErr
+}
+
+// StreamServerInterceptor delays, drops and duplicates the messages the
+// server streams, and severs streams by cancelling their context.
+func (injector *Injector) StreamServerInterceptor(server any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	config, isActive := injector.active(info.FullMethod)
+	if isActive {
+		injector.delay(config)
+		failErr := injector.fail(config, info.FullMethod)
+		if failErr != nil {
+			return failErr
+		}
+	}
+
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+
+	faultyStream := &faultyServerStream{ServerStream: stream, ctx: ctx, cancel: cancel, injector: injector, method: info.FullMethod}
+	handlerErr := handler(server, faultyStream)
+	if faultyStream.isSevered() {
+		return severedError(info.FullMethod)
+	}
+
+	return handlerErr
+}
+
+type faultyServerStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	cancel   context.CancelFunc
+	injector *Injector
+	method   string
+
+	mutex   sync.Mutex
+	sent    int
+	severed bool
+}
+
+func (stream *faultyServerStream) Context() context.Context {
+	return stream.ctx
+}
+
+func (stream *faultyServerStream) isSevered() bool {
+	stream.mutex.Lock()
+	defer stream.mutex.Unlock()
+
+	return stream.severed
+}
+
+func (stream *faultyServerStream) SendMsg(message any) error {
+	config, isActive := stream.injector.active(stream.method)
+	if !isActive {
+		return stream.ServerStream.SendMsg(message)
+	}
+
+	stream.injector.delay(config)
+
+	stream.mutex.Lock()
+	sent := stream.sent
+	stream.sent++
+	stream.mutex.Unlock()
+
+	if stream.injector.sever(config, sent) {
+		log.Printf("Chaos | Severing %s stream after %d message(s)", stream.method, sent)
+		stream.mutex.Lock()
+		stream.severed = true
+		stream.mutex.Unlock()
+		stream.cancel()
+		return severedError(stream.method)
+	}
+	if stream.injector.chance(config.DropRate) {
+		log.Printf("Chaos | Dropping a message on %s", stream.method)
+		return nil
+	}
+
+	sendErr := stream.ServerStream.SendMsg(message)
+	if sendErr == nil && stream.injector.chance(config.DuplicateRate) {
+		log.Printf("Chaos | Sending a message on %s twice", stream.method)
+		sendErr = stream.ServerStream.SendMsg(message)
+	}
+
+	return sendErr
+}
+
+// UnaryClientInterceptor delays calls, fails them before they are sent,
+// sends them twice or loses their response.
+func (injector *Injector) UnaryClientInterceptor(ctx context.Context, method string, request any, reply any, connection *grpc.ClientConn, invoker grpc.UnaryInvoker, options ...grpc.CallOption) error {
+	config, isActive := injector.active(method)
+	if !isActive {
+		return invoker(ctx, method, request, reply, connection, options...)
+	}
+
+	injector.delay(config)
+	failErr := injector.fail(config, method)
+	if failErr != nil {
+		return failErr
+	}
+
+	invokeErr := invoker(ctx, method, request, reply, connection, options...)
+	if injector.chance(config.DuplicateRate) {
+		log.Printf("Chaos | Sending %s twice", method)
+		invoker(ctx, method, request, reply, connection, options...)
+	}
+	if injector.chance(config.DropRate) {
+		log.Printf("Chaos | Dropping the response to %s", method)
+		return droppedError(method)
+	}
+
+	return invokeErr
+}
+
+// StreamClientInterceptor delays, drops and duplicates the messages the
+// client receives, and severs streams by cancelling them.
+func (injector *Injector) StreamClientInterceptor(ctx context.Context, description *grpc.StreamDesc, connection *grpc.ClientConn, method string, streamer grpc.Streamer, options ...grpc.CallOption) (grpc.ClientStream, error) {
+	config, isActive := injector.active(method)
+	if isActive {
+		injector.delay(config)
+		failErr := injector.fail(config, method)
+		if failErr != nil {
+			return nil, failErr
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	stream, streamErr := streamer(ctx, description, connection, method, options...)
+	if streamErr != nil {
+		cancel()
+		return nil, streamErr
+	}
+
+	return &faultyClientStream{ClientStream: stream, cancel: cancel, injector: injector, method: method}, nil
+}
+
+type faultyClientStream struct {
+	grpc.ClientStream
+	cancel   context.CancelFunc
+	injector *Injector
+	method   string
+
+	received  int
+	duplicate proto.Message
+}
+
+func (stream *faultyClientStream) RecvMsg(message any) error {
+	if stream.duplicate != nil {
+		target := message.(proto.Message)
+		proto.Reset(target)
+		proto.Merge(target, stream.duplicate)
+		stream.duplicate = nil
+		return nil
+	}
+
+	for {
+		recvErr := stream.ClientStream.RecvMsg(message)
+		if recvErr != nil {
+			return recvErr
+		}
+
+		config, isActive := stream.injector.active(stream.method)
+		if !isActive {
+			return nil
+		}
+
+		stream.injector.delay(config)
+		received := stream.received
+		stream.received++
+
+		if stream.injector.sever(config, received) {
+			log.Printf("Chaos | Severing %s stream after %d message(s)", stream.method, received)
+			stream.cancel()
+			return severedError(stream.method)
+		}
+		if stream.injector.chance(config.DropRate) {
+			log.Printf("Chaos | Dropping a message on %s", stream.method)
+			continue
+		}
+		if stream.injector.chance(config.DuplicateRate) {
+			log.Printf("Chaos | Receiving a message on %s twice", stream.method)
+			stream.duplicate = proto.Clone(message.(proto.Message))
+		}
+
+		return nil
+	}
+}
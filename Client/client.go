@@ -1,6 +1,7 @@
-package main
+package client
 
 import (
+	clock "Chitty-Chat/Clock"
 	proto "Chitty-Chat/GRPC"
 	"bufio"
 	"context"
@@ -8,150 +9,511 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
 )
 
-const port = 5050
+const seenHistoryLength = 10
+
+type ClientConfig struct {
+	Address  string
+	Username string
+	// FailoverAddresses are tried in order, after Address, whenever the
+	// server the client is connected to cannot be reached.
+	FailoverAddresses []string
+
+	SendReadReceipts  bool
+	ShowSeen          bool
+	Reconnect         bool
+	HeartbeatInterval time.Duration
+	KeepaliveTime     time.Duration
+	RingBell          bool
+	EventLogPath      string
+	// Clock is the kind of clock that stamps messages: "lamport" (the
+	// default), "vector" or "hlc".
+	Clock string
+
+	// Output receives everything the client prints, os.Stderr by default.
+	Output io.Writer
+	// DialOptions are added to the options the client dials the server
+	// with, e.g. to connect through an in-memory listener.
+	DialOptions []grpc.DialOption
+	// OnMessage, when set, is called for every chat message received, with
+	// the client's Lamport time after receiving it.
+	OnMessage func(message *proto.Chat, timestamp int64)
+}
+
+type ChatClient struct {
+	config     ClientConfig
+	connection *redirectingConnection
+	service    proto.ChatServiceClient
+	logger     *log.Logger
+	username   string
 
-var reader *bufio.Scanner
-var username string
-var programFinished = make(chan bool)
-var Timestamp int32 = 0
+	clock clock.Clock
 
-func main() {
-	getUsername()
+	lastMessageMutex sync.Mutex
+	lastMessageId    int64
 
-	clientConnection, client := startClient()
-	chatStream := joinChat(client)
+	receiptMutex        sync.Mutex
+	sendReadReceipts    bool
+	unreadMessageIds    []int64
+	ownMessageIds       []int64
+	deliveredMessageIds []int64
+	readMessageIds      []int64
+	receiptsQueued      chan struct{}
 
-	go listenToStream(chatStream)
-	go listenForInput(client)
+	mentionMutex   sync.Mutex
+	recentMentions []string
 
-	<-programFinished
+	floorMutex sync.Mutex
+	floor      floorState
 
-	closeClient(clientConnection)
+	events      *slog.Logger
+	eventCloser io.Closer
+
+	finished  chan error
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
-func updateTimestamp(incomingTimestamp int32) {
-	Timestamp = max(incomingTimestamp, Timestamp) + 1
+func NewChatClient(config ClientConfig) (*ChatClient, error) {
+	if config.Output == nil {
+		config.Output = os.Stderr
+	}
+
+	dialOptions := []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                config.KeepaliveTime,
+			Timeout:             10 * time.Second,
+			PermitWithoutStream: true,
+		}),
+	}
+	dialOptions = append(dialOptions, config.DialOptions...)
+	target := config.Address
+	initialOptions := dialOptions
+	if len(config.FailoverAddresses) > 0 {
+		var failoverOption grpc.DialOption
+		target, failoverOption = failoverTarget(append([]string{config.Address}, config.FailoverAddresses...))
+		initialOptions = append(slices.Clone(dialOptions), failoverOption)
+	}
+	initialConnection, connectionEstablishErr := grpc.NewClient(target, initialOptions...)
+	if connectionEstablishErr != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", config.Address, connectionEstablishErr)
+	}
+	connection := &redirectingConnection{
+		addresses:   append([]string{config.Address}, config.FailoverAddresses...),
+		dialOptions: dialOptions,
+		current:     initialConnection,
+	}
+
+	clientClock, clockErr := clock.New(config.Clock, config.Username)
+	if clockErr != nil {
+		connection.Close()
+		return nil, fmt.Errorf("creating clock: %w", clockErr)
+	}
+
+	events, eventCloser, eventLogErr := openEventLog(config.EventLogPath)
+	if eventLogErr != nil {
+		connection.Close()
+		return nil, fmt.Errorf("opening event log %s: %w", config.EventLogPath, eventLogErr)
+	}
+
+	return &ChatClient{
+		config:     config,
+		connection: connection,
+		service:    proto.NewChatServiceClient(connection),
+		logger:     log.New(config.Output, "", log.LstdFlags),
+		username:   config.Username,
+		clock:      clientClock,
+
+		sendReadReceipts: config.SendReadReceipts,
+		receiptsQueued:   make(chan struct{}, 1),
+
+		events:      events,
+		eventCloser: eventCloser,
+
+		finished: make(chan error, 1),
+		done:     make(chan struct{}),
+	}, nil
 }
 
-func getUsername() {
-	log.Print("Please enter a username:")
-	reader = bufio.NewScanner(os.Stdin)
-	reader.Scan()
-	username = reader.Text()
+// Run asks for a username unless one was configured, joins the chat and
+// handles the user's input until they leave, the input ends or the
+// connection to the server is lost.
+func (client *ChatClient) Run(input io.Reader) error {
+	reader := bufio.NewScanner(input)
+	if client.username == "" {
+		client.logger.Print("Please enter a username:")
+		if !reader.Scan() {
+			return errors.New("no username entered")
+		}
+		client.username = reader.Text()
+		client.clock, _ = clock.New(client.config.Clock, client.username)
+	}
+
+	joinErr := client.Join()
+	if joinErr != nil {
+		return joinErr
+	}
+
+	go client.listenForInput(reader)
+
+	return <-client.finished
 }
 
-func startClient() (*grpc.ClientConn, proto.ChatServiceClient) {
-	portString := fmt.Sprintf(":%d", port)
-	dialOptions := grpc.WithTransportCredentials(insecure.NewCredentials())
-	connection, connectionEstablishErr := grpc.NewClient(portString, dialOptions)
-	if connectionEstablishErr != nil {
-		log.Fatalf("Could not establish connection on port %s | %v", portString, connectionEstablishErr)
+// Join joins the chat and starts receiving messages and sending heartbeats
+// and receipts in the background.
+func (client *ChatClient) Join() error {
+	chatStream, joinErr := client.tryJoinChat()
+	if isUserFacingError(joinErr) || status.Code(joinErr) == codes.Unavailable {
+		return fmt.Errorf("could not join chat: %s", status.Convert(joinErr).Message())
 	}
+	if joinErr != nil {
+		return fmt.Errorf("could not join chat: %w", joinErr)
+	}
+
+	go client.listenToStream(chatStream)
+	go client.sendHeartbeats()
+	go client.sendReceipts()
+
+	return nil
+}
+
+// Finished receives the reason the client stopped once it does, nil when
+// the user left or the server closed the stream.
+func (client *ChatClient) Finished() <-chan error {
+	return client.finished
+}
+
+func (client *ChatClient) finish(err error) {
+	select {
+	case client.finished <- err:
+	default:
+	}
+}
+
+func (client *ChatClient) Close() error {
+	var closeErr error
+	client.closeOnce.Do(func() {
+		close(client.done)
+		closeErr = client.connection.Close()
+		if client.eventCloser != nil {
+			client.eventCloser.Close()
+		}
+	})
+
+	return closeErr
+}
 
-	return connection, proto.NewChatServiceClient(connection)
+func (client *ChatClient) Username() string {
+	return client.username
 }
 
-func closeClient(connection *grpc.ClientConn) {
-	connectionCloseErr := connection.Close()
-	if connectionCloseErr != nil {
-		log.Fatalf("Could not close connection | %v", connectionCloseErr)
+// Timestamp returns the client's Lamport time.
+func (client *ChatClient) Timestamp() int64 {
+	return client.clock.Now().Lamport
+}
+
+// tick advances the clock for a send. It also returns the Lamport time for
+// the int32 timestamp fields older servers read.
+func (client *ChatClient) tick() (clock.Time, int32) {
+	now := client.clock.Tick()
+	legacyTimestamp, _ := clock.Legacy(now.Lamport)
+	return now, legacyTimestamp
+}
+
+// Time returns the client's time on its configured clock.
+func (client *ChatClient) Time() clock.Time {
+	return client.clock.Now()
+}
+
+// receivedMessage records the newest message ID seen, which the client
+// sends when it rejoins so the server can replay what it missed.
+func (client *ChatClient) receivedMessage(messageId int64) {
+	client.lastMessageMutex.Lock()
+	defer client.lastMessageMutex.Unlock()
+
+	client.lastMessageId = max(client.lastMessageId, messageId)
+}
+
+// tryJoinChat joins the chat, following a Raft follower's redirect to the
+// leader once.
+func (client *ChatClient) tryJoinChat() (proto.ChatService_JoinChatClient, error) {
+	chatStream, joinErr := client.joinChat()
+	leader := leaderOf(joinErr)
+	if leader == "" {
+		return chatStream, joinErr
+	}
+
+	client.logger.Printf("Redirected to the leader %s", leader)
+	followErr := client.connection.follow(leader)
+	if followErr != nil {
+		return nil, fmt.Errorf("connecting to the leader %s: %w", leader, followErr)
 	}
+
+	return client.joinChat()
 }
 
-func joinChat(client proto.ChatServiceClient) proto.ChatService_JoinChatClient {
-	Timestamp++
-	user := proto.UserRequest{Username: username, Timestamp: Timestamp}
+func (client *ChatClient) joinChat() (proto.ChatService_JoinChatClient, error) {
+	now, legacyTimestamp := client.tick()
+	user := proto.UserRequest{Username: client.username, Timestamp: legacyTimestamp, Lamport: now.Lamport, Clock: now.Proto()}
+	client.lastMessageMutex.Lock()
+	user.LastMessageId = client.lastMessageId
+	client.lastMessageMutex.Unlock()
+	client.logger.Printf("%s | Joining chat as %s", now, user.Username)
+	client.logEvent("join_sent", user.Lamport)
 
-	chatStream, joinErr := client.JoinChat(context.Background(), &user)
+	chatStream, joinErr := client.service.JoinChat(context.Background(), &user)
 	if joinErr != nil {
-		log.Fatalf("Could not join chat | %v", joinErr)
+		return nil, joinErr
 	}
 
 	md, metadataErr := chatStream.Header()
 	if metadataErr != nil {
-		log.Fatalf("Could not retrieve metadata | %v", metadataErr)
+		return nil, metadataErr
 	}
 
 	serverTimestamp, ok := md["lamport-timestamp"]
-	if ok && len(serverTimestamp) > 0 {
-		timestampInt, _ := strconv.Atoi(serverTimestamp[0])
-		Timestamp = int32(timestampInt + 1)
+	if !ok || len(serverTimestamp) == 0 {
+		// The server sends no header when it rejects the join.
+		_, rejectErr := chatStream.Recv()
+		return nil, rejectErr
+	}
 
-		log.Printf("LT%d | Joining chat as %s", Timestamp, user.Username)
+	serverLamport := headerLamport(md["lamport-time"], serverTimestamp[0])
+	joined := client.clock.Merge(clock.Time{Lamport: serverLamport})
+	client.logEvent("joined", joined.Lamport, slog.Int64("sent_lamport", serverLamport))
+
+	return chatStream, nil
+}
+
+// headerLamport reads the server's Lamport time from the 64-bit header,
+// or from the int32 one older servers send instead.
+func headerLamport(lamportTime []string, legacyTimestamp string) int64 {
+	if len(lamportTime) > 0 {
+		lamport, parseErr := strconv.ParseInt(lamportTime[0], 10, 64)
+		if parseErr == nil {
+			return lamport
+		}
 	}
 
-	return chatStream
+	legacy, _ := strconv.ParseInt(legacyTimestamp, 10, 32)
+	return clock.Widen(0, int32(legacy))
 }
 
-func listenToStream(stream proto.ChatService_JoinChatClient) {
+func (client *ChatClient) listenToStream(stream proto.ChatService_JoinChatClient) {
 	for {
 		message, chatStreamErr := stream.Recv()
+		if chatStreamErr != nil && client.isClosed() {
+			client.finish(nil)
+			return
+		}
+		if chatStreamErr != nil && client.config.Reconnect && isReconnectable(chatStreamErr) {
+			var rejoinErr error
+			stream, rejoinErr = client.rejoinChat()
+			if rejoinErr != nil || stream == nil {
+				client.finish(rejoinErr)
+				return
+			}
+			continue
+		}
 		if chatStreamErr == io.EOF || errors.Is(chatStreamErr, context.Canceled) {
-			log.Printf("Server closed the stream")
-			programFinished <- true
+			client.logger.Printf("Server closed the stream")
+			client.finish(nil)
+			return
+		}
+		if status.Code(chatStreamErr) == codes.Unavailable {
+			client.logger.Printf("Lost connection to the server")
+			client.finish(nil)
+			return
+		}
+		if isUserFacingError(chatStreamErr) {
+			client.reportError(chatStreamErr)
+			client.finish(nil)
 			return
 		}
 		if chatStreamErr != nil {
-			log.Fatalf("Error receiving message | %v", chatStreamErr)
+			client.finish(fmt.Errorf("receiving message: %w", chatStreamErr))
+			return
 		}
 
-		updateTimestamp(message.Timestamp)
+		sentLamport := clock.Widen(message.Lamport, message.Timestamp)
+		now := client.clock.Merge(clock.FromProto(sentLamport, message.Clock))
+		client.receivedMessage(message.Id)
+		client.logEvent("chat_received", now.Lamport, slog.Int64("message_id", message.Id), slog.String("from", message.Username), slog.Int64("sent_lamport", sentLamport))
 
-		log.Printf("LT%d | %s: %s", Timestamp, message.Username, message.Message)
+		line := fmt.Sprintf("%s | %s: %s", now, message.Username, message.Message)
+		if message.Recipient != "" {
+			line = fmt.Sprintf("%s | [DM %s -> %s] %s", now, message.Username, message.Recipient, message.Message)
+		}
+
+		if client.mentionsMe(message) {
+			client.notifyMention(line)
+		} else {
+			client.logger.Print(line)
+		}
+
+		if client.config.OnMessage != nil {
+			client.config.OnMessage(message, now.Lamport)
+		}
+
+		if message.Kind == proto.ChatKind_SHUTDOWN {
+			client.logger.Print("The server is shutting down")
+			continue
+		}
+		if message.Kind == proto.ChatKind_FLOOR && message.Floor != nil {
+			client.handleFloor(message.Floor)
+		}
+
+		client.trackReceivedMessage(message)
 	}
 }
 
-func listenForInput(client proto.ChatServiceClient) {
-	for {
-		reader.Scan()
+func (client *ChatClient) isClosed() bool {
+	select {
+	case <-client.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (client *ChatClient) listenForInput(reader *bufio.Scanner) {
+	for reader.Scan() {
 		userInput := reader.Text()
 
+		client.markMessagesRead()
+
 		if len(userInput) == 0 {
-			log.Print("Input was empty")
+			client.logger.Print("Input was empty")
 			continue
 		}
 
-		if len(userInput) >= 128 {
-			log.Print("Message is too long, limit is 128 characters")
+		if strings.ToLower(userInput) == "leave" {
+			break
+		}
+
+		if strings.HasPrefix(userInput, "/") {
+			client.handleCommand(userInput)
 			continue
 		}
 
-		if strings.ToLower(userInput) == "leave" {
-			leaveChat(client)
-			programFinished <- true
+		client.Send(userInput)
+	}
+
+	client.finish(client.Leave())
+}
+
+func (client *ChatClient) handleCommand(userInput string) {
+	command := strings.Fields(userInput)
+	switch strings.ToLower(command[0]) {
+	case "/receipts":
+		if len(command) != 2 || (command[1] != "on" && command[1] != "off") {
+			client.logger.Print("Usage: /receipts on|off")
 			return
 		}
-
-		broadcastMessage(client, userInput)
+		client.receiptMutex.Lock()
+		client.sendReadReceipts = command[1] == "on"
+		client.receiptMutex.Unlock()
+		client.logger.Printf("Read receipts are now %s", command[1])
+	case "/seen":
+		client.showReceipts()
+	case "/kick", "/mute", "/ban":
+		client.moderate(command)
+	case "/slowmode":
+		client.setSlowMode(command)
+	case "/mentions":
+		client.showMentions()
+	case "/raise":
+		client.RaiseHand()
+	case "/yield":
+		client.Yield()
+	case "/msg":
+		if len(command) < 3 {
+			client.logger.Print("Usage: /msg <username> <message>")
+			return
+		}
+		// The message keeps its own spacing, whatever separates the
+		// command and the recipient.
+		arguments, _ := strings.CutPrefix(strings.TrimSpace(userInput), command[0])
+		directMessage, _ := strings.CutPrefix(strings.TrimSpace(arguments), command[1])
+		client.SendDirect(command[1], strings.TrimSpace(directMessage))
+	default:
+		client.logger.Printf("Unknown command %s", command[0])
 	}
 }
 
-func leaveChat(client proto.ChatServiceClient) {
-	Timestamp++
-	user := &proto.UserRequest{Username: username, Timestamp: Timestamp}
-	_, leaveErr := client.LeaveChat(context.Background(), user)
+func (client *ChatClient) Leave() error {
+	now, legacyTimestamp := client.tick()
+	user := &proto.UserRequest{Username: client.username, Timestamp: legacyTimestamp, Lamport: now.Lamport, Clock: now.Proto()}
+	client.logEvent("leave_sent", user.Lamport)
+	_, leaveErr := client.service.LeaveChat(context.Background(), user)
 	if leaveErr != nil {
-		log.Fatalf("Could not leave chat | %v", leaveErr)
+		return fmt.Errorf("could not leave chat: %w", leaveErr)
 	}
 
-	log.Printf("LT%d | Successfully left the chat", Timestamp)
+	client.logger.Printf("%s | Successfully left the chat", now)
+
+	return nil
 }
 
-func broadcastMessage(client proto.ChatServiceClient, userInput string) {
-	Timestamp++
-	message := &proto.Chat{Username: username, Message: userInput, Timestamp: Timestamp}
-	log.Printf("LT%d | Sending message", Timestamp)
+// Send broadcasts a message to everyone in the chat. Errors the user can
+// act on are printed and returned, any other error also stops the client.
+func (client *ChatClient) Send(userInput string) error {
+	return client.broadcastMessage(userInput, "")
+}
+
+func (client *ChatClient) SendDirect(recipient string, userInput string) error {
+	return client.broadcastMessage(userInput, recipient)
+}
+
+func (client *ChatClient) broadcastMessage(userInput string, recipient string) error {
+	now, legacyTimestamp := client.tick()
+	message := &proto.Chat{Username: client.username, Message: userInput, Timestamp: legacyTimestamp, Lamport: now.Lamport, Clock: now.Proto(), Recipient: recipient}
+	client.logger.Printf("%s | Sending message", now)
+	client.logEvent("message_sent", message.Lamport, slog.String("recipient", recipient))
 
-	_, broadcastErr := client.BroadcastMessage(context.Background(), message)
+	_, broadcastErr := client.service.BroadcastMessage(context.Background(), message)
+	if isUserFacingError(broadcastErr) {
+		client.reportError(broadcastErr)
+		return broadcastErr
+	}
 	if broadcastErr != nil {
-		log.Fatalf("Error Broadcasting Message | %v", broadcastErr)
+		broadcastErr = fmt.Errorf("broadcasting message: %w", broadcastErr)
+		client.finish(broadcastErr)
+		return broadcastErr
 	}
+
+	return nil
+}
+
+func isUserFacingError(err error) bool {
+	switch status.Code(err) {
+	case codes.NotFound, codes.PermissionDenied, codes.InvalidArgument, codes.ResourceExhausted, codes.FailedPrecondition:
+		return true
+	default:
+		return false
+	}
+}
+
+func (client *ChatClient) reportError(err error) {
+	if isUserFacingError(err) {
+		client.logger.Print(status.Convert(err).Message())
+		return
+	}
+
+	client.logger.Printf("Request failed | %v", err)
 }
@@ -0,0 +1,32 @@
+package client
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// openEventLog makes the client write the same kind of JSON-lines event log
+// as the server, so that both sides of a run can be analysed together.
+func openEventLog(path string) (*slog.Logger, io.Closer, error) {
+	if path == "" {
+		return slog.New(slog.NewJSONHandler(io.Discard, &slog.HandlerOptions{Level: slog.Level(1 << 30)})), nil, nil
+	}
+
+	file, openErr := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if openErr != nil {
+		return nil, nil, openErr
+	}
+
+	return slog.New(slog.NewJSONHandler(file, nil)), file, nil
+}
+
+func (client *ChatClient) logEvent(event string, timestamp int64, attributes ...slog.Attr) {
+	attributes = append([]slog.Attr{
+		slog.String("event", event),
+		slog.Int64("lamport", timestamp),
+		slog.String("user", client.username),
+	}, attributes...)
+	client.events.LogAttrs(context.Background(), slog.LevelInfo, event, attributes...)
+}
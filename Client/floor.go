@@ -0,0 +1,126 @@
+package client
+
+import (
+	clock "Chitty-Chat/Clock"
+	proto "Chitty-Chat/GRPC"
+	"context"
+)
+
+// floorState is the client's side of Ricart-Agrawala floor control. While
+// it holds the floor, or asked for it before another user by Lamport time
+// and then username, it defers that user's request until it yields.
+type floorState struct {
+	requestTimestamp int64
+	requesting       bool
+	holding          bool
+	deferred         []*proto.FloorEvent
+}
+
+func (client *ChatClient) floorEvent(action proto.FloorAction) *proto.FloorEvent {
+	now, legacyTimestamp := client.tick()
+	return &proto.FloorEvent{Action: action, Username: client.username, Timestamp: legacyTimestamp, Lamport: now.Lamport}
+}
+
+// RaiseHand asks everyone in the room for the floor.
+func (client *ChatClient) RaiseHand() error {
+	client.floorMutex.Lock()
+	if client.floor.holding || client.floor.requesting {
+		client.floorMutex.Unlock()
+		client.logger.Print("You already have or asked for the floor")
+		return nil
+	}
+	request := client.floorEvent(proto.FloorAction_REQUEST)
+	client.floor.requestTimestamp = request.Lamport
+	client.floor.requesting = true
+	client.floorMutex.Unlock()
+
+	_, requestErr := client.service.Floor(context.Background(), request)
+	if requestErr != nil {
+		client.floorMutex.Lock()
+		client.floor.requesting = false
+		deferred := client.takeDeferred()
+		client.floorMutex.Unlock()
+		client.grantAll(deferred)
+		client.reportError(requestErr)
+	}
+
+	return requestErr
+}
+
+// Yield gives the floor up, or withdraws the request for it, and grants
+// the requests that were deferred meanwhile.
+func (client *ChatClient) Yield() error {
+	client.floorMutex.Lock()
+	if !client.floor.holding && !client.floor.requesting {
+		client.floorMutex.Unlock()
+		client.logger.Print("You neither have nor asked for the floor")
+		return nil
+	}
+	client.floor.holding = false
+	client.floor.requesting = false
+	deferred := client.takeDeferred()
+	client.floorMutex.Unlock()
+
+	_, yieldErr := client.service.Floor(context.Background(), client.floorEvent(proto.FloorAction_YIELD))
+	if yieldErr != nil {
+		client.reportError(yieldErr)
+	}
+	client.grantAll(deferred)
+
+	return yieldErr
+}
+
+func (client *ChatClient) takeDeferred() []*proto.FloorEvent {
+	deferred := client.floor.deferred
+	client.floor.deferred = nil
+	return deferred
+}
+
+func (client *ChatClient) grantAll(requests []*proto.FloorEvent) {
+	for _, request := range requests {
+		grant := client.floorEvent(proto.FloorAction_GRANT)
+		grant.Requester = request.Requester
+		grant.RequestTimestamp = request.RequestTimestamp
+		grant.RequestLamport = request.RequestLamport
+		_, grantErr := client.service.Floor(context.Background(), grant)
+		if grantErr != nil {
+			client.reportError(grantErr)
+		}
+	}
+}
+
+// handleFloor answers another user's request for the floor and notices
+// when the client has been given it.
+func (client *ChatClient) handleFloor(event *proto.FloorEvent) {
+	client.floorMutex.Lock()
+	defer client.floorMutex.Unlock()
+
+	switch {
+	case event.Action == proto.FloorAction_TAKEN && event.Requester == client.username:
+		client.floor.holding = true
+		client.floor.requesting = false
+		client.logger.Print("You have the floor, type /yield when you are done")
+	case event.Action == proto.FloorAction_YIELD && event.Requester == client.username:
+		// The server also yields for users that left, so a client that
+		// rejoins learns from the replayed notice that it lost the floor.
+		client.floor.holding = false
+		if clock.Widen(event.RequestLamport, event.RequestTimestamp) == client.floor.requestTimestamp {
+			client.floor.requesting = false
+		}
+		if !client.floor.requesting {
+			go client.grantAll(client.takeDeferred())
+		}
+	case event.Action == proto.FloorAction_REQUEST && event.Requester != client.username:
+		if client.floor.holding || (client.floor.requesting && asksFirst(client.floor.requestTimestamp, client.username, clock.Widen(event.RequestLamport, event.RequestTimestamp), event.Requester)) {
+			client.floor.deferred = append(client.floor.deferred, event)
+			return
+		}
+		go client.grantAll([]*proto.FloorEvent{event})
+	}
+}
+
+// asksFirst orders requests for the floor by Lamport time, breaking ties by
+// username.
+func asksFirst(timestamp int64, username string, otherTimestamp int64, otherUsername string) bool {
+	return timestamp < otherTimestamp || (timestamp == otherTimestamp && username < otherUsername)
+}
@@ -0,0 +1,35 @@
+package client
+
+import (
+	proto "Chitty-Chat/GRPC"
+	"context"
+	"time"
+)
+
+// sendHeartbeats tells the server that the client is still alive, so that it
+// can evict users whose connection silently died.
+func (client *ChatClient) sendHeartbeats() {
+	interval := client.config.HeartbeatInterval
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-client.done:
+			return
+		case <-ticker.C:
+		}
+
+		heartbeatContext, cancel := context.WithTimeout(context.Background(), interval)
+		_, heartbeatErr := client.service.Heartbeat(heartbeatContext, &proto.UserRequest{Username: client.username})
+		cancel()
+
+		if heartbeatErr != nil && !client.isClosed() {
+			client.logger.Printf("Heartbeat failed | %v", heartbeatErr)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	proto "Chitty-Chat/GRPC"
+	"fmt"
+	"slices"
+)
+
+const mentionHistoryLength = 20
+const highlightStart = "\033[1;33m"
+const highlightEnd = "\033[0m"
+const terminalBell = "\a"
+
+func (client *ChatClient) mentionsMe(message *proto.Chat) bool {
+	return message.Username != client.username && slices.Contains(message.Mentions, client.username)
+}
+
+// notifyMention highlights a line that mentions the local user, rings the
+// terminal bell and remembers it for the /mentions command.
+func (client *ChatClient) notifyMention(line string) {
+	if client.config.RingBell {
+		fmt.Fprint(client.config.Output, terminalBell)
+	}
+	client.logger.Print(highlightStart + line + highlightEnd)
+
+	client.mentionMutex.Lock()
+	defer client.mentionMutex.Unlock()
+
+	client.recentMentions = append(client.recentMentions, line)
+	if len(client.recentMentions) > mentionHistoryLength {
+		client.recentMentions = client.recentMentions[1:]
+	}
+}
+
+func (client *ChatClient) showMentions() {
+	client.mentionMutex.Lock()
+	defer client.mentionMutex.Unlock()
+
+	if len(client.recentMentions) == 0 {
+		client.logger.Print("Nobody has mentioned you yet")
+		return
+	}
+
+	for _, line := range client.recentMentions {
+		client.logger.Print(line)
+	}
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	proto "Chitty-Chat/GRPC"
+	"context"
+	"strings"
+	"time"
+)
+
+// moderate handles "/kick <user> [reason]" as well as
+// "/mute <user> [duration] [reason]" and "/ban <user> [duration] [reason]".
+func (client *ChatClient) moderate(command []string) {
+	action := strings.ToLower(command[0])
+	if len(command) < 2 {
+		if action == "/kick" {
+			client.logger.Print("Usage: /kick <username> [reason]")
+		} else {
+			client.logger.Printf("Usage: %s <username> [duration] [reason]", action)
+		}
+		return
+	}
+
+	now, legacyTimestamp := client.tick()
+	request := &proto.ModerationRequest{Requester: client.username, Timestamp: legacyTimestamp, Lamport: now.Lamport, Target: command[1]}
+	arguments := command[2:]
+	if action != "/kick" && len(arguments) > 0 {
+		duration, durationErr := time.ParseDuration(arguments[0])
+		if durationErr == nil {
+			seconds, isWhole := wholeSeconds(duration)
+			if !isWhole {
+				client.logger.Printf("Invalid duration %s, use whole seconds, e.g. 10m", arguments[0])
+				return
+			}
+			request.DurationSeconds = seconds
+			arguments = arguments[1:]
+		}
+	}
+	request.Reason = strings.Join(arguments, " ")
+
+	var moderationErr error
+	switch action {
+	case "/kick":
+		_, moderationErr = client.service.KickUser(context.Background(), request)
+	case "/mute":
+		_, moderationErr = client.service.MuteUser(context.Background(), request)
+	case "/ban":
+		_, moderationErr = client.service.BanUser(context.Background(), request)
+	}
+
+	if moderationErr != nil {
+		client.reportError(moderationErr)
+	}
+}
+
+func (client *ChatClient) setSlowMode(command []string) {
+	if len(command) != 2 {
+		client.logger.Print("Usage: /slowmode <interval>|off")
+		return
+	}
+
+	var seconds int64
+	if command[1] != "off" {
+		interval, intervalErr := time.ParseDuration(command[1])
+		isWhole := false
+		if intervalErr == nil {
+			seconds, isWhole = wholeSeconds(interval)
+		}
+		if !isWhole {
+			client.logger.Printf("Invalid interval %s, use whole seconds, e.g. 30s", command[1])
+			return
+		}
+	}
+
+	now, legacyTimestamp := client.tick()
+	request := &proto.ModerationRequest{Requester: client.username, Timestamp: legacyTimestamp, Lamport: now.Lamport, DurationSeconds: seconds}
+	_, slowModeErr := client.service.SetSlowMode(context.Background(), request)
+	if slowModeErr != nil {
+		client.reportError(slowModeErr)
+	}
+}
+
+// wholeSeconds converts a duration for the server, which counts in seconds.
+// Anything else would be rounded down, which turns e.g. "500ms" into zero
+// and with it slow mode off or a mute permanent.
+func wholeSeconds(duration time.Duration) (int64, bool) {
+	if duration < time.Second || duration%time.Second != 0 {
+		return 0, false
+	}
+
+	return int64(duration / time.Second), true
+}
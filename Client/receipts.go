@@ -0,0 +1,126 @@
+package client
+
+import (
+	clock "Chitty-Chat/Clock"
+	proto "Chitty-Chat/GRPC"
+	"context"
+	"log/slog"
+	"strings"
+)
+
+// trackReceivedMessage queues a delivery receipt for a message from someone
+// else. Receipts are sent by sendReceipts, so that a slow server never holds
+// up the messages being shown.
+func (client *ChatClient) trackReceivedMessage(message *proto.Chat) {
+	if message.Id == 0 {
+		return
+	}
+
+	client.receiptMutex.Lock()
+	defer client.receiptMutex.Unlock()
+
+	if message.Username == client.username {
+		client.ownMessageIds = append(client.ownMessageIds, message.Id)
+		if len(client.ownMessageIds) > seenHistoryLength {
+			client.ownMessageIds = client.ownMessageIds[1:]
+		}
+		return
+	}
+
+	client.unreadMessageIds = append(client.unreadMessageIds, message.Id)
+	client.deliveredMessageIds = append(client.deliveredMessageIds, message.Id)
+	client.signalReceipts()
+}
+
+// markMessagesRead is called whenever the user submits input, as by then
+// they have had the chance to see everything printed to the terminal.
+func (client *ChatClient) markMessagesRead() {
+	client.receiptMutex.Lock()
+	defer client.receiptMutex.Unlock()
+
+	if len(client.unreadMessageIds) == 0 {
+		return
+	}
+
+	if client.sendReadReceipts {
+		client.readMessageIds = append(client.readMessageIds, client.unreadMessageIds...)
+		client.signalReceipts()
+	}
+	client.unreadMessageIds = nil
+}
+
+// signalReceipts wakes up sendReceipts. The caller must hold receiptMutex.
+func (client *ChatClient) signalReceipts() {
+	select {
+	case client.receiptsQueued <- struct{}{}:
+	default:
+	}
+}
+
+// sendReceipts acknowledges the queued messages in the background until the
+// client is closed. Whatever queued up while a receipt was being sent goes
+// out in one batch, delivery receipts before read receipts.
+func (client *ChatClient) sendReceipts() {
+	for {
+		select {
+		case <-client.done:
+			return
+		case <-client.receiptsQueued:
+		}
+
+		client.receiptMutex.Lock()
+		delivered, read := client.deliveredMessageIds, client.readMessageIds
+		client.deliveredMessageIds, client.readMessageIds = nil, nil
+		client.receiptMutex.Unlock()
+
+		if len(delivered) > 0 {
+			client.acknowledgeMessages(proto.ReceiptKind_DELIVERED, delivered)
+		}
+		if len(read) > 0 {
+			client.acknowledgeMessages(proto.ReceiptKind_READ, read)
+		}
+	}
+}
+
+func (client *ChatClient) acknowledgeMessages(kind proto.ReceiptKind, messageIds []int64) {
+	now, legacyTimestamp := client.tick()
+	ack := &proto.Acknowledgement{Username: client.username, Timestamp: legacyTimestamp, Lamport: now.Lamport, Kind: kind, MessageIds: messageIds}
+	client.logEvent("acknowledge_sent", ack.Lamport, slog.String("kind", kind.String()), slog.Any("message_ids", messageIds))
+	_, ackErr := client.service.AcknowledgeMessages(context.Background(), ack)
+	if ackErr != nil && !client.isClosed() {
+		client.logger.Printf("Could not acknowledge messages | %v", ackErr)
+	}
+}
+
+func (client *ChatClient) showReceipts() {
+	if !client.config.ShowSeen {
+		client.logger.Print("Seen-by display is disabled, restart with -show-seen to enable it")
+		return
+	}
+
+	client.receiptMutex.Lock()
+	messageIds := append([]int64(nil), client.ownMessageIds...)
+	client.receiptMutex.Unlock()
+
+	if len(messageIds) == 0 {
+		client.logger.Print("You have not sent any messages yet")
+		return
+	}
+
+	sent, legacyTimestamp := client.tick()
+	request := &proto.ReceiptRequest{Username: client.username, Timestamp: legacyTimestamp, Lamport: sent.Lamport, MessageIds: messageIds}
+	receiptList, receiptsErr := client.service.GetReceipts(context.Background(), request)
+	if receiptsErr != nil {
+		client.logger.Printf("Could not retrieve receipts | %v", receiptsErr)
+		return
+	}
+	now := client.clock.Merge(clock.Time{Lamport: clock.Widen(receiptList.Lamport, receiptList.Timestamp)})
+
+	for _, receipt := range receiptList.Receipts {
+		seenBy := "nobody"
+		if len(receipt.ReadBy) > 0 {
+			seenBy = strings.Join(receipt.ReadBy, ", ")
+		}
+		client.logger.Printf("%s | Message #%d delivered to %d user(s), seen by %s", now, receipt.MessageId, len(receipt.DeliveredTo), seenBy)
+	}
+}
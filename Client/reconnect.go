@@ -0,0 +1,60 @@
+package client
+
+import (
+	proto "Chitty-Chat/GRPC"
+	"fmt"
+	"io"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/grpc/status"
+)
+
+const initialReconnectDelay = time.Second
+const maxReconnectDelay = 30 * time.Second
+const failoverScheme = "chitty-failover"
+
+func isReconnectable(err error) bool {
+	return err == io.EOF || status.Code(err) == codes.Unavailable
+}
+
+// rejoinChat keeps trying to join the chat again, backing off exponentially
+// while the server is unavailable.
+func (client *ChatClient) rejoinChat() (proto.ChatService_JoinChatClient, error) {
+	delay := initialReconnectDelay
+	for {
+		client.logger.Printf("Reconnecting in %v...", delay)
+		select {
+		case <-client.done:
+			return nil, nil
+		case <-time.After(delay):
+		}
+
+		chatStream, joinErr := client.tryJoinChat()
+		if joinErr == nil {
+			return chatStream, nil
+		}
+		if !isReconnectable(joinErr) && status.Code(joinErr) != codes.ResourceExhausted {
+			return nil, fmt.Errorf("could not rejoin chat: %w", joinErr)
+		}
+
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+// failoverTarget resolves to every given address in order. The connection
+// uses the first one that can be reached and moves on to the next when it
+// goes away, so a client rejoining after its server died finds the backup.
+func failoverTarget(addresses []string) (string, grpc.DialOption) {
+	builder := manual.NewBuilderWithScheme(failoverScheme)
+	state := resolver.State{}
+	for _, address := range addresses {
+		state.Addresses = append(state.Addresses, resolver.Address{Addr: address})
+	}
+	builder.InitialState(state)
+
+	return failoverScheme + ":///chat", grpc.WithResolvers(builder)
+}
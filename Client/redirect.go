@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+const notLeaderReason = "NOT_LEADER"
+
+// leaderOf returns the leader a Raft follower pointed the client to, if
+// the error is such a redirect.
+func leaderOf(err error) string {
+	for _, detail := range status.Convert(err).Details() {
+		errorInfo, isErrorInfo := detail.(*errdetails.ErrorInfo)
+		if isErrorInfo && errorInfo.Reason == notLeaderReason {
+			return errorInfo.Metadata["leader"]
+		}
+	}
+
+	return ""
+}
+
+// redirectingConnection sends every call over the connection to the server
+// that leads the cluster as far as the client knows, switching when a
+// follower names a different leader. Unary calls are retried once on the
+// leader; streams are left to the caller.
+type redirectingConnection struct {
+	addresses   []string
+	dialOptions []grpc.DialOption
+
+	mutex   sync.Mutex
+	current *grpc.ClientConn
+	leader  string
+	retired []*grpc.ClientConn
+}
+
+func (connection *redirectingConnection) get() *grpc.ClientConn {
+	connection.mutex.Lock()
+	defer connection.mutex.Unlock()
+
+	return connection.current
+}
+
+// follow connects to leader, falling back to the configured addresses in
+// order when it cannot be reached. The old connection stays open until the
+// client closes, since a stream may still be using it.
+func (connection *redirectingConnection) follow(leader string) error {
+	connection.mutex.Lock()
+	defer connection.mutex.Unlock()
+
+	if leader == connection.leader {
+		return nil
+	}
+
+	addresses := append([]string{leader}, slices.DeleteFunc(slices.Clone(connection.addresses), func(address string) bool { return address == leader })...)
+	target, failoverOption := failoverTarget(addresses)
+	next, dialErr := grpc.NewClient(target, append(slices.Clone(connection.dialOptions), failoverOption)...)
+	if dialErr != nil {
+		return dialErr
+	}
+
+	connection.retired = append(connection.retired, connection.current)
+	connection.current = next
+	connection.leader = leader
+
+	return nil
+}
+
+func (connection *redirectingConnection) Invoke(ctx context.Context, method string, args any, reply any, opts ...grpc.CallOption) error {
+	invokeErr := connection.get().Invoke(ctx, method, args, reply, opts...)
+	leader := leaderOf(invokeErr)
+	if leader == "" || connection.follow(leader) != nil {
+		return invokeErr
+	}
+
+	return connection.get().Invoke(ctx, method, args, reply, opts...)
+}
+
+func (connection *redirectingConnection) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return connection.get().NewStream(ctx, desc, method, opts...)
+}
+
+func (connection *redirectingConnection) Close() error {
+	connection.mutex.Lock()
+	defer connection.mutex.Unlock()
+
+	for _, retired := range connection.retired {
+		retired.Close()
+	}
+
+	return connection.current.Close()
+}
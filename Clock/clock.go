@@ -0,0 +1,267 @@
+// Package clock provides the logical clocks that order Chitty-Chat events:
+// plain Lamport clocks, vector clocks and hybrid logical clocks. Every clock
+// also keeps a Lamport counter, so the Lamport timestamps on the wire and in
+// the logs stay meaningful whichever clock a process runs.
+package clock
+
+import (
+	proto "Chitty-Chat/GRPC"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Clock kinds accepted by New.
+const (
+	KindLamport = "lamport"
+	KindVector  = "vector"
+	KindHybrid  = "hlc"
+)
+
+// Clock is safe for concurrent use.
+type Clock interface {
+	// Now returns the current time without advancing the clock.
+	Now() Time
+	// Tick advances the clock for a local or send event.
+	Tick() Time
+	// Merge advances the clock past a received time.
+	Merge(received Time) Time
+}
+
+// Time is a reading of a clock. Physical and Logical are only set by hybrid
+// logical clocks, Vector only by vector clocks.
+type Time struct {
+	Lamport int64
+	// Physical is in milliseconds since the Unix epoch.
+	Physical int64
+	Logical  int32
+	Vector   map[string]int64
+}
+
+func (now Time) String() string {
+	switch {
+	case now.Physical != 0:
+		return fmt.Sprintf("%s+%d", time.UnixMilli(now.Physical).Format("15:04:05.000"), now.Logical)
+	case now.Vector != nil:
+		var entries []string
+		for _, id := range slices.Sorted(maps.Keys(now.Vector)) {
+			entries = append(entries, fmt.Sprintf("%s:%d", id, now.Vector[id]))
+		}
+		return "[" + strings.Join(entries, " ") + "]"
+	default:
+		return fmt.Sprintf("LT%d", now.Lamport)
+	}
+}
+
+// Before reports whether now happened before other as far as the clocks
+// can tell: by the vectors if both have one, by the hybrid time if both
+// have one, and by the Lamport time otherwise.
+func (now Time) Before(other Time) bool {
+	switch {
+	case now.Vector != nil && other.Vector != nil:
+		for id, count := range now.Vector {
+			if count > other.Vector[id] {
+				return false
+			}
+		}
+		return !maps.Equal(now.Vector, other.Vector)
+	case now.Physical != 0 && other.Physical != 0:
+		return now.Physical < other.Physical || (now.Physical == other.Physical && now.Logical < other.Logical)
+	default:
+		return now.Lamport < other.Lamport
+	}
+}
+
+// FromProto combines the Lamport timestamp of a message with its clock
+// time, which is nil for messages stamped by a Lamport clock.
+func FromProto(lamport int64, clockTime *proto.ClockTime) Time {
+	if clockTime == nil {
+		return Time{Lamport: lamport}
+	}
+
+	return Time{Lamport: lamport, Physical: clockTime.Physical, Logical: clockTime.Logical, Vector: maps.Clone(clockTime.Vector)}
+}
+
+// Proto returns the part of the time not carried by the Lamport timestamp,
+// or nil if there is none.
+func (now Time) Proto() *proto.ClockTime {
+	if now.Physical == 0 && now.Vector == nil {
+		return nil
+	}
+
+	return &proto.ClockTime{Physical: now.Physical, Logical: now.Logical, Vector: maps.Clone(now.Vector)}
+}
+
+// Legacy returns the Lamport time for the int32 timestamp fields older
+// clients read, capped at math.MaxInt32, and whether it fit.
+func Legacy(lamport int64) (int32, bool) {
+	if lamport > math.MaxInt32 {
+		return math.MaxInt32, false
+	}
+
+	return int32(lamport), true
+}
+
+// Widen returns the Lamport time of a message from its 64-bit field, or
+// from the int32 timestamp if an older sender left it unset. Older senders
+// wrap around to negative timestamps past math.MaxInt32; those are read as
+// the unsigned time they stand for.
+func Widen(lamport int64, legacy int32) int64 {
+	if lamport != 0 {
+		return lamport
+	}
+
+	return int64(uint32(legacy))
+}
+
+// New returns a clock of the given kind for the process id, which names the
+// process's entry in a vector clock.
+func New(kind string, id string) (Clock, error) {
+	switch kind {
+	case KindLamport, "":
+		return &lamportClock{}, nil
+	case KindVector:
+		return &vectorClock{id: id, vector: make(map[string]int64)}, nil
+	case KindHybrid:
+		return NewHybrid(time.Now), nil
+	default:
+		return nil, fmt.Errorf("unknown clock %q, want %s, %s or %s", kind, KindLamport, KindVector, KindHybrid)
+	}
+}
+
+type lamportClock struct {
+	mutex   sync.Mutex
+	lamport int64
+}
+
+func (clock *lamportClock) Now() Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	return Time{Lamport: clock.lamport}
+}
+
+func (clock *lamportClock) Tick() Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	clock.lamport++
+	return Time{Lamport: clock.lamport}
+}
+
+func (clock *lamportClock) Merge(received Time) Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	clock.lamport = max(received.Lamport, clock.lamport) + 1
+	return Time{Lamport: clock.lamport}
+}
+
+// vectorClock counts the events of every process it has heard of.
+type vectorClock struct {
+	mutex   sync.Mutex
+	id      string
+	lamport int64
+	vector  map[string]int64
+}
+
+func (clock *vectorClock) now() Time {
+	return Time{Lamport: clock.lamport, Vector: maps.Clone(clock.vector)}
+}
+
+func (clock *vectorClock) Now() Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	return clock.now()
+}
+
+func (clock *vectorClock) Tick() Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	clock.lamport++
+	clock.vector[clock.id]++
+	return clock.now()
+}
+
+func (clock *vectorClock) Merge(received Time) Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	clock.lamport = max(received.Lamport, clock.lamport) + 1
+	for id, count := range received.Vector {
+		clock.vector[id] = max(count, clock.vector[id])
+	}
+	clock.vector[clock.id]++
+	return clock.now()
+}
+
+// hybridClock is a hybrid logical clock (Kulkarni et al.): physical is the
+// largest wall time it has seen, and logical orders the events that happen
+// within it. It never runs behind the local wall clock and stays within the
+// clock skew of the processes ahead of it.
+type hybridClock struct {
+	mutex    sync.Mutex
+	wallTime func() time.Time
+	lamport  int64
+	physical int64
+	logical  int32
+}
+
+// NewHybrid returns a hybrid logical clock reading the wall time from
+// wallTime.
+func NewHybrid(wallTime func() time.Time) Clock {
+	return &hybridClock{wallTime: wallTime}
+}
+
+func (clock *hybridClock) now() Time {
+	return Time{Lamport: clock.lamport, Physical: clock.physical, Logical: clock.logical}
+}
+
+func (clock *hybridClock) Now() Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	return clock.now()
+}
+
+func (clock *hybridClock) Tick() Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	clock.lamport++
+	clock.advance(clock.physical, clock.logical)
+	return clock.now()
+}
+
+func (clock *hybridClock) Merge(received Time) Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	clock.lamport = max(received.Lamport, clock.lamport) + 1
+	if received.Physical > clock.physical || (received.Physical == clock.physical && received.Logical > clock.logical) {
+		clock.advance(received.Physical, received.Logical)
+	} else {
+		clock.advance(clock.physical, clock.logical)
+	}
+	return clock.now()
+}
+
+// advance moves the clock past the latest of its own time, the received
+// time passed in, and the wall time.
+func (clock *hybridClock) advance(physical int64, logical int32) {
+	wallTime := clock.wallTime().UnixMilli()
+	if wallTime > physical {
+		clock.physical = wallTime
+		clock.logical = 0
+		return
+	}
+
+	clock.physical = physical
+	clock.logical = logical + 1
+}
@@ -0,0 +1,139 @@
+package clock
+
+import (
+	"maps"
+	"math"
+	"testing"
+	"time"
+)
+
+// wallClock is a wall clock the test sets by hand.
+type wallClock struct {
+	now time.Time
+}
+
+func (wall *wallClock) read() time.Time {
+	return wall.now
+}
+
+func TestHybridClockStaysNearWallTimeUnderSkew(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	aheadWall := &wallClock{now: start.Add(2 * time.Second)}
+	behindWall := &wallClock{now: start}
+	ahead := NewHybrid(aheadWall.read)
+	behind := NewHybrid(behindWall.read)
+
+	sent := ahead.Tick()
+	received := behind.Merge(sent)
+	if !sent.Before(received) {
+		t.Fatalf("Receive at %v is not after the send at %v", received, sent)
+	}
+	if received.Physical != sent.Physical || received.Logical != sent.Logical+1 {
+		t.Fatalf("Receive behind in wall time is at %v, want %s with logical %d", received, time.UnixMilli(sent.Physical).Format("15:04:05.000"), sent.Logical+1)
+	}
+
+	local := behind.Tick()
+	if !received.Before(local) {
+		t.Fatalf("Local event at %v is not after the receive at %v", local, received)
+	}
+
+	behindWall.now = start.Add(3 * time.Second)
+	caughtUp := behind.Tick()
+	if caughtUp.Physical != behindWall.now.UnixMilli() || caughtUp.Logical != 0 {
+		t.Fatalf("Clock is at %v once its wall time passed the skew, want %v+0", caughtUp, behindWall.now.Format("15:04:05.000"))
+	}
+}
+
+func TestHybridClockKeepsLamportTime(t *testing.T) {
+	hybrid := NewHybrid((&wallClock{now: time.Now()}).read)
+
+	hybrid.Tick()
+	received := hybrid.Merge(Time{Lamport: 41})
+	if received.Lamport != 42 {
+		t.Fatalf("Lamport time after receiving LT41 is LT%d, want LT42", received.Lamport)
+	}
+}
+
+func TestVectorClockTracksEveryProcess(t *testing.T) {
+	alice, _ := New(KindVector, "alice")
+	bob, _ := New(KindVector, "bob")
+
+	sent := alice.Tick()
+	bob.Tick()
+	received := bob.Merge(sent)
+
+	want := map[string]int64{"alice": 1, "bob": 2}
+	if !maps.Equal(received.Vector, want) {
+		t.Fatalf("bob's vector is %v, want %v", received.Vector, want)
+	}
+	if !sent.Before(received) {
+		t.Fatalf("Send at %v is not before the receive at %v", sent, received)
+	}
+
+	concurrent := alice.Tick()
+	if concurrent.Before(received) || received.Before(concurrent) {
+		t.Fatalf("Concurrent events %v and %v are ordered", concurrent, received)
+	}
+}
+
+func TestTimeFormats(t *testing.T) {
+	tests := []struct {
+		name string
+		time Time
+		want string
+	}{
+		{"lamport", Time{Lamport: 37}, "LT37"},
+		{"vector", Time{Lamport: 3, Vector: map[string]int64{"server": 5, "alice": 2}}, "[alice:2 server:5]"},
+		{"hybrid", Time{Lamport: 3, Physical: time.Date(2024, 1, 1, 15, 4, 5, 6e6, time.Local).UnixMilli(), Logical: 2}, "15:04:05.006+2"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.time.String() != test.want {
+				t.Fatalf("Formatted as %s, want %s", test.time, test.want)
+			}
+			if roundTrip := FromProto(test.time.Lamport, test.time.Proto()); roundTrip.String() != test.want {
+				t.Fatalf("Round trip through the wire formats as %s, want %s", roundTrip, test.want)
+			}
+		})
+	}
+}
+
+func TestLegacyTimestamps(t *testing.T) {
+	tests := []struct {
+		name    string
+		lamport int64
+		legacy  int32
+		fits    bool
+	}{
+		{"small", 37, 37, true},
+		{"largest int32", math.MaxInt32, math.MaxInt32, true},
+		{"past int32", math.MaxInt32 + 1, math.MaxInt32, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			legacy, fits := Legacy(test.lamport)
+			if legacy != test.legacy || fits != test.fits {
+				t.Fatalf("Legacy(%d) = %d, %v, want %d, %v", test.lamport, legacy, fits, test.legacy, test.fits)
+			}
+		})
+	}
+
+	if widened := Widen(0, 37); widened != 37 {
+		t.Fatalf("Older sender's LT37 was read as LT%d", widened)
+	}
+	if widened := Widen(math.MaxInt32+5, math.MaxInt32); widened != math.MaxInt32+5 {
+		t.Fatalf("64-bit LT%d was read as LT%d", int64(math.MaxInt32)+5, widened)
+	}
+	if widened := Widen(0, math.MinInt32); widened != math.MaxInt32+1 {
+		t.Fatalf("Older sender that wrapped around past LT%d was read as LT%d", math.MaxInt32, widened)
+	}
+}
+
+func TestUnknownClockIsRejected(t *testing.T) {
+	_, newErr := New("sundial", "alice")
+	if newErr == nil {
+		t.Fatalf("Created a clock of unknown kind")
+	}
+}
@@ -0,0 +1,57 @@
+package harness
+
+import (
+	client "Chitty-Chat/Client"
+	clock "Chitty-Chat/Clock"
+	server "Chitty-Chat/Server"
+	"testing"
+	"time"
+)
+
+func TestHybridClockMessagesCarryWallTime(t *testing.T) {
+	harness := Start(t, server.ServerConfig{Clock: clock.KindHybrid})
+	alice := harness.NewClient("alice", client.ClientConfig{Clock: clock.KindHybrid})
+	bob := harness.NewClient("bob", client.ClientConfig{Clock: clock.KindHybrid})
+	for _, simulated := range []*Client{alice, bob} {
+		joinErr := simulated.Join()
+		if joinErr != nil {
+			t.Fatalf("%s could not join | %v", simulated.Username(), joinErr)
+		}
+		simulated.WaitForJoin(simulated.Username())
+	}
+
+	before := time.Now()
+	alice.Send("hello")
+	question := bob.WaitForMessage("alice", "hello").Message
+	sent := clock.FromProto(question.Lamport, question.Clock)
+	if drift := time.UnixMilli(sent.Physical).Sub(before); drift < -time.Second || drift > time.Second {
+		t.Fatalf("Message was stamped %v, %v away from the wall time", sent, drift)
+	}
+
+	if received := bob.Time(); !sent.Before(received) {
+		t.Fatalf("bob's clock is at %v after receiving a message stamped %v", received, sent)
+	}
+
+	bob.Send("hi")
+	answer := alice.WaitForMessage("bob", "hi").Message
+	if replied := clock.FromProto(answer.Lamport, answer.Clock); !sent.Before(replied) {
+		t.Fatalf("Reply was stamped %v, not after the message at %v it answers", replied, sent)
+	}
+}
+
+func TestVectorClockServerAndClientsExchangeVectors(t *testing.T) {
+	harness := Start(t, server.ServerConfig{Clock: clock.KindVector})
+	alice := harness.NewClient("alice", client.ClientConfig{Clock: clock.KindVector})
+	joinErr := alice.Join()
+	if joinErr != nil {
+		t.Fatalf("alice could not join | %v", joinErr)
+	}
+	alice.WaitForJoin("alice")
+
+	alice.Send("hello")
+	delivery := alice.WaitForMessage("alice", "hello")
+	stamped := clock.FromProto(delivery.Message.Lamport, delivery.Message.Clock)
+	if stamped.Vector["alice"] == 0 || stamped.Vector["server"] == 0 {
+		t.Fatalf("Message was stamped %v, want entries for alice and the server", stamped)
+	}
+}
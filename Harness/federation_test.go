@@ -0,0 +1,129 @@
+package harness
+
+import (
+	client "Chitty-Chat/Client"
+	proto "Chitty-Chat/GRPC"
+	server "Chitty-Chat/Server"
+	"strings"
+	"testing"
+)
+
+func waitForRemoteJoin(simulated *Client, username string) Delivery {
+	simulated.t.Helper()
+
+	return simulated.WaitFor("the remote join of "+username, func(message *proto.Chat) bool {
+		return message.Kind == proto.ChatKind_SYSTEM && strings.HasPrefix(message.Message, "User "+username+" joined on server")
+	})
+}
+
+func TestFederatedServersShareOneRoom(t *testing.T) {
+	servers := StartFederation(t, server.ServerConfig{Peers: []string{"server1"}}, server.ServerConfig{})
+	bob := servers[1].Join("bob")
+	bob.WaitForJoin("bob")
+	alice := servers[0].Join("alice")
+	waitForRemoteJoin(bob, "alice")
+
+	sendErr := alice.Send("hello from server0")
+	if sendErr != nil {
+		t.Fatalf("alice could not send | %v", sendErr)
+	}
+	received := bob.WaitForMessage("alice", "hello from server0")
+	sent := alice.WaitForMessage("alice", "hello from server0")
+	if received.Timestamp <= sent.Message.Lamport {
+		t.Fatalf("bob received at LT%d, not after server0 broadcast at LT%d", received.Timestamp, sent.Message.Lamport)
+	}
+
+	sendErr = bob.SendDirect("alice", "hello back")
+	if sendErr != nil {
+		t.Fatalf("bob could not send a direct message | %v", sendErr)
+	}
+	alice.WaitForMessage("bob", "hello back")
+
+	leaveErr := bob.Leave()
+	if leaveErr != nil {
+		t.Fatalf("bob could not leave | %v", leaveErr)
+	}
+	alice.WaitFor("bob leaving server1", func(message *proto.Chat) bool {
+		return strings.HasPrefix(message.Message, "User bob left server server1")
+	})
+}
+
+func TestFederationRelaysEachMessageOnce(t *testing.T) {
+	servers := StartFederation(t,
+		server.ServerConfig{Peers: []string{"server1", "server2"}},
+		server.ServerConfig{Peers: []string{"server2", "server0"}},
+		server.ServerConfig{Peers: []string{"server0"}},
+	)
+	carol := servers[2].Join("carol")
+	carol.WaitForJoin("carol")
+	alice := servers[0].Join("alice")
+	bob := servers[1].Join("bob")
+	waitForRemoteJoin(carol, "alice")
+	waitForRemoteJoin(carol, "bob")
+
+	for _, text := range []string{"first", "second"} {
+		sendErr := alice.Send(text)
+		if sendErr != nil {
+			t.Fatalf("alice could not send | %v", sendErr)
+		}
+	}
+	bob.WaitForMessage("alice", "second")
+	carol.WaitForMessage("alice", "second")
+
+	sendErr := bob.Send("done")
+	if sendErr != nil {
+		t.Fatalf("bob could not send | %v", sendErr)
+	}
+	for _, receiver := range []*Client{alice, carol} {
+		receiver.WaitForMessage("bob", "done")
+		messages := receiver.Messages()
+		if len(messages) != 3 {
+			t.Fatalf("%s received %d messages, want each of 3 once: %v", receiver.Username(), len(messages), messages)
+		}
+	}
+}
+
+func TestFederationRelaysAlongAChain(t *testing.T) {
+	servers := StartFederation(t,
+		server.ServerConfig{Peers: []string{"server1"}},
+		server.ServerConfig{Peers: []string{"server2"}},
+		server.ServerConfig{},
+	)
+	carol := servers[2].Join("carol")
+	carol.WaitForJoin("carol")
+	alice := servers[0].Join("alice")
+	waitForRemoteJoin(carol, "alice")
+
+	sendErr := alice.Send("across two links")
+	if sendErr != nil {
+		t.Fatalf("alice could not send | %v", sendErr)
+	}
+	carol.WaitForMessage("alice", "across two links")
+}
+
+func TestUsernameIsTakenAcrossServers(t *testing.T) {
+	servers := StartFederation(t, server.ServerConfig{Peers: []string{"server1"}}, server.ServerConfig{})
+	bob := servers[1].Join("bob")
+	bob.WaitForJoin("bob")
+	servers[0].Join("alice")
+	waitForRemoteJoin(bob, "alice")
+
+	impostor := servers[1].NewClient("alice", client.ClientConfig{})
+	joinErr := impostor.Join()
+	if joinErr == nil || !strings.Contains(joinErr.Error(), "already in the chat on server server0") {
+		t.Fatalf("Joining as alice on server1 returned %v, want already in the chat", joinErr)
+	}
+}
+
+func TestUsersLeaveWhenPeerGoesAway(t *testing.T) {
+	servers := StartFederation(t, server.ServerConfig{Peers: []string{"server1"}}, server.ServerConfig{})
+	bob := servers[1].Join("bob")
+	bob.WaitForJoin("bob")
+	servers[0].Join("alice")
+	waitForRemoteJoin(bob, "alice")
+
+	servers[0].Server.Shutdown()
+	bob.WaitFor("alice leaving with server0", func(message *proto.Chat) bool {
+		return strings.HasPrefix(message.Message, "User alice left")
+	})
+}
@@ -0,0 +1,171 @@
+package harness
+
+import (
+	proto "Chitty-Chat/GRPC"
+	server "Chitty-Chat/Server"
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func waitForFloor(simulated *Client, action proto.FloorAction, requester string) *proto.FloorEvent {
+	simulated.t.Helper()
+
+	delivery := simulated.WaitFor(action.String()+" of "+requester, func(message *proto.Chat) bool {
+		return message.Kind == proto.ChatKind_FLOOR && message.Floor.Action == action && message.Floor.Requester == requester
+	})
+
+	return delivery.Message.Floor
+}
+
+func TestOnlyTheFloorHolderMaySpeak(t *testing.T) {
+	harness := Start(t, server.ServerConfig{FloorControl: true})
+	clients := harness.JoinAll("alice", "bob")
+	alice, bob := clients[0], clients[1]
+
+	sendErr := alice.Send("too early")
+	if status.Code(sendErr) != codes.PermissionDenied {
+		t.Fatalf("Sending without the floor returned %v, want PermissionDenied", sendErr)
+	}
+
+	raiseErr := alice.RaiseHand()
+	if raiseErr != nil {
+		t.Fatalf("alice could not ask for the floor | %v", raiseErr)
+	}
+	waitForFloor(alice, proto.FloorAction_TAKEN, "alice")
+	sendErr = alice.Send("my talk")
+	if sendErr != nil {
+		t.Fatalf("alice could not speak with the floor | %v", sendErr)
+	}
+	bob.WaitForMessage("alice", "my talk")
+
+	raiseErr = bob.RaiseHand()
+	if raiseErr != nil {
+		t.Fatalf("bob could not ask for the floor | %v", raiseErr)
+	}
+	waitForFloor(alice, proto.FloorAction_REQUEST, "bob")
+	sendErr = bob.Send("interrupting")
+	if status.Code(sendErr) != codes.PermissionDenied {
+		t.Fatalf("bob sent while alice had the floor, got %v", sendErr)
+	}
+
+	yieldErr := alice.Yield()
+	if yieldErr != nil {
+		t.Fatalf("alice could not yield | %v", yieldErr)
+	}
+	waitForFloor(bob, proto.FloorAction_TAKEN, "bob")
+	sendErr = bob.Send("my question")
+	if sendErr != nil {
+		t.Fatalf("bob could not speak with the floor | %v", sendErr)
+	}
+	sendErr = alice.Send("done?")
+	if status.Code(sendErr) != codes.PermissionDenied {
+		t.Fatalf("alice sent after yielding, got %v", sendErr)
+	}
+}
+
+func TestFloorPassesInRequestTimestampOrder(t *testing.T) {
+	harness := Start(t, server.ServerConfig{FloorControl: true})
+	clients := harness.JoinAll("alice", "bob", "carol", "dave")
+	alice := clients[0]
+	byName := make(map[string]*Client)
+	for _, simulated := range clients {
+		byName[simulated.Username()] = simulated
+	}
+
+	alice.RaiseHand()
+	waitForFloor(alice, proto.FloorAction_TAKEN, "alice")
+
+	var raising sync.WaitGroup
+	for _, simulated := range clients[1:] {
+		raising.Add(1)
+		go func() {
+			defer raising.Done()
+			simulated.RaiseHand()
+		}()
+	}
+	raising.Wait()
+
+	var requests []*proto.FloorEvent
+	for _, simulated := range clients[1:] {
+		requests = append(requests, waitForFloor(alice, proto.FloorAction_REQUEST, simulated.Username()))
+	}
+	slices.SortFunc(requests, func(a *proto.FloorEvent, b *proto.FloorEvent) int {
+		return cmp.Or(cmp.Compare(a.RequestTimestamp, b.RequestTimestamp), cmp.Compare(a.Requester, b.Requester))
+	})
+
+	alice.Yield()
+	var takenOrder []string
+	for range requests {
+		taken := alice.WaitFor("the next floor holder", func(message *proto.Chat) bool {
+			return message.Kind == proto.ChatKind_FLOOR && message.Floor.Action == proto.FloorAction_TAKEN &&
+				message.Floor.Requester != "alice" && !slices.Contains(takenOrder, message.Floor.Requester)
+		})
+		holder := byName[taken.Message.Floor.Requester]
+		takenOrder = append(takenOrder, holder.Username())
+		waitForFloor(holder, proto.FloorAction_TAKEN, holder.Username())
+		holder.Yield()
+	}
+
+	var wantOrder []string
+	for _, request := range requests {
+		wantOrder = append(wantOrder, request.Requester)
+	}
+	if !slices.Equal(takenOrder, wantOrder) {
+		t.Fatalf("Floor went to %v, want the order of the request timestamps %v", takenOrder, wantOrder)
+	}
+}
+
+func TestLeavingHolderPassesTheFloor(t *testing.T) {
+	harness := Start(t, server.ServerConfig{FloorControl: true})
+	clients := harness.JoinAll("alice", "bob")
+	alice, bob := clients[0], clients[1]
+
+	alice.RaiseHand()
+	waitForFloor(bob, proto.FloorAction_TAKEN, "alice")
+	bob.RaiseHand()
+	waitForFloor(alice, proto.FloorAction_REQUEST, "bob")
+
+	leaveErr := alice.Leave()
+	if leaveErr != nil {
+		t.Fatalf("alice could not leave | %v", leaveErr)
+	}
+	waitForFloor(bob, proto.FloorAction_TAKEN, "bob")
+	sendErr := bob.Send("my turn")
+	if sendErr != nil {
+		t.Fatalf("bob could not speak after alice left | %v", sendErr)
+	}
+}
+
+func TestNonHolderCannotSpeakAsTheHolder(t *testing.T) {
+	harness := Start(t, server.ServerConfig{FloorControl: true})
+	alice := harness.Join("alice")
+	alice.WaitForJoin("alice")
+	alice.RaiseHand()
+	waitForFloor(alice, proto.FloorAction_TAKEN, "alice")
+
+	// mallory joins once alice has the floor, as the legacy client does not
+	// acknowledge floor requests.
+	mallory := joinLegacy(t, harness, "mallory", 1)
+	alice.WaitForJoin("mallory")
+
+	_, sendErr := mallory.service.BroadcastMessage(context.Background(), &proto.Chat{Username: "alice", Message: "as alice"})
+	if status.Code(sendErr) != codes.PermissionDenied {
+		t.Fatalf("mallory sending as the floor holder returned %v, want PermissionDenied", sendErr)
+	}
+
+	sendErr = alice.Send("my talk")
+	if sendErr != nil {
+		t.Fatalf("alice could not speak with the floor | %v", sendErr)
+	}
+	for _, delivery := range alice.WaitForCount(1) {
+		if delivery.Message.Message != "my talk" {
+			t.Errorf("Impersonated message was broadcast: %q", delivery.Message.Message)
+		}
+	}
+}
@@ -0,0 +1,495 @@
+// Package harness runs a ChatServer on an in-memory bufconn listener and
+// connects simulated clients to it, so tests can exercise the server and
+// client together without opening any network ports.
+package harness
+
+import (
+	client "Chitty-Chat/Client"
+	proto "Chitty-Chat/GRPC"
+	raft "Chitty-Chat/Raft"
+	server "Chitty-Chat/Server"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const bufferSize = 1 << 20
+
+// WaitTimeout is how long the Wait helpers wait before failing the test.
+var WaitTimeout = 5 * time.Second
+
+type Harness struct {
+	Server   *server.ChatServer
+	listener *bufconn.Listener
+	t        testing.TB
+}
+
+// Start serves a ChatServer with the given configuration until the test
+// ends. The data directory defaults to a temporary directory.
+func Start(t testing.TB, config server.ServerConfig) *Harness {
+	t.Helper()
+
+	return start(t, config, bufconn.Listen(bufferSize))
+}
+
+// StartFederation serves one ChatServer per configuration, named
+// "server0", "server1" and so on unless they have a ServerID, and waits
+// until they have linked up. Peers may be given by those names. The
+// servers share a federation secret unless they have one.
+func StartFederation(t testing.TB, configs ...server.ServerConfig) []*Harness {
+	t.Helper()
+
+	listeners := make(map[string]*bufconn.Listener)
+	for i := range configs {
+		if configs[i].ServerID == "" {
+			configs[i].ServerID = fmt.Sprintf("server%d", i)
+		}
+		if configs[i].FederationSecret == "" {
+			configs[i].FederationSecret = "harness"
+		}
+		listeners[configs[i].ServerID] = bufconn.Listen(bufferSize)
+	}
+	dialer := namedDialer(listeners)
+
+	neighbours := make(map[string]map[string]bool)
+	for _, config := range configs {
+		neighbours[config.ServerID] = make(map[string]bool)
+	}
+	for i := range configs {
+		peers := make([]string, 0, len(configs[i].Peers))
+		for _, peerId := range configs[i].Peers {
+			if neighbours[peerId] == nil {
+				t.Fatalf("%s peers with unknown server %s", configs[i].ServerID, peerId)
+			}
+			neighbours[configs[i].ServerID][peerId] = true
+			neighbours[peerId][configs[i].ServerID] = true
+			peers = append(peers, "passthrough:///"+peerId)
+		}
+		configs[i].Peers = peers
+		configs[i].PeerDialOptions = append(configs[i].PeerDialOptions, dialer)
+	}
+
+	harnesses := make([]*Harness, len(configs))
+	for i, config := range configs {
+		harnesses[i] = start(t, config, listeners[config.ServerID])
+	}
+
+	deadline := time.Now().Add(WaitTimeout)
+	for i, config := range configs {
+		for !linkedTo(harnesses[i].Server.FederationPeers(), neighbours[config.ServerID]) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s linked with %v, want %v", config.ServerID, harnesses[i].Server.FederationPeers(), neighbours[config.ServerID])
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	return harnesses
+}
+
+// namedDialer connects to the listener named by the address being dialed.
+func namedDialer(listeners map[string]*bufconn.Listener) grpc.DialOption {
+	return grpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) {
+		listener, isKnown := listeners[address]
+		if !isKnown {
+			return nil, fmt.Errorf("no server named %s", address)
+		}
+		return listener.DialContext(ctx)
+	})
+}
+
+// Cluster is a group of servers named "server0", "server1" and so on that
+// clients fail over between in that order: a primary and its backups, or
+// the members of a Raft cluster connected through Network.
+type Cluster struct {
+	Servers []*Harness
+	Network *raft.MemoryNetwork
+	dialer  grpc.DialOption
+	t       testing.TB
+}
+
+func newCluster(t testing.TB, size int) (*Cluster, []string, map[string]*bufconn.Listener) {
+	names := make([]string, size)
+	listeners := make(map[string]*bufconn.Listener)
+	for i := range names {
+		names[i] = fmt.Sprintf("server%d", i)
+		listeners[names[i]] = bufconn.Listen(bufferSize)
+	}
+
+	return &Cluster{dialer: namedDialer(listeners), t: t}, names, listeners
+}
+
+// StartReplicaSet serves count ChatServers with the given configuration,
+// the first as primary and the others as its backups, and waits until every
+// backup follows the primary. Heartbeats and failover are fast unless the
+// configuration says otherwise.
+func StartReplicaSet(t testing.TB, count int, config server.ServerConfig) *Cluster {
+	t.Helper()
+
+	if config.ReplicationHeartbeat == 0 {
+		config.ReplicationHeartbeat = 20 * time.Millisecond
+	}
+	if config.FailoverTimeout == 0 {
+		config.FailoverTimeout = 200 * time.Millisecond
+	}
+
+	replicas, names, listeners := newCluster(t, count)
+	for i, name := range names {
+		replicaConfig := config
+		replicaConfig.ServerID = name
+		replicaConfig.ReplicaOf = nil
+		replicaConfig.Replicas = names[i+1:]
+		for _, primary := range names[:i] {
+			replicaConfig.ReplicaOf = append(replicaConfig.ReplicaOf, "passthrough:///"+primary)
+		}
+		replicaConfig.ReplicaDialOptions = append(slices.Clone(config.ReplicaDialOptions), replicas.dialer)
+		replicas.Servers = append(replicas.Servers, start(t, replicaConfig, listeners[name]))
+	}
+
+	deadline := time.Now().Add(WaitTimeout)
+	for !slices.Equal(replicas.Servers[0].Server.Backups(), names[1:]) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Primary has backups %v, want %v", replicas.Servers[0].Server.Backups(), names[1:])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return replicas
+}
+
+// StartRaftCluster serves size ChatServers that form a Raft cluster over
+// an in-memory network, and waits until one of them leads. Elections are
+// fast unless the configuration says otherwise.
+func StartRaftCluster(t testing.TB, size int, config server.ServerConfig) *Cluster {
+	t.Helper()
+
+	if config.RaftElectionTimeout == 0 {
+		config.RaftElectionTimeout = 50 * time.Millisecond
+	}
+	if config.RaftHeartbeat == 0 {
+		config.RaftHeartbeat = 10 * time.Millisecond
+	}
+
+	cluster, names, listeners := newCluster(t, size)
+	cluster.Network = raft.NewMemoryNetwork()
+	for _, name := range names {
+		memberConfig := config
+		memberConfig.ServerID = name
+		memberConfig.RaftPeers = slices.DeleteFunc(slices.Clone(names), func(peer string) bool { return peer == name })
+		memberConfig.RaftTransport = cluster.Network.Transport(name)
+
+		member := start(t, memberConfig, listeners[name])
+		cluster.Network.Add(member.Server.RaftNode())
+		cluster.Servers = append(cluster.Servers, member)
+	}
+	cluster.Leader()
+
+	return cluster
+}
+
+// Leader waits until exactly one of the given servers, or of all of them,
+// accepts clients and returns it.
+func (cluster *Cluster) Leader(among ...*Harness) *Harness {
+	cluster.t.Helper()
+
+	if len(among) == 0 {
+		among = cluster.Servers
+	}
+	deadline := time.Now().Add(WaitTimeout)
+	for time.Now().Before(deadline) {
+		var leaders []*Harness
+		for _, member := range among {
+			if member.Server.IsPrimary() {
+				leaders = append(leaders, member)
+			}
+		}
+		if len(leaders) == 1 {
+			return leaders[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cluster.t.Fatalf("No single leader was elected")
+
+	return nil
+}
+
+// NewClient creates a client for username that connects to server0 and
+// fails over to the other servers in order, without joining the chat.
+func (cluster *Cluster) NewClient(username string, config client.ClientConfig) *Client {
+	cluster.t.Helper()
+
+	config.Address = "server0"
+	config.FailoverAddresses = nil
+	for i := 1; i < len(cluster.Servers); i++ {
+		config.FailoverAddresses = append(config.FailoverAddresses, fmt.Sprintf("server%d", i))
+	}
+	config.DialOptions = append(config.DialOptions, cluster.dialer)
+
+	return newClient(cluster.t, username, config)
+}
+
+func linkedTo(peers []string, want map[string]bool) bool {
+	for peerId := range want {
+		if !slices.Contains(peers, peerId) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func start(t testing.TB, config server.ServerConfig, listener *bufconn.Listener) *Harness {
+	t.Helper()
+
+	if config.DataDirectory == "" {
+		config.DataDirectory = t.TempDir()
+	}
+	if config.ShutdownTimeout == 0 {
+		config.ShutdownTimeout = time.Second
+	}
+
+	chatServer, serverErr := server.NewChatServer(config)
+	if serverErr != nil {
+		t.Fatalf("Failed to create server | %v", serverErr)
+	}
+
+	go chatServer.Serve(&peerListener{Listener: listener})
+	t.Cleanup(chatServer.Shutdown)
+
+	return &Harness{Server: chatServer, listener: listener, t: t}
+}
+
+// peerListener gives every connection it accepts an address of its own, as
+// TCP does, so that the server tells the connections of different clients
+// apart. bufconn calls them all "bufconn".
+type peerListener struct {
+	net.Listener
+	accepted atomic.Int64
+}
+
+func (listener *peerListener) Accept() (net.Conn, error) {
+	connection, acceptErr := listener.Listener.Accept()
+	if acceptErr != nil {
+		return nil, acceptErr
+	}
+
+	return &peerConn{Conn: connection, remote: peerAddr(fmt.Sprintf("bufconn:%d", listener.accepted.Add(1)))}, nil
+}
+
+type peerConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (connection *peerConn) RemoteAddr() net.Addr {
+	return connection.remote
+}
+
+type peerAddr string
+
+func (address peerAddr) Network() string {
+	return "bufconn"
+}
+
+func (address peerAddr) String() string {
+	return string(address)
+}
+
+// DialOptions connect a gRPC client to the in-memory listener. Use them
+// with the address "passthrough:///bufconn".
+func (harness *Harness) DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return harness.listener.DialContext(ctx)
+		}),
+	}
+}
+
+// NewClient creates a client for username without joining the chat. The
+// configuration's address, username, dial options and message hook are
+// filled in by the harness.
+func (harness *Harness) NewClient(username string, config client.ClientConfig) *Client {
+	harness.t.Helper()
+
+	config.Address = "passthrough:///bufconn"
+	config.DialOptions = append(config.DialOptions, harness.DialOptions()...)
+
+	return newClient(harness.t, username, config)
+}
+
+func newClient(t testing.TB, username string, config client.ClientConfig) *Client {
+	t.Helper()
+
+	simulated := &Client{t: t, changed: make(chan struct{})}
+	config.Username = username
+	config.OnMessage = simulated.record
+	if config.Output == nil {
+		config.Output = io.Discard
+	}
+
+	chatClient, clientErr := client.NewChatClient(config)
+	if clientErr != nil {
+		t.Fatalf("Failed to create client %s | %v", username, clientErr)
+	}
+	t.Cleanup(func() { chatClient.Close() })
+	simulated.ChatClient = chatClient
+
+	return simulated
+}
+
+// Join creates a client for username and joins the chat with it.
+func (harness *Harness) Join(username string) *Client {
+	harness.t.Helper()
+
+	simulated := harness.NewClient(username, client.ClientConfig{})
+	joinErr := simulated.Join()
+	if joinErr != nil {
+		harness.t.Fatalf("%s could not join | %v", username, joinErr)
+	}
+
+	return simulated
+}
+
+// JoinAll joins one client per username, each after the previous one has
+// seen its own join message, so the join order is deterministic.
+func (harness *Harness) JoinAll(usernames ...string) []*Client {
+	harness.t.Helper()
+
+	clients := make([]*Client, 0, len(usernames))
+	for _, username := range usernames {
+		simulated := harness.Join(username)
+		simulated.WaitForJoin(username)
+		clients = append(clients, simulated)
+	}
+
+	return clients
+}
+
+// Delivery is a message received by a simulated client together with the
+// client's Lamport time after receiving it.
+type Delivery struct {
+	Message   *proto.Chat
+	Timestamp int64
+}
+
+type Client struct {
+	*client.ChatClient
+	t testing.TB
+
+	mutex      sync.Mutex
+	deliveries []Delivery
+	changed    chan struct{}
+}
+
+func (simulated *Client) record(message *proto.Chat, timestamp int64) {
+	simulated.mutex.Lock()
+	defer simulated.mutex.Unlock()
+
+	simulated.deliveries = append(simulated.deliveries, Delivery{Message: message, Timestamp: timestamp})
+	close(simulated.changed)
+	simulated.changed = make(chan struct{})
+}
+
+// Deliveries returns everything the client has received so far.
+func (simulated *Client) Deliveries() []Delivery {
+	simulated.mutex.Lock()
+	defer simulated.mutex.Unlock()
+
+	return append([]Delivery(nil), simulated.deliveries...)
+}
+
+// Messages returns the user messages the client has received, leaving out
+// the server's join, leave and shutdown notices.
+func (simulated *Client) Messages() []Delivery {
+	var messages []Delivery
+	for _, delivery := range simulated.Deliveries() {
+		if delivery.Message.Kind == proto.ChatKind_MESSAGE {
+			messages = append(messages, delivery)
+		}
+	}
+
+	return messages
+}
+
+// WaitFor waits until the client has received a message matching the
+// predicate and returns its delivery, failing the test after WaitTimeout.
+func (simulated *Client) WaitFor(description string, predicate func(*proto.Chat) bool) Delivery {
+	simulated.t.Helper()
+
+	timeout := time.After(WaitTimeout)
+	for {
+		simulated.mutex.Lock()
+		for _, delivery := range simulated.deliveries {
+			if predicate(delivery.Message) {
+				simulated.mutex.Unlock()
+				return delivery
+			}
+		}
+		changed := simulated.changed
+		simulated.mutex.Unlock()
+
+		select {
+		case <-changed:
+		case <-timeout:
+			simulated.t.Fatalf("%s never received %s, got %v", simulated.Username(), description, simulated.Deliveries())
+		}
+	}
+}
+
+func (simulated *Client) WaitForMessage(from string, text string) Delivery {
+	simulated.t.Helper()
+
+	return simulated.WaitFor(from+": "+text, func(message *proto.Chat) bool {
+		return message.Kind == proto.ChatKind_MESSAGE && message.Username == from && message.Message == text
+	})
+}
+
+func (simulated *Client) WaitForJoin(username string) Delivery {
+	simulated.t.Helper()
+
+	return simulated.WaitFor("the join of "+username, func(message *proto.Chat) bool {
+		return message.Kind == proto.ChatKind_SYSTEM && strings.HasPrefix(message.Message, "User "+username+" join request received at LT")
+	})
+}
+
+func (simulated *Client) WaitForLeave(username string) Delivery {
+	simulated.t.Helper()
+
+	return simulated.WaitFor("the leave of "+username, func(message *proto.Chat) bool {
+		return message.Kind == proto.ChatKind_SYSTEM && strings.HasPrefix(message.Message, "User "+username+" leave request received at LT")
+	})
+}
+
+// WaitForCount waits until the client has received at least count user
+// messages and returns them.
+func (simulated *Client) WaitForCount(count int) []Delivery {
+	simulated.t.Helper()
+
+	timeout := time.After(WaitTimeout)
+	for {
+		simulated.mutex.Lock()
+		changed := simulated.changed
+		simulated.mutex.Unlock()
+
+		messages := simulated.Messages()
+		if len(messages) >= count {
+			return messages
+		}
+
+		select {
+		case <-changed:
+		case <-timeout:
+			simulated.t.Fatalf("%s received %d message(s), want %d", simulated.Username(), len(messages), count)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package harness
+
+import (
+	client "Chitty-Chat/Client"
+	proto "Chitty-Chat/GRPC"
+	server "Chitty-Chat/Server"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func usernames(count int) []string {
+	names := make([]string, count)
+	for i := range names {
+		names[i] = fmt.Sprintf("user%d", i)
+	}
+
+	return names
+}
+
+func TestJoinAndLeaveAreAnnounced(t *testing.T) {
+	harness := Start(t, server.ServerConfig{})
+	clients := harness.JoinAll("alice", "bob", "carol")
+
+	for _, later := range []string{"bob", "carol"} {
+		clients[0].WaitForJoin(later)
+	}
+
+	leaveErr := clients[2].Leave()
+	if leaveErr != nil {
+		t.Fatalf("carol could not leave | %v", leaveErr)
+	}
+	for _, remaining := range clients[:2] {
+		notice := remaining.WaitForLeave("carol")
+		if notice.Message.Username != "Server" {
+			t.Errorf("Leave notice was sent by %s, want Server", notice.Message.Username)
+		}
+	}
+}
+
+func TestBroadcastReachesEveryClientInOneOrder(t *testing.T) {
+	const clientCount = 8
+	harness := Start(t, server.ServerConfig{})
+	clients := harness.JoinAll(usernames(clientCount)...)
+
+	for i, sender := range clients {
+		sendErr := sender.Send(fmt.Sprintf("hello from %d", i))
+		if sendErr != nil {
+			t.Fatalf("%s could not send | %v", sender.Username(), sendErr)
+		}
+	}
+
+	var firstOrder []int64
+	for _, receiver := range clients {
+		messages := receiver.WaitForCount(clientCount)
+		if len(messages) != clientCount {
+			t.Fatalf("%s received %d messages, want %d", receiver.Username(), len(messages), clientCount)
+		}
+
+		var order []int64
+		for _, delivery := range messages {
+			order = append(order, delivery.Message.Id)
+		}
+		if firstOrder == nil {
+			firstOrder = order
+		} else if fmt.Sprint(order) != fmt.Sprint(firstOrder) {
+			t.Errorf("%s received messages in order %v, %s received %v", receiver.Username(), order, clients[0].Username(), firstOrder)
+		}
+	}
+}
+
+func TestLamportTimestamps(t *testing.T) {
+	harness := Start(t, server.ServerConfig{})
+	clients := harness.JoinAll(usernames(4)...)
+
+	for round := 0; round < 3; round++ {
+		for _, sender := range clients {
+			sender.Send(fmt.Sprintf("round %d", round))
+		}
+	}
+
+	for _, receiver := range clients {
+		receiver.WaitForCount(3 * len(clients))
+
+		var previous Delivery
+		for i, delivery := range receiver.Deliveries() {
+			if delivery.Timestamp <= delivery.Message.Lamport {
+				t.Errorf("%s received #%d sent at LT%d at LT%d", receiver.Username(), delivery.Message.Id, delivery.Message.Lamport, delivery.Timestamp)
+			}
+			if i > 0 && delivery.Message.Lamport <= previous.Message.Lamport {
+				t.Errorf("%s received #%d at server LT%d after #%d at LT%d", receiver.Username(), delivery.Message.Id, delivery.Message.Lamport, previous.Message.Id, previous.Message.Lamport)
+			}
+			if i > 0 && delivery.Timestamp <= previous.Timestamp {
+				t.Errorf("%s's clock went from LT%d to LT%d", receiver.Username(), previous.Timestamp, delivery.Timestamp)
+			}
+			previous = delivery
+		}
+
+		if receiver.Timestamp() < previous.Timestamp {
+			t.Errorf("%s's clock is LT%d, behind its last receive at LT%d", receiver.Username(), receiver.Timestamp(), previous.Timestamp)
+		}
+	}
+}
+
+func TestSendTimestampIsBelowServerTimestamp(t *testing.T) {
+	harness := Start(t, server.ServerConfig{})
+	clients := harness.JoinAll("alice", "bob")
+
+	before := clients[0].Timestamp()
+	clients[0].Send("ping")
+	delivery := clients[1].WaitForMessage("alice", "ping")
+
+	if delivery.Message.Lamport <= before+1 {
+		t.Errorf("Server broadcast alice's message sent at LT%d at LT%d", before+1, delivery.Message.Lamport)
+	}
+}
+
+func TestDirectMessageOnlyReachesRecipient(t *testing.T) {
+	harness := Start(t, server.ServerConfig{})
+	clients := harness.JoinAll("alice", "bob", "carol")
+
+	sendErr := clients[0].SendDirect("carol", "psst")
+	if sendErr != nil {
+		t.Fatalf("alice could not send a direct message | %v", sendErr)
+	}
+	clients[0].Send("done")
+
+	clients[2].WaitForMessage("alice", "psst")
+	clients[0].WaitForMessage("alice", "psst")
+	clients[1].WaitForMessage("alice", "done")
+	for _, delivery := range clients[1].Messages() {
+		if delivery.Message.Message == "psst" {
+			t.Errorf("bob received alice's direct message to carol")
+		}
+	}
+}
+
+// lockedBuffer collects client output written from several goroutines.
+type lockedBuffer struct {
+	mutex   sync.Mutex
+	builder strings.Builder
+}
+
+func (buffer *lockedBuffer) Write(data []byte) (int, error) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	return buffer.builder.Write(data)
+}
+
+func (buffer *lockedBuffer) String() string {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	return buffer.builder.String()
+}
+
+func TestRunLeavesWhenInputEnds(t *testing.T) {
+	harness := Start(t, server.ServerConfig{})
+	observer := harness.JoinAll("observer")[0]
+
+	output := &lockedBuffer{}
+	runner := harness.NewClient("alice", client.ClientConfig{Output: output})
+	runErr := runner.Run(strings.NewReader("\nhello\n/unknown\n"))
+	if runErr != nil {
+		t.Fatalf("Run failed | %v", runErr)
+	}
+
+	observer.WaitForMessage("alice", "hello")
+	observer.WaitForLeave("alice")
+	for _, expected := range []string{"Input was empty", "Unknown command /unknown", "Successfully left the chat"} {
+		if !strings.Contains(output.String(), expected) {
+			t.Errorf("Output does not contain %q:\n%s", expected, output.String())
+		}
+	}
+}
+
+func TestMsgCommandAcceptsAnyWhitespace(t *testing.T) {
+	harness := Start(t, server.ServerConfig{})
+	bob := harness.JoinAll("bob")[0]
+
+	runner := harness.NewClient("alice", client.ClientConfig{})
+	runErr := runner.Run(strings.NewReader("/msg\tbob\thi there\n/msg  bob   two  spaces\n"))
+	if runErr != nil {
+		t.Fatalf("Run failed | %v", runErr)
+	}
+
+	for _, text := range []string{"hi there", "two  spaces"} {
+		delivery := bob.WaitForMessage("alice", text)
+		if delivery.Message.Recipient != "bob" {
+			t.Errorf("%q was sent to %q, want bob", text, delivery.Message.Recipient)
+		}
+	}
+}
+
+func TestSubSecondModerationDurationsAreRejected(t *testing.T) {
+	harness := Start(t, server.ServerConfig{Owner: "olivia"})
+	observer := harness.JoinAll("bob")[0]
+
+	output := &lockedBuffer{}
+	owner := harness.NewClient("olivia", client.ClientConfig{Output: output})
+	runErr := owner.Run(strings.NewReader("/slowmode 500ms\n/mute bob 1500ms\nbye\n"))
+	if runErr != nil {
+		t.Fatalf("Run failed | %v", runErr)
+	}
+
+	observer.WaitForMessage("olivia", "bye")
+	for _, expected := range []string{"Invalid interval 500ms", "Invalid duration 1500ms"} {
+		if !strings.Contains(output.String(), expected) {
+			t.Errorf("Output does not contain %q:\n%s", expected, output.String())
+		}
+	}
+	for _, delivery := range observer.Deliveries() {
+		if strings.Contains(delivery.Message.Message, "Slow mode") || strings.Contains(delivery.Message.Message, "muted") {
+			t.Errorf("Sub-second duration was sent to the server: %q", delivery.Message.Message)
+		}
+	}
+}
+
+func TestRejectedMessageIsReported(t *testing.T) {
+	harness := Start(t, server.ServerConfig{MaxMessageLength: 5})
+	alice := harness.JoinAll("alice")[0]
+
+	sendErr := alice.Send("far too long")
+	if sendErr == nil {
+		t.Fatal("Server accepted a message over the length limit")
+	}
+
+	alice.Send("short")
+	for _, delivery := range alice.WaitForCount(1) {
+		if delivery.Message.Kind == proto.ChatKind_MESSAGE && delivery.Message.Message != "short" {
+			t.Errorf("Rejected message was broadcast: %q", delivery.Message.Message)
+		}
+	}
+}
+
+func TestMutedUserCannotSendAsSomeoneElse(t *testing.T) {
+	harness := Start(t, server.ServerConfig{Owner: "olivia"})
+	alice := harness.Join("alice")
+	alice.WaitForJoin("alice")
+	mallory := joinLegacy(t, harness, "mallory", 1)
+	alice.WaitForJoin("mallory")
+
+	owner := harness.NewClient("olivia", client.ClientConfig{})
+	runErr := owner.Run(strings.NewReader("/mute mallory 10m\n"))
+	if runErr != nil {
+		t.Fatalf("Run failed | %v", runErr)
+	}
+	alice.WaitFor("mute of mallory", func(message *proto.Chat) bool {
+		return message.Kind == proto.ChatKind_SYSTEM && strings.Contains(message.Message, "User mallory was muted")
+	})
+	alice.WaitForLeave("olivia")
+
+	_, sendErr := mallory.service.BroadcastMessage(context.Background(), &proto.Chat{Username: "alice", Message: "as alice"})
+	if status.Code(sendErr) != codes.PermissionDenied {
+		t.Errorf("mallory sending as alice returned %v, want PermissionDenied", sendErr)
+	}
+	_, sendErr = mallory.service.BroadcastMessage(context.Background(), &proto.Chat{Username: "olivia", Message: "as olivia"})
+	if status.Code(sendErr) != codes.FailedPrecondition {
+		t.Errorf("mallory sending as the departed olivia returned %v, want FailedPrecondition", sendErr)
+	}
+
+	alice.Send("done")
+	alice.WaitForMessage("alice", "done")
+	for _, delivery := range alice.Messages() {
+		if delivery.Message.Message != "done" {
+			t.Errorf("Impersonated message was broadcast: %q", delivery.Message.Message)
+		}
+	}
+}
+
+func TestOfflineMessagesAreQueuedWithTheDefaultConfig(t *testing.T) {
+	harness := Start(t, server.ServerConfig{})
+	clients := harness.JoinAll("alice", "carol")
+	alice, carol := clients[0], clients[1]
+
+	leaveErr := carol.Leave()
+	if leaveErr != nil {
+		t.Fatalf("carol could not leave | %v", leaveErr)
+	}
+	alice.WaitForLeave("carol")
+
+	sendErr := alice.SendDirect("carol", "psst")
+	if sendErr != nil {
+		t.Fatalf("alice could not send a direct message | %v", sendErr)
+	}
+	sendErr = alice.Send("see you @carol")
+	if sendErr != nil {
+		t.Fatalf("alice could not mention carol | %v", sendErr)
+	}
+
+	rejoined := harness.Join("carol")
+	direct := rejoined.WaitForMessage("alice", "psst")
+	mention := rejoined.WaitForMessage("alice", "see you @carol")
+	if direct.Message.Lamport >= mention.Message.Lamport {
+		t.Errorf("Direct message at LT%d was not flushed before the mention at LT%d", direct.Message.Lamport, mention.Message.Lamport)
+	}
+	var order []string
+	for _, delivery := range rejoined.Messages() {
+		order = append(order, delivery.Message.Message)
+	}
+	if fmt.Sprint(order) != fmt.Sprint([]string{"psst", "see you @carol"}) {
+		t.Errorf("carol received %q, want the direct message and then the mention", order)
+	}
+}
+
+func TestMentionsAreHighlightedAndListed(t *testing.T) {
+	harness := Start(t, server.ServerConfig{})
+	bob := harness.JoinAll("bob")[0]
+
+	output := &lockedBuffer{}
+	alice := harness.NewClient("alice", client.ClientConfig{Output: output, RingBell: true})
+	input, typing := io.Pipe()
+	finished := make(chan error, 1)
+	go func() { finished <- alice.Run(input) }()
+	bob.WaitForJoin("alice")
+
+	bob.Send("hi @alice")
+	bob.Send("hi everyone")
+	alice.WaitForMessage("bob", "hi everyone")
+	fmt.Fprintln(typing, "/mentions")
+	typing.Close()
+	runErr := <-finished
+	if runErr != nil {
+		t.Fatalf("Run failed | %v", runErr)
+	}
+
+	printed := output.String()
+	if count := strings.Count(printed, "\a"); count != 1 {
+		t.Errorf("Bell rang %d times, want once for the one mention:\n%q", count, printed)
+	}
+	if !regexp.MustCompile("\033\\[1;33m[^\n]*bob: hi @alice\033\\[0m").MatchString(printed) {
+		t.Errorf("Mention is not highlighted:\n%q", printed)
+	}
+	if strings.Count(printed, "\033[1;33m") != 1 {
+		t.Errorf("More than the mention was highlighted:\n%q", printed)
+	}
+	if count := strings.Count(printed, "bob: hi @alice"); count != 2 {
+		t.Errorf("Mention was printed %d times, want once when received and once by /mentions:\n%q", count, printed)
+	}
+}
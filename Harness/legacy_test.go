@@ -0,0 +1,133 @@
+package harness
+
+import (
+	proto "Chitty-Chat/GRPC"
+	server "Chitty-Chat/Server"
+	"context"
+	"math"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// legacyClient speaks the protocol of clients from before 64-bit Lamport
+// times: it only sets and reads the int32 timestamps.
+type legacyClient struct {
+	t       *testing.T
+	service proto.ChatServiceClient
+	stream  proto.ChatService_JoinChatClient
+}
+
+func joinLegacy(t *testing.T, harness *Harness, username string, timestamp int32) *legacyClient {
+	t.Helper()
+
+	connection, connectErr := grpc.NewClient("passthrough:///bufconn",
+		append(harness.DialOptions(), grpc.WithTransportCredentials(insecure.NewCredentials()))...)
+	if connectErr != nil {
+		t.Fatalf("Connecting %s failed | %v", username, connectErr)
+	}
+	t.Cleanup(func() { connection.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	service := proto.NewChatServiceClient(connection)
+	stream, joinErr := service.JoinChat(ctx, &proto.UserRequest{Username: username, Timestamp: timestamp})
+	if joinErr != nil {
+		t.Fatalf("%s could not join | %v", username, joinErr)
+	}
+	header, headerErr := stream.Header()
+	if headerErr != nil || len(header["lamport-timestamp"]) == 0 {
+		t.Fatalf("%s got no Lamport timestamp header | %v", username, headerErr)
+	}
+
+	return &legacyClient{t: t, service: service, stream: stream}
+}
+
+func (legacy *legacyClient) send(username string, text string, timestamp int32) {
+	legacy.t.Helper()
+
+	_, sendErr := legacy.service.BroadcastMessage(context.Background(), &proto.Chat{Username: username, Message: text, Timestamp: timestamp})
+	if sendErr != nil {
+		legacy.t.Fatalf("Sending %q failed | %v", text, sendErr)
+	}
+}
+
+// waitForMessage receives until the message from username arrives and
+// returns it, failing the test if the stream ends first.
+func (legacy *legacyClient) waitForMessage(username string, text string) *proto.Chat {
+	legacy.t.Helper()
+
+	for {
+		message, receiveErr := legacy.stream.Recv()
+		if receiveErr != nil {
+			legacy.t.Fatalf("Stream ended before %s: %s arrived | %v", username, text, receiveErr)
+		}
+		if message.Kind == proto.ChatKind_MESSAGE && message.Username == username && message.Message == text {
+			return message
+		}
+	}
+}
+
+func TestLegacyAndNewClientsChatTogether(t *testing.T) {
+	harness := Start(t, server.ServerConfig{})
+	alice := harness.Join("alice")
+	alice.WaitForJoin("alice")
+	old := joinLegacy(t, harness, "old", 1)
+	alice.WaitForJoin("old")
+
+	old.send("old", "hi from the past", 100)
+	received := alice.WaitForMessage("old", "hi from the past")
+	if received.Message.Lamport <= 100 || int64(received.Message.Timestamp) != received.Message.Lamport {
+		t.Fatalf("alice received a message sent at LT100 stamped LT%d, with the legacy timestamp LT%d", received.Message.Lamport, received.Message.Timestamp)
+	}
+	if received.Timestamp <= received.Message.Lamport {
+		t.Fatalf("alice received a message stamped LT%d at LT%d", received.Message.Lamport, received.Timestamp)
+	}
+
+	sendErr := alice.Send("hi back")
+	if sendErr != nil {
+		t.Fatalf("alice could not reply | %v", sendErr)
+	}
+	reply := old.waitForMessage("alice", "hi back")
+	if reply.Timestamp <= received.Message.Timestamp {
+		t.Fatalf("Reply reached the legacy client stamped LT%d, not after LT%d", reply.Timestamp, received.Message.Timestamp)
+	}
+}
+
+func TestLamportTimePastInt32ReachesBothKindsOfClients(t *testing.T) {
+	harness := Start(t, server.ServerConfig{})
+	alice := harness.Join("alice")
+	alice.WaitForJoin("alice")
+	old := joinLegacy(t, harness, "old", 1)
+	alice.WaitForJoin("old")
+
+	// A legacy client that ran past math.MaxInt32 wraps around to negative
+	// timestamps.
+	old.send("old", "wrapped", math.MinInt32+10)
+	wrapped := alice.WaitForMessage("old", "wrapped")
+	if wrapped.Message.Lamport <= math.MaxInt32+10 {
+		t.Fatalf("Wrapped timestamp was read as LT%d, want past LT%d", wrapped.Message.Lamport, int64(math.MaxInt32)+10)
+	}
+	if wrapped.Message.Timestamp != math.MaxInt32 {
+		t.Fatalf("Legacy timestamp is LT%d, want it capped at LT%d", wrapped.Message.Timestamp, math.MaxInt32)
+	}
+
+	sendErr := alice.Send("still ordered")
+	if sendErr != nil {
+		t.Fatalf("alice could not send | %v", sendErr)
+	}
+	ordered := alice.WaitForMessage("alice", "still ordered")
+	if ordered.Message.Lamport <= wrapped.Message.Lamport {
+		t.Fatalf("alice's message was stamped LT%d, not after LT%d", ordered.Message.Lamport, wrapped.Message.Lamport)
+	}
+	if legacy := old.waitForMessage("alice", "still ordered"); legacy.Timestamp != math.MaxInt32 {
+		t.Fatalf("Legacy client received LT%d, want it capped at LT%d", legacy.Timestamp, math.MaxInt32)
+	}
+
+	bob := harness.Join("bob")
+	bob.WaitForJoin("bob")
+	if bob.Timestamp() <= ordered.Message.Lamport {
+		t.Fatalf("bob joined at LT%d, not after LT%d", bob.Timestamp(), ordered.Message.Lamport)
+	}
+}
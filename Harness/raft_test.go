@@ -0,0 +1,111 @@
+package harness
+
+import (
+	client "Chitty-Chat/Client"
+	server "Chitty-Chat/Server"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRaftClientsAreRedirectedToTheLeader(t *testing.T) {
+	cluster := StartRaftCluster(t, 3, server.ServerConfig{})
+	bob := joinCluster(t, cluster, "bob")
+	alice := joinCluster(t, cluster, "alice")
+
+	sendErr := alice.Send("hello")
+	if sendErr != nil {
+		t.Fatalf("alice could not send | %v", sendErr)
+	}
+	received := bob.WaitForMessage("alice", "hello")
+
+	for _, member := range cluster.Servers {
+		deadline := time.Now().Add(WaitTimeout)
+		for member.Server.RaftNode().Status().CommitIndex < received.Message.Id {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s did not commit message #%d", member.Server.RaftNode().ID(), received.Message.Id)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestRaftAcknowledgedMessagesSurviveLeaderLoss(t *testing.T) {
+	cluster := StartRaftCluster(t, 3, server.ServerConfig{})
+	bob := joinCluster(t, cluster, "bob")
+	carol := joinCluster(t, cluster, "carol")
+	alice := joinCluster(t, cluster, "alice")
+	oldLeader := cluster.Leader()
+
+	var acknowledged []string
+	deadline := time.Now().Add(3 * WaitTimeout)
+	for i := 0; len(acknowledged) < 30; i++ {
+		if time.Now().After(deadline) {
+			t.Fatalf("Only %d messages were acknowledged", len(acknowledged))
+		}
+		if len(acknowledged) == 10 && oldLeader.Server.IsPrimary() {
+			oldLeader.Server.Kill()
+		}
+
+		text := fmt.Sprintf("message %d", i)
+		sendErr := alice.Send(text)
+		if sendErr != nil {
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+		acknowledged = append(acknowledged, text)
+	}
+
+	for _, receiver := range []*Client{bob, carol} {
+		for _, text := range acknowledged {
+			receiver.WaitForMessage("alice", text)
+		}
+	}
+}
+
+func TestRaftLeaderInMinorityCannotAcknowledge(t *testing.T) {
+	cluster := StartRaftCluster(t, 5, server.ServerConfig{ReplicationTimeout: 300 * time.Millisecond})
+	bob := joinCluster(t, cluster, "bob")
+	alice := joinCluster(t, cluster, "alice")
+	oldLeader := cluster.Leader()
+
+	minority := []string{oldLeader.Server.RaftNode().ID()}
+	var majority []*Harness
+	var majorityIds []string
+	for _, member := range cluster.Servers {
+		switch {
+		case member == oldLeader:
+		case len(minority) < 2:
+			minority = append(minority, member.Server.RaftNode().ID())
+		default:
+			majority = append(majority, member)
+			majorityIds = append(majorityIds, member.Server.RaftNode().ID())
+		}
+	}
+	cluster.Network.Partition(minority, majorityIds)
+
+	sendErr := alice.Send("lost")
+	if sendErr == nil {
+		t.Fatalf("Leader cut off from the majority acknowledged a message")
+	}
+
+	newLeader := cluster.Leader(majority...)
+	carol := newLeader.NewClient("carol", client.ClientConfig{})
+	joinErr := carol.Join()
+	if joinErr != nil {
+		t.Fatalf("carol could not join the new leader | %v", joinErr)
+	}
+	carol.WaitForJoin("carol")
+	sendErr = carol.Send("committed")
+	if sendErr != nil {
+		t.Fatalf("carol could not send to the new leader | %v", sendErr)
+	}
+
+	cluster.Network.Heal()
+	bob.WaitForMessage("carol", "committed")
+	alice.WaitForMessage("carol", "committed")
+	if slices.ContainsFunc(bob.Messages(), func(delivery Delivery) bool { return delivery.Message.Message == "lost" }) {
+		t.Fatalf("bob received a message that was never committed")
+	}
+}
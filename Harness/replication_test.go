@@ -0,0 +1,114 @@
+package harness
+
+import (
+	client "Chitty-Chat/Client"
+	server "Chitty-Chat/Server"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// joinCluster joins a client that reconnects on its own, as clients of a
+// replica set or Raft cluster must to follow a failover.
+func joinCluster(t *testing.T, cluster *Cluster, username string) *Client {
+	t.Helper()
+
+	simulated := cluster.NewClient(username, client.ClientConfig{Reconnect: true})
+	joinErr := simulated.Join()
+	if joinErr != nil {
+		t.Fatalf("%s could not join | %v", username, joinErr)
+	}
+	simulated.WaitForJoin(username)
+
+	return simulated
+}
+
+func TestAcknowledgedMessagesSurviveFailover(t *testing.T) {
+	replicas := StartReplicaSet(t, 3, server.ServerConfig{})
+	bob := joinCluster(t, replicas, "bob")
+	carol := joinCluster(t, replicas, "carol")
+	alice := joinCluster(t, replicas, "alice")
+
+	var acknowledged []string
+	deadline := time.Now().Add(3 * WaitTimeout)
+	for i := 0; len(acknowledged) < 30; i++ {
+		if time.Now().After(deadline) {
+			t.Fatalf("Only %d messages were acknowledged", len(acknowledged))
+		}
+		if len(acknowledged) == 10 && replicas.Servers[0].Server.IsPrimary() {
+			replicas.Servers[0].Server.Kill()
+		}
+
+		text := fmt.Sprintf("message %d", i)
+		sendErr := alice.Send(text)
+		if sendErr != nil {
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+		acknowledged = append(acknowledged, text)
+	}
+
+	if !replicas.Servers[1].Server.IsPrimary() {
+		t.Fatalf("server1 was not promoted after the primary was killed")
+	}
+	if replicas.Servers[2].Server.IsPrimary() {
+		t.Fatalf("server2 was promoted although server1 took over")
+	}
+	for _, receiver := range []*Client{bob, carol} {
+		for _, text := range acknowledged {
+			receiver.WaitForMessage("alice", text)
+		}
+	}
+}
+
+func TestBackupFollowsPromotedPrimary(t *testing.T) {
+	replicas := StartReplicaSet(t, 3, server.ServerConfig{})
+	replicas.Servers[0].Server.Kill()
+
+	deadline := time.Now().Add(WaitTimeout)
+	for len(replicas.Servers[1].Server.Backups()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("server2 did not follow server1 after the failover")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if backups := replicas.Servers[1].Server.Backups(); backups[0] != "server2" {
+		t.Fatalf("server1 has backups %v, want [server2]", backups)
+	}
+}
+
+func TestBackupRejectsClients(t *testing.T) {
+	replicas := StartReplicaSet(t, 2, server.ServerConfig{})
+	alice := replicas.Servers[1].NewClient("alice", client.ClientConfig{})
+
+	joinErr := alice.Join()
+	if joinErr == nil || !strings.Contains(joinErr.Error(), "server1 is a backup") {
+		t.Fatalf("Join on a backup returned %v, want it to be rejected", joinErr)
+	}
+}
+
+func TestRejoiningClientGetsMissedMessages(t *testing.T) {
+	replicas := StartReplicaSet(t, 2, server.ServerConfig{})
+	bob := joinCluster(t, replicas, "bob")
+	alice := joinCluster(t, replicas, "alice")
+	sendErr := alice.Send("before")
+	if sendErr != nil {
+		t.Fatalf("alice could not send | %v", sendErr)
+	}
+	bob.WaitForMessage("alice", "before")
+
+	replicas.Servers[0].Server.Kill()
+	deadline := time.Now().Add(WaitTimeout)
+	for alice.Send("after") != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("alice could not send after the failover")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	bob.WaitForMessage("alice", "after")
+	if count := len(bob.Messages()); count != 2 {
+		t.Fatalf("bob received %d messages, want each of the 2 once", count)
+	}
+}
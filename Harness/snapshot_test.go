@@ -0,0 +1,86 @@
+package harness
+
+import (
+	proto "Chitty-Chat/GRPC"
+	server "Chitty-Chat/Server"
+	snapshot "Chitty-Chat/Snapshot"
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func snapshotClient(t *testing.T, harness *Harness) proto.SnapshotServiceClient {
+	t.Helper()
+
+	connection, dialErr := grpc.NewClient("passthrough:///bufconn", append(harness.DialOptions(), grpc.WithTransportCredentials(insecure.NewCredentials()))...)
+	if dialErr != nil {
+		t.Fatalf("Could not dial server | %v", dialErr)
+	}
+	t.Cleanup(func() { connection.Close() })
+
+	return proto.NewSnapshotServiceClient(connection)
+}
+
+func TestSnapshotIsConsistentWhileChatting(t *testing.T) {
+	servers := StartFederation(t,
+		server.ServerConfig{Peers: []string{"server1", "server2"}},
+		server.ServerConfig{Peers: []string{"server2"}},
+		server.ServerConfig{},
+	)
+	carol := servers[2].Join("carol")
+	carol.WaitForJoin("carol")
+	alice := servers[0].Join("alice")
+	bob := servers[1].Join("bob")
+	waitForRemoteJoin(carol, "alice")
+	waitForRemoteJoin(carol, "bob")
+
+	stop := make(chan struct{})
+	chatted := make(chan struct{})
+	go func() {
+		defer close(chatted)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			alice.Send(fmt.Sprintf("message %d", i))
+			bob.Send(fmt.Sprintf("reply %d", i))
+		}
+	}()
+	carol.WaitForMessage("alice", "message 20")
+
+	var clients []proto.SnapshotServiceClient
+	for _, harness := range servers {
+		clients = append(clients, snapshotClient(t, harness))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), WaitTimeout)
+	defer cancel()
+	global, takeErr := snapshot.Take(ctx, clients)
+	close(stop)
+	<-chatted
+	if takeErr != nil {
+		t.Fatalf("Could not take snapshot | %v", takeErr)
+	}
+
+	verifyErr := snapshot.Verify(global)
+	if verifyErr != nil {
+		t.Fatalf("Snapshot is not a consistent cut | %v", verifyErr)
+	}
+	for i, username := range []string{"alice", "bob", "carol"} {
+		local := global.Servers[i]
+		if !slices.Equal(local.LocalUsers, []string{username}) {
+			t.Fatalf("%s recorded local users %v, want [%s]", local.ServerId, local.LocalUsers, username)
+		}
+		if len(local.RemoteUsers) != 2 {
+			t.Fatalf("%s recorded remote users %v, want the other two", local.ServerId, local.RemoteUsers)
+		}
+		if len(local.Channels) != 2 {
+			t.Fatalf("%s recorded channels to %d servers, want 2", local.ServerId, len(local.Channels))
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package peer
+
+import (
+	proto "Chitty-Chat/GRPC"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// membership is a peer's view of the others, spread by gossip. Every peer
+// raises its own heartbeat each round; a peer whose heartbeat has not grown
+// for the failure timeout is no longer gossiped with, nor passed on to
+// others, and is forgotten after twice that.
+type membership struct {
+	self           *proto.PeerMember
+	failureTimeout time.Duration
+	members        map[string]*member
+}
+
+type member struct {
+	record  *proto.PeerMember
+	updated time.Time
+}
+
+func newMembership(self *proto.PeerMember, failureTimeout time.Duration) *membership {
+	return &membership{self: self, failureTimeout: failureTimeout, members: make(map[string]*member)}
+}
+
+// merge takes in the records another peer knows, keeping the newest record
+// of every member.
+func (members *membership) merge(records []*proto.PeerMember, now time.Time) {
+	for _, record := range records {
+		if record.Id == members.self.Id {
+			continue
+		}
+
+		known, isKnown := members.members[record.Id]
+		isNewer := !isKnown || record.Heartbeat > known.record.Heartbeat ||
+			(record.Heartbeat == known.record.Heartbeat && record.Left && !known.record.Left)
+		if isNewer {
+			members.members[record.Id] = &member{record: protobuf.Clone(record).(*proto.PeerMember), updated: now}
+		}
+	}
+}
+
+func (members *membership) isLive(known *member, now time.Time) bool {
+	return !known.record.Left && now.Sub(known.updated) < members.failureTimeout
+}
+
+// live returns the members that are still gossiped with, ordered by
+// username.
+func (members *membership) live(now time.Time) []*proto.PeerMember {
+	var records []*proto.PeerMember
+	for _, known := range members.members {
+		if members.isLive(known, now) {
+			records = append(records, known.record)
+		}
+	}
+	slices.SortFunc(records, func(a *proto.PeerMember, b *proto.PeerMember) int {
+		return strings.Compare(a.Username, b.Username)
+	})
+
+	return records
+}
+
+// records returns the peer's own record and those of the members heard
+// from within the failure timeout, those that left included, to gossip to
+// another peer.
+func (members *membership) records(now time.Time) []*proto.PeerMember {
+	records := []*proto.PeerMember{protobuf.Clone(members.self).(*proto.PeerMember)}
+	for _, known := range members.members {
+		if now.Sub(known.updated) < members.failureTimeout {
+			records = append(records, known.record)
+		}
+	}
+
+	return records
+}
+
+// forget drops the members not heard from for twice the failure timeout.
+// Until then a stale record gossiped by another peer is not taken for news.
+func (members *membership) forget(now time.Time) {
+	for id, known := range members.members {
+		if now.Sub(known.updated) >= 2*members.failureTimeout {
+			delete(members.members, id)
+		}
+	}
+}
+
+// delivered returns how many messages per peer both the owner of the own
+// vector and every live member have delivered.
+func (members *membership) delivered(own map[string]int64, now time.Time) map[string]int64 {
+	everyone := maps.Clone(own)
+	for _, known := range members.members {
+		if !members.isLive(known, now) {
+			continue
+		}
+		for origin, count := range everyone {
+			everyone[origin] = min(count, known.record.Delivered[origin])
+		}
+	}
+
+	return everyone
+}
+
+// holder returns a live member other than the peer itself that uses the
+// username, if any.
+func (members *membership) holder(username string, now time.Time) *proto.PeerMember {
+	for _, known := range members.members {
+		if known.record.Username == username && members.isLive(known, now) {
+			return known.record
+		}
+	}
+
+	return nil
+}
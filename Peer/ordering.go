@@ -0,0 +1,127 @@
+package peer
+
+import (
+	proto "Chitty-Chat/GRPC"
+	"maps"
+
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// maxGossipBytes bounds the missed messages sent in one GossipResponse,
+// well below gRPC's default limit of 4 MB. The rest follow in later rounds.
+const maxGossipBytes = 1 << 20
+
+// causalOrder delivers gossiped messages in causal order. delivered is a
+// vector clock counting the messages delivered from every peer; a message
+// is held back until everything its sender had delivered before sending it
+// has been delivered here too. seen only holds the IDs of held back
+// messages, as delivered ones are recognized by the vector clock.
+type causalOrder struct {
+	delivered map[string]int64
+	seen      map[string]bool
+	pending   []*proto.PeerMessage
+}
+
+func newCausalOrder() *causalOrder {
+	return &causalOrder{delivered: make(map[string]int64), seen: make(map[string]bool)}
+}
+
+// stamp counts a message origin is sending and returns its sequence number
+// and vector timestamp.
+func (order *causalOrder) stamp(origin string) (int64, map[string]int64) {
+	order.delivered[origin]++
+	return order.delivered[origin], maps.Clone(order.delivered)
+}
+
+func (order *causalOrder) snapshot() map[string]int64 {
+	return maps.Clone(order.delivered)
+}
+
+// add returns whether the message is new, and the messages it made
+// deliverable in the order to deliver them. Duplicates are recognized by
+// their ID.
+func (order *causalOrder) add(message *proto.PeerMessage) (bool, []*proto.PeerMessage) {
+	if order.seen[message.Id] || message.Vector[message.Origin] <= order.delivered[message.Origin] {
+		return false, nil
+	}
+	order.seen[message.Id] = true
+	order.pending = append(order.pending, message)
+
+	return true, order.drain()
+}
+
+// skip counts the messages another peer pruned as delivered without
+// delivering them, and returns the held back messages that made
+// deliverable.
+func (order *causalOrder) skip(pruned map[string]int64) []*proto.PeerMessage {
+	for origin, count := range pruned {
+		order.delivered[origin] = max(order.delivered[origin], count)
+	}
+
+	var pending []*proto.PeerMessage
+	for _, message := range order.pending {
+		if message.Vector[message.Origin] <= order.delivered[message.Origin] {
+			delete(order.seen, message.Id)
+			continue
+		}
+		pending = append(pending, message)
+	}
+	order.pending = pending
+
+	return order.drain()
+}
+
+// drain returns the held back messages that can be delivered, in the order
+// to deliver them.
+func (order *causalOrder) drain() []*proto.PeerMessage {
+	var deliverable []*proto.PeerMessage
+	for progressed := true; progressed; {
+		progressed = false
+		for i, pending := range order.pending {
+			if !order.isDeliverable(pending) {
+				continue
+			}
+			order.delivered[pending.Origin] = pending.Vector[pending.Origin]
+			delete(order.seen, pending.Id)
+			deliverable = append(deliverable, pending)
+			order.pending = append(order.pending[:i], order.pending[i+1:]...)
+			progressed = true
+			break
+		}
+	}
+
+	return deliverable
+}
+
+func (order *causalOrder) isDeliverable(message *proto.PeerMessage) bool {
+	for origin, count := range message.Vector {
+		if origin == message.Origin && count != order.delivered[origin]+1 {
+			return false
+		}
+		if origin != message.Origin && count > order.delivered[origin] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// missing returns the oldest messages of history the owner of the
+// delivered vector has not delivered yet, as many as fit in maxGossipBytes.
+func missing(history []*proto.PeerMessage, delivered map[string]int64) []*proto.PeerMessage {
+	var messages []*proto.PeerMessage
+	size := 0
+	for _, message := range history {
+		if message.Vector[message.Origin] <= delivered[message.Origin] {
+			continue
+		}
+
+		size += protobuf.Size(message)
+		if size > maxGossipBytes && len(messages) > 0 {
+			break
+		}
+		messages = append(messages, message)
+	}
+
+	return messages
+}
@@ -0,0 +1,506 @@
+// Package peer runs Chitty-Chat without a server. Every peer serves a small
+// PeerService, learns about the others from a list of seeds and spreads
+// messages by gossip, delivering them in causal order.
+package peer
+
+import (
+	clock "Chitty-Chat/Clock"
+	proto "Chitty-Chat/GRPC"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"math/rand/v2"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const defaultFanout = 3
+const defaultGossipInterval = 200 * time.Millisecond
+const defaultFailureTimeout = 5 * time.Second
+const defaultHistoryLength = 1000
+const gossipTimeout = time.Second
+
+type PeerConfig struct {
+	Username string
+	// Address is where the other peers reach this one. The peer listens on
+	// it unless Listener is set.
+	Address  string
+	Listener net.Listener
+	// Seeds are addresses of peers to learn the others from when joining.
+	// A peer without seeds starts a new chat.
+	Seeds []string
+	// Fanout is how many peers a new message is pushed to, and how many
+	// are asked for missed messages every GossipInterval.
+	Fanout         int
+	GossipInterval time.Duration
+	// FailureTimeout is how long a peer may go without a new heartbeat
+	// before it is no longer gossiped with.
+	FailureTimeout time.Duration
+	// HistoryLength is how many of the latest messages are kept for peers
+	// that join late, 1000 by default. Older messages are dropped once every
+	// live peer has delivered them, and in any case once there are four
+	// times as many.
+	HistoryLength int
+
+	// Output receives everything the peer prints, os.Stderr by default.
+	Output io.Writer
+	// DialOptions are added to the options other peers are dialed with.
+	DialOptions []grpc.DialOption
+}
+
+type Peer struct {
+	proto.UnimplementedPeerServiceServer
+	config     PeerConfig
+	logger     *log.Logger
+	grpcServer *grpc.Server
+
+	mutex       sync.Mutex
+	self        *proto.PeerMember
+	members     *membership
+	order       *causalOrder
+	history     []*proto.PeerMessage
+	pruned      map[string]int64
+	lamportTime int64
+	connections map[string]*grpc.ClientConn
+
+	finished  chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewPeer(config PeerConfig) (*Peer, error) {
+	if config.Address == "" {
+		return nil, errors.New("peer address is required")
+	}
+	if config.Output == nil {
+		config.Output = os.Stderr
+	}
+	if config.Fanout == 0 {
+		config.Fanout = defaultFanout
+	}
+	if config.GossipInterval == 0 {
+		config.GossipInterval = defaultGossipInterval
+	}
+	if config.FailureTimeout == 0 {
+		config.FailureTimeout = defaultFailureTimeout
+	}
+	if config.HistoryLength == 0 {
+		config.HistoryLength = defaultHistoryLength
+	}
+	config.DialOptions = append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, config.DialOptions...)
+
+	return &Peer{
+		config:      config,
+		logger:      log.New(config.Output, "", log.LstdFlags),
+		order:       newCausalOrder(),
+		pruned:      make(map[string]int64),
+		connections: make(map[string]*grpc.ClientConn),
+		finished:    make(chan error, 1),
+		done:        make(chan struct{}),
+	}, nil
+}
+
+// Run asks for a username unless one was configured, joins the chat and
+// handles the user's input until they leave or the input ends.
+func (peer *Peer) Run(input io.Reader) error {
+	reader := bufio.NewScanner(input)
+	if peer.config.Username == "" {
+		peer.logger.Print("Please enter a username:")
+		if !reader.Scan() {
+			return errors.New("no username entered")
+		}
+		peer.config.Username = reader.Text()
+	}
+
+	joinErr := peer.Join()
+	if joinErr != nil {
+		return joinErr
+	}
+
+	go peer.listenForInput(reader)
+
+	return <-peer.finished
+}
+
+// Join starts serving the PeerService, learns the chat's members and
+// history from the seeds and announces the peer to everyone.
+func (peer *Peer) Join() error {
+	listener := peer.config.Listener
+	if listener == nil {
+		var listenErr error
+		listener, listenErr = net.Listen("tcp", peer.config.Address)
+		if listenErr != nil {
+			return fmt.Errorf("listening on %s: %w", peer.config.Address, listenErr)
+		}
+	}
+
+	peer.mutex.Lock()
+	peer.self = &proto.PeerMember{
+		Id:       fmt.Sprintf("%s/%d", peer.config.Username, time.Now().UnixNano()),
+		Username: peer.config.Username,
+		Address:  peer.config.Address,
+	}
+	peer.members = newMembership(peer.self, peer.config.FailureTimeout)
+	peer.mutex.Unlock()
+
+	peer.grpcServer = grpc.NewServer()
+	proto.RegisterPeerServiceServer(peer.grpcServer, peer)
+	go peer.grpcServer.Serve(listener)
+
+	reachedSeed := len(peer.config.Seeds) == 0
+	for _, seed := range peer.config.Seeds {
+		gossipErr := peer.gossip(seed, nil)
+		if gossipErr != nil {
+			peer.logger.Printf("Could not reach seed %s | %v", seed, gossipErr)
+			continue
+		}
+		reachedSeed = true
+	}
+	if !reachedSeed {
+		peer.grpcServer.Stop()
+		return errors.New("could not join chat: no seed could be reached")
+	}
+
+	peer.mutex.Lock()
+	holder := peer.members.holder(peer.config.Username, time.Now())
+	peer.mutex.Unlock()
+	if holder != nil {
+		peer.grpcServer.Stop()
+		return fmt.Errorf("could not join chat: username %s is used by the peer at %s", holder.Username, holder.Address)
+	}
+
+	peer.publish(fmt.Sprintf("User %s joined", peer.config.Username), proto.ChatKind_SYSTEM)
+	go peer.gossipPeriodically()
+
+	return nil
+}
+
+// Finished receives the reason the peer stopped once it does, nil when the
+// user left.
+func (peer *Peer) Finished() <-chan error {
+	return peer.finished
+}
+
+func (peer *Peer) finish(err error) {
+	select {
+	case peer.finished <- err:
+	default:
+	}
+}
+
+// Leave tells the other peers that this one is leaving.
+func (peer *Peer) Leave() error {
+	peer.publish(fmt.Sprintf("User %s left", peer.config.Username), proto.ChatKind_SYSTEM)
+
+	peer.mutex.Lock()
+	peer.self.Heartbeat++
+	peer.self.Left = true
+	peer.mutex.Unlock()
+
+	var reached bool
+	for _, address := range peer.targets() {
+		reached = peer.gossip(address, nil) == nil || reached
+	}
+	if !reached && len(peer.Members()) > 0 {
+		return errors.New("could not leave chat: no peer could be reached")
+	}
+
+	peer.logger.Printf("LT%d | Successfully left the chat", peer.Timestamp())
+
+	return nil
+}
+
+func (peer *Peer) Close() error {
+	peer.closeOnce.Do(func() {
+		close(peer.done)
+		if peer.grpcServer != nil {
+			peer.grpcServer.Stop()
+		}
+
+		peer.mutex.Lock()
+		defer peer.mutex.Unlock()
+		for address, connection := range peer.connections {
+			connection.Close()
+			delete(peer.connections, address)
+		}
+	})
+
+	return nil
+}
+
+func (peer *Peer) Username() string {
+	return peer.config.Username
+}
+
+func (peer *Peer) Timestamp() int64 {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	return peer.lamportTime
+}
+
+// Members returns the other peers currently gossiped with.
+func (peer *Peer) Members() []*proto.PeerMember {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	if peer.members == nil {
+		return nil
+	}
+
+	return peer.members.live(time.Now())
+}
+
+// Messages returns the delivered messages the peer still keeps, in
+// delivery order.
+func (peer *Peer) Messages() []*proto.PeerMessage {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	return append([]*proto.PeerMessage(nil), peer.history...)
+}
+
+// Send delivers a message locally and gossips it to the other peers.
+func (peer *Peer) Send(userInput string) {
+	peer.publish(userInput, proto.ChatKind_MESSAGE)
+}
+
+func (peer *Peer) publish(text string, kind proto.ChatKind) {
+	peer.mutex.Lock()
+	sequence, vector := peer.order.stamp(peer.self.Id)
+	peer.lamportTime++
+	legacyTimestamp, _ := clock.Legacy(peer.lamportTime)
+	message := &proto.PeerMessage{
+		Id:        fmt.Sprintf("%s/%d", peer.self.Id, sequence),
+		Origin:    peer.self.Id,
+		Username:  peer.config.Username,
+		Message:   text,
+		Timestamp: legacyTimestamp,
+		Lamport:   peer.lamportTime,
+		Vector:    vector,
+		Kind:      kind,
+	}
+	peer.deliver(message)
+	peer.mutex.Unlock()
+
+	peer.push([]*proto.PeerMessage{message})
+}
+
+// deliver shows a message to the user and keeps it for peers that missed
+// it. The caller must hold the mutex.
+func (peer *Peer) deliver(message *proto.PeerMessage) {
+	if message.Origin != peer.self.Id {
+		peer.lamportTime = max(peer.lamportTime, clock.Widen(message.Lamport, message.Timestamp)) + 1
+	}
+	peer.history = append(peer.history, message)
+
+	if message.Kind == proto.ChatKind_SYSTEM {
+		peer.logger.Printf("LT%d | %s", peer.lamportTime, message.Message)
+		return
+	}
+	peer.logger.Printf("LT%d | %s: %s", peer.lamportTime, message.Username, message.Message)
+}
+
+// pruneHistory drops the messages older than the latest HistoryLength
+// that every live peer has delivered, and the oldest beyond four times
+// HistoryLength. The caller must hold the mutex.
+func (peer *Peer) pruneHistory(now time.Time) {
+	keepFrom := len(peer.history) - peer.config.HistoryLength
+	if keepFrom <= 0 {
+		return
+	}
+	overflow := len(peer.history) - 4*peer.config.HistoryLength
+	everyone := peer.members.delivered(peer.order.snapshot(), now)
+
+	var kept []*proto.PeerMessage
+	for i, message := range peer.history {
+		sequence := message.Vector[message.Origin]
+		if i < overflow || (i < keepFrom && sequence <= everyone[message.Origin]) {
+			peer.pruned[message.Origin] = max(peer.pruned[message.Origin], sequence)
+			continue
+		}
+		kept = append(kept, message)
+	}
+	peer.history = kept
+}
+
+// absorb merges what another peer sent and delivers every message that has
+// become deliverable. Messages the other peer pruned before this one
+// delivered them are skipped. It returns the messages that were new, to
+// pass on.
+func (peer *Peer) absorb(members []*proto.PeerMember, messages []*proto.PeerMessage, pruned map[string]int64) []*proto.PeerMessage {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	peer.members.merge(members, time.Now())
+	for _, ready := range peer.order.skip(pruned) {
+		peer.deliver(ready)
+	}
+
+	var fresh []*proto.PeerMessage
+	for _, message := range messages {
+		isNew, deliverable := peer.order.add(message)
+		if isNew {
+			fresh = append(fresh, message)
+		}
+		for _, ready := range deliverable {
+			peer.deliver(ready)
+		}
+	}
+
+	return fresh
+}
+
+func (peer *Peer) Gossip(ctx context.Context, request *proto.GossipRequest) (*proto.GossipResponse, error) {
+	fresh := peer.absorb(request.Members, request.Messages, nil)
+	if len(fresh) > 0 {
+		go peer.push(fresh)
+	}
+
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	return &proto.GossipResponse{
+		Members:  peer.members.records(time.Now()),
+		Messages: missing(peer.history, request.Delivered),
+		Pruned:   maps.Clone(peer.pruned),
+	}, nil
+}
+
+// targets picks up to Fanout live peers at random, or the seeds while no
+// other peer is known.
+func (peer *Peer) targets() []string {
+	members := peer.Members()
+	if len(members) == 0 {
+		return peer.config.Seeds
+	}
+
+	rand.Shuffle(len(members), func(i int, j int) { members[i], members[j] = members[j], members[i] })
+	var addresses []string
+	for _, member := range members[:min(peer.config.Fanout, len(members))] {
+		addresses = append(addresses, member.Address)
+	}
+
+	return addresses
+}
+
+// push sends new messages to random peers, which pass on what they had not
+// seen before.
+func (peer *Peer) push(messages []*proto.PeerMessage) {
+	for _, address := range peer.targets() {
+		gossipErr := peer.gossip(address, messages)
+		if gossipErr != nil {
+			peer.logger.Printf("Could not gossip to %s | %v", address, gossipErr)
+		}
+	}
+}
+
+// gossip exchanges members with the peer at address, sending it messages
+// and delivering whatever it has that this peer has not.
+func (peer *Peer) gossip(address string, messages []*proto.PeerMessage) error {
+	peer.mutex.Lock()
+	connection, isOpen := peer.connections[address]
+	if !isOpen {
+		var dialErr error
+		connection, dialErr = grpc.NewClient(address, peer.config.DialOptions...)
+		if dialErr != nil {
+			peer.mutex.Unlock()
+			return fmt.Errorf("dialing %s: %w", address, dialErr)
+		}
+		peer.connections[address] = connection
+	}
+	request := &proto.GossipRequest{Members: peer.members.records(time.Now()), Messages: messages, Delivered: peer.order.snapshot()}
+	peer.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), gossipTimeout)
+	defer cancel()
+	response, gossipErr := proto.NewPeerServiceClient(connection).Gossip(ctx, request)
+	if gossipErr != nil {
+		return gossipErr
+	}
+
+	fresh := peer.absorb(response.Members, response.Messages, response.Pruned)
+	if len(fresh) > 0 {
+		go peer.push(fresh)
+	}
+
+	return nil
+}
+
+// gossipPeriodically raises the peer's heartbeat, passing on what it has
+// delivered, asks random peers for anything it missed, e.g. while it was cut
+// off, and drops the messages and members it no longer needs.
+func (peer *Peer) gossipPeriodically() {
+	ticker := time.NewTicker(peer.config.GossipInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-peer.done:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		peer.mutex.Lock()
+		peer.self.Heartbeat++
+		peer.self.Delivered = peer.order.snapshot()
+		peer.members.forget(now)
+		peer.pruneHistory(now)
+		peer.mutex.Unlock()
+
+		for _, address := range peer.targets() {
+			peer.gossip(address, nil)
+		}
+	}
+}
+
+func (peer *Peer) listenForInput(reader *bufio.Scanner) {
+	for reader.Scan() {
+		userInput := reader.Text()
+
+		if len(userInput) == 0 {
+			peer.logger.Print("Input was empty")
+			continue
+		}
+
+		if strings.ToLower(userInput) == "leave" {
+			break
+		}
+
+		if strings.ToLower(userInput) == "/peers" {
+			peer.showMembers()
+			continue
+		}
+		if strings.HasPrefix(userInput, "/") {
+			peer.logger.Printf("Unknown command %s", strings.Fields(userInput)[0])
+			continue
+		}
+
+		peer.Send(userInput)
+	}
+
+	peer.finish(peer.Leave())
+}
+
+func (peer *Peer) showMembers() {
+	members := peer.Members()
+	if len(members) == 0 {
+		peer.logger.Print("No other peers are known")
+		return
+	}
+
+	for _, member := range members {
+		peer.logger.Printf("%s at %s", member.Username, member.Address)
+	}
+}
@@ -0,0 +1,266 @@
+package peer
+
+import (
+	proto "Chitty-Chat/GRPC"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const waitTimeout = 5 * time.Second
+
+// network connects peers in the same process, each reached at the address
+// "passthrough:///" followed by its username.
+type network struct {
+	t         *testing.T
+	mutex     sync.Mutex
+	listeners map[string]*bufconn.Listener
+	// historyLength is passed on to every peer started.
+	historyLength int
+}
+
+func newNetwork(t *testing.T) *network {
+	return &network{t: t, listeners: make(map[string]*bufconn.Listener)}
+}
+
+func (peers *network) dialer() grpc.DialOption {
+	return grpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) {
+		peers.mutex.Lock()
+		listener, isKnown := peers.listeners[address]
+		peers.mutex.Unlock()
+		if !isKnown {
+			return nil, fmt.Errorf("no peer at %s", address)
+		}
+		return listener.DialContext(ctx)
+	})
+}
+
+func (peers *network) start(username string, seeds ...string) *Peer {
+	peers.t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	peers.mutex.Lock()
+	peers.listeners[username] = listener
+	peers.mutex.Unlock()
+
+	var seedAddresses []string
+	for _, seed := range seeds {
+		seedAddresses = append(seedAddresses, "passthrough:///"+seed)
+	}
+
+	peer, peerErr := NewPeer(PeerConfig{
+		Username:       username,
+		Address:        "passthrough:///" + username,
+		Listener:       listener,
+		Seeds:          seedAddresses,
+		GossipInterval: 20 * time.Millisecond,
+		FailureTimeout: 500 * time.Millisecond,
+		HistoryLength:  peers.historyLength,
+		Output:         io.Discard,
+		DialOptions:    []grpc.DialOption{peers.dialer()},
+	})
+	if peerErr != nil {
+		peers.t.Fatalf("Failed to create peer %s | %v", username, peerErr)
+	}
+	joinErr := peer.Join()
+	if joinErr != nil {
+		peers.t.Fatalf("%s could not join | %v", username, joinErr)
+	}
+	peers.t.Cleanup(func() { peer.Close() })
+
+	return peer
+}
+
+func chatLines(peer *Peer) []string {
+	var lines []string
+	for _, message := range peer.Messages() {
+		if message.Kind == proto.ChatKind_MESSAGE {
+			lines = append(lines, message.Username+": "+message.Message)
+		}
+	}
+
+	return lines
+}
+
+func waitForLines(t *testing.T, peer *Peer, want []string) {
+	t.Helper()
+
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		lines := chatLines(peer)
+		if slices.Equal(lines, want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s delivered %v, want %v", peer.Username(), lines, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMessagesReachEveryPeer(t *testing.T) {
+	peers := newNetwork(t)
+	alice := peers.start("alice")
+	bob := peers.start("bob", "alice")
+	carol := peers.start("carol", "bob")
+
+	alice.Send("hello")
+	for _, peer := range []*Peer{alice, bob, carol} {
+		waitForLines(t, peer, []string{"alice: hello"})
+	}
+
+	carol.Send("hi alice")
+	for _, peer := range []*Peer{alice, bob, carol} {
+		waitForLines(t, peer, []string{"alice: hello", "carol: hi alice"})
+	}
+}
+
+func TestLateJoinerReceivesHistoryInOrder(t *testing.T) {
+	peers := newNetwork(t)
+	alice := peers.start("alice")
+	bob := peers.start("bob", "alice")
+
+	want := []string{"alice: one", "bob: two", "alice: three"}
+	alice.Send("one")
+	waitForLines(t, bob, want[:1])
+	bob.Send("two")
+	waitForLines(t, alice, want[:2])
+	alice.Send("three")
+
+	carol := peers.start("carol", "bob")
+	waitForLines(t, carol, want)
+}
+
+func TestLateJoinerSkipsPrunedHistory(t *testing.T) {
+	peers := newNetwork(t)
+	peers.historyLength = 2
+	alice := peers.start("alice")
+	bob := peers.start("bob", "alice")
+
+	want := []string{"alice: one", "alice: two", "alice: three", "alice: four", "alice: five"}
+	for _, line := range want {
+		alice.Send(strings.TrimPrefix(line, "alice: "))
+	}
+	waitForLines(t, bob, want)
+
+	deadline := time.Now().Add(waitTimeout)
+	for len(alice.Messages()) > peers.historyLength {
+		if time.Now().After(deadline) {
+			t.Fatalf("alice keeps %d messages after bob delivered them, want %d", len(alice.Messages()), peers.historyLength)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	carol := peers.start("carol", "alice")
+	waitForLines(t, carol, want[3:])
+	alice.Send("six")
+	waitForLines(t, carol, []string{"alice: four", "alice: five", "alice: six"})
+}
+
+func TestUsernameInUseIsRejected(t *testing.T) {
+	peers := newNetwork(t)
+	peers.start("alice")
+
+	impostor, _ := NewPeer(PeerConfig{
+		Username:    "alice",
+		Address:     "passthrough:///impostor",
+		Listener:    bufconn.Listen(1 << 20),
+		Seeds:       []string{"passthrough:///alice"},
+		Output:      io.Discard,
+		DialOptions: []grpc.DialOption{peers.dialer()},
+	})
+	defer impostor.Close()
+
+	joinErr := impostor.Join()
+	if joinErr == nil {
+		t.Fatalf("Second peer named alice joined")
+	}
+}
+
+func TestLeftPeerIsForgotten(t *testing.T) {
+	peers := newNetwork(t)
+	alice := peers.start("alice")
+	bob := peers.start("bob", "alice")
+
+	deadline := time.Now().Add(waitTimeout)
+	for len(alice.Members()) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("alice knows %d peers, want 1", len(alice.Members()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	leaveErr := bob.Leave()
+	if leaveErr != nil {
+		t.Fatalf("bob could not leave | %v", leaveErr)
+	}
+	if len(alice.Members()) != 0 {
+		t.Fatalf("alice still gossips with %v after bob left", alice.Members())
+	}
+}
+
+func message(origin string, vector map[string]int64) *proto.PeerMessage {
+	return &proto.PeerMessage{Id: fmt.Sprintf("%s/%d", origin, vector[origin]), Origin: origin, Message: fmt.Sprint(vector), Vector: vector}
+}
+
+func TestCausalOrderHoldsBackMessagesUntilTheirCauses(t *testing.T) {
+	order := newCausalOrder()
+	first := message("alice", map[string]int64{"alice": 1})
+	reply := message("bob", map[string]int64{"alice": 1, "bob": 1})
+	second := message("alice", map[string]int64{"alice": 2, "bob": 1})
+
+	var delivered []*proto.PeerMessage
+	for _, next := range []*proto.PeerMessage{second, reply, first, reply, first} {
+		_, deliverable := order.add(next)
+		delivered = append(delivered, deliverable...)
+	}
+
+	if !slices.Equal(delivered, []*proto.PeerMessage{first, reply, second}) {
+		t.Fatalf("Delivered %v, want first, reply, second", delivered)
+	}
+}
+
+func TestCausalOrderSkipsPrunedMessages(t *testing.T) {
+	order := newCausalOrder()
+	third := message("alice", map[string]int64{"alice": 3})
+	if _, deliverable := order.add(third); len(deliverable) != 0 {
+		t.Fatalf("Delivered %v before the first two messages", deliverable)
+	}
+
+	deliverable := order.skip(map[string]int64{"alice": 2})
+	if !slices.Equal(deliverable, []*proto.PeerMessage{third}) {
+		t.Fatalf("Skipping the pruned messages delivered %v, want the third message", deliverable)
+	}
+	if isNew, _ := order.add(message("alice", map[string]int64{"alice": 1})); isNew {
+		t.Errorf("Pruned message was taken as new after it was skipped")
+	}
+}
+
+func TestMissingMessagesArePaginated(t *testing.T) {
+	var history []*proto.PeerMessage
+	for sequence := int64(1); sequence <= 5; sequence++ {
+		large := message("alice", map[string]int64{"alice": sequence})
+		large.Message = strings.Repeat("x", maxGossipBytes/3)
+		history = append(history, large)
+	}
+
+	delivered := map[string]int64{}
+	var pages [][]*proto.PeerMessage
+	for page := missing(history, delivered); len(page) > 0; page = missing(history, delivered) {
+		pages = append(pages, page)
+		delivered["alice"] = page[len(page)-1].Vector["alice"]
+	}
+
+	if len(pages) != 3 || len(pages[0]) != 2 || delivered["alice"] != 5 {
+		t.Errorf("Missing messages came in %d pages of which the first had %d messages, up to #%d, want 3 pages of at most 2 up to #5", len(pages), len(pages[0]), delivered["alice"])
+	}
+}
@@ -0,0 +1,680 @@
+// Package raft lets a cluster of servers agree on one ordered log using the
+// Raft consensus algorithm. A command proposed to the leader is applied on
+// every server in the same order once a majority has stored it.
+package raft
+
+import (
+	proto "Chitty-Chat/GRPC"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+const defaultElectionTimeout = 300 * time.Millisecond
+const defaultHeartbeatInterval = 50 * time.Millisecond
+const maxEntriesPerAppend = 256
+
+// ErrStopped is returned for proposals made to, or still waiting on, a
+// stopped node.
+var ErrStopped = errors.New("raft node was stopped")
+
+// ErrLostLeadership is returned when the leader was replaced before a
+// proposal was committed, so the proposal may or may not end up applied.
+var ErrLostLeadership = errors.New("leadership was lost before the entry was committed")
+
+// NotLeaderError is returned for proposals made to a node that is not the
+// leader. Leader is the ID of the leader the node knows of, if any.
+type NotLeaderError struct {
+	Leader string
+}
+
+func (notLeaderErr *NotLeaderError) Error() string {
+	if notLeaderErr.Leader == "" {
+		return "not the leader, no leader is known"
+	}
+
+	return fmt.Sprintf("not the leader, the leader is %s", notLeaderErr.Leader)
+}
+
+// Entry is one command in the log. Index counts from 1.
+type Entry struct {
+	Index   int64
+	Term    int64
+	Command []byte
+}
+
+// State is what a node must keep across restarts to stay safe.
+type State struct {
+	Term     int64
+	VotedFor string
+	Log      []Entry
+}
+
+// Storage persists a node's State. Without one the state only lives in
+// memory and is lost when the node restarts. The log is only ever appended
+// to, so storing it costs as much as the new entries and not the whole log.
+type Storage interface {
+	LoadState() (State, error)
+	SaveTerm(term int64, votedFor string) error
+	// AppendEntries stores consecutive entries. An entry replaces the stored
+	// entry with the same index and every entry after it.
+	AppendEntries(entries []Entry) error
+}
+
+// Transport sends the Raft RPCs to other nodes by ID.
+type Transport interface {
+	RequestVote(ctx context.Context, to string, request *proto.VoteRequest) (*proto.VoteResponse, error)
+	AppendEntries(ctx context.Context, to string, request *proto.AppendEntriesRequest) (*proto.AppendEntriesResponse, error)
+}
+
+type Config struct {
+	ID string
+	// Peers are the IDs of the other nodes in the cluster.
+	Peers     []string
+	Transport Transport
+	Storage   Storage
+	// A follower that has not heard from a leader for a random time between
+	// ElectionTimeout and twice that starts an election, 300ms by default.
+	// The leader sends a heartbeat every HeartbeatInterval, 50ms by default.
+	ElectionTimeout   time.Duration
+	HeartbeatInterval time.Duration
+	// Apply is called for every committed entry, in log order and never
+	// concurrently. Its result is returned to the proposer.
+	Apply func(entry Entry) any
+	// OnRoleChange, when set, is called in a goroutine of its own whenever
+	// the node's role changes. Status tells the role it has by then.
+	OnRoleChange func()
+}
+
+type Role int
+
+const (
+	Follower Role = iota
+	Candidate
+	Leader
+)
+
+func (role Role) String() string {
+	switch role {
+	case Candidate:
+		return "candidate"
+	case Leader:
+		return "leader"
+	default:
+		return "follower"
+	}
+}
+
+// Status is a snapshot of a node's view of the cluster.
+type Status struct {
+	ID          string
+	Role        Role
+	Term        int64
+	Leader      string
+	CommitIndex int64
+	LastIndex   int64
+}
+
+type proposal struct {
+	term int64
+	done chan proposalResult
+}
+
+type proposalResult struct {
+	value any
+	err   error
+}
+
+type Node struct {
+	id                string
+	peers             []string
+	transport         Transport
+	storage           Storage
+	electionTimeout   time.Duration
+	heartbeatInterval time.Duration
+	apply             func(entry Entry) any
+	onRoleChange      func()
+
+	mutex            sync.Mutex
+	role             Role
+	term             int64
+	votedFor         string
+	entries          []Entry
+	commitIndex      int64
+	lastApplied      int64
+	leader           string
+	electionDeadline time.Time
+	nextIndex        map[string]int64
+	matchIndex       map[string]int64
+	replicating      map[string]bool
+	proposals        map[int64]*proposal
+
+	commitChanged chan struct{}
+	stopped       chan struct{}
+	stopOnce      sync.Once
+}
+
+// NewNode restores a node from its storage. It does nothing until started.
+func NewNode(config Config) (*Node, error) {
+	if config.ID == "" {
+		return nil, errors.New("a node ID is required")
+	}
+	if config.Transport == nil && len(config.Peers) > 0 {
+		return nil, errors.New("a transport is required to reach the peers")
+	}
+
+	node := &Node{
+		id:                config.ID,
+		peers:             config.Peers,
+		transport:         config.Transport,
+		storage:           config.Storage,
+		electionTimeout:   config.ElectionTimeout,
+		heartbeatInterval: config.HeartbeatInterval,
+		apply:             config.Apply,
+		onRoleChange:      config.OnRoleChange,
+
+		entries:     []Entry{{}},
+		nextIndex:   make(map[string]int64),
+		matchIndex:  make(map[string]int64),
+		replicating: make(map[string]bool),
+		proposals:   make(map[int64]*proposal),
+
+		commitChanged: make(chan struct{}, 1),
+		stopped:       make(chan struct{}),
+	}
+	if node.electionTimeout == 0 {
+		node.electionTimeout = defaultElectionTimeout
+	}
+	if node.heartbeatInterval == 0 {
+		node.heartbeatInterval = defaultHeartbeatInterval
+	}
+	if node.apply == nil {
+		node.apply = func(Entry) any { return nil }
+	}
+
+	if node.storage != nil {
+		state, loadErr := node.storage.LoadState()
+		if loadErr != nil {
+			return nil, fmt.Errorf("loading raft state: %w", loadErr)
+		}
+		node.term = state.Term
+		node.votedFor = state.VotedFor
+		node.entries = append(node.entries, state.Log...)
+	}
+
+	return node, nil
+}
+
+func (node *Node) ID() string {
+	return node.id
+}
+
+// Start runs elections, replication and the applying of committed entries
+// in the background until Stop is called.
+func (node *Node) Start() {
+	node.mutex.Lock()
+	node.resetElectionDeadline()
+	node.mutex.Unlock()
+
+	go node.run()
+	go node.applyCommitted()
+}
+
+// Stop halts the node. Proposals still waiting fail with ErrStopped.
+func (node *Node) Stop() {
+	node.stopOnce.Do(func() {
+		close(node.stopped)
+	})
+}
+
+func (node *Node) Status() Status {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	return Status{
+		ID:          node.id,
+		Role:        node.role,
+		Term:        node.term,
+		Leader:      node.leader,
+		CommitIndex: node.commitIndex,
+		LastIndex:   node.lastIndex(),
+	}
+}
+
+// IsLeader reports whether the node currently believes it is the leader.
+func (node *Node) IsLeader() bool {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	return node.role == Leader
+}
+
+// Leader returns the ID of the leader as far as the node knows.
+func (node *Node) Leader() string {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	return node.leader
+}
+
+// Propose appends a command to the leader's log and waits until it has
+// been committed and applied on this node, returning Apply's result.
+func (node *Node) Propose(ctx context.Context, command []byte) (any, error) {
+	node.mutex.Lock()
+	index, submitErr := node.submit(command)
+	if submitErr != nil {
+		node.mutex.Unlock()
+		return nil, submitErr
+	}
+	waiting := &proposal{term: node.term, done: make(chan proposalResult, 1)}
+	node.proposals[index] = waiting
+	node.mutex.Unlock()
+
+	select {
+	case result := <-waiting.done:
+		return result.value, result.err
+	case <-ctx.Done():
+		node.mutex.Lock()
+		delete(node.proposals, index)
+		node.mutex.Unlock()
+		return nil, ctx.Err()
+	case <-node.stopped:
+		return nil, ErrStopped
+	}
+}
+
+// Submit appends a command to the leader's log without waiting for it to
+// be committed.
+func (node *Node) Submit(command []byte) error {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	_, submitErr := node.submit(command)
+	return submitErr
+}
+
+func (node *Node) isStopped() bool {
+	select {
+	case <-node.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+func (node *Node) submit(command []byte) (int64, error) {
+	if node.isStopped() {
+		return 0, ErrStopped
+	}
+	if node.role != Leader {
+		return 0, &NotLeaderError{Leader: node.leader}
+	}
+	if command == nil {
+		command = []byte{}
+	}
+
+	index := node.appendEntry(command)
+	node.replicateToAll()
+
+	return index, nil
+}
+
+func (node *Node) appendEntry(command []byte) int64 {
+	entry := Entry{Index: node.lastIndex() + 1, Term: node.term, Command: command}
+	node.entries = append(node.entries, entry)
+	node.persistEntries([]Entry{entry})
+	node.advanceCommit()
+
+	return entry.Index
+}
+
+func (node *Node) lastIndex() int64 {
+	return node.entries[len(node.entries)-1].Index
+}
+
+func (node *Node) lastTerm() int64 {
+	return node.entries[len(node.entries)-1].Term
+}
+
+func (node *Node) persistTerm() {
+	if node.storage == nil {
+		return
+	}
+
+	saveErr := node.storage.SaveTerm(node.term, node.votedFor)
+	if saveErr != nil {
+		log.Printf("Raft %s | Failed to save term | %v", node.id, saveErr)
+	}
+}
+
+func (node *Node) persistEntries(entries []Entry) {
+	if node.storage == nil || len(entries) == 0 {
+		return
+	}
+
+	saveErr := node.storage.AppendEntries(entries)
+	if saveErr != nil {
+		log.Printf("Raft %s | Failed to save log entries | %v", node.id, saveErr)
+	}
+}
+
+func (node *Node) resetElectionDeadline() {
+	timeout := node.electionTimeout + rand.N(node.electionTimeout)
+	node.electionDeadline = time.Now().Add(timeout)
+}
+
+func (node *Node) run() {
+	ticker := time.NewTicker(min(node.heartbeatInterval, node.electionTimeout/10))
+	defer ticker.Stop()
+
+	lastHeartbeat := time.Now()
+	for {
+		select {
+		case <-node.stopped:
+			return
+		case <-ticker.C:
+		}
+
+		node.mutex.Lock()
+		switch {
+		case node.role == Leader && time.Since(lastHeartbeat) >= node.heartbeatInterval:
+			lastHeartbeat = time.Now()
+			node.replicateToAll()
+		case node.role != Leader && time.Now().After(node.electionDeadline):
+			node.startElection()
+		}
+		node.mutex.Unlock()
+	}
+}
+
+func (node *Node) setRole(role Role) {
+	if role == node.role {
+		return
+	}
+
+	node.role = role
+	if node.onRoleChange != nil {
+		go node.onRoleChange()
+	}
+}
+
+func (node *Node) startElection() {
+	node.setRole(Candidate)
+	node.term++
+	node.votedFor = node.id
+	node.leader = ""
+	node.persistTerm()
+	node.resetElectionDeadline()
+	log.Printf("Raft %s | Starting election for term %d", node.id, node.term)
+
+	votes := 1
+	if votes > len(node.peers)/2 {
+		node.becomeLeader()
+		return
+	}
+
+	request := &proto.VoteRequest{Term: node.term, CandidateId: node.id, LastLogIndex: node.lastIndex(), LastLogTerm: node.lastTerm()}
+	for _, peerId := range node.peers {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), node.electionTimeout)
+			defer cancel()
+
+			response, voteErr := node.transport.RequestVote(ctx, peerId, request)
+			if voteErr != nil {
+				return
+			}
+
+			node.mutex.Lock()
+			defer node.mutex.Unlock()
+
+			if response.Term > node.term {
+				node.stepDown(response.Term)
+				return
+			}
+			if node.role != Candidate || node.term != request.Term || !response.VoteGranted {
+				return
+			}
+			votes++
+			if votes > len(node.peers)/2 {
+				node.becomeLeader()
+			}
+		}()
+	}
+}
+
+// becomeLeader appends an empty entry of the new term, which commits every
+// entry left over from earlier terms once a majority has it.
+func (node *Node) becomeLeader() {
+	node.setRole(Leader)
+	node.leader = node.id
+	for _, peerId := range node.peers {
+		node.nextIndex[peerId] = node.lastIndex() + 1
+		node.matchIndex[peerId] = 0
+	}
+	log.Printf("Raft %s | Became leader for term %d", node.id, node.term)
+
+	node.appendEntry(nil)
+	node.replicateToAll()
+}
+
+func (node *Node) stepDown(term int64) {
+	if term > node.term {
+		node.term = term
+		node.votedFor = ""
+		node.persistTerm()
+	}
+	if node.role != Follower {
+		log.Printf("Raft %s | Stepping down to follower in term %d", node.id, node.term)
+	}
+	node.setRole(Follower)
+}
+
+func (node *Node) replicateToAll() {
+	for _, peerId := range node.peers {
+		if !node.replicating[peerId] {
+			node.replicating[peerId] = true
+			go node.replicateTo(peerId)
+		}
+	}
+}
+
+// replicateTo sends the peer the entries it is missing, or a heartbeat when
+// it has them all, and keeps going while the peer is behind.
+func (node *Node) replicateTo(peerId string) {
+	for {
+		node.mutex.Lock()
+		if node.role != Leader || node.isStopped() {
+			node.replicating[peerId] = false
+			node.mutex.Unlock()
+			return
+		}
+
+		prevIndex := node.nextIndex[peerId] - 1
+		request := &proto.AppendEntriesRequest{
+			Term:         node.term,
+			LeaderId:     node.id,
+			PrevLogIndex: prevIndex,
+			PrevLogTerm:  node.entries[prevIndex].Term,
+			LeaderCommit: node.commitIndex,
+		}
+		for _, entry := range node.entries[prevIndex+1 : min(prevIndex+1+maxEntriesPerAppend, int64(len(node.entries)))] {
+			request.Entries = append(request.Entries, &proto.RaftEntry{Index: entry.Index, Term: entry.Term, Command: entry.Command})
+		}
+		node.mutex.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), node.electionTimeout)
+		response, appendErr := node.transport.AppendEntries(ctx, peerId, request)
+		cancel()
+
+		node.mutex.Lock()
+		if appendErr != nil || node.role != Leader || node.term != request.Term {
+			node.replicating[peerId] = false
+			node.mutex.Unlock()
+			return
+		}
+		if response.Term > node.term {
+			node.stepDown(response.Term)
+			node.replicating[peerId] = false
+			node.mutex.Unlock()
+			return
+		}
+
+		if response.Success {
+			node.matchIndex[peerId] = max(node.matchIndex[peerId], prevIndex+int64(len(request.Entries)))
+			node.nextIndex[peerId] = node.matchIndex[peerId] + 1
+			node.advanceCommit()
+		} else {
+			node.nextIndex[peerId] = max(1, min(response.ConflictIndex, node.nextIndex[peerId]-1))
+		}
+
+		caughtUp := node.nextIndex[peerId] > node.lastIndex()
+		if caughtUp {
+			node.replicating[peerId] = false
+		}
+		node.mutex.Unlock()
+
+		if caughtUp {
+			return
+		}
+	}
+}
+
+// advanceCommit commits the newest entry of the current term stored on a
+// majority, and with it every entry before it.
+func (node *Node) advanceCommit() {
+	for index := node.lastIndex(); index > node.commitIndex; index-- {
+		if node.entries[index].Term != node.term {
+			return
+		}
+
+		stored := 1
+		for _, peerId := range node.peers {
+			if node.matchIndex[peerId] >= index {
+				stored++
+			}
+		}
+		if stored > (len(node.peers)+1)/2 {
+			node.setCommitIndex(index)
+			return
+		}
+	}
+}
+
+func (node *Node) setCommitIndex(index int64) {
+	if index <= node.commitIndex {
+		return
+	}
+
+	node.commitIndex = index
+	select {
+	case node.commitChanged <- struct{}{}:
+	default:
+	}
+}
+
+func (node *Node) applyCommitted() {
+	for {
+		select {
+		case <-node.stopped:
+			return
+		case <-node.commitChanged:
+		}
+
+		for {
+			node.mutex.Lock()
+			if node.lastApplied >= node.commitIndex {
+				node.mutex.Unlock()
+				break
+			}
+			node.lastApplied++
+			entry := node.entries[node.lastApplied]
+			waiting := node.proposals[entry.Index]
+			delete(node.proposals, entry.Index)
+			node.mutex.Unlock()
+
+			// Empty entries only mark the start of a leader's term.
+			var value any
+			if len(entry.Command) > 0 {
+				value = node.apply(entry)
+			}
+
+			if waiting == nil {
+				continue
+			}
+			if waiting.term != entry.Term {
+				waiting.done <- proposalResult{err: ErrLostLeadership}
+				continue
+			}
+			waiting.done <- proposalResult{value: value}
+		}
+	}
+}
+
+func (node *Node) handleRequestVote(request *proto.VoteRequest) *proto.VoteResponse {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	if request.Term > node.term {
+		node.stepDown(request.Term)
+	}
+
+	upToDate := request.LastLogTerm > node.lastTerm() || (request.LastLogTerm == node.lastTerm() && request.LastLogIndex >= node.lastIndex())
+	canVote := node.votedFor == "" || node.votedFor == request.CandidateId
+	granted := request.Term == node.term && canVote && upToDate
+	if granted {
+		node.votedFor = request.CandidateId
+		node.persistTerm()
+		node.resetElectionDeadline()
+	}
+
+	return &proto.VoteResponse{Term: node.term, VoteGranted: granted}
+}
+
+func (node *Node) handleAppendEntries(request *proto.AppendEntriesRequest) *proto.AppendEntriesResponse {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	if request.Term < node.term {
+		return &proto.AppendEntriesResponse{Term: node.term}
+	}
+	if request.Term > node.term || node.role != Follower {
+		node.stepDown(request.Term)
+	}
+	node.leader = request.LeaderId
+	node.resetElectionDeadline()
+
+	if request.PrevLogIndex > node.lastIndex() {
+		return &proto.AppendEntriesResponse{Term: node.term, ConflictIndex: node.lastIndex() + 1}
+	}
+	if node.entries[request.PrevLogIndex].Term != request.PrevLogTerm {
+		conflictTerm := node.entries[request.PrevLogIndex].Term
+		conflictIndex := request.PrevLogIndex
+		for conflictIndex > 1 && node.entries[conflictIndex-1].Term == conflictTerm {
+			conflictIndex--
+		}
+		return &proto.AppendEntriesResponse{Term: node.term, ConflictIndex: conflictIndex}
+	}
+
+	var newEntries []Entry
+	for _, entry := range request.Entries {
+		if entry.Index <= node.lastIndex() {
+			if node.entries[entry.Index].Term == entry.Term {
+				continue
+			}
+			node.entries = node.entries[:entry.Index]
+		}
+		newEntry := Entry{Index: entry.Index, Term: entry.Term, Command: entry.Command}
+		node.entries = append(node.entries, newEntry)
+		newEntries = append(newEntries, newEntry)
+	}
+	node.persistEntries(newEntries)
+
+	lastNewIndex := request.PrevLogIndex + int64(len(request.Entries))
+	node.setCommitIndex(min(request.LeaderCommit, lastNewIndex))
+
+	return &proto.AppendEntriesResponse{Term: node.term, Success: true}
+}
@@ -0,0 +1,275 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+const waitTimeout = 5 * time.Second
+
+type cluster struct {
+	t       *testing.T
+	network *MemoryNetwork
+	nodes   []*Node
+
+	mutex   sync.Mutex
+	applied map[string][]string
+}
+
+func startCluster(t *testing.T, size int) *cluster {
+	t.Helper()
+
+	ids := make([]string, size)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("node%d", i)
+	}
+
+	nodes := &cluster{t: t, network: NewMemoryNetwork(), applied: make(map[string][]string)}
+	for _, id := range ids {
+		node, nodeErr := NewNode(Config{
+			ID:                id,
+			Peers:             slices.DeleteFunc(slices.Clone(ids), func(peerId string) bool { return peerId == id }),
+			Transport:         nodes.network.Transport(id),
+			ElectionTimeout:   50 * time.Millisecond,
+			HeartbeatInterval: 10 * time.Millisecond,
+			Apply: func(entry Entry) any {
+				nodes.mutex.Lock()
+				defer nodes.mutex.Unlock()
+				nodes.applied[id] = append(nodes.applied[id], string(entry.Command))
+				return entry.Index
+			},
+		})
+		if nodeErr != nil {
+			t.Fatalf("Failed to create node %s | %v", id, nodeErr)
+		}
+		nodes.network.Add(node)
+		nodes.nodes = append(nodes.nodes, node)
+	}
+	for _, node := range nodes.nodes {
+		node.Start()
+		t.Cleanup(node.Stop)
+	}
+
+	return nodes
+}
+
+// waitForLeader waits until exactly one of the given nodes leads and all of
+// them agree on it.
+func (nodes *cluster) waitForLeader(among ...*Node) *Node {
+	nodes.t.Helper()
+
+	if len(among) == 0 {
+		among = nodes.nodes
+	}
+	deadline := time.Now().Add(waitTimeout)
+	for time.Now().Before(deadline) {
+		var leaders []*Node
+		agreed := true
+		for _, node := range among {
+			if node.IsLeader() {
+				leaders = append(leaders, node)
+			}
+		}
+		if len(leaders) == 1 {
+			for _, node := range among {
+				agreed = agreed && node.Leader() == leaders[0].ID()
+			}
+			if agreed {
+				return leaders[0]
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	nodes.t.Fatalf("No single leader was elected")
+
+	return nil
+}
+
+func (nodes *cluster) waitForApplied(node *Node, want []string) {
+	nodes.t.Helper()
+
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		nodes.mutex.Lock()
+		applied := slices.Clone(nodes.applied[node.ID()])
+		nodes.mutex.Unlock()
+		if slices.Equal(applied, want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			nodes.t.Fatalf("%s applied %v, want %v", node.ID(), applied, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func propose(t *testing.T, node *Node, command string) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+	_, proposeErr := node.Propose(ctx, []byte(command))
+	if proposeErr != nil {
+		t.Fatalf("Proposing %q to %s failed | %v", command, node.ID(), proposeErr)
+	}
+}
+
+func TestCommittedEntriesAreAppliedEverywhereInOrder(t *testing.T) {
+	nodes := startCluster(t, 3)
+	leader := nodes.waitForLeader()
+
+	want := []string{"a", "b", "c"}
+	for _, command := range want {
+		propose(t, leader, command)
+	}
+	for _, node := range nodes.nodes {
+		nodes.waitForApplied(node, want)
+	}
+}
+
+func TestProposalToFollowerNamesTheLeader(t *testing.T) {
+	nodes := startCluster(t, 3)
+	leader := nodes.waitForLeader()
+	follower := nodes.nodes[0]
+	if follower == leader {
+		follower = nodes.nodes[1]
+	}
+
+	_, proposeErr := follower.Propose(context.Background(), []byte("a"))
+	var notLeaderErr *NotLeaderError
+	if !errors.As(proposeErr, &notLeaderErr) || notLeaderErr.Leader != leader.ID() {
+		t.Fatalf("Proposing to a follower returned %v, want a redirect to %s", proposeErr, leader.ID())
+	}
+}
+
+func TestMinorityLeaderCannotCommit(t *testing.T) {
+	nodes := startCluster(t, 5)
+	oldLeader := nodes.waitForLeader()
+	propose(t, oldLeader, "before")
+
+	var majority []*Node
+	var majorityIds []string
+	for _, node := range nodes.nodes {
+		if node != oldLeader && len(majority) < 3 {
+			majority = append(majority, node)
+			majorityIds = append(majorityIds, node.ID())
+		}
+	}
+	var minorityIds []string
+	for _, node := range nodes.nodes {
+		if !slices.Contains(majorityIds, node.ID()) {
+			minorityIds = append(minorityIds, node.ID())
+		}
+	}
+	nodes.network.Partition(majorityIds, minorityIds)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, lostErr := oldLeader.Propose(ctx, []byte("lost"))
+	if lostErr == nil {
+		t.Fatalf("Leader cut off from the majority committed an entry")
+	}
+
+	newLeader := nodes.waitForLeader(majority...)
+	propose(t, newLeader, "after")
+
+	nodes.network.Heal()
+	nodes.waitForLeader()
+	for _, node := range nodes.nodes {
+		nodes.waitForApplied(node, []string{"before", "after"})
+	}
+}
+
+func TestIsolatedFollowerCatchesUp(t *testing.T) {
+	nodes := startCluster(t, 3)
+	leader := nodes.waitForLeader()
+
+	var isolated *Node
+	var connected []string
+	for _, node := range nodes.nodes {
+		if node != leader && isolated == nil {
+			isolated = node
+			continue
+		}
+		connected = append(connected, node.ID())
+	}
+	nodes.network.Partition(connected)
+
+	want := []string{"a", "b", "c"}
+	for _, command := range want {
+		propose(t, leader, command)
+	}
+	nodes.waitForApplied(isolated, nil)
+
+	nodes.network.Heal()
+	nodes.waitForApplied(isolated, want)
+}
+
+func waitUntilLeading(t *testing.T, node *Node) {
+	t.Helper()
+
+	deadline := time.Now().Add(waitTimeout)
+	for !node.IsLeader() {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s did not become leader", node.ID())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+type memoryStorage struct {
+	state State
+}
+
+func (storage *memoryStorage) LoadState() (State, error) {
+	return storage.state, nil
+}
+
+func (storage *memoryStorage) SaveTerm(term int64, votedFor string) error {
+	storage.state.Term, storage.state.VotedFor = term, votedFor
+	return nil
+}
+
+func (storage *memoryStorage) AppendEntries(entries []Entry) error {
+	storage.state.Log = append(storage.state.Log[:entries[0].Index-1], entries...)
+	return nil
+}
+
+func TestNodeRestoresItsState(t *testing.T) {
+	storage := &memoryStorage{}
+	node, nodeErr := NewNode(Config{ID: "solo", Storage: storage, ElectionTimeout: 20 * time.Millisecond, HeartbeatInterval: 5 * time.Millisecond})
+	if nodeErr != nil {
+		t.Fatalf("Failed to create node | %v", nodeErr)
+	}
+	node.Start()
+	waitUntilLeading(t, node)
+	propose(t, node, "a")
+	node.Stop()
+
+	var applied []string
+	restarted, restartErr := NewNode(Config{ID: "solo", Storage: storage, ElectionTimeout: 20 * time.Millisecond, HeartbeatInterval: 5 * time.Millisecond,
+		Apply: func(entry Entry) any {
+			applied = append(applied, string(entry.Command))
+			return nil
+		},
+	})
+	if restartErr != nil {
+		t.Fatalf("Failed to restart node | %v", restartErr)
+	}
+	if status := restarted.Status(); status.Term == 0 || status.LastIndex < 2 {
+		t.Fatalf("Restarted node has term %d and last index %d", status.Term, status.LastIndex)
+	}
+
+	restarted.Start()
+	defer restarted.Stop()
+	waitUntilLeading(t, restarted)
+	propose(t, restarted, "b")
+	if !slices.Equal(applied, []string{"a", "b"}) {
+		t.Fatalf("Restarted node applied %v, want [a b]", applied)
+	}
+}
@@ -0,0 +1,193 @@
+package raft
+
+import (
+	proto "Chitty-Chat/GRPC"
+	"context"
+	"fmt"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// Service serves a node's Raft RPCs over gRPC.
+type Service struct {
+	proto.UnimplementedRaftServiceServer
+	node *Node
+}
+
+func NewService(node *Node) *Service {
+	return &Service{node: node}
+}
+
+func (service *Service) RequestVote(ctx context.Context, request *proto.VoteRequest) (*proto.VoteResponse, error) {
+	return service.node.handleRequestVote(request), nil
+}
+
+func (service *Service) AppendEntries(ctx context.Context, request *proto.AppendEntriesRequest) (*proto.AppendEntriesResponse, error) {
+	return service.node.handleAppendEntries(request), nil
+}
+
+// GRPCTransport reaches other nodes through their RaftService, using each
+// node's ID as its address.
+type GRPCTransport struct {
+	dialOptions []grpc.DialOption
+
+	mutex       sync.Mutex
+	connections map[string]*grpc.ClientConn
+}
+
+func NewGRPCTransport(dialOptions ...grpc.DialOption) *GRPCTransport {
+	return &GRPCTransport{
+		dialOptions: append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, dialOptions...),
+		connections: make(map[string]*grpc.ClientConn),
+	}
+}
+
+func (transport *GRPCTransport) client(to string) (proto.RaftServiceClient, error) {
+	transport.mutex.Lock()
+	defer transport.mutex.Unlock()
+
+	connection, isOpen := transport.connections[to]
+	if !isOpen {
+		var dialErr error
+		connection, dialErr = grpc.NewClient(to, transport.dialOptions...)
+		if dialErr != nil {
+			return nil, fmt.Errorf("dialing %s: %w", to, dialErr)
+		}
+		transport.connections[to] = connection
+	}
+
+	return proto.NewRaftServiceClient(connection), nil
+}
+
+func (transport *GRPCTransport) RequestVote(ctx context.Context, to string, request *proto.VoteRequest) (*proto.VoteResponse, error) {
+	raftClient, clientErr := transport.client(to)
+	if clientErr != nil {
+		return nil, clientErr
+	}
+
+	return raftClient.RequestVote(ctx, request)
+}
+
+func (transport *GRPCTransport) AppendEntries(ctx context.Context, to string, request *proto.AppendEntriesRequest) (*proto.AppendEntriesResponse, error) {
+	raftClient, clientErr := transport.client(to)
+	if clientErr != nil {
+		return nil, clientErr
+	}
+
+	return raftClient.AppendEntries(ctx, request)
+}
+
+func (transport *GRPCTransport) Close() error {
+	transport.mutex.Lock()
+	defer transport.mutex.Unlock()
+
+	for to, connection := range transport.connections {
+		connection.Close()
+		delete(transport.connections, to)
+	}
+
+	return nil
+}
+
+// MemoryNetwork connects nodes in the same process and can partition them,
+// for testing how the cluster behaves when servers cannot reach each other.
+type MemoryNetwork struct {
+	mutex sync.Mutex
+	nodes map[string]*Node
+	// groups assigns every node to a partition; nodes only reach nodes in
+	// the same one. Without groups every node reaches every other.
+	groups map[string]int
+}
+
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{nodes: make(map[string]*Node)}
+}
+
+// Transport returns the transport the node with the given ID sends through.
+func (network *MemoryNetwork) Transport(from string) Transport {
+	return &memoryTransport{network: network, from: from}
+}
+
+// Add makes a node reachable through the network.
+func (network *MemoryNetwork) Add(node *Node) {
+	network.mutex.Lock()
+	defer network.mutex.Unlock()
+
+	network.nodes[node.ID()] = node
+}
+
+// Partition splits the network into the given groups of node IDs. A node
+// left out of every group is cut off from all others.
+func (network *MemoryNetwork) Partition(groups ...[]string) {
+	network.mutex.Lock()
+	defer network.mutex.Unlock()
+
+	network.groups = make(map[string]int)
+	for i, group := range groups {
+		for _, id := range group {
+			network.groups[id] = i + 1
+		}
+	}
+}
+
+// Heal reconnects every node.
+func (network *MemoryNetwork) Heal() {
+	network.mutex.Lock()
+	defer network.mutex.Unlock()
+
+	network.groups = nil
+}
+
+func (network *MemoryNetwork) reach(from string, to string) (*Node, error) {
+	network.mutex.Lock()
+	defer network.mutex.Unlock()
+
+	node, isKnown := network.nodes[to]
+	if !isKnown || node.isStopped() {
+		return nil, status.Errorf(codes.Unavailable, "No node %s on the network", to)
+	}
+	if network.groups != nil && (network.groups[from] == 0 || network.groups[from] != network.groups[to]) {
+		return nil, status.Errorf(codes.Unavailable, "Node %s cannot reach %s", from, to)
+	}
+
+	return node, nil
+}
+
+type memoryTransport struct {
+	network *MemoryNetwork
+	from    string
+}
+
+func (transport *memoryTransport) RequestVote(ctx context.Context, to string, request *proto.VoteRequest) (*proto.VoteResponse, error) {
+	node, reachErr := transport.network.reach(transport.from, to)
+	if reachErr != nil {
+		return nil, reachErr
+	}
+
+	response := node.handleRequestVote(request)
+	_, replyErr := transport.network.reach(to, transport.from)
+	if replyErr != nil {
+		return nil, replyErr
+	}
+
+	return response, nil
+}
+
+func (transport *memoryTransport) AppendEntries(ctx context.Context, to string, request *proto.AppendEntriesRequest) (*proto.AppendEntriesResponse, error) {
+	node, reachErr := transport.network.reach(transport.from, to)
+	if reachErr != nil {
+		return nil, reachErr
+	}
+
+	response := node.handleAppendEntries(request)
+	_, replyErr := transport.network.reach(to, transport.from)
+	if replyErr != nil {
+		return nil, replyErr
+	}
+
+	return response, nil
+}
@@ -1,111 +1,688 @@
-package main
+package server
 
 import (
+	chaos "Chitty-Chat/Chaos"
+	clock "Chitty-Chat/Clock"
 	proto "Chitty-Chat/GRPC"
+	raft "Chitty-Chat/Raft"
+	"cmp"
 	"context"
 	"fmt"
 	"google.golang.org/grpc/metadata"
+	"io"
 	"log"
+	"log/slog"
 	"net"
+	"os"
+	"os/signal"
+	"sort"
 	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
 )
 
 const port = 5050
+const clientQueueLength = 256
+const minClientKeepaliveTime = 10 * time.Second
+
+type ServerConfig struct {
+	DataDirectory    string
+	MailboxCapacity  int
+	MailboxExpiry    time.Duration
+	Owner            string
+	Moderators       []string
+	MessageRate      float64
+	MessageBurst     int
+	JoinRate         float64
+	JoinBurst        int
+	SlowMode         time.Duration
+	MaxMessageLength int
+	ShutdownTimeout  time.Duration
+	// FloorControl lets only the user holding the floor post to the room.
+	// A request for the floor stops waiting for the users that have not
+	// granted it after FloorGrantTimeout, 10 seconds by default.
+	FloorControl      bool
+	FloorGrantTimeout time.Duration
+	// Clock is the kind of clock that stamps messages: "lamport" (the
+	// default), "vector" or "hlc".
+	Clock string
+
+	EnableReflection    bool
+	HealthCheckInterval time.Duration
+
+	KeepaliveTime    time.Duration
+	KeepaliveTimeout time.Duration
+	AwayAfter        time.Duration
+	EvictAfter       time.Duration
+
+	MetricsAddress string
+
+	Chaos              *chaos.Injector
+	EnableChaosControl bool
+
+	// ListenAddress is where StartServer listens, ":5050" by default.
+	ListenAddress string
+	// ServerID names this server to its federation peers and enables the
+	// FederationService. Peers are the addresses of the servers it links
+	// to, dialed with PeerDialOptions. Servers only accept links from peers
+	// with the same FederationSecret, and none without one.
+	ServerID         string
+	Peers            []string
+	PeerDialOptions  []grpc.DialOption
+	FederationSecret string
+
+	// HistoryLength is how many recent broadcasts are kept for rejoining
+	// clients and new backups, 1000 by default.
+	HistoryLength int
+	// ReplicaOf makes the server a backup. It lists the servers ahead of it
+	// in the failover order, the primary first, dialed with
+	// ReplicaDialOptions. The primary sends a heartbeat every
+	// ReplicationHeartbeat, a backup takes over after FailoverTimeout per
+	// server ahead of it without one, and a message is only confirmed to
+	// its sender once the backups have it or ReplicationTimeout has passed,
+	// when the backups that have not acknowledged it are dropped. Replicas
+	// are the IDs of the backups allowed to follow this server, their
+	// ServerID or else their listen address.
+	ReplicaOf            []string
+	Replicas             []string
+	ReplicaDialOptions   []grpc.DialOption
+	ReplicationHeartbeat time.Duration
+	FailoverTimeout      time.Duration
+	ReplicationTimeout   time.Duration
+
+	// RaftPeers makes the server one of a Raft cluster with the servers at
+	// these addresses, which agree on the order of every message. ServerID
+	// must be the address the peers reach this server at. RaftTransport
+	// replaces the gRPC transport, e.g. with an in-memory network in tests.
+	// Messages time out after ReplicationTimeout without a majority.
+	RaftPeers           []string
+	RaftDialOptions     []grpc.DialOption
+	RaftTransport       raft.Transport
+	RaftElectionTimeout time.Duration
+	RaftHeartbeat       time.Duration
+
+	EventLogPath    string
+	EventLogLevel   string
+	EventLogMaxSize int64
+	EventLogBackups int
+}
 
 type ChatServer struct {
 	proto.UnimplementedChatServiceServer
-	clients     map[string]*Client
-	lamportTime int32
+	mutex          sync.Mutex
+	clients        map[string]*Client
+	clock          clock.Clock
+	lastMessageId  int64
+	receipts       *receiptTracker
+	mailbox        *mailbox
+	moderation     *moderation
+	messageLimiter *rateLimiter
+	joinLimiter    *rateLimiter
+	slowMode       time.Duration
+	lastPosts      map[string]time.Time
+	floor          *floorControl
+
+	maxMessageLength int
+
+	shutdownTimeout time.Duration
+	shuttingDown    bool
+	draining        chan struct{}
+	activeStreams   sync.WaitGroup
+
+	store               *storage
+	grpcServer          *grpc.Server
+	health              *health.Server
+	enableReflection    bool
+	healthCheckInterval time.Duration
+	serving             bool
+	storageHealthy      bool
+
+	keepaliveTime    time.Duration
+	keepaliveTimeout time.Duration
+	awayAfter        time.Duration
+	evictAfter       time.Duration
+
+	metrics        *metrics
+	metricsAddress string
+
+	chaos              *chaos.Injector
+	enableChaosControl bool
+
+	listenAddress string
+	federation    *federation
+	history       *messageLog
+	replication   *replication
+	consensus     *consensus
+
+	events      *slog.Logger
+	eventCloser io.Closer
 }
 
 type Client struct {
 	username string
 	stream   proto.ChatService_JoinChatServer
+	queue    chan *proto.Chat
+	removed  chan error
+	server   *ChatServer
+	sink     Sink
+	// peer is the address the client joined from. RPCs made on the user's
+	// behalf must come from the same address.
+	peer string
+
+	lastHeartbeat time.Time
+	lastActivity  time.Time
+	heartbeating  bool
+	away          bool
+}
+
+func newClient(username string, stream proto.ChatService_JoinChatServer, server *ChatServer) *Client {
+	return &Client{
+		username: username,
+		stream:   stream,
+		queue:    make(chan *proto.Chat, clientQueueLength),
+		removed:  make(chan error, 1),
+		server:   server,
+
+		lastHeartbeat: time.Now(),
+		lastActivity:  time.Now(),
+	}
 }
 
-func main() {
-	server := NewChatServer()
-	server.StartServer()
+func (client *Client) enqueue(message *proto.Chat) {
+	if client.sink != nil {
+		client.sink(message)
+		return
+	}
+
+	select {
+	case client.queue <- message:
+	default:
+		log.Printf("Send queue of %s is full, dropping message #%d", client.username, message.Id)
+		client.server.metrics.sendFailed("queue_full")
+		client.server.logEvent(slog.LevelWarn, "queue_full", userAttr(client.username), messageAttr(message.Id))
+	}
 }
 
-func NewChatServer() *ChatServer {
-	return &ChatServer{
-		clients:     make(map[string]*Client),
-		lamportTime: 0,
+func NewChatServer(config ServerConfig) (*ChatServer, error) {
+	store, storageErr := newStorage(config.DataDirectory)
+	if storageErr != nil {
+		return nil, fmt.Errorf("opening data directory %s: %w", config.DataDirectory, storageErr)
+	}
+
+	events, eventCloser, eventLogErr := newEventLogger(config.EventLogPath, config.EventLogLevel, config.EventLogMaxSize, config.EventLogBackups)
+	if eventLogErr != nil {
+		return nil, fmt.Errorf("opening event log %s: %w", config.EventLogPath, eventLogErr)
+	}
+
+	if len(config.Peers) > 0 && config.ServerID == "" {
+		return nil, fmt.Errorf("federating with %v: a server ID is required", config.Peers)
+	}
+	isClustered := len(config.RaftPeers) > 0
+	if isClustered && config.ServerID == "" {
+		return nil, fmt.Errorf("joining Raft cluster %v: a server ID is required", config.RaftPeers)
+	}
+	if isClustered && (len(config.Peers) > 0 || len(config.ReplicaOf) > 0 || len(config.Replicas) > 0) {
+		return nil, fmt.Errorf("a Raft cluster cannot be combined with federation or primary-backup replication")
+	}
+	var links *federation
+	if config.ServerID != "" && !isClustered {
+		links = newFederation(config.ServerID, config.FederationSecret, config.Peers, config.PeerDialOptions)
+	}
+
+	listenAddress := config.ListenAddress
+	if listenAddress == "" {
+		listenAddress = fmt.Sprintf(":%d", port)
+	}
+
+	replicaId := config.ServerID
+	if replicaId == "" {
+		replicaId = listenAddress
+	}
+
+	serverClock, clockErr := clock.New(config.Clock, cmp.Or(config.ServerID, "server"))
+	if clockErr != nil {
+		return nil, fmt.Errorf("creating clock: %w", clockErr)
+	}
+
+	chaosInjector := config.Chaos
+	if chaosInjector == nil && config.EnableChaosControl {
+		chaosInjector, _ = chaos.NewInjector(chaos.Config{})
+	}
+
+	server := &ChatServer{
+		clients:    make(map[string]*Client),
+		clock:      serverClock,
+		receipts:   newReceiptTracker(maxTrackedReceipts),
+		mailbox:    newMailbox(store, config.MailboxCapacity, config.MailboxExpiry),
+		moderation: newModeration(store, config.Owner, config.Moderators),
+
+		messageLimiter: newRateLimiter(config.MessageRate, config.MessageBurst),
+		joinLimiter:    newRateLimiter(config.JoinRate, config.JoinBurst),
+		slowMode:       config.SlowMode,
+		floor:          newFloorControl(config.FloorControl, config.FloorGrantTimeout),
+		lastPosts:      make(map[string]time.Time),
+
+		maxMessageLength: config.MaxMessageLength,
+
+		shutdownTimeout: config.ShutdownTimeout,
+		draining:        make(chan struct{}),
+
+		store:               store,
+		health:              newHealthServer(),
+		enableReflection:    config.EnableReflection,
+		healthCheckInterval: config.HealthCheckInterval,
+		storageHealthy:      true,
+
+		keepaliveTime:    config.KeepaliveTime,
+		keepaliveTimeout: config.KeepaliveTimeout,
+		awayAfter:        config.AwayAfter,
+		evictAfter:       config.EvictAfter,
+
+		metrics:        newMetrics(),
+		metricsAddress: config.MetricsAddress,
+
+		chaos:              chaosInjector,
+		enableChaosControl: config.EnableChaosControl,
+
+		listenAddress: listenAddress,
+		federation:    links,
+		history:       newMessageLog(config.HistoryLength),
+		replication:   newReplication(config, replicaId),
+
+		events:      events,
+		eventCloser: eventCloser,
+	}
+
+	if isClustered {
+		cluster, consensusErr := newConsensus(server, config)
+		if consensusErr != nil {
+			return nil, fmt.Errorf("starting Raft: %w", consensusErr)
+		}
+		server.consensus = cluster
 	}
+
+	return server, nil
+}
+
+func (server *ChatServer) LamportTime() int64 {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	return server.clock.Now().Lamport
+}
+
+// mergeClock advances the server's clock past a received time.
+func (server *ChatServer) mergeClock(received clock.Time) clock.Time {
+	server.metrics.lamportJump(received.Lamport - server.clock.Now().Lamport)
+	return server.clock.Merge(received)
 }
 
-func (server *ChatServer) updateTimestamp(incomingTimestamp int32) {
-	server.lamportTime = max(incomingTimestamp, server.lamportTime) + 1
+// legacyTimestamp returns the Lamport time for the int32 timestamp fields
+// older clients read, counting and reporting when it no longer fits.
+func (server *ChatServer) legacyTimestamp(lamport int64) int32 {
+	legacy, fits := clock.Legacy(lamport)
+	if !fits && server.metrics.legacyOverflow() == 1 {
+		log.Printf("LT%d | Lamport time no longer fits in 32 bits, older clients see LT%d", lamport, legacy)
+		server.logEvent(slog.LevelWarn, "legacy_timestamp_overflow", slog.Int64("legacy_lamport", int64(legacy)))
+	}
+
+	return legacy
 }
 
-func (server *ChatServer) StartServer() {
-	portString := fmt.Sprintf(":%d", port)
-	listener, listenErr := net.Listen("tcp", portString)
+// StartServer serves on the listen address until the process receives
+// SIGINT or SIGTERM, then shuts the server down gracefully.
+func (server *ChatServer) StartServer() error {
+	listener, listenErr := net.Listen("tcp", server.listenAddress)
 	if listenErr != nil {
-		log.Fatalf("Failed to listen on port %s | %v", portString, listenErr)
+		return fmt.Errorf("listening on %s: %w", server.listenAddress, listenErr)
 	}
 
-	grpcServer := grpc.NewServer()
-	proto.RegisterChatServiceServer(grpcServer, server)
-	log.Printf("LT%d | ChatService server has started", server.lamportTime)
+	signalContext, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	shutdownComplete := make(chan struct{})
+	go func() {
+		<-signalContext.Done()
+		server.Shutdown()
+		close(shutdownComplete)
+	}()
 
-	serveListenerErr := grpcServer.Serve(listener)
+	serveListenerErr := server.Serve(listener)
 	if serveListenerErr != nil {
-		log.Fatalf("Failed to serve listener | %v", serveListenerErr)
+		return fmt.Errorf("serving listener: %w", serveListenerErr)
+	}
+
+	<-shutdownComplete
+	log.Print("ChatService server has stopped")
+
+	return nil
+}
+
+func (server *ChatServer) Serve(listener net.Listener) error {
+	unaryInterceptors := []grpc.UnaryServerInterceptor{server.metrics.unaryInterceptor, server.followerUnaryInterceptor}
+	streamInterceptors := []grpc.StreamServerInterceptor{server.metrics.streamInterceptor, server.followerStreamInterceptor}
+	if server.chaos != nil {
+		unaryInterceptors = append(unaryInterceptors, server.chaos.UnaryServerInterceptor)
+		streamInterceptors = append(streamInterceptors, server.chaos.StreamServerInterceptor)
+	}
+
+	serverOptions := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             minClientKeepaliveTime,
+			PermitWithoutStream: true,
+		}),
+	}
+	if server.keepaliveTime > 0 {
+		serverOptions = append(serverOptions, grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    server.keepaliveTime,
+			Timeout: server.keepaliveTimeout,
+		}))
+	}
+
+	grpcServer := grpc.NewServer(serverOptions...)
+	proto.RegisterChatServiceServer(grpcServer, server)
+	healthpb.RegisterHealthServer(grpcServer, server.health)
+	if server.enableChaosControl {
+		proto.RegisterChaosServiceServer(grpcServer, chaos.NewControlServer(server.chaos))
+	}
+	if server.federation != nil {
+		proto.RegisterFederationServiceServer(grpcServer, &federationService{server: server})
+		proto.RegisterSnapshotServiceServer(grpcServer, &snapshotService{server: server})
+	}
+	if server.replication.isConfigured() {
+		proto.RegisterReplicationServiceServer(grpcServer, &replicationService{server: server})
 	}
+	if server.consensus != nil {
+		proto.RegisterRaftServiceServer(grpcServer, raft.NewService(server.consensus.node))
+	}
+	if server.enableReflection {
+		reflection.Register(grpcServer)
+	}
+
+	server.mutex.Lock()
+	server.grpcServer = grpcServer
+	server.serving = true
+	server.updateHealth()
+	log.Printf("LT%d | ChatService server has started", server.clock.Now().Lamport)
+	server.logEvent(slog.LevelInfo, "server_started", slog.String("address", listener.Addr().String()))
+	server.mutex.Unlock()
+
+	if server.healthCheckInterval > 0 {
+		go server.monitorStorage()
+	}
+	if server.awayAfter > 0 || server.evictAfter > 0 {
+		go server.monitorPresence()
+	}
+	if server.metricsAddress != "" {
+		go server.serveMetrics(server.metricsAddress)
+	}
+	if server.federation != nil {
+		for _, address := range server.federation.peerAddresses {
+			go server.linkPeer(address)
+		}
+	}
+	if len(server.replication.primaries) > 0 {
+		go server.followPrimary()
+	}
+	if server.consensus != nil {
+		server.consensus.node.Start()
+	}
+
+	return grpcServer.Serve(listener)
 }
 
 func (server *ChatServer) JoinChat(user *proto.UserRequest, stream proto.ChatService_JoinChatServer) error {
+	newUserClient := newClient(user.Username, stream, server)
+	joined, joinErr := server.join(stream.Context(), user, newUserClient, func(lamportTime int64) error {
+		return stream.SetHeader(metadata.Pairs(
+			"lamport-timestamp", strconv.Itoa(int(server.legacyTimestamp(lamportTime))),
+			"lamport-time", strconv.FormatInt(lamportTime, 10),
+		))
+	})
+	if !joined {
+		return joinErr
+	}
+	defer server.activeStreams.Done()
+
+	for {
+		select {
+		case message := <-newUserClient.queue:
+			newUserClient.send(message)
+		case <-stream.Context().Done():
+			server.mutex.Lock()
+			if server.clients[user.Username] == newUserClient {
+				now := server.clock.Now()
+				user.Lamport, user.Clock = now.Lamport, now.Proto()
+				server.leaveChat(user)
+			}
+			server.mutex.Unlock()
+			return status.Error(codes.Canceled, "Stream was closed")
+		case removedErr := <-newUserClient.removed:
+			return removedErr
+		case <-server.draining:
+			newUserClient.drain()
+			return nil
+		}
+	}
+}
+
+// join admits a new client, announces it and hands it its queued offline
+// messages. sendHeader is given the server's Lamport time after receiving
+// the join, which the client merges into its clock. It returns false when
+// the client was not admitted; a user that has already joined is ignored
+// without an error.
+func (server *ChatServer) join(ctx context.Context, user *proto.UserRequest, newUserClient *Client, sendHeader func(int64) error) (bool, error) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if server.shuttingDown {
+		return false, status.Error(codes.Unavailable, "Server is shutting down")
+	}
+
+	usernameErr := validateUsername(user.Username)
+	if usernameErr != nil {
+		server.logEvent(slog.LevelWarn, "join_rejected", userAttr(user.Username), peerAttr(ctx), slog.String("reason", "invalid_username"))
+		return false, usernameErr
+	}
+
+	joinRateErr := server.checkJoinRate(ctx, user.Username)
+	if joinRateErr != nil {
+		server.logEvent(slog.LevelWarn, "join_rejected", userAttr(user.Username), peerAttr(ctx), slog.String("reason", "rate_limited"))
+		return false, joinRateErr
+	}
+
 	_, userAlreadyJoined := server.clients[user.Username]
 	if userAlreadyJoined {
+		server.logEvent(slog.LevelWarn, "join_rejected", userAttr(user.Username), peerAttr(ctx), slog.String("reason", "already_joined"))
 		log.Printf("User %s has already joined, but is requesting to join again, ignoring...", user.Username)
-		return nil
+		return false, nil
+	}
+	if server.isRemoteUser(user.Username) {
+		server.logEvent(slog.LevelWarn, "join_rejected", userAttr(user.Username), peerAttr(ctx), slog.String("reason", "joined_on_peer"))
+		return false, status.Errorf(codes.AlreadyExists, "User %s is already in the chat on server %s", user.Username, server.federation.remoteUsers[user.Username].home)
 	}
 
-	md := metadata.Pairs("lamport-timestamp", strconv.Itoa(int(server.lamportTime)))
-	streamHeaderErr := stream.SetHeader(md)
-	if streamHeaderErr != nil {
-		log.Fatalf("Failed to set header on stream | %v", streamHeaderErr)
+	ban, isBanned := server.moderation.activeSanction(server.moderation.Bans, user.Username, time.Now())
+	if isBanned {
+		server.logEvent(slog.LevelWarn, "join_rejected", userAttr(user.Username), peerAttr(ctx), slog.String("reason", "banned"))
+		log.Printf("Banned user %s tried to join, rejecting", user.Username)
+		return false, status.Errorf(codes.PermissionDenied, "You are banned %s", ban.describe())
 	}
 
-	newUserClient := &Client{username: user.Username, stream: stream}
-	server.clients[user.Username] = newUserClient
+	server.clock.Tick()
+	server.mergeClock(clock.FromProto(clock.Widen(user.Lamport, user.Timestamp), user.Clock))
+
+	headerErr := sendHeader(server.clock.Now().Lamport)
+	if headerErr != nil {
+		log.Printf("Failed to set header on stream | %v", headerErr)
+		return false, headerErr
+	}
+	var seenUpTo int64
+	if user.LastMessageId > 0 {
+		seenUpTo = server.replayHistory(newUserClient, user.LastMessageId)
+	}
 
-	server.lamportTime++
-	server.updateTimestamp(user.Timestamp)
+	newUserClient.peer = peerAddress(ctx)
+	server.clients[user.Username] = newUserClient
 
-	joinMessage := fmt.Sprintf("User %s join request received at LT%d", user.Username, server.lamportTime)
+	joinMessage := fmt.Sprintf("User %s join request received at LT%d", user.Username, server.clock.Now().Lamport)
 	log.Print(joinMessage)
+	server.logEvent(slog.LevelInfo, "join", userAttr(user.Username), peerAttr(ctx), slog.Int64("sent_lamport", clock.Widen(user.Lamport, user.Timestamp)))
 
 	joinMsg := &proto.Chat{
-		Username:  "Server",
-		Message:   joinMessage,
-		Timestamp: server.lamportTime,
+		Username: "Server",
+		Message:  joinMessage,
+		Kind:     proto.ChatKind_SYSTEM,
+	}
+	server.publish(joinMsg, user.Username)
+	server.federatePresence(proto.FederationKind_USER_JOINED, user.Username)
+	server.mailbox.rememberUser(user.Username)
+	server.replicate(&proto.ReplicationEntry{KnownUsers: []string{user.Username}})
+	server.flushMailbox(newUserClient, seenUpTo)
+	if newUserClient.stream != nil {
+		server.activeStreams.Add(1)
 	}
-	server.broadcastMessage(joinMsg)
 
-	select {
-	case <-stream.Context().Done():
-		user.Timestamp = server.lamportTime
-		server.leaveChat(user)
-		return status.Error(codes.Canceled, "Stream was closed")
+	return true, nil
+}
+
+// send streams a message to the client. It is called from the client's
+// stream loop without the server mutex held.
+func (client *Client) send(message *proto.Chat) {
+	sendErr := client.stream.Send(message)
+	if sendErr != nil {
+		log.Printf("Failed to send message to %s | %v", client.username, sendErr)
+		client.server.metrics.sendFailed("send_error")
+		client.server.mutex.Lock()
+		client.server.logEvent(slog.LevelWarn, "send_failed", userAttr(client.username), messageAttr(message.Id), slog.String("error", sendErr.Error()))
+		client.server.mutex.Unlock()
 	}
 }
 
+func (client *Client) drain() {
+	for {
+		select {
+		case message := <-client.queue:
+			client.send(message)
+		default:
+			return
+		}
+	}
+}
+
+// BroadcastMessage confirms a message once it has been broadcast and every
+// backup has a copy of it or has been dropped for not acknowledging it in
+// time, or in a Raft cluster once a majority has it.
 func (server *ChatServer) BroadcastMessage(ctx context.Context, chat *proto.Chat) (*proto.Empty, error) {
-	server.updateTimestamp(chat.Timestamp)
-	log.Printf("LT%d | Message received", server.lamportTime)
-	server.broadcastMessage(chat)
+	if server.consensus != nil {
+		commitErr := server.commitChat(ctx, chat)
+		if commitErr != nil {
+			return nil, commitErr
+		}
+		return &proto.Empty{}, nil
+	}
+
+	broadcastErr := server.broadcastChat(ctx, chat)
+	if broadcastErr != nil {
+		return nil, broadcastErr
+	}
+
+	server.awaitReplication(ctx, chat.Id)
 
 	return &proto.Empty{}, nil
 }
 
+func (server *ChatServer) broadcastChat(ctx context.Context, chat *proto.Chat) error {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	acceptErr := server.acceptChat(ctx, chat)
+	if acceptErr != nil {
+		return acceptErr
+	}
+
+	server.broadcastMessage(chat)
+	server.federateChat(chat)
+	server.receipts.track(chat)
+	server.queueForOfflineUsers(chat)
+	server.markActive(chat.Username)
+
+	return nil
+}
+
+// acceptChat checks that the sender is the joined user the message names,
+// merges their clock and checks that the message may be sent, filling in
+// its mentions.
+func (server *ChatServer) acceptChat(ctx context.Context, chat *proto.Chat) error {
+	if server.shuttingDown {
+		return status.Error(codes.Unavailable, "Server is shutting down")
+	}
+
+	authenticateErr := server.authenticate(ctx, chat.Username)
+	if authenticateErr != nil {
+		return authenticateErr
+	}
+
+	server.mergeClock(clock.FromProto(clock.Widen(chat.Lamport, chat.Timestamp), chat.Clock))
+	log.Printf("LT%d | Message received", server.clock.Now().Lamport)
+	server.logEvent(slog.LevelInfo, "message_received", userAttr(chat.Username), peerAttr(ctx), slog.Int64("sent_lamport", clock.Widen(chat.Lamport, chat.Timestamp)))
+
+	rejectErr := server.checkMessage(ctx, chat)
+	if rejectErr != nil {
+		server.logEvent(slog.LevelWarn, "message_rejected", userAttr(chat.Username), peerAttr(ctx), slog.String("reason", status.Code(rejectErr).String()))
+		return rejectErr
+	}
+
+	chat.Mentions = mentionedUsernames(chat.Message)
+	server.metrics.messageReceived()
+
+	return nil
+}
+
+func (server *ChatServer) checkMessage(ctx context.Context, chat *proto.Chat) error {
+	mute, isMuted := server.moderation.activeSanction(server.moderation.Mutes, chat.Username, time.Now())
+	if isMuted {
+		return status.Errorf(codes.PermissionDenied, "You are muted %s", mute.describe())
+	}
+
+	floorErr := server.checkFloor(chat)
+	if floorErr != nil {
+		return floorErr
+	}
+
+	validationErr := server.validateMessage(chat.Message)
+	if validationErr != nil {
+		return validationErr
+	}
+
+	if chat.Recipient != "" && !server.mailbox.isKnown(chat.Recipient) && !server.isRemoteUser(chat.Recipient) {
+		return status.Errorf(codes.NotFound, "User %s has never joined the chat", chat.Recipient)
+	}
+
+	// Only messages that pass every other check count against the limits.
+	messageRateErr := server.checkMessageRate(ctx, chat.Username)
+	if messageRateErr != nil {
+		return messageRateErr
+	}
+
+	return nil
+}
+
 func (server *ChatServer) LeaveChat(ctx context.Context, user *proto.UserRequest) (*proto.Empty, error) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
 	server.leaveChat(user)
 
 	return &proto.Empty{}, nil
@@ -117,29 +694,51 @@ func (server *ChatServer) leaveChat(user *proto.UserRequest) {
 		return
 	}
 
-	server.updateTimestamp(user.Timestamp)
+	server.mergeClock(clock.FromProto(clock.Widen(user.Lamport, user.Timestamp), user.Clock))
 	delete(server.clients, user.Username)
+	server.federatePresence(proto.FederationKind_USER_LEFT, user.Username)
+	server.dropFromFloor(user.Username)
 
-	leaveMessage := fmt.Sprintf("User %s leave request received at LT%d", user.Username, server.lamportTime)
+	leaveMessage := fmt.Sprintf("User %s leave request received at LT%d", user.Username, server.clock.Now().Lamport)
 	log.Print(leaveMessage)
+	server.logEvent(slog.LevelInfo, "leave", userAttr(user.Username), slog.Int64("sent_lamport", clock.Widen(user.Lamport, user.Timestamp)))
 
 	leaveMsg := &proto.Chat{
-		Username:  "Server",
-		Message:   leaveMessage,
-		Timestamp: server.lamportTime,
+		Username: "Server",
+		Message:  leaveMessage,
+		Kind:     proto.ChatKind_SYSTEM,
 	}
-	server.broadcastMessage(leaveMsg)
+	server.publish(leaveMsg)
+}
+
+func (server *ChatServer) onlineUsernames() []string {
+	usernames := make([]string, 0, len(server.clients))
+	for username := range server.clients {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+
+	return usernames
 }
 
 func (server *ChatServer) broadcastMessage(message *proto.Chat) {
-	server.lamportTime++
-	message.Timestamp = server.lamportTime
-	log.Printf("LT%d | Broadcasting: '%s: %s'", server.lamportTime, message.Username, message.Message)
-	for username, userConnection := range server.clients {
-		sendErr := userConnection.stream.Send(message)
-		if sendErr != nil {
-			log.Printf("Failed to send message to %s | %v", username, sendErr)
+	now := server.clock.Tick()
+	server.lastMessageId++
+	message.Timestamp = server.legacyTimestamp(now.Lamport)
+	message.Lamport = now.Lamport
+	message.Clock = now.Proto()
+	message.Id = server.lastMessageId
+	server.metrics.messageBroadcast()
+	log.Printf("LT%d | Broadcasting: '%s: %s'", now.Lamport, message.Username, message.Message)
+	server.logEvent(slog.LevelInfo, "broadcast", userAttr(message.Username), messageAttr(message.Id), slog.String("kind", message.Kind.String()), slog.String("recipient", message.Recipient))
+	server.history.append(message)
+	server.replicate(&proto.ReplicationEntry{Chat: message})
+	for _, username := range server.onlineUsernames() {
+		if !isAddressedTo(message, username) {
 			continue
 		}
+
+		server.logEvent(slog.LevelDebug, "deliver", userAttr(username), messageAttr(message.Id))
+		server.clients[username].enqueue(message)
 	}
 }
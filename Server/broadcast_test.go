@@ -0,0 +1,57 @@
+package server
+
+import (
+	proto "Chitty-Chat/GRPC"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"testing"
+)
+
+// BenchmarkBroadcastMessage measures the fan-out of one broadcast to the
+// send queues of every connected client, with a goroutine per client
+// draining its queue like JoinChat does.
+func BenchmarkBroadcastMessage(b *testing.B) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for _, clientCount := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("clients=%d", clientCount), func(b *testing.B) {
+			server := newTestServer(b, ServerConfig{})
+			done := make(chan struct{})
+			var draining sync.WaitGroup
+			for i := range clientCount {
+				username := fmt.Sprintf("user%d", i)
+				queueClient := newClient(username, nil, server)
+				server.clients[username] = queueClient
+
+				draining.Add(1)
+				go func() {
+					defer draining.Done()
+					for {
+						select {
+						case <-queueClient.queue:
+						case <-done:
+							return
+						}
+					}
+				}()
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				server.mutex.Lock()
+				server.broadcastMessage(&proto.Chat{Username: "user0", Message: "hello"})
+				server.mutex.Unlock()
+			}
+			b.StopTimer()
+
+			close(done)
+			draining.Wait()
+			b.ReportMetric(float64(b.N*clientCount)/b.Elapsed().Seconds(), "deliveries/s")
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package server
+
+import (
+	proto "Chitty-Chat/GRPC"
+	raft "Chitty-Chat/Raft"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+const raftDocument = "raft"
+const raftLogJournal = "raft-log"
+const notLeaderReason = "NOT_LEADER"
+
+// consensus runs the server as one of a Raft cluster. Every broadcast goes
+// through the Raft log and is applied on every server in log order, so all
+// of them number and order messages the same way. Only the leader accepts
+// clients; the others point them to it.
+type consensus struct {
+	node          *raft.Node
+	transport     raft.Transport
+	commitTimeout time.Duration
+	// restoredIndex is the last log entry stored before the server
+	// restarted. Those entries only rebuild the history when applied again.
+	restoredIndex int64
+}
+
+// raftStorage keeps the term and vote in the raft document, which is
+// replaced whenever they change, and the log in the raft-log journal, which
+// is only ever appended to.
+type raftStorage struct {
+	store *storage
+}
+
+func (raftStore raftStorage) LoadState() (raft.State, error) {
+	var state raft.State
+	loadErr := raftStore.store.load(raftDocument, &state)
+	if loadErr != nil {
+		return state, loadErr
+	}
+
+	replayErr := raftStore.store.loadRecords(raftLogJournal, func(record json.RawMessage) error {
+		var entry raft.Entry
+		unmarshalErr := json.Unmarshal(record, &entry)
+		if unmarshalErr != nil {
+			return unmarshalErr
+		}
+		if entry.Index < 1 || entry.Index > int64(len(state.Log))+1 {
+			return fmt.Errorf("raft log entry %d does not follow entry %d", entry.Index, len(state.Log))
+		}
+
+		state.Log = append(state.Log[:entry.Index-1], entry)
+		return nil
+	})
+
+	return state, replayErr
+}
+
+func (raftStore raftStorage) SaveTerm(term int64, votedFor string) error {
+	return raftStore.store.save(raftDocument, raft.State{Term: term, VotedFor: votedFor})
+}
+
+func (raftStore raftStorage) AppendEntries(entries []raft.Entry) error {
+	records := make([]any, 0, len(entries))
+	for _, entry := range entries {
+		records = append(records, entry)
+	}
+
+	return raftStore.store.appendRecords(raftLogJournal, records...)
+}
+
+func newConsensus(server *ChatServer, config ServerConfig) (*consensus, error) {
+	transport := config.RaftTransport
+	if transport == nil {
+		transport = raft.NewGRPCTransport(config.RaftDialOptions...)
+	}
+
+	cluster := &consensus{transport: transport, commitTimeout: config.ReplicationTimeout}
+	if cluster.commitTimeout == 0 {
+		cluster.commitTimeout = defaultReplicationTimeout
+	}
+
+	node, nodeErr := raft.NewNode(raft.Config{
+		ID:                config.ServerID,
+		Peers:             config.RaftPeers,
+		Transport:         transport,
+		Storage:           raftStorage{store: server.store},
+		ElectionTimeout:   config.RaftElectionTimeout,
+		HeartbeatInterval: config.RaftHeartbeat,
+		Apply:             server.applyCommitted,
+		OnRoleChange:      server.roleChanged,
+	})
+	if nodeErr != nil {
+		return nil, nodeErr
+	}
+	cluster.node = node
+	cluster.restoredIndex = node.Status().LastIndex
+
+	return cluster, nil
+}
+
+// RaftNode returns the server's Raft node, or nil when it does not run as
+// part of a cluster.
+func (server *ChatServer) RaftNode() *raft.Node {
+	if server.consensus == nil {
+		return nil
+	}
+
+	return server.consensus.node
+}
+
+func notLeaderError(serverId string, leader string) error {
+	if leader == "" {
+		return status.Errorf(codes.Unavailable, "Server %s is not the leader and no leader is elected", serverId)
+	}
+
+	notLeaderStatus := status.Newf(codes.Unavailable, "Server %s is not the leader, the leader is %s", serverId, leader)
+	detailedStatus, detailsErr := notLeaderStatus.WithDetails(&errdetails.ErrorInfo{
+		Reason:   notLeaderReason,
+		Domain:   "chitty-chat",
+		Metadata: map[string]string{"leader": leader},
+	})
+	if detailsErr != nil {
+		return notLeaderStatus.Err()
+	}
+
+	return detailedStatus.Err()
+}
+
+func (server *ChatServer) rejectOnRaftFollower() error {
+	if server.consensus == nil {
+		return nil
+	}
+
+	nodeStatus := server.consensus.node.Status()
+	if nodeStatus.Role == raft.Leader {
+		return nil
+	}
+
+	return notLeaderError(nodeStatus.ID, nodeStatus.Leader)
+}
+
+// roleChanged disconnects everyone once the server is no longer the
+// leader, so that clients rejoin the new one.
+func (server *ChatServer) roleChanged() {
+	nodeStatus := server.consensus.node.Status()
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	log.Printf("LT%d | Raft role is now %s in term %d", server.clock.Now().Lamport, nodeStatus.Role, nodeStatus.Term)
+	server.logEvent(slog.LevelInfo, "raft_role", slog.String("role", nodeStatus.Role.String()), slog.Int64("term", nodeStatus.Term))
+	if nodeStatus.Role == raft.Leader {
+		return
+	}
+
+	for _, username := range server.onlineUsernames() {
+		server.removeClient(username, notLeaderError(nodeStatus.ID, nodeStatus.Leader))
+	}
+}
+
+// publish broadcasts a server notice, through the Raft log when the server
+// is part of a cluster. knownUsers are remembered by every server.
+func (server *ChatServer) publish(message *proto.Chat, knownUsers ...string) {
+	if server.consensus == nil {
+		server.broadcastMessage(message)
+		return
+	}
+
+	command, marshalErr := protobuf.Marshal(&proto.ReplicationEntry{Chat: message, KnownUsers: knownUsers})
+	if marshalErr != nil {
+		log.Printf("Failed to encode notice | %v", marshalErr)
+		return
+	}
+
+	submitErr := server.consensus.node.Submit(command)
+	if submitErr != nil {
+		log.Printf("Dropping notice '%s' | %v", message.Message, submitErr)
+	}
+}
+
+// commitNotice broadcasts a server notice through the Raft log and waits
+// until it has been applied or the deadline has passed.
+func (server *ChatServer) commitNotice(message *proto.Chat, deadline time.Time) {
+	command, marshalErr := protobuf.Marshal(&proto.ReplicationEntry{Chat: message})
+	if marshalErr != nil {
+		log.Printf("Failed to encode notice | %v", marshalErr)
+		return
+	}
+
+	commitCtx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	_, proposeErr := server.consensus.node.Propose(commitCtx, command)
+	if proposeErr != nil {
+		log.Printf("Dropping notice '%s' | %v", message.Message, proposeErr)
+	}
+}
+
+// commitChat checks a message and confirms it once a majority of the
+// cluster has stored it and this server has broadcast it.
+func (server *ChatServer) commitChat(ctx context.Context, chat *proto.Chat) error {
+	server.mutex.Lock()
+	acceptErr := server.acceptChat(ctx, chat)
+	server.mutex.Unlock()
+	if acceptErr != nil {
+		return acceptErr
+	}
+
+	command, marshalErr := protobuf.Marshal(&proto.ReplicationEntry{Chat: chat})
+	if marshalErr != nil {
+		return status.Errorf(codes.Internal, "Could not encode message: %v", marshalErr)
+	}
+
+	commitCtx, cancel := context.WithTimeout(ctx, server.consensus.commitTimeout)
+	defer cancel()
+	messageId, proposeErr := server.consensus.node.Propose(commitCtx, command)
+
+	var notLeaderErr *raft.NotLeaderError
+	switch {
+	case proposeErr == nil:
+		chat.Id = messageId.(int64)
+		return nil
+	case errors.As(proposeErr, &notLeaderErr):
+		return notLeaderError(server.consensus.node.ID(), notLeaderErr.Leader)
+	case errors.Is(proposeErr, raft.ErrLostLeadership), errors.Is(proposeErr, raft.ErrStopped):
+		return status.Error(codes.Unavailable, "Leadership changed before the message was committed")
+	case errors.Is(proposeErr, context.DeadlineExceeded) && ctx.Err() == nil:
+		return status.Errorf(codes.DeadlineExceeded, "Message was not committed by a majority within %v", server.consensus.commitTimeout)
+	default:
+		return status.FromContextError(proposeErr).Err()
+	}
+}
+
+// applyCommitted broadcasts a committed entry on every server of the
+// cluster and returns the ID the message was given.
+func (server *ChatServer) applyCommitted(entry raft.Entry) any {
+	command := &proto.ReplicationEntry{}
+	unmarshalErr := protobuf.Unmarshal(entry.Command, command)
+	if unmarshalErr != nil {
+		log.Printf("Skipping undecodable Raft entry %d | %v", entry.Index, unmarshalErr)
+		return int64(0)
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	for _, username := range command.KnownUsers {
+		server.mailbox.rememberUser(username)
+	}
+	if command.Chat == nil {
+		return int64(0)
+	}
+
+	chat := command.Chat
+	server.broadcastMessage(chat)
+	if entry.Index > server.consensus.restoredIndex && chat.Kind == proto.ChatKind_MESSAGE {
+		server.receipts.track(chat)
+		server.queueForOfflineUsers(chat)
+		server.markActive(chat.Username)
+	}
+
+	return chat.Id
+}
+
+func (server *ChatServer) stopConsensus() {
+	if server.consensus == nil {
+		return
+	}
+
+	server.consensus.node.Stop()
+	if closer, isCloser := server.consensus.transport.(interface{ Close() error }); isCloser {
+		closeErr := closer.Close()
+		if closeErr != nil {
+			log.Printf("Failed to close Raft connections | %v", closeErr)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	raft "Chitty-Chat/Raft"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func entryTerms(entries []raft.Entry) []int64 {
+	terms := make([]int64, 0, len(entries))
+	for _, entry := range entries {
+		terms = append(terms, entry.Term)
+	}
+
+	return terms
+}
+
+func TestRaftStorageReplaysTheJournal(t *testing.T) {
+	directory := t.TempDir()
+	store, storageErr := newStorage(directory)
+	if storageErr != nil {
+		t.Fatalf("Opening storage failed | %v", storageErr)
+	}
+	raftStore := raftStorage{store: store}
+
+	for _, entries := range [][]raft.Entry{
+		{{Index: 1, Term: 1}, {Index: 2, Term: 1}, {Index: 3, Term: 1}},
+		{{Index: 2, Term: 2}},
+		{{Index: 3, Term: 3}},
+	} {
+		appendErr := raftStore.AppendEntries(entries)
+		if appendErr != nil {
+			t.Fatalf("Appending entries failed | %v", appendErr)
+		}
+	}
+	saveErr := raftStore.SaveTerm(3, "server-2")
+	if saveErr != nil {
+		t.Fatalf("Saving the term failed | %v", saveErr)
+	}
+
+	// A crash while appending leaves the last record half-written.
+	journal, openErr := os.OpenFile(filepath.Join(directory, raftLogJournal+".jsonl"), os.O_WRONLY|os.O_APPEND, 0)
+	if openErr != nil {
+		t.Fatalf("Opening the journal failed | %v", openErr)
+	}
+	journal.WriteString(`{"Index":4,"Te`)
+	journal.Close()
+
+	state, loadErr := raftStore.LoadState()
+	if loadErr != nil {
+		t.Fatalf("Loading the state failed | %v", loadErr)
+	}
+	if state.Term != 3 || state.VotedFor != "server-2" {
+		t.Errorf("Loaded term %d with vote for %q, want term 3 with vote for server-2", state.Term, state.VotedFor)
+	}
+	if terms, want := entryTerms(state.Log), []int64{1, 2, 3}; !slices.Equal(terms, want) {
+		t.Fatalf("Loaded log with terms %v, want %v", terms, want)
+	}
+
+	appendErr := raftStore.AppendEntries([]raft.Entry{{Index: 4, Term: 3}})
+	if appendErr != nil {
+		t.Fatalf("Appending after the torn record failed | %v", appendErr)
+	}
+	state, loadErr = raftStore.LoadState()
+	if loadErr != nil {
+		t.Fatalf("Loading the state again failed | %v", loadErr)
+	}
+	if terms, want := entryTerms(state.Log), []int64{1, 2, 3, 3}; !slices.Equal(terms, want) {
+		t.Errorf("Loaded log with terms %v after appending, want %v", terms, want)
+	}
+}
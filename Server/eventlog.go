@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+const disabledLevel = slog.Level(1 << 30)
+
+// rotatingFile is an io.Writer that starts a new file once the current one
+// would grow beyond maxSize, keeping up to backups older files named
+// path.1 (newest) to path.N (oldest).
+type rotatingFile struct {
+	mutex   sync.Mutex
+	path    string
+	maxSize int64
+	backups int
+	file    *os.File
+	size    int64
+}
+
+func openRotatingFile(path string, maxSize int64, backups int) (*rotatingFile, error) {
+	rotating := &rotatingFile{path: path, maxSize: maxSize, backups: backups}
+	openErr := rotating.open()
+	if openErr != nil {
+		return nil, openErr
+	}
+
+	return rotating, nil
+}
+
+func (rotating *rotatingFile) open() error {
+	file, openErr := os.OpenFile(rotating.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if openErr != nil {
+		return openErr
+	}
+
+	info, statErr := file.Stat()
+	if statErr != nil {
+		file.Close()
+		return statErr
+	}
+
+	rotating.file = file
+	rotating.size = info.Size()
+
+	return nil
+}
+
+func (rotating *rotatingFile) Write(data []byte) (int, error) {
+	rotating.mutex.Lock()
+	defer rotating.mutex.Unlock()
+
+	if rotating.maxSize > 0 && rotating.size > 0 && rotating.size+int64(len(data)) > rotating.maxSize {
+		rotateErr := rotating.rotate()
+		if rotateErr != nil {
+			return 0, rotateErr
+		}
+	}
+
+	written, writeErr := rotating.file.Write(data)
+	rotating.size += int64(written)
+
+	return written, writeErr
+}
+
+func (rotating *rotatingFile) rotate() error {
+	closeErr := rotating.file.Close()
+	if closeErr != nil {
+		return closeErr
+	}
+
+	if rotating.backups > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", rotating.path, rotating.backups))
+		for backup := rotating.backups - 1; backup >= 1; backup-- {
+			os.Rename(fmt.Sprintf("%s.%d", rotating.path, backup), fmt.Sprintf("%s.%d", rotating.path, backup+1))
+		}
+		renameErr := os.Rename(rotating.path, rotating.path+".1")
+		if renameErr != nil {
+			return renameErr
+		}
+	} else {
+		removeErr := os.Remove(rotating.path)
+		if removeErr != nil {
+			return removeErr
+		}
+	}
+
+	return rotating.open()
+}
+
+func (rotating *rotatingFile) Close() error {
+	rotating.mutex.Lock()
+	defer rotating.mutex.Unlock()
+
+	return rotating.file.Close()
+}
+
+// newEventLogger returns a JSON-lines logger writing to path, or to stdout
+// for "-". Without a path all events are discarded.
+func newEventLogger(path string, level string, maxSize int64, backups int) (*slog.Logger, io.Closer, error) {
+	if path == "" {
+		return slog.New(slog.NewJSONHandler(io.Discard, &slog.HandlerOptions{Level: disabledLevel})), nil, nil
+	}
+
+	var minimumLevel slog.Level
+	levelErr := minimumLevel.UnmarshalText([]byte(level))
+	if levelErr != nil {
+		return nil, nil, levelErr
+	}
+
+	var writer io.Writer = os.Stdout
+	var closer io.Closer
+	if path != "-" {
+		rotating, openErr := openRotatingFile(path, maxSize, backups)
+		if openErr != nil {
+			return nil, nil, openErr
+		}
+		writer, closer = rotating, rotating
+	}
+
+	handler := slog.NewJSONHandler(writer, &slog.HandlerOptions{Level: minimumLevel})
+	return slog.New(handler), closer, nil
+}
+
+// logEvent records a structured event stamped with the current Lamport time.
+// It must be called with the server mutex held.
+func (server *ChatServer) logEvent(level slog.Level, event string, attributes ...slog.Attr) {
+	attributes = append([]slog.Attr{
+		slog.String("event", event),
+		slog.Int64("lamport", server.clock.Now().Lamport),
+	}, attributes...)
+	server.events.LogAttrs(context.Background(), level, event, attributes...)
+}
+
+func userAttr(username string) slog.Attr {
+	return slog.String("user", username)
+}
+
+func peerAttr(ctx context.Context) slog.Attr {
+	return slog.String("peer", peerAddress(ctx))
+}
+
+func messageAttr(messageId int64) slog.Attr {
+	return slog.Int64("message_id", messageId)
+}
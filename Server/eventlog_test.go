@@ -0,0 +1,140 @@
+package server
+
+import (
+	clock "Chitty-Chat/Clock"
+	proto "Chitty-Chat/GRPC"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFileKeepsBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	rotating, openErr := openRotatingFile(path, 10, 2)
+	if openErr != nil {
+		t.Fatalf("Opening failed | %v", openErr)
+	}
+	defer rotating.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, writeErr := rotating.Write([]byte(line))
+		if writeErr != nil {
+			t.Fatalf("Writing failed | %v", writeErr)
+		}
+	}
+
+	for suffix, want := range map[string]string{"": "fourth\n", ".1": "third\n", ".2": "second\n"} {
+		content, readErr := os.ReadFile(path + suffix)
+		if readErr != nil {
+			t.Fatalf("Reading %s failed | %v", path+suffix, readErr)
+		}
+		if string(content) != want {
+			t.Errorf("%s contains %q, want %q", path+suffix, content, want)
+		}
+	}
+
+	_, statErr := os.Stat(path + ".3")
+	if !os.IsNotExist(statErr) {
+		t.Errorf("Expected only two backups, found %s.3", path)
+	}
+}
+
+func TestEventLogWritesJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	server := newTestServer(t, ServerConfig{DataDirectory: t.TempDir(), EventLogPath: path, EventLogLevel: "debug"})
+
+	server.mutex.Lock()
+	server.clock.Merge(clock.Time{Lamport: 40})
+	server.logEvent(slog.LevelInfo, "broadcast", userAttr("alice"), messageAttr(7))
+	server.mutex.Unlock()
+	server.eventCloser.Close()
+
+	file, openErr := os.Open(path)
+	if openErr != nil {
+		t.Fatalf("Opening event log failed | %v", openErr)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		t.Fatal("Event log is empty")
+	}
+
+	var event map[string]any
+	unmarshalErr := json.Unmarshal(scanner.Bytes(), &event)
+	if unmarshalErr != nil {
+		t.Fatalf("Event is not JSON | %v", unmarshalErr)
+	}
+
+	for key, want := range map[string]any{"event": "broadcast", "lamport": 41.0, "user": "alice", "message_id": 7.0, "level": "INFO"} {
+		if event[key] != want {
+			t.Errorf("Event field %s = %v, want %v", key, event[key], want)
+		}
+	}
+	if _, hasTime := event["time"]; !hasTime {
+		t.Error("Event has no wall time")
+	}
+}
+
+func TestEventLogLevel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	server := newTestServer(t, ServerConfig{DataDirectory: t.TempDir(), EventLogPath: path, EventLogLevel: "warn"})
+
+	server.mutex.Lock()
+	server.logEvent(slog.LevelInfo, "broadcast")
+	server.logEvent(slog.LevelWarn, "queue_full")
+	server.mutex.Unlock()
+	server.eventCloser.Close()
+
+	content, readErr := os.ReadFile(path)
+	if readErr != nil {
+		t.Fatalf("Reading event log failed | %v", readErr)
+	}
+	if strings.Contains(string(content), "broadcast") || !strings.Contains(string(content), "queue_full") {
+		t.Errorf("Event log at warn level contains:\n%s", content)
+	}
+}
+
+// failingStream is a JoinChat stream whose sends all fail.
+type failingStream struct {
+	proto.ChatService_JoinChatServer
+}
+
+func (failingStream) Send(*proto.Chat) error {
+	return errors.New("connection reset")
+}
+
+func TestFailedSendIsLoggedAsEvent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	server := newTestServer(t, ServerConfig{DataDirectory: t.TempDir(), EventLogPath: path, EventLogLevel: "info"})
+
+	newClient("alice", failingStream{}, server).send(&proto.Chat{Id: 3})
+	server.eventCloser.Close()
+
+	content, readErr := os.ReadFile(path)
+	if readErr != nil {
+		t.Fatalf("Reading event log failed | %v", readErr)
+	}
+	if count := strings.Count(string(content), `"event"`); count != 1 {
+		t.Errorf("Event log has %d event fields, want 1 | %s", count, content)
+	}
+
+	var event map[string]any
+	unmarshalErr := json.Unmarshal(content, &event)
+	if unmarshalErr != nil {
+		t.Fatalf("Event is not JSON | %v", unmarshalErr)
+	}
+	for key, want := range map[string]any{"event": "send_failed", "user": "alice", "message_id": 3.0, "error": "connection reset"} {
+		if event[key] != want {
+			t.Errorf("Event field %s = %v, want %v", key, event[key], want)
+		}
+	}
+	if _, hasLamport := event["lamport"]; !hasLamport {
+		t.Error("Event has no Lamport time")
+	}
+}
@@ -0,0 +1,540 @@
+package server
+
+import (
+	clock "Chitty-Chat/Clock"
+	proto "Chitty-Chat/GRPC"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+const federationQueueLength = 1024
+const maxSeenFederationEvents = 4096
+const initialPeerRetryDelay = time.Second
+const maxPeerRetryDelay = 30 * time.Second
+const federationSecretHeader = "federation-secret"
+
+// federation relays chats and presence between linked servers so that
+// their users share one room. Every event carries the server it started on
+// and a sequence number, and each server relays an event at most once, so
+// events neither loop nor arrive twice whatever the topology. Servers only
+// accept links from peers that present the shared secret.
+type federation struct {
+	serverID      string
+	secret        string
+	epoch         int64
+	sequence      int64
+	peerAddresses []string
+	dialOptions   []grpc.DialOption
+
+	peers       map[*peerLink]bool
+	seen        map[eventKey]bool
+	seenOrder   []eventKey
+	remoteUsers map[string]*remoteUser
+
+	snapshots     map[string]*snapshot
+	snapshotOrder []string
+}
+
+type eventKey struct {
+	origin   string
+	epoch    int64
+	sequence int64
+}
+
+type peerLink struct {
+	id    string
+	queue chan *proto.FederationEvent
+	// markers holds the snapshot markers that found the queue full. They
+	// are sent once the queue is empty, and until then other events are
+	// dropped rather than queued, so no event overtakes a marker.
+	markerMutex sync.Mutex
+	markers     []*proto.FederationEvent
+	// sent and received count the events other than markers queued for and
+	// received from the peer.
+	sent     int64
+	received int64
+}
+
+type remoteUser struct {
+	home string
+	via  *peerLink
+}
+
+type linkStream interface {
+	Send(*proto.FederationEvent) error
+	Recv() (*proto.FederationEvent, error)
+}
+
+func newFederation(serverID string, secret string, peerAddresses []string, dialOptions []grpc.DialOption) *federation {
+	return &federation{
+		serverID:      serverID,
+		secret:        secret,
+		epoch:         time.Now().UnixNano(),
+		peerAddresses: peerAddresses,
+		dialOptions:   dialOptions,
+
+		peers:       make(map[*peerLink]bool),
+		seen:        make(map[eventKey]bool),
+		remoteUsers: make(map[string]*remoteUser),
+		snapshots:   make(map[string]*snapshot),
+	}
+}
+
+// remember records an event as handled and reports whether it was new.
+func (links *federation) remember(event *proto.FederationEvent) bool {
+	key := eventKey{origin: event.Origin, epoch: event.Epoch, sequence: event.Sequence}
+	if links.seen[key] {
+		return false
+	}
+
+	links.seen[key] = true
+	links.seenOrder = append(links.seenOrder, key)
+	if len(links.seenOrder) > maxSeenFederationEvents {
+		delete(links.seen, links.seenOrder[0])
+		links.seenOrder = links.seenOrder[1:]
+	}
+
+	return true
+}
+
+type federationService struct {
+	proto.UnimplementedFederationServiceServer
+	server *ChatServer
+}
+
+func (service *federationService) Link(stream proto.FederationService_LinkServer) error {
+	authenticateErr := service.server.federation.authenticate(stream.Context())
+	if authenticateErr != nil {
+		service.server.logEvent(slog.LevelWarn, "link_rejected", peerAttr(stream.Context()))
+		return authenticateErr
+	}
+
+	_, linkErr := service.server.runLink(stream.Context(), stream)
+	return linkErr
+}
+
+// authenticate checks that a server linking to this one presented the
+// shared secret. Without a secret no server may link to this one, though
+// it still links to its own peers.
+func (links *federation) authenticate(ctx context.Context) error {
+	if links.secret == "" {
+		return status.Error(codes.PermissionDenied, "Server does not accept links without a federation secret")
+	}
+
+	presented := metadata.ValueFromIncomingContext(ctx, federationSecretHeader)
+	if len(presented) != 1 || subtle.ConstantTimeCompare([]byte(presented[0]), []byte(links.secret)) != 1 {
+		return status.Error(codes.PermissionDenied, "Wrong federation secret")
+	}
+
+	return nil
+}
+
+// FederationPeers returns the IDs of the servers currently linked to this
+// one, sorted and with one entry per link.
+func (server *ChatServer) FederationPeers() []string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if server.federation == nil {
+		return nil
+	}
+
+	var peerIds []string
+	for linked := range server.federation.peers {
+		peerIds = append(peerIds, linked.id)
+	}
+	sort.Strings(peerIds)
+
+	return peerIds
+}
+
+func (server *ChatServer) isRemoteUser(username string) bool {
+	if server.federation == nil {
+		return false
+	}
+
+	_, isRemote := server.federation.remoteUsers[username]
+	return isRemote
+}
+
+// federate starts a new event on this server and relays it to every peer.
+func (server *ChatServer) federate(event *proto.FederationEvent) {
+	if server.federation == nil {
+		return
+	}
+
+	server.federation.sequence++
+	event.Origin = server.federation.serverID
+	event.Epoch = server.federation.epoch
+	event.Sequence = server.federation.sequence
+	server.federation.remember(event)
+	server.relay(event, nil)
+}
+
+func (server *ChatServer) federateChat(chat *proto.Chat) {
+	_, isLocalRecipient := server.clients[chat.Recipient]
+	if chat.Recipient != "" && isLocalRecipient {
+		return
+	}
+
+	server.federate(&proto.FederationEvent{Kind: proto.FederationKind_RELAYED_CHAT, Chat: protobuf.Clone(chat).(*proto.Chat)})
+}
+
+func (server *ChatServer) federatePresence(kind proto.FederationKind, username string) {
+	if server.federation == nil {
+		return
+	}
+
+	server.federate(&proto.FederationEvent{Kind: kind, Username: username, Home: server.federation.serverID})
+}
+
+// relay sends an event to every peer except the one it came from and the
+// server it started on.
+func (server *ChatServer) relay(event *proto.FederationEvent, from *peerLink) {
+	event.Sender = server.federation.serverID
+	now := server.clock.Now()
+	event.Timestamp = server.legacyTimestamp(now.Lamport)
+	event.Lamport = now.Lamport
+	event.Clock = now.Proto()
+
+	for linked := range server.federation.peers {
+		if linked == from || linked.id == event.Origin {
+			continue
+		}
+		server.sendToPeer(linked, event)
+	}
+}
+
+func (server *ChatServer) sendToPeer(linked *peerLink, event *proto.FederationEvent) {
+	linked.markerMutex.Lock()
+	defer linked.markerMutex.Unlock()
+
+	isMarker := event.Kind == proto.FederationKind_SNAPSHOT_MARKER
+	if len(linked.markers) == 0 {
+		select {
+		case linked.queue <- event:
+			if !isMarker {
+				linked.sent++
+			}
+			return
+		default:
+		}
+	}
+
+	if isMarker {
+		linked.markers = append(linked.markers, event)
+		return
+	}
+	log.Printf("Federation queue of server %s is full, dropping %v event", linked.id, event.Kind)
+	server.logEvent(slog.LevelWarn, "federation_queue_full", slog.String("peer", linked.id))
+}
+
+// takeMarkers returns the markers that found the queue full, once every
+// event queued before them has been taken.
+func (linked *peerLink) takeMarkers() []*proto.FederationEvent {
+	linked.markerMutex.Lock()
+	defer linked.markerMutex.Unlock()
+
+	if len(linked.queue) > 0 {
+		return nil
+	}
+	markers := linked.markers
+	linked.markers = nil
+
+	return markers
+}
+
+// receiveFederated applies an event that arrived over the link from. Events
+// that were still in flight when the link was removed are dropped, since
+// nothing would clear the remote users they add.
+func (server *ChatServer) receiveFederated(from *peerLink, event *proto.FederationEvent) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if !server.federation.peers[from] {
+		log.Printf("Dropping %v from server %s, the link is gone", event.Kind, from.id)
+		return
+	}
+	if event.Kind == proto.FederationKind_SNAPSHOT_MARKER {
+		server.receiveMarker(from, event.SnapshotId)
+		return
+	}
+	from.received++
+	server.recordInFlight(from, event)
+
+	if event.Origin == server.federation.serverID || !server.federation.remember(event) {
+		return
+	}
+
+	server.mergeClock(clock.FromProto(clock.Widen(event.Lamport, event.Timestamp), event.Clock))
+	log.Printf("LT%d | Received %v from server %s (started on %s)", server.clock.Now().Lamport, event.Kind, from.id, event.Origin)
+	server.logEvent(slog.LevelInfo, "federation_received", slog.String("kind", event.Kind.String()), slog.String("peer", from.id), slog.String("origin", event.Origin), slog.Int64("sent_lamport", clock.Widen(event.Lamport, event.Timestamp)))
+
+	switch event.Kind {
+	case proto.FederationKind_RELAYED_CHAT:
+		chat := protobuf.Clone(event.Chat).(*proto.Chat)
+		rejectErr := server.checkRelayedChat(chat)
+		if rejectErr != nil {
+			log.Printf("Not broadcasting chat from %s relayed by server %s | %v", chat.Username, from.id, rejectErr)
+			server.logEvent(slog.LevelWarn, "message_rejected", userAttr(chat.Username), slog.String("peer", from.id), slog.String("reason", status.Code(rejectErr).String()))
+			break
+		}
+		server.broadcastMessage(chat)
+		server.receipts.track(chat)
+	case proto.FederationKind_USER_JOINED:
+		_, isLocal := server.clients[event.Username]
+		if isLocal {
+			log.Printf("User %s joined on server %s but is also connected here", event.Username, event.Home)
+			break
+		}
+		if server.isRemoteUser(event.Username) {
+			break
+		}
+		server.federation.remoteUsers[event.Username] = &remoteUser{home: event.Home, via: from}
+		server.announce(fmt.Sprintf("User %s joined on server %s at LT%d", event.Username, event.Home, server.clock.Now().Lamport))
+	case proto.FederationKind_USER_LEFT:
+		user, isRemote := server.federation.remoteUsers[event.Username]
+		if !isRemote {
+			break
+		}
+		delete(server.federation.remoteUsers, event.Username)
+		server.announce(fmt.Sprintf("User %s left server %s at LT%d", event.Username, user.home, server.clock.Now().Lamport))
+	}
+
+	server.relay(event, from)
+}
+
+// checkRelayedChat applies the checks a message sent to this server gets
+// to one relayed from a peer, except for rate limits, which the server it
+// was sent to applies. Only user messages are relayed, so anything else,
+// such as a notice claiming to come from the server, is rejected. Each
+// server checks for itself, so a rejected chat is still relayed on.
+func (server *ChatServer) checkRelayedChat(chat *proto.Chat) error {
+	if chat.Kind != proto.ChatKind_MESSAGE {
+		return status.Errorf(codes.InvalidArgument, "Servers do not relay %v messages", chat.Kind)
+	}
+
+	usernameErr := validateUsername(chat.Username)
+	if usernameErr != nil {
+		return usernameErr
+	}
+
+	_, isLocal := server.clients[chat.Username]
+	if isLocal {
+		return status.Errorf(codes.PermissionDenied, "User %s is connected to this server", chat.Username)
+	}
+
+	ban, isBanned := server.moderation.activeSanction(server.moderation.Bans, chat.Username, time.Now())
+	if isBanned {
+		return status.Errorf(codes.PermissionDenied, "User %s is banned %s", chat.Username, ban.describe())
+	}
+
+	mute, isMuted := server.moderation.activeSanction(server.moderation.Mutes, chat.Username, time.Now())
+	if isMuted {
+		return status.Errorf(codes.PermissionDenied, "User %s is muted %s", chat.Username, mute.describe())
+	}
+
+	// The floor is held by a user of this server, if anyone, so chats from
+	// other servers may only be direct messages.
+	floorErr := server.checkFloor(chat)
+	if floorErr != nil {
+		return floorErr
+	}
+
+	return server.validateMessage(chat.Message)
+}
+
+// addPeer registers a link and tells the peer about everyone in the room
+// it cannot already know about.
+func (server *ChatServer) addPeer(linked *peerLink) error {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if server.shuttingDown {
+		return status.Error(codes.Unavailable, "Server is shutting down")
+	}
+
+	server.federation.peers[linked] = true
+	log.Printf("LT%d | Linked with server %s", server.clock.Now().Lamport, linked.id)
+	server.logEvent(slog.LevelInfo, "peer_linked", slog.String("peer", linked.id))
+	server.sendPendingMarkers(linked)
+
+	for _, username := range server.onlineUsernames() {
+		server.announceUserTo(linked, username, server.federation.serverID)
+	}
+	for username, user := range server.federation.remoteUsers {
+		if user.via != linked && user.home != linked.id {
+			server.announceUserTo(linked, username, user.home)
+		}
+	}
+
+	return nil
+}
+
+func (server *ChatServer) announceUserTo(linked *peerLink, username string, home string) {
+	server.federation.sequence++
+	now := server.clock.Now()
+	event := &proto.FederationEvent{
+		Kind:      proto.FederationKind_USER_JOINED,
+		Origin:    server.federation.serverID,
+		Epoch:     server.federation.epoch,
+		Sequence:  server.federation.sequence,
+		Sender:    server.federation.serverID,
+		Timestamp: server.legacyTimestamp(now.Lamport),
+		Lamport:   now.Lamport,
+		Username:  username,
+		Home:      home,
+	}
+	server.federation.remember(event)
+	server.sendToPeer(linked, event)
+}
+
+// removePeer forgets a link that went down. The users reached through it
+// leave the room, here and on the remaining peers.
+func (server *ChatServer) removePeer(linked *peerLink) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	delete(server.federation.peers, linked)
+	server.stopRecording(linked)
+	server.clock.Tick()
+	log.Printf("LT%d | Lost link with server %s", server.clock.Now().Lamport, linked.id)
+	server.logEvent(slog.LevelWarn, "peer_lost", slog.String("peer", linked.id))
+
+	var lostUsers []string
+	for username, user := range server.federation.remoteUsers {
+		if user.via == linked {
+			lostUsers = append(lostUsers, username)
+		}
+	}
+	sort.Strings(lostUsers)
+
+	for _, username := range lostUsers {
+		user := server.federation.remoteUsers[username]
+		delete(server.federation.remoteUsers, username)
+		server.announce(fmt.Sprintf("User %s left, lost the link to server %s at LT%d", username, user.home, server.clock.Now().Lamport))
+		server.federate(&proto.FederationEvent{Kind: proto.FederationKind_USER_LEFT, Username: username, Home: user.home})
+	}
+}
+
+// runLink greets the other server, then relays events both ways until the
+// link breaks or the server shuts down. It reports whether the link got
+// as far as being registered.
+func (server *ChatServer) runLink(ctx context.Context, stream linkStream) (bool, error) {
+	helloErr := stream.Send(&proto.FederationEvent{Kind: proto.FederationKind_HELLO, Sender: server.federation.serverID})
+	if helloErr != nil {
+		return false, fmt.Errorf("sending hello: %w", helloErr)
+	}
+
+	hello, recvErr := stream.Recv()
+	if recvErr != nil {
+		return false, fmt.Errorf("receiving hello: %w", recvErr)
+	}
+	if hello.Kind != proto.FederationKind_HELLO || hello.Sender == "" {
+		return false, status.Error(codes.InvalidArgument, "Link must start with a hello")
+	}
+	if hello.Sender == server.federation.serverID {
+		return false, status.Errorf(codes.InvalidArgument, "Server %s cannot link with itself", hello.Sender)
+	}
+
+	linked := &peerLink{id: hello.Sender, queue: make(chan *proto.FederationEvent, federationQueueLength)}
+	addErr := server.addPeer(linked)
+	if addErr != nil {
+		return false, addErr
+	}
+	defer server.removePeer(linked)
+
+	linkErrs := make(chan error, 2)
+	go func() {
+		for {
+			select {
+			case event := <-linked.queue:
+				for _, next := range append([]*proto.FederationEvent{event}, linked.takeMarkers()...) {
+					sendErr := stream.Send(next)
+					if sendErr != nil {
+						linkErrs <- sendErr
+						return
+					}
+				}
+			case <-ctx.Done():
+				linkErrs <- ctx.Err()
+				return
+			case <-server.draining:
+				linkErrs <- nil
+				return
+			}
+		}
+	}()
+	go func() {
+		for {
+			event, eventErr := stream.Recv()
+			if eventErr != nil {
+				linkErrs <- eventErr
+				return
+			}
+			server.receiveFederated(linked, event)
+		}
+	}()
+
+	return true, <-linkErrs
+}
+
+// linkPeer keeps a link to the server at address up, backing off
+// exponentially while it cannot be reached, until this server shuts down.
+func (server *ChatServer) linkPeer(address string) {
+	dialOptions := append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, server.federation.dialOptions...)
+	connection, dialErr := grpc.NewClient(address, dialOptions...)
+	if dialErr != nil {
+		log.Printf("Could not dial server %s | %v", address, dialErr)
+		return
+	}
+	defer connection.Close()
+
+	federationClient := proto.NewFederationServiceClient(connection)
+	delay := initialPeerRetryDelay
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		ctx = metadata.AppendToOutgoingContext(ctx, federationSecretHeader, server.federation.secret)
+		stream, streamErr := federationClient.Link(ctx)
+		linked := false
+		linkErr := streamErr
+		if streamErr == nil {
+			linked, linkErr = server.runLink(ctx, stream)
+		}
+		cancel()
+
+		select {
+		case <-server.draining:
+			return
+		default:
+		}
+
+		if linked {
+			delay = initialPeerRetryDelay
+		}
+		if linkErr != nil && !errors.Is(linkErr, context.Canceled) {
+			log.Printf("Link to server %s failed, retrying in %v | %v", address, delay, linkErr)
+		}
+
+		select {
+		case <-server.draining:
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxPeerRetryDelay)
+	}
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	proto "Chitty-Chat/GRPC"
+	"context"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestFederationRejectsLinksWithoutTheSecret(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		secret    string
+		presented []string
+		want      codes.Code
+	}{
+		{"no secret presented", "s3cret", nil, codes.PermissionDenied},
+		{"wrong secret", "s3cret", []string{"guess"}, codes.PermissionDenied},
+		{"server without a secret", "", []string{""}, codes.PermissionDenied},
+		{"right secret", "s3cret", []string{"s3cret"}, codes.OK},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, connection := startTestServer(t, ServerConfig{ServerID: "site-a", FederationSecret: test.secret})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			for _, secret := range test.presented {
+				ctx = metadata.AppendToOutgoingContext(ctx, federationSecretHeader, secret)
+			}
+			stream, linkErr := proto.NewFederationServiceClient(connection).Link(ctx)
+			if linkErr != nil {
+				t.Fatalf("Opening the link failed | %v", linkErr)
+			}
+
+			_, recvErr := stream.Recv()
+			if status.Code(recvErr) != test.want {
+				t.Errorf("Link returned %v, want %v", recvErr, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckRelayedChat(t *testing.T) {
+	server := newTestServer(t, ServerConfig{DataDirectory: t.TempDir(), MaxMessageLength: 10})
+	server.clients["alice"] = newClient("alice", nil, server)
+	server.moderation.Bans["mallory"] = sanction{By: "olivia"}
+	server.moderation.Mutes["trudy"] = sanction{By: "olivia"}
+
+	for _, test := range []struct {
+		name string
+		chat *proto.Chat
+		want codes.Code
+	}{
+		{"message", &proto.Chat{Username: "bob", Message: "hello"}, codes.OK},
+		{"notice", &proto.Chat{Username: "Server", Message: "restarting", Kind: proto.ChatKind_SYSTEM}, codes.InvalidArgument},
+		{"local user", &proto.Chat{Username: "alice", Message: "hello"}, codes.PermissionDenied},
+		{"banned user", &proto.Chat{Username: "mallory", Message: "hello"}, codes.PermissionDenied},
+		{"muted user", &proto.Chat{Username: "trudy", Message: "hello"}, codes.PermissionDenied},
+		{"too long", &proto.Chat{Username: "bob", Message: strings.Repeat("a", 11)}, codes.InvalidArgument},
+		{"control character", &proto.Chat{Username: "bob", Message: "a\x07b"}, codes.InvalidArgument},
+	} {
+		checkErr := server.checkRelayedChat(test.chat)
+		if status.Code(checkErr) != test.want {
+			t.Errorf("%s: checkRelayedChat returned %v, want %v", test.name, checkErr, test.want)
+		}
+	}
+}
+
+func TestEventsFromARemovedLinkAreDropped(t *testing.T) {
+	server := newTestServer(t, ServerConfig{DataDirectory: t.TempDir(), ServerID: "a"})
+	linked := &peerLink{id: "b", queue: make(chan *proto.FederationEvent, federationQueueLength)}
+
+	addErr := server.addPeer(linked)
+	if addErr != nil {
+		t.Fatalf("Adding the link failed | %v", addErr)
+	}
+	server.removePeer(linked)
+	server.receiveFederated(linked, &proto.FederationEvent{Kind: proto.FederationKind_USER_JOINED, Origin: "b", Sequence: 1, Username: "bob", Home: "b"})
+
+	server.mutex.Lock()
+	_, isRemote := server.federation.remoteUsers["bob"]
+	server.mutex.Unlock()
+	if isRemote {
+		t.Fatalf("bob joined through a link that was already removed")
+	}
+
+	_, connectErr := server.Connect(context.Background(), &proto.UserRequest{Username: "bob"}, func(*proto.Chat) {})
+	if connectErr != nil {
+		t.Errorf("bob could not join locally | %v", connectErr)
+	}
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	clock "Chitty-Chat/Clock"
+	proto "Chitty-Chat/GRPC"
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const defaultFloorGrantTimeout = 10 * time.Second
+
+// floorControl lets one user at a time speak, following Ricart-Agrawala:
+// a user asks everyone for the floor, and the others grant the request
+// unless they hold the floor or asked first by Lamport time, in which case
+// they grant it once they yield. The clients decide; the server relays the
+// requests and counts the grants, so it knows who holds the floor. Users
+// that have not granted a request within the grant timeout, such as those
+// on clients without floor control, are no longer waited for.
+type floorControl struct {
+	enabled      bool
+	grantTimeout time.Duration
+	holder       string
+	requests     map[string]*floorRequest
+}
+
+type floorRequest struct {
+	timestamp int64
+	// awaiting are the users that were online when the request was made
+	// and have not granted it yet.
+	awaiting map[string]bool
+}
+
+func newFloorControl(enabled bool, grantTimeout time.Duration) *floorControl {
+	if grantTimeout == 0 {
+		grantTimeout = defaultFloorGrantTimeout
+	}
+
+	return &floorControl{enabled: enabled, grantTimeout: grantTimeout, requests: make(map[string]*floorRequest)}
+}
+
+func (server *ChatServer) checkFloor(chat *proto.Chat) error {
+	if !server.floor.enabled || chat.Recipient != "" || server.floor.holder == chat.Username {
+		return nil
+	}
+
+	if server.floor.holder == "" {
+		return status.Error(codes.PermissionDenied, "Nobody has the floor, type /raise to ask for it")
+	}
+	return status.Errorf(codes.PermissionDenied, "%s has the floor, type /raise to ask for it", server.floor.holder)
+}
+
+func (server *ChatServer) Floor(ctx context.Context, event *proto.FloorEvent) (*proto.Empty, error) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if !server.floor.enabled {
+		return nil, status.Error(codes.FailedPrecondition, "Floor control is off in this room")
+	}
+	authenticateErr := server.authenticate(ctx, event.Username)
+	if authenticateErr != nil {
+		return nil, authenticateErr
+	}
+
+	server.mergeClock(clock.Time{Lamport: clock.Widen(event.Lamport, event.Timestamp)})
+	server.logEvent(slog.LevelInfo, "floor", userAttr(event.Username), slog.String("action", event.Action.String()), slog.Int64("sent_lamport", clock.Widen(event.Lamport, event.Timestamp)))
+
+	switch event.Action {
+	case proto.FloorAction_REQUEST:
+		return server.requestFloor(event)
+	case proto.FloorAction_GRANT:
+		request, isPending := server.floor.requests[event.Requester]
+		if isPending && request.timestamp == clock.Widen(event.RequestLamport, event.RequestTimestamp) {
+			delete(request.awaiting, event.Username)
+			server.passFloor()
+		}
+	case proto.FloorAction_YIELD:
+		if server.floor.holder != event.Username && server.floor.requests[event.Username] == nil {
+			return nil, status.Error(codes.FailedPrecondition, "You neither have nor asked for the floor")
+		}
+		server.yieldFloor(event.Username, fmt.Sprintf("User %s yielded the floor at LT%d", event.Username, server.clock.Now().Lamport))
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "Clients cannot send %v", event.Action)
+	}
+
+	return &proto.Empty{}, nil
+}
+
+func (server *ChatServer) requestFloor(event *proto.FloorEvent) (*proto.Empty, error) {
+	if server.floor.holder == event.Username {
+		return nil, status.Error(codes.FailedPrecondition, "You already have the floor")
+	}
+	if server.floor.requests[event.Username] != nil {
+		return nil, status.Error(codes.FailedPrecondition, "You already asked for the floor")
+	}
+
+	request := &floorRequest{timestamp: clock.Widen(event.Lamport, event.Timestamp), awaiting: make(map[string]bool)}
+	for _, username := range server.onlineUsernames() {
+		if username != event.Username {
+			request.awaiting[username] = true
+		}
+	}
+	server.floor.requests[event.Username] = request
+	time.AfterFunc(server.floor.grantTimeout, func() { server.stopAwaitingGrants(event.Username, request) })
+
+	server.announceFloor(proto.FloorAction_REQUEST, event.Username, request.timestamp,
+		fmt.Sprintf("User %s asked for the floor at LT%d", event.Username, request.timestamp))
+	server.passFloor()
+
+	return &proto.Empty{}, nil
+}
+
+// stopAwaitingGrants gives up on the users that have not granted a request
+// that is still pending after the grant timeout. The server still hands
+// the floor out one request at a time in timestamp order, so the floor
+// stays exclusive.
+func (server *ChatServer) stopAwaitingGrants(requester string, request *floorRequest) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if server.floor.requests[requester] != request || len(request.awaiting) == 0 {
+		return
+	}
+
+	missing := make([]string, 0, len(request.awaiting))
+	for username := range request.awaiting {
+		missing = append(missing, username)
+	}
+	sort.Strings(missing)
+	log.Printf("LT%d | No grant from %s for the floor request of %s, no longer waiting", server.clock.Now().Lamport, strings.Join(missing, ", "), requester)
+	server.logEvent(slog.LevelWarn, "floor_grant_timeout", userAttr(requester), slog.Any("missing", missing))
+
+	request.awaiting = make(map[string]bool)
+	server.passFloor()
+}
+
+// passFloor gives a free floor to the earliest request, by Lamport time
+// and then username, that every user has granted.
+func (server *ChatServer) passFloor() {
+	if server.floor.holder != "" {
+		return
+	}
+
+	var granted []string
+	for requester, request := range server.floor.requests {
+		if len(request.awaiting) == 0 {
+			granted = append(granted, requester)
+		}
+	}
+	if len(granted) == 0 {
+		return
+	}
+	sort.Slice(granted, func(i int, j int) bool {
+		first, second := server.floor.requests[granted[i]], server.floor.requests[granted[j]]
+		if first.timestamp != second.timestamp {
+			return first.timestamp < second.timestamp
+		}
+		return granted[i] < granted[j]
+	})
+
+	holder := granted[0]
+	request := server.floor.requests[holder]
+	delete(server.floor.requests, holder)
+	server.floor.holder = holder
+	server.announceFloor(proto.FloorAction_TAKEN, holder, request.timestamp,
+		fmt.Sprintf("User %s has the floor since LT%d", holder, server.clock.Now().Lamport))
+}
+
+// yieldFloor frees the floor or withdraws the user's request.
+func (server *ChatServer) yieldFloor(username string, notice string) {
+	request, isPending := server.floor.requests[username]
+	if server.floor.holder != username && !isPending {
+		return
+	}
+
+	requestTimestamp := int64(0)
+	if isPending {
+		requestTimestamp = request.timestamp
+		delete(server.floor.requests, username)
+	}
+	if server.floor.holder == username {
+		server.floor.holder = ""
+	}
+
+	server.announceFloor(proto.FloorAction_YIELD, username, requestTimestamp, notice)
+	server.passFloor()
+}
+
+// dropFromFloor yields for a user that left and stops waiting for their
+// grants.
+func (server *ChatServer) dropFromFloor(username string) {
+	if !server.floor.enabled {
+		return
+	}
+
+	for _, request := range server.floor.requests {
+		delete(request.awaiting, username)
+	}
+	server.yieldFloor(username, fmt.Sprintf("User %s left and gave up the floor at LT%d", username, server.clock.Now().Lamport))
+	server.passFloor()
+}
+
+func (server *ChatServer) announceFloor(action proto.FloorAction, requester string, requestTimestamp int64, message string) {
+	server.publish(&proto.Chat{
+		Username: "Server",
+		Message:  message,
+		Kind:     proto.ChatKind_FLOOR,
+		Floor:    &proto.FloorEvent{Action: action, Requester: requester, RequestTimestamp: server.legacyTimestamp(requestTimestamp), RequestLamport: requestTimestamp},
+	})
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	proto "Chitty-Chat/GRPC"
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// waitForFloorNotice receives from stream until the floor notice with the
+// given action and requester arrives.
+func waitForFloorNotice(t *testing.T, stream proto.ChatService_JoinChatClient, action proto.FloorAction, requester string) {
+	t.Helper()
+
+	for {
+		message, recvErr := stream.Recv()
+		if recvErr != nil {
+			t.Fatalf("Stream ended before %v of %s | %v", action, requester, recvErr)
+		}
+		if message.Kind == proto.ChatKind_FLOOR && message.Floor.Action == action && message.Floor.Requester == requester {
+			return
+		}
+	}
+}
+
+func TestFloorRejectsImpersonation(t *testing.T) {
+	_, connection := startTestServer(t, ServerConfig{FloorControl: true})
+	joinTestUser(t, connection, "alice")
+	mallory := dialTestServer(t, connection)
+	joinTestUser(t, mallory, "mallory")
+	service := proto.NewChatServiceClient(mallory)
+
+	_, requestErr := service.Floor(context.Background(), &proto.FloorEvent{Action: proto.FloorAction_REQUEST, Username: "mallory", Lamport: 5})
+	if requestErr != nil {
+		t.Fatalf("mallory could not ask for the floor | %v", requestErr)
+	}
+
+	for _, action := range []proto.FloorAction{proto.FloorAction_GRANT, proto.FloorAction_YIELD} {
+		_, floorErr := service.Floor(context.Background(), &proto.FloorEvent{Action: action, Username: "alice", Requester: "mallory", RequestLamport: 5})
+		if status.Code(floorErr) != codes.PermissionDenied {
+			t.Errorf("Sending %v as alice from another connection returned %v, want PermissionDenied", action, floorErr)
+		}
+	}
+}
+
+func TestFloorGrantTimeout(t *testing.T) {
+	const grantTimeout = 100 * time.Millisecond
+	_, connection := startTestServer(t, ServerConfig{FloorControl: true, FloorGrantTimeout: grantTimeout})
+	alice := joinTestUser(t, connection, "alice")
+	// bob's client does not know floor control and never grants.
+	joinTestUser(t, connection, "bob")
+
+	started := time.Now()
+	_, requestErr := proto.NewChatServiceClient(connection).Floor(context.Background(), &proto.FloorEvent{Action: proto.FloorAction_REQUEST, Username: "alice", Lamport: 10})
+	if requestErr != nil {
+		t.Fatalf("alice could not ask for the floor | %v", requestErr)
+	}
+
+	waitForFloorNotice(t, alice, proto.FloorAction_TAKEN, "alice")
+	if elapsed := time.Since(started); elapsed < grantTimeout {
+		t.Errorf("alice got the floor after %v without bob's grant, before the grant timeout of %v", elapsed, grantTimeout)
+	}
+}
+
+func TestRelayedChatsNeedTheFloor(t *testing.T) {
+	server := newTestServer(t, ServerConfig{DataDirectory: t.TempDir(), FloorControl: true})
+
+	publicErr := server.checkRelayedChat(&proto.Chat{Username: "bob", Message: "hello"})
+	if status.Code(publicErr) != codes.PermissionDenied {
+		t.Errorf("Relayed chat to the room returned %v, want PermissionDenied", publicErr)
+	}
+
+	directErr := server.checkRelayedChat(&proto.Chat{Username: "bob", Message: "hello", Recipient: "alice"})
+	if directErr != nil {
+		t.Errorf("Relayed direct message returned %v, want it allowed", directErr)
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const chatServiceName = "ChatService"
+
+func newHealthServer() *health.Server {
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	healthServer.SetServingStatus(chatServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
+
+	return healthServer
+}
+
+// updateHealth reports SERVING only once the server is accepting
+// connections, is not shutting down and can write to its storage.
+func (server *ChatServer) updateHealth() {
+	servingStatus := healthpb.HealthCheckResponse_NOT_SERVING
+	if server.serving && !server.shuttingDown && server.storageHealthy {
+		servingStatus = healthpb.HealthCheckResponse_SERVING
+	}
+
+	server.health.SetServingStatus("", servingStatus)
+	server.health.SetServingStatus(chatServiceName, servingStatus)
+}
+
+func (server *ChatServer) monitorStorage() {
+	ticker := time.NewTicker(server.healthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			checkErr := server.store.check()
+
+			server.mutex.Lock()
+			storageHealthy := checkErr == nil
+			if storageHealthy != server.storageHealthy {
+				if storageHealthy {
+					log.Print("Storage is available again")
+				} else {
+					log.Printf("Storage is unavailable | %v", checkErr)
+				}
+			}
+			server.storageHealthy = storageHealthy
+			server.updateHealth()
+			server.mutex.Unlock()
+		case <-server.draining:
+			return
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"net"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+)
+
+func newTestServer(t testing.TB, config ServerConfig) *ChatServer {
+	t.Helper()
+
+	server, serverErr := NewChatServer(config)
+	if serverErr != nil {
+		t.Fatalf("Failed to create server | %v", serverErr)
+	}
+
+	return server
+}
+
+func startTestServer(t *testing.T, config ServerConfig) (*ChatServer, *grpc.ClientConn) {
+	t.Helper()
+
+	if config.DataDirectory == "" {
+		config.DataDirectory = t.TempDir()
+	}
+	if config.ShutdownTimeout == 0 {
+		config.ShutdownTimeout = time.Second
+	}
+	server := newTestServer(t, config)
+
+	listener, listenErr := net.Listen("tcp", "127.0.0.1:0")
+	if listenErr != nil {
+		t.Fatalf("Failed to listen | %v", listenErr)
+	}
+	go server.Serve(listener)
+	t.Cleanup(server.Shutdown)
+
+	connection, connectionErr := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if connectionErr != nil {
+		t.Fatalf("Failed to connect | %v", connectionErr)
+	}
+	t.Cleanup(func() { connection.Close() })
+
+	return server, connection
+}
+
+func waitForServingStatus(t *testing.T, client healthpb.HealthClient, service string, want healthpb.HealthCheckResponse_ServingStatus) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		response, checkErr := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		if checkErr == nil && response.Status == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Service %q never reported %v, last response %v, error %v", service, want, response, checkErr)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHealthReportsServing(t *testing.T) {
+	_, connection := startTestServer(t, ServerConfig{})
+	client := healthpb.NewHealthClient(connection)
+
+	waitForServingStatus(t, client, "", healthpb.HealthCheckResponse_SERVING)
+	waitForServingStatus(t, client, chatServiceName, healthpb.HealthCheckResponse_SERVING)
+}
+
+func TestHealthBeforeServing(t *testing.T) {
+	server := newTestServer(t, ServerConfig{DataDirectory: t.TempDir()})
+
+	response, checkErr := server.health.Check(context.Background(), &healthpb.HealthCheckRequest{Service: chatServiceName})
+	if checkErr != nil {
+		t.Fatalf("Check failed | %v", checkErr)
+	}
+	if response.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("Status before serving = %v, want NOT_SERVING", response.Status)
+	}
+}
+
+func TestHealthReportsUnavailableStorage(t *testing.T) {
+	dataDirectory := t.TempDir()
+	_, connection := startTestServer(t, ServerConfig{DataDirectory: dataDirectory, HealthCheckInterval: 10 * time.Millisecond})
+	client := healthpb.NewHealthClient(connection)
+	waitForServingStatus(t, client, chatServiceName, healthpb.HealthCheckResponse_SERVING)
+
+	os.RemoveAll(dataDirectory)
+	os.WriteFile(dataDirectory, nil, 0o644)
+	waitForServingStatus(t, client, chatServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
+
+	os.Remove(dataDirectory)
+	os.Mkdir(dataDirectory, 0o755)
+	waitForServingStatus(t, client, chatServiceName, healthpb.HealthCheckResponse_SERVING)
+}
+
+func TestHealthDuringShutdown(t *testing.T) {
+	server, connection := startTestServer(t, ServerConfig{})
+	client := healthpb.NewHealthClient(connection)
+	waitForServingStatus(t, client, chatServiceName, healthpb.HealthCheckResponse_SERVING)
+
+	watchContext, cancelWatch := context.WithCancel(context.Background())
+	defer cancelWatch()
+	watch, watchErr := client.Watch(watchContext, &healthpb.HealthCheckRequest{Service: chatServiceName})
+	if watchErr != nil {
+		t.Fatalf("Watch failed | %v", watchErr)
+	}
+	watch.Recv()
+
+	go server.Shutdown()
+
+	response, recvErr := watch.Recv()
+	if recvErr != nil {
+		t.Fatalf("Watch ended before reporting shutdown | %v", recvErr)
+	}
+	if response.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("Status during shutdown = %v, want NOT_SERVING", response.Status)
+	}
+}
+
+func listServices(t *testing.T, connection *grpc.ClientConn) ([]string, error) {
+	t.Helper()
+
+	stream, streamErr := reflectionpb.NewServerReflectionClient(connection).ServerReflectionInfo(context.Background())
+	if streamErr != nil {
+		return nil, streamErr
+	}
+	defer stream.CloseSend()
+
+	sendErr := stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	})
+	if sendErr != nil {
+		return nil, sendErr
+	}
+
+	response, recvErr := stream.Recv()
+	if recvErr != nil {
+		return nil, recvErr
+	}
+
+	var services []string
+	for _, service := range response.GetListServicesResponse().GetService() {
+		services = append(services, service.Name)
+	}
+
+	return services, nil
+}
+
+func TestReflectionEnabled(t *testing.T) {
+	_, connection := startTestServer(t, ServerConfig{EnableReflection: true})
+
+	services, listErr := listServices(t, connection)
+	if listErr != nil {
+		t.Fatalf("Listing services failed | %v", listErr)
+	}
+
+	for _, want := range []string{chatServiceName, "grpc.health.v1.Health"} {
+		if !slices.Contains(services, want) {
+			t.Errorf("Reflection lists %v, missing %s", services, want)
+		}
+	}
+}
+
+func TestReflectionDisabledByDefault(t *testing.T) {
+	_, connection := startTestServer(t, ServerConfig{})
+
+	_, listErr := listServices(t, connection)
+	if status.Code(listErr) != codes.Unimplemented {
+		t.Errorf("Listing services without reflection returned %v, want Unimplemented", listErr)
+	}
+}
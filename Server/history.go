@@ -0,0 +1,74 @@
+package server
+
+import (
+	proto "Chitty-Chat/GRPC"
+	"log"
+	"log/slog"
+)
+
+const defaultHistoryLength = 1000
+
+// messageLog keeps the most recent broadcasts in the order they were sent,
+// so that rejoining clients can catch up and backups can be brought up to
+// date.
+type messageLog struct {
+	limit    int
+	messages []*proto.Chat
+}
+
+func newMessageLog(limit int) *messageLog {
+	if limit <= 0 {
+		limit = defaultHistoryLength
+	}
+
+	return &messageLog{limit: limit}
+}
+
+func (history *messageLog) append(message *proto.Chat) {
+	if message.Kind == proto.ChatKind_SHUTDOWN {
+		return
+	}
+	if len(history.messages) > 0 && message.Id <= history.messages[len(history.messages)-1].Id {
+		return
+	}
+
+	history.messages = append(history.messages, message)
+	if len(history.messages) > history.limit {
+		history.messages = history.messages[1:]
+	}
+}
+
+// since returns the logged messages newer than messageId.
+func (history *messageLog) since(messageId int64) []*proto.Chat {
+	for i, message := range history.messages {
+		if message.Id > messageId {
+			return history.messages[i:]
+		}
+	}
+
+	return nil
+}
+
+func isAddressedTo(message *proto.Chat, username string) bool {
+	return message.Recipient == "" || username == message.Recipient || username == message.Username
+}
+
+// replayHistory queues the logged messages a rejoining client missed and
+// returns the ID of the last message the client now has.
+func (server *ChatServer) replayHistory(client *Client, lastMessageId int64) int64 {
+	replayed := 0
+	for _, message := range server.history.since(lastMessageId) {
+		lastMessageId = message.Id
+		if isAddressedTo(message, client.username) {
+			client.enqueue(message)
+			replayed++
+		}
+	}
+
+	if replayed > 0 {
+		server.logEvent(slog.LevelInfo, "history_replayed", userAttr(client.username), slog.Int("count", replayed))
+		log.Printf("LT%d | Replayed %d missed message(s) to %s", server.clock.Now().Lamport, replayed, client.username)
+	}
+
+	return lastMessageId
+}
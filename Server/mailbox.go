@@ -0,0 +1,183 @@
+package server
+
+import (
+	clock "Chitty-Chat/Clock"
+	proto "Chitty-Chat/GRPC"
+	"log"
+	"log/slog"
+	"slices"
+	"sort"
+	"time"
+)
+
+const mailboxDocument = "mailbox"
+const defaultMailboxCapacity = 100
+
+type mailboxEntry struct {
+	Id        int64
+	Timestamp int64
+	Username  string
+	Recipient string
+	Message   string
+	Mentions  []string
+	QueuedAt  time.Time
+}
+
+// mailbox holds direct messages and mentions for users that are known to
+// the server but currently offline, until they next join.
+type mailbox struct {
+	store      *storage
+	capacity   int
+	expiry     time.Duration
+	KnownUsers map[string]bool
+	Entries    map[string][]mailboxEntry
+}
+
+func newMailbox(store *storage, capacity int, expiry time.Duration) *mailbox {
+	if capacity <= 0 {
+		capacity = defaultMailboxCapacity
+	}
+
+	userMailbox := &mailbox{
+		store:      store,
+		capacity:   capacity,
+		expiry:     expiry,
+		KnownUsers: make(map[string]bool),
+		Entries:    make(map[string][]mailboxEntry),
+	}
+
+	loadErr := store.load(mailboxDocument, userMailbox)
+	if loadErr != nil {
+		log.Printf("Failed to load mailbox, starting empty | %v", loadErr)
+	}
+
+	return userMailbox
+}
+
+func (userMailbox *mailbox) isKnown(username string) bool {
+	return userMailbox.KnownUsers[username]
+}
+
+func (userMailbox *mailbox) rememberUser(username string) {
+	if userMailbox.KnownUsers[username] {
+		return
+	}
+
+	userMailbox.KnownUsers[username] = true
+	userMailbox.persist()
+}
+
+func (userMailbox *mailbox) enqueue(recipient string, message *proto.Chat, now time.Time) {
+	entries := userMailbox.unexpiredEntries(recipient, now)
+	entries = append(entries, mailboxEntry{
+		Id:        message.Id,
+		Timestamp: clock.Widen(message.Lamport, message.Timestamp),
+		Username:  message.Username,
+		Recipient: message.Recipient,
+		Message:   message.Message,
+		Mentions:  message.Mentions,
+		QueuedAt:  now,
+	})
+
+	if len(entries) > userMailbox.capacity {
+		droppedCount := len(entries) - userMailbox.capacity
+		log.Printf("Mailbox for %s is full, dropping %d oldest message(s)", recipient, droppedCount)
+		entries = entries[droppedCount:]
+	}
+
+	userMailbox.Entries[recipient] = entries
+	userMailbox.persist()
+}
+
+// take removes and returns everything queued for the recipient, ordered by
+// the Lamport timestamp the messages were originally broadcast with.
+func (userMailbox *mailbox) take(recipient string, now time.Time) []*proto.Chat {
+	entries := userMailbox.unexpiredEntries(recipient, now)
+	_, hadEntries := userMailbox.Entries[recipient]
+	if !hadEntries {
+		return nil
+	}
+
+	delete(userMailbox.Entries, recipient)
+	userMailbox.persist()
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Timestamp != entries[j].Timestamp {
+			return entries[i].Timestamp < entries[j].Timestamp
+		}
+		return entries[i].Id < entries[j].Id
+	})
+
+	messages := make([]*proto.Chat, 0, len(entries))
+	for _, entry := range entries {
+		legacyTimestamp, _ := clock.Legacy(entry.Timestamp)
+		messages = append(messages, &proto.Chat{
+			Id:        entry.Id,
+			Timestamp: legacyTimestamp,
+			Lamport:   entry.Timestamp,
+			Username:  entry.Username,
+			Recipient: entry.Recipient,
+			Message:   entry.Message,
+			Mentions:  entry.Mentions,
+		})
+	}
+
+	return messages
+}
+
+func (userMailbox *mailbox) unexpiredEntries(recipient string, now time.Time) []mailboxEntry {
+	var entries []mailboxEntry
+	for _, entry := range userMailbox.Entries[recipient] {
+		if userMailbox.expiry > 0 && now.Sub(entry.QueuedAt) > userMailbox.expiry {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func (userMailbox *mailbox) persist() {
+	saveErr := userMailbox.store.save(mailboxDocument, userMailbox)
+	if saveErr != nil {
+		log.Printf("Failed to persist mailbox | %v", saveErr)
+	}
+}
+
+// queueForOfflineUsers stores a copy of the message for every offline user
+// it is addressed to, either directly or through an @mention.
+func (server *ChatServer) queueForOfflineUsers(message *proto.Chat) {
+	var recipients []string
+	if message.Recipient != "" {
+		recipients = append(recipients, message.Recipient)
+	} else {
+		recipients = message.Mentions
+	}
+
+	now := time.Now()
+	for _, recipient := range recipients {
+		_, isOnline := server.clients[recipient]
+		if isOnline || recipient == message.Username || !server.mailbox.isKnown(recipient) {
+			continue
+		}
+
+		log.Printf("LT%d | %s is offline, queueing message #%d", server.clock.Now().Lamport, recipient, message.Id)
+		server.logEvent(slog.LevelInfo, "mailbox_queued", userAttr(recipient), messageAttr(message.Id))
+		server.mailbox.enqueue(recipient, message, now)
+	}
+}
+
+// flushMailbox delivers the queued messages the client does not have yet,
+// skipping those up to seenUpTo that a history replay already covered.
+func (server *ChatServer) flushMailbox(client *Client, seenUpTo int64) {
+	queuedMessages := server.mailbox.take(client.username, time.Now())
+	queuedMessages = slices.DeleteFunc(queuedMessages, func(message *proto.Chat) bool { return message.Id <= seenUpTo })
+	for _, message := range queuedMessages {
+		client.enqueue(message)
+	}
+
+	if len(queuedMessages) > 0 {
+		server.logEvent(slog.LevelInfo, "mailbox_flushed", userAttr(client.username), slog.Int("count", len(queuedMessages)))
+		log.Printf("LT%d | Delivered %d queued message(s) to %s", server.clock.Now().Lamport, len(queuedMessages), client.username)
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	proto "Chitty-Chat/GRPC"
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func queuedTexts(messages []*proto.Chat) []string {
+	texts := make([]string, 0, len(messages))
+	for _, message := range messages {
+		texts = append(texts, message.Message)
+	}
+
+	return texts
+}
+
+func TestMailboxOrdersCapsAndPersists(t *testing.T) {
+	store, storageErr := newStorage(t.TempDir())
+	if storageErr != nil {
+		t.Fatalf("Opening storage failed | %v", storageErr)
+	}
+	now := time.Now()
+	userMailbox := newMailbox(store, 3, 0)
+	userMailbox.rememberUser("bob")
+
+	for _, message := range []*proto.Chat{
+		{Id: 1, Lamport: 10, Username: "alice", Recipient: "bob", Message: "dropped"},
+		{Id: 4, Lamport: 40, Username: "alice", Recipient: "bob", Message: "last"},
+		{Id: 2, Lamport: 20, Username: "carol", Message: "@bob first", Mentions: []string{"bob"}},
+		{Id: 3, Lamport: 20, Username: "alice", Recipient: "bob", Message: "second"},
+	} {
+		userMailbox.enqueue("bob", message, now)
+	}
+
+	reloaded := newMailbox(store, 3, 0)
+	if !reloaded.isKnown("bob") {
+		t.Errorf("bob is not known after reloading the mailbox")
+	}
+	messages := reloaded.take("bob", now)
+	if texts, want := queuedTexts(messages), []string{"@bob first", "second", "last"}; !slices.Equal(texts, want) {
+		t.Fatalf("Mailbox returned %q, want %q", texts, want)
+	}
+	if messages[0].Lamport != 20 || messages[0].Timestamp != 20 || !slices.Equal(messages[0].Mentions, []string{"bob"}) {
+		t.Errorf("First queued message is %v, want it stamped LT20 and mentioning bob", messages[0])
+	}
+
+	if again := newMailbox(store, 3, 0).take("bob", now); len(again) != 0 {
+		t.Errorf("Mailbox still holds %q after taking it", queuedTexts(again))
+	}
+}
+
+func TestMailboxExpiry(t *testing.T) {
+	store, _ := newStorage("")
+	userMailbox := newMailbox(store, 10, time.Hour)
+	queuedAt := time.Now()
+	userMailbox.enqueue("bob", &proto.Chat{Id: 1, Lamport: 1, Username: "alice", Recipient: "bob", Message: "old"}, queuedAt)
+	userMailbox.enqueue("bob", &proto.Chat{Id: 2, Lamport: 2, Username: "alice", Recipient: "bob", Message: "new"}, queuedAt.Add(30*time.Minute))
+
+	messages := userMailbox.take("bob", queuedAt.Add(90*time.Minute))
+	if texts, want := queuedTexts(messages), []string{"new"}; !slices.Equal(texts, want) {
+		t.Errorf("Mailbox returned %q after the first message expired, want %q", texts, want)
+	}
+}
+
+func TestMailboxIsFlushedOnJoin(t *testing.T) {
+	server := newTestServer(t, ServerConfig{DataDirectory: t.TempDir(), MailboxCapacity: 10})
+	server.mailbox.rememberUser("bob")
+	for _, message := range []*proto.Chat{
+		{Id: 1, Lamport: 1, Username: "alice", Recipient: "bob", Message: "first"},
+		{Id: 2, Lamport: 2, Username: "alice", Recipient: "bob", Message: "second"},
+	} {
+		server.mailbox.enqueue("bob", message, time.Now())
+	}
+
+	var received []*proto.Chat
+	_, connectErr := server.Connect(context.Background(), &proto.UserRequest{Username: "bob"}, func(message *proto.Chat) {
+		if message.Kind == proto.ChatKind_MESSAGE {
+			received = append(received, message)
+		}
+	})
+	if connectErr != nil {
+		t.Fatalf("bob could not connect | %v", connectErr)
+	}
+	if texts, want := queuedTexts(received), []string{"first", "second"}; !slices.Equal(texts, want) {
+		t.Fatalf("bob received %q on joining, want %q", texts, want)
+	}
+
+	server.mutex.Lock()
+	server.mailbox.enqueue("bob", &proto.Chat{Id: 1, Lamport: 1, Username: "alice", Recipient: "bob", Message: "replayed"}, time.Now())
+	server.mailbox.enqueue("bob", &proto.Chat{Id: 3, Lamport: 3, Username: "alice", Recipient: "bob", Message: "missed"}, time.Now())
+	received = nil
+	server.flushMailbox(server.clients["bob"], 2)
+	server.mutex.Unlock()
+	if texts, want := queuedTexts(received), []string{"missed"}; !slices.Equal(texts, want) {
+		t.Errorf("Flushing after a replay up to #2 delivered %q, want %q", texts, want)
+	}
+}
@@ -0,0 +1,20 @@
+package server
+
+import "regexp"
+
+var mentionPattern = regexp.MustCompile(`(?:^|\s)@([\pL\pN_]+(?:[.-][\pL\pN_]+)*)`)
+
+func mentionedUsernames(message string) []string {
+	var usernames []string
+	seen := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(message, -1) {
+		username := match[1]
+		if seen[username] {
+			continue
+		}
+		seen[username] = true
+		usernames = append(usernames, username)
+	}
+
+	return usernames
+}
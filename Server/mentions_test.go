@@ -0,0 +1,32 @@
+package server
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMentionedUsernames(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    []string
+	}{
+		{"plain", "hi @alice", []string{"alice"}},
+		{"at the start", "@alice hi", []string{"alice"}},
+		{"dot inside", "@a.b", []string{"a.b"}},
+		{"trailing dot", "thanks @a-b.", []string{"a-b"}},
+		{"trailing punctuation", "@alice, @bob! @carol?", []string{"alice", "bob", "carol"}},
+		{"email address", "mail me at email@host", nil},
+		{"duplicates", "@alice @bob @alice", []string{"alice", "bob"}},
+		{"non-ASCII", "@zoë and @Łukasz", []string{"zoë", "Łukasz"}},
+		{"lone at sign", "meet @ noon", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mentioned := mentionedUsernames(test.message)
+			if !slices.Equal(mentioned, test.want) {
+				t.Errorf("%q mentions %q, want %q", test.message, mentioned, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+var rpcDurationBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+var lamportJumpBuckets = []float64{-1000, -100, -10, -1, 0, 1, 10, 100, 1000}
+
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (distribution *histogram) observe(value float64) {
+	for i, bound := range distribution.bounds {
+		if value <= bound {
+			distribution.counts[i]++
+		}
+	}
+	distribution.sum += value
+	distribution.count++
+}
+
+func (distribution *histogram) write(writer io.Writer, name string, labels string) {
+	labelPrefix := ""
+	if labels != "" {
+		labelPrefix = labels + ","
+	}
+
+	for i, bound := range distribution.bounds {
+		fmt.Fprintf(writer, "%s_bucket{%sle=\"%s\"} %d\n", name, labelPrefix, formatFloat(bound), distribution.counts[i])
+	}
+	fmt.Fprintf(writer, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labelPrefix, distribution.count)
+
+	suffix := ""
+	if labels != "" {
+		suffix = "{" + labels + "}"
+	}
+	fmt.Fprintf(writer, "%s_sum%s %s\n", name, suffix, formatFloat(distribution.sum))
+	fmt.Fprintf(writer, "%s_count%s %d\n", name, suffix, distribution.count)
+}
+
+// metrics collects the server statistics exposed in the Prometheus text
+// format. It has its own lock as RPC latencies are recorded outside of the
+// server mutex.
+type metrics struct {
+	mutex             sync.Mutex
+	messagesReceived  uint64
+	messagesBroadcast uint64
+	sendFailures      map[string]uint64
+	rpcDurations      map[string]*histogram
+	lamportJumps      *histogram
+	legacyOverflows   uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		sendFailures: make(map[string]uint64),
+		rpcDurations: make(map[string]*histogram),
+		lamportJumps: newHistogram(lamportJumpBuckets),
+	}
+}
+
+func (serverMetrics *metrics) messageReceived() {
+	serverMetrics.mutex.Lock()
+	defer serverMetrics.mutex.Unlock()
+
+	serverMetrics.messagesReceived++
+}
+
+func (serverMetrics *metrics) messageBroadcast() {
+	serverMetrics.mutex.Lock()
+	defer serverMetrics.mutex.Unlock()
+
+	serverMetrics.messagesBroadcast++
+}
+
+func (serverMetrics *metrics) sendFailed(reason string) {
+	serverMetrics.mutex.Lock()
+	defer serverMetrics.mutex.Unlock()
+
+	serverMetrics.sendFailures[reason]++
+}
+
+func (serverMetrics *metrics) lamportJump(jump int64) {
+	serverMetrics.mutex.Lock()
+	defer serverMetrics.mutex.Unlock()
+
+	serverMetrics.lamportJumps.observe(float64(jump))
+}
+
+// legacyOverflow counts a Lamport time too large for an int32 timestamp
+// field and returns how many there have been.
+func (serverMetrics *metrics) legacyOverflow() uint64 {
+	serverMetrics.mutex.Lock()
+	defer serverMetrics.mutex.Unlock()
+
+	serverMetrics.legacyOverflows++
+	return serverMetrics.legacyOverflows
+}
+
+func (serverMetrics *metrics) rpcCompleted(method string, duration time.Duration) {
+	serverMetrics.mutex.Lock()
+	defer serverMetrics.mutex.Unlock()
+
+	durations, exists := serverMetrics.rpcDurations[method]
+	if !exists {
+		durations = newHistogram(rpcDurationBuckets)
+		serverMetrics.rpcDurations[method] = durations
+	}
+	durations.observe(duration.Seconds())
+}
+
+func (serverMetrics *metrics) unaryInterceptor(ctx context.Context, request any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	response, handlerErr := handler(ctx, request)
+	serverMetrics.rpcCompleted(info.FullMethod, time.Since(start))
+
+	return response, handlerErr
+}
+
+func (serverMetrics *metrics) streamInterceptor(server any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	handlerErr := handler(server, stream)
+	serverMetrics.rpcCompleted(info.FullMethod, time.Since(start))
+
+	return handlerErr
+}
+
+func (server *ChatServer) writeMetrics(writer io.Writer) {
+	server.mutex.Lock()
+	connectedClients := len(server.clients)
+	lamportTime := server.clock.Now().Lamport
+	queueDepths := make(map[string]int, len(server.clients))
+	for username, client := range server.clients {
+		queueDepths[username] = len(client.queue)
+	}
+	server.mutex.Unlock()
+
+	serverMetrics := server.metrics
+	serverMetrics.mutex.Lock()
+	defer serverMetrics.mutex.Unlock()
+
+	writeHeader(writer, "chitty_connected_clients", "gauge", "Number of users currently in the chat.")
+	fmt.Fprintf(writer, "chitty_connected_clients %d\n", connectedClients)
+
+	writeHeader(writer, "chitty_messages_received_total", "counter", "Chat messages accepted from clients.")
+	fmt.Fprintf(writer, "chitty_messages_received_total %d\n", serverMetrics.messagesReceived)
+
+	writeHeader(writer, "chitty_messages_broadcast_total", "counter", "Messages fanned out to connected clients, including server announcements.")
+	fmt.Fprintf(writer, "chitty_messages_broadcast_total %d\n", serverMetrics.messagesBroadcast)
+
+	writeHeader(writer, "chitty_send_failures_total", "counter", "Messages that could not be delivered to a client.")
+	for _, reason := range sortedKeys(serverMetrics.sendFailures) {
+		fmt.Fprintf(writer, "chitty_send_failures_total{reason=\"%s\"} %d\n", escapeLabel(reason), serverMetrics.sendFailures[reason])
+	}
+
+	writeHeader(writer, "chitty_client_queue_depth", "gauge", "Messages waiting in a client's send queue.")
+	for _, username := range sortedKeys(queueDepths) {
+		fmt.Fprintf(writer, "chitty_client_queue_depth{user=\"%s\"} %d\n", escapeLabel(username), queueDepths[username])
+	}
+
+	writeHeader(writer, "chitty_lamport_time", "gauge", "Current Lamport time of the server.")
+	fmt.Fprintf(writer, "chitty_lamport_time %d\n", lamportTime)
+
+	writeHeader(writer, "chitty_legacy_timestamp_overflows_total", "counter", "Timestamps capped because the Lamport time no longer fits the int32 field older clients read.")
+	fmt.Fprintf(writer, "chitty_legacy_timestamp_overflows_total %d\n", serverMetrics.legacyOverflows)
+
+	writeHeader(writer, "chitty_lamport_jump", "histogram", "Difference between incoming and local Lamport time when receiving an event.")
+	serverMetrics.lamportJumps.write(writer, "chitty_lamport_jump", "")
+
+	writeHeader(writer, "chitty_rpc_duration_seconds", "histogram", "Duration of handled RPCs by method.")
+	for _, method := range sortedKeys(serverMetrics.rpcDurations) {
+		serverMetrics.rpcDurations[method].write(writer, "chitty_rpc_duration_seconds", fmt.Sprintf("method=\"%s\"", escapeLabel(method)))
+	}
+}
+
+func (server *ChatServer) metricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		server.writeMetrics(writer)
+	})
+
+	return mux
+}
+
+func (server *ChatServer) serveMetrics(address string) {
+	log.Printf("Serving metrics on %s/metrics", address)
+	serveErr := http.ListenAndServe(address, server.metricsHandler())
+	if serveErr != nil {
+		log.Printf("Metrics listener stopped | %v", serveErr)
+	}
+}
+
+func writeHeader(writer io.Writer, name string, metricType string, help string) {
+	fmt.Fprintf(writer, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(writer, "# TYPE %s %s\n", name, metricType)
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	proto "Chitty-Chat/GRPC"
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrapeMetrics(t *testing.T, server *ChatServer) string {
+	t.Helper()
+
+	httpServer := httptest.NewServer(server.metricsHandler())
+	defer httpServer.Close()
+
+	response, getErr := http.Get(httpServer.URL + "/metrics")
+	if getErr != nil {
+		t.Fatalf("Scraping metrics failed | %v", getErr)
+	}
+	defer response.Body.Close()
+
+	if contentType := response.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q, want the Prometheus text format", contentType)
+	}
+
+	body, readErr := io.ReadAll(response.Body)
+	if readErr != nil {
+		t.Fatalf("Reading metrics failed | %v", readErr)
+	}
+
+	return string(body)
+}
+
+func TestMetricsAfterChatting(t *testing.T) {
+	server, connection := startTestServer(t, ServerConfig{})
+	client := proto.NewChatServiceClient(connection)
+
+	stream, joinErr := client.JoinChat(context.Background(), &proto.UserRequest{Username: "alice", Timestamp: 1})
+	if joinErr != nil {
+		t.Fatalf("Joining failed | %v", joinErr)
+	}
+	stream.Recv()
+
+	_, broadcastErr := client.BroadcastMessage(context.Background(), &proto.Chat{Username: "alice", Message: "hello", Timestamp: 50})
+	if broadcastErr != nil {
+		t.Fatalf("Broadcasting failed | %v", broadcastErr)
+	}
+	stream.Recv()
+
+	body := scrapeMetrics(t, server)
+	for _, want := range []string{
+		"chitty_connected_clients 1\n",
+		"chitty_messages_received_total 1\n",
+		"chitty_messages_broadcast_total 2\n",
+		"chitty_client_queue_depth{user=\"alice\"} 0\n",
+		"chitty_lamport_time 52\n",
+		"chitty_lamport_jump_count 2\n",
+		"chitty_lamport_jump_bucket{le=\"10\"} 1\n",
+		"chitty_lamport_jump_bucket{le=\"100\"} 2\n",
+		"chitty_rpc_duration_seconds_count{method=\"/ChatService/BroadcastMessage\"} 1\n",
+		"# TYPE chitty_rpc_duration_seconds histogram\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Metrics are missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	distribution := newHistogram([]float64{-1, 0, 1})
+	for _, value := range []float64{-5, 0, 0.5, 3} {
+		distribution.observe(value)
+	}
+
+	var output strings.Builder
+	distribution.write(&output, "jump", "")
+
+	want := strings.Join([]string{
+		`jump_bucket{le="-1"} 1`,
+		`jump_bucket{le="0"} 2`,
+		`jump_bucket{le="1"} 3`,
+		`jump_bucket{le="+Inf"} 4`,
+		`jump_sum -1.5`,
+		`jump_count 4`,
+	}, "\n") + "\n"
+	if output.String() != want {
+		t.Errorf("Histogram output:\n%s\nwant:\n%s", output.String(), want)
+	}
+}
+
+func TestEscapeLabel(t *testing.T) {
+	got := escapeLabel("a\"b\\c\nd")
+	want := `a\"b\\c\nd`
+	if got != want {
+		t.Errorf("escapeLabel = %q, want %q", got, want)
+	}
+}
+
+func TestLegacyTimestampOverflowIsCounted(t *testing.T) {
+	server, connection := startTestServer(t, ServerConfig{})
+	client := proto.NewChatServiceClient(connection)
+
+	stream, joinErr := client.JoinChat(context.Background(), &proto.UserRequest{Username: "alice", Lamport: math.MaxInt32})
+	if joinErr != nil {
+		t.Fatalf("Joining failed | %v", joinErr)
+	}
+	joinNotice, receiveErr := stream.Recv()
+	if receiveErr != nil {
+		t.Fatalf("Receiving the join failed | %v", receiveErr)
+	}
+	if joinNotice.Timestamp != math.MaxInt32 || joinNotice.Lamport <= math.MaxInt32 {
+		t.Fatalf("Join was stamped LT%d with the legacy timestamp LT%d, want it past and capped at LT%d", joinNotice.Lamport, joinNotice.Timestamp, math.MaxInt32)
+	}
+
+	body := scrapeMetrics(t, server)
+	if !strings.Contains(body, "chitty_legacy_timestamp_overflows_total 2\n") {
+		t.Errorf("Metrics do not count the join header and notice as overflows:\n%s", body)
+	}
+}
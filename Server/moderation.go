@@ -0,0 +1,239 @@
+package server
+
+import (
+	clock "Chitty-Chat/Clock"
+	proto "Chitty-Chat/GRPC"
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const moderationDocument = "moderation"
+
+type sanction struct {
+	By     string
+	Reason string
+	Until  time.Time
+}
+
+func (userSanction sanction) activeAt(now time.Time) bool {
+	return userSanction.Until.IsZero() || now.Before(userSanction.Until)
+}
+
+func (userSanction sanction) describe() string {
+	description := "permanently"
+	if !userSanction.Until.IsZero() {
+		description = "until " + userSanction.Until.Format(time.DateTime)
+	}
+	if userSanction.Reason != "" {
+		description += " (" + userSanction.Reason + ")"
+	}
+
+	return description
+}
+
+// moderation keeps the persisted roles, bans and mutes of the chat.
+type moderation struct {
+	store *storage
+	Roles map[string]proto.Role
+	Bans  map[string]sanction
+	Mutes map[string]sanction
+}
+
+func newModeration(store *storage, owner string, moderators []string) *moderation {
+	chatModeration := &moderation{
+		store: store,
+		Roles: make(map[string]proto.Role),
+		Bans:  make(map[string]sanction),
+		Mutes: make(map[string]sanction),
+	}
+
+	loadErr := store.load(moderationDocument, chatModeration)
+	if loadErr != nil {
+		log.Printf("Failed to load moderation state, starting empty | %v", loadErr)
+	}
+
+	for _, moderator := range moderators {
+		if chatModeration.Roles[moderator] < proto.Role_MODERATOR {
+			chatModeration.Roles[moderator] = proto.Role_MODERATOR
+		}
+	}
+	if owner != "" {
+		chatModeration.Roles[owner] = proto.Role_OWNER
+	}
+	chatModeration.persist()
+
+	return chatModeration
+}
+
+func (chatModeration *moderation) roleOf(username string) proto.Role {
+	return chatModeration.Roles[username]
+}
+
+func (chatModeration *moderation) activeSanction(sanctions map[string]sanction, username string, now time.Time) (sanction, bool) {
+	userSanction, isSanctioned := sanctions[username]
+	if !isSanctioned {
+		return sanction{}, false
+	}
+
+	if !userSanction.activeAt(now) {
+		delete(sanctions, username)
+		chatModeration.persist()
+		return sanction{}, false
+	}
+
+	return userSanction, true
+}
+
+func (chatModeration *moderation) persist() {
+	saveErr := chatModeration.store.save(moderationDocument, chatModeration)
+	if saveErr != nil {
+		log.Printf("Failed to persist moderation state | %v", saveErr)
+	}
+}
+
+// authenticate checks that username is in the chat and that the RPC comes
+// from the connection the user joined over, so that nobody can act on
+// behalf of another user by sending their name.
+func (server *ChatServer) authenticate(ctx context.Context, username string) error {
+	client, isOnline := server.clients[username]
+	if !isOnline {
+		return status.Errorf(codes.FailedPrecondition, "User %s is not in the chat", username)
+	}
+
+	if client.peer != peerAddress(ctx) {
+		server.logEvent(slog.LevelWarn, "session_mismatch", userAttr(username), peerAttr(ctx))
+		return status.Errorf(codes.PermissionDenied, "You are not connected as %s", username)
+	}
+
+	return nil
+}
+
+// authorize checks that the requester may moderate the target, which needs
+// a joined session, at least the moderator role and a role strictly above
+// the target's.
+func (server *ChatServer) authorize(ctx context.Context, request *proto.ModerationRequest) error {
+	authenticateErr := server.authenticate(ctx, request.Requester)
+	if authenticateErr != nil {
+		return authenticateErr
+	}
+
+	requesterRole := server.moderation.roleOf(request.Requester)
+	if requesterRole < proto.Role_MODERATOR {
+		return status.Error(codes.PermissionDenied, "Only moderators and the owner can do that")
+	}
+
+	if request.Target == "" {
+		return status.Error(codes.InvalidArgument, "No target user given")
+	}
+
+	if server.moderation.roleOf(request.Target) >= requesterRole {
+		return status.Errorf(codes.PermissionDenied, "You cannot moderate %s", request.Target)
+	}
+
+	return nil
+}
+
+func (server *ChatServer) sanctionFor(request *proto.ModerationRequest) sanction {
+	userSanction := sanction{By: request.Requester, Reason: request.Reason}
+	if request.DurationSeconds > 0 {
+		userSanction.Until = time.Now().Add(time.Duration(request.DurationSeconds) * time.Second)
+	}
+
+	return userSanction
+}
+
+func (server *ChatServer) announce(message string) {
+	log.Print(message)
+	server.publish(&proto.Chat{
+		Username: "Server",
+		Message:  message,
+		Kind:     proto.ChatKind_SYSTEM,
+	})
+}
+
+// removeClient disconnects an online user, ending their JoinChat stream with
+// the given error.
+func (server *ChatServer) removeClient(username string, reason error) bool {
+	client, isOnline := server.clients[username]
+	if !isOnline {
+		return false
+	}
+
+	delete(server.clients, username)
+	server.federatePresence(proto.FederationKind_USER_LEFT, username)
+	server.dropFromFloor(username)
+	client.removed <- reason
+
+	return true
+}
+
+func (server *ChatServer) KickUser(ctx context.Context, request *proto.ModerationRequest) (*proto.Empty, error) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.mergeClock(clock.Time{Lamport: clock.Widen(request.Lamport, request.Timestamp)})
+	authorizeErr := server.authorize(ctx, request)
+	if authorizeErr != nil {
+		return nil, authorizeErr
+	}
+
+	reason := fmt.Sprintf("You were kicked by %s", request.Requester)
+	if request.Reason != "" {
+		reason += " (" + request.Reason + ")"
+	}
+	if !server.removeClient(request.Target, status.Error(codes.PermissionDenied, reason)) {
+		return nil, status.Errorf(codes.NotFound, "User %s is not in the chat", request.Target)
+	}
+
+	server.logEvent(slog.LevelInfo, "kick", userAttr(request.Target), slog.String("by", request.Requester), slog.String("reason", request.Reason))
+	server.announce(fmt.Sprintf("User %s was kicked by %s at LT%d", request.Target, request.Requester, server.clock.Now().Lamport))
+
+	return &proto.Empty{}, nil
+}
+
+func (server *ChatServer) MuteUser(ctx context.Context, request *proto.ModerationRequest) (*proto.Empty, error) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.mergeClock(clock.Time{Lamport: clock.Widen(request.Lamport, request.Timestamp)})
+	authorizeErr := server.authorize(ctx, request)
+	if authorizeErr != nil {
+		return nil, authorizeErr
+	}
+
+	mute := server.sanctionFor(request)
+	server.moderation.Mutes[request.Target] = mute
+	server.moderation.persist()
+
+	server.logEvent(slog.LevelInfo, "mute", userAttr(request.Target), slog.String("by", request.Requester), slog.String("reason", request.Reason), slog.Int64("duration_seconds", request.DurationSeconds))
+	server.announce(fmt.Sprintf("User %s was muted by %s %s at LT%d", request.Target, request.Requester, mute.describe(), server.clock.Now().Lamport))
+
+	return &proto.Empty{}, nil
+}
+
+func (server *ChatServer) BanUser(ctx context.Context, request *proto.ModerationRequest) (*proto.Empty, error) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.mergeClock(clock.Time{Lamport: clock.Widen(request.Lamport, request.Timestamp)})
+	authorizeErr := server.authorize(ctx, request)
+	if authorizeErr != nil {
+		return nil, authorizeErr
+	}
+
+	ban := server.sanctionFor(request)
+	server.moderation.Bans[request.Target] = ban
+	server.moderation.persist()
+	server.removeClient(request.Target, status.Errorf(codes.PermissionDenied, "You were banned by %s %s", request.Requester, ban.describe()))
+
+	server.logEvent(slog.LevelInfo, "ban", userAttr(request.Target), slog.String("by", request.Requester), slog.String("reason", request.Reason), slog.Int64("duration_seconds", request.DurationSeconds))
+	server.announce(fmt.Sprintf("User %s was banned by %s %s at LT%d", request.Target, request.Requester, ban.describe(), server.clock.Now().Lamport))
+
+	return &proto.Empty{}, nil
+}
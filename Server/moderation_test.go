@@ -0,0 +1,117 @@
+package server
+
+import (
+	proto "Chitty-Chat/GRPC"
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// dialTestServer opens another connection to the server behind connection,
+// which the server sees as a different peer.
+func dialTestServer(t *testing.T, connection *grpc.ClientConn) *grpc.ClientConn {
+	t.Helper()
+
+	otherConnection, connectionErr := grpc.NewClient(connection.Target(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if connectionErr != nil {
+		t.Fatalf("Failed to connect | %v", connectionErr)
+	}
+	t.Cleanup(func() { otherConnection.Close() })
+
+	return otherConnection
+}
+
+// joinTestUser joins the chat as username and waits for the join notice.
+func joinTestUser(t *testing.T, connection *grpc.ClientConn, username string) proto.ChatService_JoinChatClient {
+	t.Helper()
+
+	stream, joinErr := proto.NewChatServiceClient(connection).JoinChat(context.Background(), &proto.UserRequest{Username: username})
+	if joinErr != nil {
+		t.Fatalf("Joining as %s failed | %v", username, joinErr)
+	}
+	_, recvErr := stream.Recv()
+	if recvErr != nil {
+		t.Fatalf("Receiving the join notice of %s failed | %v", username, recvErr)
+	}
+
+	return stream
+}
+
+func TestModerationRejectsImpersonation(t *testing.T) {
+	_, connection := startTestServer(t, ServerConfig{Owner: "olivia"})
+	joinTestUser(t, connection, "olivia")
+	mallory := dialTestServer(t, connection)
+	joinTestUser(t, mallory, "mallory")
+	service := proto.NewChatServiceClient(mallory)
+
+	_, kickErr := service.KickUser(context.Background(), &proto.ModerationRequest{Requester: "olivia", Target: "bob"})
+	if status.Code(kickErr) != codes.PermissionDenied {
+		t.Errorf("Kicking as olivia from another connection returned %v, want PermissionDenied", kickErr)
+	}
+
+	_, slowModeErr := service.SetSlowMode(context.Background(), &proto.ModerationRequest{Requester: "olivia", DurationSeconds: 60})
+	if status.Code(slowModeErr) != codes.PermissionDenied {
+		t.Errorf("Setting slow mode as olivia from another connection returned %v, want PermissionDenied", slowModeErr)
+	}
+
+	_, banErr := service.BanUser(context.Background(), &proto.ModerationRequest{Requester: "oscar", Target: "olivia"})
+	if status.Code(banErr) != codes.FailedPrecondition {
+		t.Errorf("Banning as a user that is not in the chat returned %v, want FailedPrecondition", banErr)
+	}
+}
+
+func TestModerationRoleOrdering(t *testing.T) {
+	_, connection := startTestServer(t, ServerConfig{Owner: "olivia", Moderators: []string{"mona", "max"}})
+	service := proto.NewChatServiceClient(connection)
+	for _, username := range []string{"olivia", "mona", "max", "bob"} {
+		joinTestUser(t, connection, username)
+	}
+
+	for _, test := range []struct {
+		requester string
+		target    string
+		want      codes.Code
+	}{
+		{"bob", "max", codes.PermissionDenied},
+		{"mona", "olivia", codes.PermissionDenied},
+		{"mona", "max", codes.PermissionDenied},
+		{"mona", "bob", codes.OK},
+		{"olivia", "max", codes.OK},
+	} {
+		_, muteErr := service.MuteUser(context.Background(), &proto.ModerationRequest{Requester: test.requester, Target: test.target})
+		if status.Code(muteErr) != test.want {
+			t.Errorf("%s muting %s returned %v, want %v", test.requester, test.target, muteErr, test.want)
+		}
+	}
+
+	_, broadcastErr := service.BroadcastMessage(context.Background(), &proto.Chat{Username: "bob", Message: "hello"})
+	if status.Code(broadcastErr) != codes.PermissionDenied {
+		t.Errorf("Muted bob broadcasting returned %v, want PermissionDenied", broadcastErr)
+	}
+}
+
+func TestModerationPersists(t *testing.T) {
+	dataDirectory := t.TempDir()
+	_, connection := startTestServer(t, ServerConfig{DataDirectory: dataDirectory, Owner: "olivia"})
+	joinTestUser(t, connection, "olivia")
+	joinTestUser(t, connection, "bob")
+
+	_, banErr := proto.NewChatServiceClient(connection).BanUser(context.Background(), &proto.ModerationRequest{Requester: "olivia", Target: "bob", Reason: "spam"})
+	if banErr != nil {
+		t.Fatalf("Banning bob failed | %v", banErr)
+	}
+
+	restarted := newTestServer(t, ServerConfig{DataDirectory: dataDirectory})
+	ban, isBanned := restarted.moderation.activeSanction(restarted.moderation.Bans, "bob", time.Now())
+	if !isBanned || ban.By != "olivia" || ban.Reason != "spam" {
+		t.Errorf("After a restart bob's ban is %+v, banned %v, want a permanent ban by olivia for spam", ban, isBanned)
+	}
+	if role := restarted.moderation.roleOf("olivia"); role != proto.Role_OWNER {
+		t.Errorf("After a restart olivia has the role %v, want OWNER", role)
+	}
+}
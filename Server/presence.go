@@ -0,0 +1,95 @@
+package server
+
+import (
+	proto "Chitty-Chat/GRPC"
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Heartbeat lets a client prove that its connection is still alive. Only the
+// connection the user joined from can keep them in the chat. Heartbeats are
+// not chat events, so they do not advance the Lamport clock.
+func (server *ChatServer) Heartbeat(ctx context.Context, user *proto.UserRequest) (*proto.Empty, error) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	client, isOnline := server.clients[user.Username]
+	if !isOnline {
+		return nil, status.Errorf(codes.NotFound, "User %s is not in the chat", user.Username)
+	}
+	authenticateErr := server.authenticate(ctx, user.Username)
+	if authenticateErr != nil {
+		return nil, authenticateErr
+	}
+
+	client.lastHeartbeat = time.Now()
+	client.heartbeating = true
+
+	return &proto.Empty{}, nil
+}
+
+func (server *ChatServer) markActive(username string) {
+	client, isOnline := server.clients[username]
+	if !isOnline {
+		return
+	}
+
+	client.lastActivity = time.Now()
+	if client.away {
+		client.away = false
+		server.logEvent(slog.LevelInfo, "back", userAttr(username))
+		server.announce(fmt.Sprintf("User %s is back at LT%d", username, server.clock.Now().Lamport))
+	}
+}
+
+// monitorPresence marks users that have not posted for a while as away and
+// evicts users whose heartbeats have stopped. Clients that never sent a
+// heartbeat are left to the gRPC keepalive.
+func (server *ChatServer) monitorPresence() {
+	ticker := time.NewTicker(server.presenceCheckInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			server.mutex.Lock()
+			server.checkPresence(now)
+			server.mutex.Unlock()
+		case <-server.draining:
+			return
+		}
+	}
+}
+
+func (server *ChatServer) presenceCheckInterval() time.Duration {
+	interval := time.Second
+	if server.evictAfter > 0 {
+		interval = min(interval, server.evictAfter/2)
+	}
+
+	return interval
+}
+
+func (server *ChatServer) checkPresence(now time.Time) {
+	for _, username := range server.onlineUsernames() {
+		client := server.clients[username]
+
+		if server.evictAfter > 0 && client.heartbeating && now.Sub(client.lastHeartbeat) > server.evictAfter {
+			server.removeClient(username, status.Error(codes.Unavailable, "Connection stopped responding"))
+			server.logEvent(slog.LevelWarn, "evicted", userAttr(username), slog.Duration("silent_for", now.Sub(client.lastHeartbeat)))
+			server.announce(fmt.Sprintf("User %s stopped responding and was removed at LT%d", username, server.clock.Now().Lamport))
+			continue
+		}
+
+		if server.awayAfter > 0 && !client.away && now.Sub(client.lastActivity) > server.awayAfter {
+			client.away = true
+			server.logEvent(slog.LevelInfo, "away", userAttr(username))
+			server.announce(fmt.Sprintf("User %s is away at LT%d", username, server.clock.Now().Lamport))
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	proto "Chitty-Chat/GRPC"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHeartbeatRejectsImpersonation(t *testing.T) {
+	_, connection := startTestServer(t, ServerConfig{EvictAfter: time.Minute})
+	joinTestUser(t, connection, "alice")
+	mallory := dialTestServer(t, connection)
+	joinTestUser(t, mallory, "mallory")
+
+	_, heartbeatErr := proto.NewChatServiceClient(mallory).Heartbeat(context.Background(), &proto.UserRequest{Username: "alice"})
+	if status.Code(heartbeatErr) != codes.PermissionDenied {
+		t.Errorf("Heartbeat for alice from another connection returned %v, want PermissionDenied", heartbeatErr)
+	}
+
+	_, heartbeatErr = proto.NewChatServiceClient(connection).Heartbeat(context.Background(), &proto.UserRequest{Username: "alice"})
+	if heartbeatErr != nil {
+		t.Errorf("Heartbeat from alice's own connection failed | %v", heartbeatErr)
+	}
+}
+
+func TestIdleUsersAreAwayAndSilentOnesEvicted(t *testing.T) {
+	server := newTestServer(t, ServerConfig{DataDirectory: t.TempDir(), AwayAfter: time.Minute, EvictAfter: time.Minute})
+	var notices []*proto.Chat
+	for _, username := range []string{"alice", "bob"} {
+		_, connectErr := server.Connect(context.Background(), &proto.UserRequest{Username: username}, func(message *proto.Chat) {
+			if username == "alice" && message.Kind == proto.ChatKind_SYSTEM {
+				notices = append(notices, message)
+			}
+		})
+		if connectErr != nil {
+			t.Fatalf("%s could not connect | %v", username, connectErr)
+		}
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.clients["bob"].heartbeating = true
+	notices = nil
+	startLamport := server.clock.Now().Lamport
+	server.checkPresence(time.Now().Add(2 * time.Minute))
+
+	if _, isOnline := server.clients["bob"]; isOnline {
+		t.Errorf("bob is still online after their heartbeats stopped")
+	}
+	if !server.clients["alice"].away {
+		t.Errorf("alice is not away after being idle")
+	}
+
+	want := []string{
+		fmt.Sprintf("User alice is away at LT%d", startLamport),
+		fmt.Sprintf("User bob stopped responding and was removed at LT%d", startLamport+1),
+	}
+	if len(notices) != len(want) {
+		t.Fatalf("alice got %d notices, want %d", len(notices), len(want))
+	}
+	for i, notice := range notices {
+		if notice.Message != want[i] || notice.Lamport != startLamport+int64(i)+1 {
+			t.Errorf("Notice %d is %q at LT%d, want %q at LT%d", i, notice.Message, notice.Lamport, want[i], startLamport+int64(i)+1)
+		}
+	}
+}
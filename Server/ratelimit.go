@@ -0,0 +1,200 @@
+package server
+
+import (
+	clock "Chitty-Chat/Clock"
+	proto "Chitty-Chat/GRPC"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const rateLimiterSweepInterval = time.Minute
+
+type tokenBucket struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+// rateLimiter hands out tokens per key from buckets that hold at most burst
+// tokens and refill at refillRate tokens per second. A refillRate of zero
+// disables the limiter.
+type rateLimiter struct {
+	refillRate float64
+	burst      float64
+	buckets    map[string]*tokenBucket
+	lastSweep  time.Time
+}
+
+func newRateLimiter(refillRate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		refillRate: refillRate,
+		burst:      float64(max(burst, 1)),
+		buckets:    make(map[string]*tokenBucket),
+	}
+}
+
+func (limiter *rateLimiter) refill(bucket *tokenBucket, now time.Time) {
+	elapsed := now.Sub(bucket.lastRefill).Seconds()
+	bucket.tokens = math.Min(limiter.burst, bucket.tokens+elapsed*limiter.refillRate)
+	bucket.lastRefill = now
+}
+
+// available refills the bucket for the key and reports whether it holds a
+// token, or how long the caller has to wait until it does.
+func (limiter *rateLimiter) available(key string, now time.Time) (bool, time.Duration) {
+	if limiter.refillRate <= 0 {
+		return true, 0
+	}
+	limiter.sweep(now)
+
+	bucket, exists := limiter.buckets[key]
+	if !exists {
+		bucket = &tokenBucket{tokens: limiter.burst, lastRefill: now}
+		limiter.buckets[key] = bucket
+	}
+	limiter.refill(bucket, now)
+
+	if bucket.tokens < 1 {
+		missingTokens := 1 - bucket.tokens
+		return false, time.Duration(missingTokens / limiter.refillRate * float64(time.Second))
+	}
+
+	return true, 0
+}
+
+// take consumes a token for the key, or reports how long the caller has to
+// wait until one becomes available.
+func (limiter *rateLimiter) take(key string, now time.Time) (bool, time.Duration) {
+	allowed, retryAfter := limiter.available(key, now)
+	if allowed && limiter.refillRate > 0 {
+		limiter.buckets[key].tokens--
+	}
+
+	return allowed, retryAfter
+}
+
+// sweep forgets buckets that have refilled completely, as they behave exactly
+// like a freshly created bucket.
+func (limiter *rateLimiter) sweep(now time.Time) {
+	if now.Sub(limiter.lastSweep) < rateLimiterSweepInterval {
+		return
+	}
+	limiter.lastSweep = now
+
+	for key, bucket := range limiter.buckets {
+		limiter.refill(bucket, now)
+		if bucket.tokens >= limiter.burst {
+			delete(limiter.buckets, key)
+		}
+	}
+}
+
+func peerAddress(ctx context.Context) string {
+	connectionPeer, hasPeer := peer.FromContext(ctx)
+	if !hasPeer || connectionPeer.Addr == nil {
+		return "unknown"
+	}
+
+	return connectionPeer.Addr.String()
+}
+
+func resourceExhaustedError(message string, retryAfter time.Duration) error {
+	retryAfter = retryAfter.Round(time.Millisecond)
+	exhaustedStatus := status.New(codes.ResourceExhausted, fmt.Sprintf("%s, try again in %v", message, retryAfter))
+	detailedStatus, detailsErr := exhaustedStatus.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)})
+	if detailsErr != nil {
+		return exhaustedStatus.Err()
+	}
+
+	return detailedStatus.Err()
+}
+
+// peerHost returns the IP address a request comes from, without the port,
+// which changes with every connection.
+func peerHost(ctx context.Context) string {
+	address := peerAddress(ctx)
+	host, _, splitErr := net.SplitHostPort(address)
+	if splitErr != nil {
+		return address
+	}
+
+	return host
+}
+
+// allow takes a token from both the per-user and the per-IP bucket, so
+// that neither switching usernames nor opening more connections helps a
+// flooding client. Neither token is taken unless both buckets have one.
+func allow(limiter *rateLimiter, username string, host string, now time.Time) (bool, time.Duration) {
+	userAllowed, userRetryAfter := limiter.available("user:"+username, now)
+	hostAllowed, hostRetryAfter := limiter.available("host:"+host, now)
+	if !userAllowed || !hostAllowed {
+		return false, max(userRetryAfter, hostRetryAfter)
+	}
+
+	limiter.take("user:"+username, now)
+	limiter.take("host:"+host, now)
+	return true, 0
+}
+
+func (server *ChatServer) checkJoinRate(ctx context.Context, username string) error {
+	allowed, retryAfter := allow(server.joinLimiter, username, peerHost(ctx), time.Now())
+	if !allowed {
+		log.Printf("Join rate limit exceeded by %s, rejecting", username)
+		return resourceExhaustedError("Too many join attempts", retryAfter)
+	}
+
+	return nil
+}
+
+func (server *ChatServer) checkMessageRate(ctx context.Context, username string) error {
+	now := time.Now()
+	allowed, retryAfter := allow(server.messageLimiter, username, peerHost(ctx), now)
+	if !allowed {
+		log.Printf("Message rate limit exceeded by %s, rejecting", username)
+		return resourceExhaustedError("You are sending messages too quickly", retryAfter)
+	}
+
+	if server.slowMode > 0 && server.moderation.roleOf(username) < proto.Role_MODERATOR {
+		lastPost, hasPosted := server.lastPosts[username]
+		if hasPosted && now.Sub(lastPost) < server.slowMode {
+			return resourceExhaustedError("Slow mode is on", server.slowMode-now.Sub(lastPost))
+		}
+		server.lastPosts[username] = now
+	}
+
+	return nil
+}
+
+func (server *ChatServer) SetSlowMode(ctx context.Context, request *proto.ModerationRequest) (*proto.Empty, error) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.mergeClock(clock.Time{Lamport: clock.Widen(request.Lamport, request.Timestamp)})
+	authenticateErr := server.authenticate(ctx, request.Requester)
+	if authenticateErr != nil {
+		return nil, authenticateErr
+	}
+	if server.moderation.roleOf(request.Requester) < proto.Role_MODERATOR {
+		return nil, status.Error(codes.PermissionDenied, "Only moderators and the owner can do that")
+	}
+
+	server.slowMode = time.Duration(request.DurationSeconds) * time.Second
+	server.lastPosts = make(map[string]time.Time)
+
+	if server.slowMode > 0 {
+		server.announce(fmt.Sprintf("Slow mode was set to one message per %v by %s at LT%d", server.slowMode, request.Requester, server.clock.Now().Lamport))
+	} else {
+		server.announce(fmt.Sprintf("Slow mode was turned off by %s at LT%d", request.Requester, server.clock.Now().Lamport))
+	}
+
+	return &proto.Empty{}, nil
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	proto "Chitty-Chat/GRPC"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func peerContext(address string) context.Context {
+	tcpAddress, _ := net.ResolveTCPAddr("tcp", address)
+	return peer.NewContext(context.Background(), &peer.Peer{Addr: tcpAddress})
+}
+
+func TestTokenBucketRefills(t *testing.T) {
+	limiter := newRateLimiter(1, 2)
+	start := time.Now()
+
+	for i := range 2 {
+		if allowed, _ := limiter.take("key", start); !allowed {
+			t.Fatalf("Token %d of the burst was refused", i+1)
+		}
+	}
+	allowed, retryAfter := limiter.take("key", start)
+	if allowed || retryAfter != time.Second {
+		t.Errorf("Token after the burst: allowed %v, retry after %v, want refused for 1s", allowed, retryAfter)
+	}
+
+	allowed, retryAfter = limiter.take("key", start.Add(500*time.Millisecond))
+	if allowed || retryAfter != 500*time.Millisecond {
+		t.Errorf("Token after half a refill: allowed %v, retry after %v, want refused for 500ms", allowed, retryAfter)
+	}
+	if allowed, _ = limiter.take("key", start.Add(time.Second)); !allowed {
+		t.Errorf("Token after a full refill was refused")
+	}
+}
+
+func TestMessageRateIsPerHost(t *testing.T) {
+	server := newTestServer(t, ServerConfig{DataDirectory: t.TempDir(), MessageRate: 0.001, MessageBurst: 2})
+
+	for i, username := range []string{"alice", "bob"} {
+		rateErr := server.checkMessageRate(peerContext(fmt.Sprintf("10.0.0.1:%d", 40000+i)), username)
+		if rateErr != nil {
+			t.Fatalf("Message %d from 10.0.0.1 was refused | %v", i+1, rateErr)
+		}
+	}
+
+	rateErr := server.checkMessageRate(peerContext("10.0.0.1:40002"), "carol")
+	if status.Code(rateErr) != codes.ResourceExhausted {
+		t.Errorf("Third message from 10.0.0.1 with a new username and port returned %v, want ResourceExhausted", rateErr)
+	}
+
+	for range 2 {
+		rateErr = server.checkMessageRate(peerContext("10.0.0.2:40000"), "carol")
+		if rateErr != nil {
+			t.Errorf("carol lost a token to the refused message from 10.0.0.1 | %v", rateErr)
+		}
+	}
+}
+
+func TestSetSlowMode(t *testing.T) {
+	_, connection := startTestServer(t, ServerConfig{Owner: "olivia"})
+	joinTestUser(t, connection, "olivia")
+	joinTestUser(t, connection, "bob")
+	service := proto.NewChatServiceClient(connection)
+
+	_, memberErr := service.SetSlowMode(context.Background(), &proto.ModerationRequest{Requester: "bob", DurationSeconds: 30})
+	if status.Code(memberErr) != codes.PermissionDenied {
+		t.Errorf("Member setting slow mode returned %v, want PermissionDenied", memberErr)
+	}
+
+	_, slowModeErr := service.SetSlowMode(context.Background(), &proto.ModerationRequest{Requester: "olivia", DurationSeconds: 30})
+	if slowModeErr != nil {
+		t.Fatalf("Owner could not set slow mode | %v", slowModeErr)
+	}
+	for _, test := range []struct {
+		username string
+		want     codes.Code
+	}{
+		{"bob", codes.OK},
+		{"bob", codes.ResourceExhausted},
+		{"olivia", codes.OK},
+		{"olivia", codes.OK},
+	} {
+		_, broadcastErr := service.BroadcastMessage(context.Background(), &proto.Chat{Username: test.username, Message: "hello"})
+		if status.Code(broadcastErr) != test.want {
+			t.Errorf("%s broadcasting in slow mode returned %v, want %v", test.username, broadcastErr, test.want)
+		}
+	}
+
+	_, offErr := service.SetSlowMode(context.Background(), &proto.ModerationRequest{Requester: "olivia"})
+	if offErr != nil {
+		t.Fatalf("Owner could not turn slow mode off | %v", offErr)
+	}
+	_, broadcastErr := service.BroadcastMessage(context.Background(), &proto.Chat{Username: "bob", Message: "hello"})
+	if broadcastErr != nil {
+		t.Errorf("bob broadcasting after slow mode was turned off returned %v", broadcastErr)
+	}
+}
+
+func TestUnknownRecipientDoesNotCountAsAPost(t *testing.T) {
+	_, connection := startTestServer(t, ServerConfig{SlowMode: 30 * time.Second})
+	joinTestUser(t, connection, "alice")
+	joinTestUser(t, connection, "bob")
+	service := proto.NewChatServiceClient(connection)
+
+	_, typoErr := service.BroadcastMessage(context.Background(), &proto.Chat{Username: "bob", Recipient: "alcie", Message: "hello"})
+	if status.Code(typoErr) != codes.NotFound {
+		t.Fatalf("Direct message to an unknown user returned %v, want NotFound", typoErr)
+	}
+	_, resendErr := service.BroadcastMessage(context.Background(), &proto.Chat{Username: "bob", Recipient: "alice", Message: "hello"})
+	if resendErr != nil {
+		t.Errorf("bob was held back by slow mode after a rejected message | %v", resendErr)
+	}
+}
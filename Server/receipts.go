@@ -0,0 +1,124 @@
+package server
+
+import (
+	clock "Chitty-Chat/Clock"
+	proto "Chitty-Chat/GRPC"
+	"context"
+	"log"
+	"log/slog"
+	"sort"
+)
+
+const maxTrackedReceipts = 1000
+
+type receipt struct {
+	message     *proto.Chat
+	deliveredTo map[string]bool
+	readBy      map[string]bool
+}
+
+// receiptTracker remembers delivery and read state for the most recent
+// user messages, evicting the oldest once the limit is reached.
+type receiptTracker struct {
+	limit    int
+	receipts map[int64]*receipt
+	order    []int64
+}
+
+func newReceiptTracker(limit int) *receiptTracker {
+	return &receiptTracker{
+		limit:    limit,
+		receipts: make(map[int64]*receipt),
+	}
+}
+
+func (tracker *receiptTracker) track(message *proto.Chat) {
+	tracker.receipts[message.Id] = &receipt{
+		message:     message,
+		deliveredTo: make(map[string]bool),
+		readBy:      make(map[string]bool),
+	}
+	tracker.order = append(tracker.order, message.Id)
+
+	if len(tracker.order) > tracker.limit {
+		delete(tracker.receipts, tracker.order[0])
+		tracker.order = tracker.order[1:]
+	}
+}
+
+// acknowledge records the receipts of username for the tracked messages
+// that were addressed to them, ignoring their own messages.
+func (tracker *receiptTracker) acknowledge(username string, kind proto.ReceiptKind, messageIds []int64) {
+	for _, messageId := range messageIds {
+		messageReceipt, isTracked := tracker.receipts[messageId]
+		if !isTracked || messageReceipt.message.Username == username || !isAddressedTo(messageReceipt.message, username) {
+			continue
+		}
+
+		messageReceipt.deliveredTo[username] = true
+		if kind == proto.ReceiptKind_READ {
+			messageReceipt.readBy[username] = true
+		}
+	}
+}
+
+func (tracker *receiptTracker) receiptsFor(requester string, messageIds []int64) []*proto.Receipt {
+	var receipts []*proto.Receipt
+	for _, messageId := range messageIds {
+		messageReceipt, isTracked := tracker.receipts[messageId]
+		if !isTracked || messageReceipt.message.Username != requester {
+			continue
+		}
+
+		receipts = append(receipts, &proto.Receipt{
+			MessageId:   messageId,
+			DeliveredTo: sortedUsernames(messageReceipt.deliveredTo),
+			ReadBy:      sortedUsernames(messageReceipt.readBy),
+		})
+	}
+
+	return receipts
+}
+
+func sortedUsernames(usernames map[string]bool) []string {
+	sorted := make([]string, 0, len(usernames))
+	for username := range usernames {
+		sorted = append(sorted, username)
+	}
+	sort.Strings(sorted)
+
+	return sorted
+}
+
+func (server *ChatServer) AcknowledgeMessages(ctx context.Context, ack *proto.Acknowledgement) (*proto.Empty, error) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	authenticateErr := server.authenticate(ctx, ack.Username)
+	if authenticateErr != nil {
+		return nil, authenticateErr
+	}
+
+	server.mergeClock(clock.Time{Lamport: clock.Widen(ack.Lamport, ack.Timestamp)})
+	log.Printf("LT%d | %s acknowledged %d message(s) as %s", server.clock.Now().Lamport, ack.Username, len(ack.MessageIds), ack.Kind)
+	server.logEvent(slog.LevelDebug, "acknowledge", userAttr(ack.Username), peerAttr(ctx), slog.String("kind", ack.Kind.String()), slog.Any("message_ids", ack.MessageIds), slog.Int64("sent_lamport", clock.Widen(ack.Lamport, ack.Timestamp)))
+	server.receipts.acknowledge(ack.Username, ack.Kind, ack.MessageIds)
+
+	return &proto.Empty{}, nil
+}
+
+func (server *ChatServer) GetReceipts(ctx context.Context, request *proto.ReceiptRequest) (*proto.ReceiptList, error) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	authenticateErr := server.authenticate(ctx, request.Username)
+	if authenticateErr != nil {
+		return nil, authenticateErr
+	}
+
+	server.mergeClock(clock.Time{Lamport: clock.Widen(request.Lamport, request.Timestamp)})
+	receipts := server.receipts.receiptsFor(request.Username, request.MessageIds)
+	now := server.clock.Tick()
+
+	return &proto.ReceiptList{Receipts: receipts, Timestamp: server.legacyTimestamp(now.Lamport), Lamport: now.Lamport}, nil
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	proto "Chitty-Chat/GRPC"
+	"context"
+	"slices"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestReceiptTracker(t *testing.T) {
+	tracker := newReceiptTracker(2)
+	tracker.track(&proto.Chat{Id: 1, Username: "alice"})
+	tracker.track(&proto.Chat{Id: 2, Username: "alice"})
+
+	tracker.acknowledge("bob", proto.ReceiptKind_DELIVERED, []int64{1, 2})
+	tracker.acknowledge("carol", proto.ReceiptKind_READ, []int64{1, 7})
+	tracker.acknowledge("alice", proto.ReceiptKind_READ, []int64{1})
+
+	receipts := tracker.receiptsFor("alice", []int64{1, 2, 7})
+	if len(receipts) != 2 {
+		t.Fatalf("alice got %d receipts, want 2 for the tracked messages", len(receipts))
+	}
+	if !slices.Equal(receipts[0].DeliveredTo, []string{"bob", "carol"}) || !slices.Equal(receipts[0].ReadBy, []string{"carol"}) {
+		t.Errorf("Receipt of #1 is delivered to %v and read by %v, want bob and carol, and carol", receipts[0].DeliveredTo, receipts[0].ReadBy)
+	}
+	if !slices.Equal(receipts[1].DeliveredTo, []string{"bob"}) || len(receipts[1].ReadBy) != 0 {
+		t.Errorf("Receipt of #2 is delivered to %v and read by %v, want bob and nobody", receipts[1].DeliveredTo, receipts[1].ReadBy)
+	}
+
+	if others := tracker.receiptsFor("bob", []int64{1, 2}); len(others) != 0 {
+		t.Errorf("bob got receipts for alice's messages: %v", others)
+	}
+
+	tracker.track(&proto.Chat{Id: 3, Username: "bob"})
+	if evicted := tracker.receiptsFor("alice", []int64{1}); len(evicted) != 0 {
+		t.Errorf("Receipt of #1 is still tracked beyond the limit of 2")
+	}
+	if kept := tracker.receiptsFor("alice", []int64{2}); len(kept) != 1 {
+		t.Errorf("Receipt of #2 was evicted before the oldest one")
+	}
+}
+
+func TestDirectMessageReceiptsOnlyCountTheRecipient(t *testing.T) {
+	tracker := newReceiptTracker(10)
+	tracker.track(&proto.Chat{Id: 1, Username: "alice", Recipient: "bob"})
+
+	tracker.acknowledge("mallory", proto.ReceiptKind_READ, []int64{1})
+	tracker.acknowledge("bob", proto.ReceiptKind_DELIVERED, []int64{1})
+
+	receipts := tracker.receiptsFor("alice", []int64{1})
+	if len(receipts) != 1 {
+		t.Fatalf("alice got %d receipts, want 1", len(receipts))
+	}
+	if !slices.Equal(receipts[0].DeliveredTo, []string{"bob"}) || len(receipts[0].ReadBy) != 0 {
+		t.Errorf("Direct message to bob is delivered to %v and read by %v, want bob and nobody", receipts[0].DeliveredTo, receipts[0].ReadBy)
+	}
+}
+
+func TestReceiptsRejectImpersonation(t *testing.T) {
+	server, connection := startTestServer(t, ServerConfig{})
+	joinTestUser(t, connection, "alice")
+	mallory := dialTestServer(t, connection)
+	joinTestUser(t, mallory, "mallory")
+
+	alice := proto.NewChatServiceClient(connection)
+	_, broadcastErr := alice.BroadcastMessage(context.Background(), &proto.Chat{Username: "alice", Message: "hello"})
+	if broadcastErr != nil {
+		t.Fatalf("alice could not send | %v", broadcastErr)
+	}
+	server.mutex.Lock()
+	messageId := server.lastMessageId
+	server.mutex.Unlock()
+
+	service := proto.NewChatServiceClient(mallory)
+	_, ackErr := service.AcknowledgeMessages(context.Background(), &proto.Acknowledgement{Username: "bob", Kind: proto.ReceiptKind_READ, MessageIds: []int64{messageId}})
+	if status.Code(ackErr) != codes.FailedPrecondition {
+		t.Errorf("Acknowledging as bob, who is not in the chat, returned %v, want FailedPrecondition", ackErr)
+	}
+	_, ackErr = service.AcknowledgeMessages(context.Background(), &proto.Acknowledgement{Username: "alice", Kind: proto.ReceiptKind_DELIVERED, MessageIds: []int64{messageId}})
+	if status.Code(ackErr) != codes.PermissionDenied {
+		t.Errorf("Acknowledging as alice from another connection returned %v, want PermissionDenied", ackErr)
+	}
+	_, receiptsErr := service.GetReceipts(context.Background(), &proto.ReceiptRequest{Username: "alice", MessageIds: []int64{messageId}})
+	if status.Code(receiptsErr) != codes.PermissionDenied {
+		t.Errorf("Fetching alice's receipts from another connection returned %v, want PermissionDenied", receiptsErr)
+	}
+
+	_, ackErr = service.AcknowledgeMessages(context.Background(), &proto.Acknowledgement{Username: "mallory", Kind: proto.ReceiptKind_READ, MessageIds: []int64{messageId}})
+	if ackErr != nil {
+		t.Fatalf("mallory could not acknowledge from their own connection | %v", ackErr)
+	}
+	receiptList, receiptsErr := alice.GetReceipts(context.Background(), &proto.ReceiptRequest{Username: "alice", MessageIds: []int64{messageId}})
+	if receiptsErr != nil {
+		t.Fatalf("alice could not fetch their receipts | %v", receiptsErr)
+	}
+	if len(receiptList.Receipts) != 1 || !slices.Equal(receiptList.Receipts[0].ReadBy, []string{"mallory"}) {
+		t.Errorf("alice got receipts %v, want #%d read by mallory", receiptList.Receipts, messageId)
+	}
+}
@@ -0,0 +1,468 @@
+package server
+
+import (
+	clock "Chitty-Chat/Clock"
+	proto "Chitty-Chat/GRPC"
+	"context"
+	"log"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+const replicationQueueLength = 1024
+const defaultReplicationHeartbeat = 500 * time.Millisecond
+const defaultFailoverTimeout = 3 * time.Second
+const defaultReplicationTimeout = 2 * time.Second
+
+// replication keeps backups in step with the primary. The primary streams
+// every broadcast and its Lamport time to the backups and only confirms a
+// message to its sender once every backup has applied it, dropping backups
+// that take longer than the ack timeout. Only the configured replicas may
+// follow the primary. A backup follows the first server ahead of it in the
+// failover order that is primary and promotes itself when it has heard from
+// none of them for a while.
+type replication struct {
+	replicaId         string
+	primaries         []string
+	replicas          map[string]bool
+	dialOptions       []grpc.DialOption
+	heartbeatInterval time.Duration
+	failoverTimeout   time.Duration
+	ackTimeout        time.Duration
+
+	isBackup   bool
+	backups    map[*backupLink]bool
+	ackChanged chan struct{}
+}
+
+type backupLink struct {
+	id      string
+	queue   chan *proto.ReplicationEntry
+	dropped chan struct{}
+	acked   int64
+}
+
+// replicationPeer is a server a backup follows, kept with the address it
+// was dialled at.
+type replicationPeer struct {
+	address string
+	client  proto.ReplicationServiceClient
+}
+
+func newReplication(config ServerConfig, replicaId string) *replication {
+	links := &replication{
+		replicaId:         replicaId,
+		primaries:         config.ReplicaOf,
+		replicas:          make(map[string]bool),
+		dialOptions:       config.ReplicaDialOptions,
+		heartbeatInterval: config.ReplicationHeartbeat,
+		failoverTimeout:   config.FailoverTimeout,
+		ackTimeout:        config.ReplicationTimeout,
+
+		isBackup:   len(config.ReplicaOf) > 0,
+		backups:    make(map[*backupLink]bool),
+		ackChanged: make(chan struct{}),
+	}
+	if links.heartbeatInterval == 0 {
+		links.heartbeatInterval = defaultReplicationHeartbeat
+	}
+	if links.failoverTimeout == 0 {
+		links.failoverTimeout = defaultFailoverTimeout
+	}
+	if links.ackTimeout == 0 {
+		links.ackTimeout = defaultReplicationTimeout
+	}
+	for _, replicaId := range config.Replicas {
+		links.replicas[replicaId] = true
+	}
+
+	return links
+}
+
+// isConfigured reports whether the server is part of a replica set, either
+// following a primary or with backups allowed to follow it.
+func (links *replication) isConfigured() bool {
+	return len(links.primaries) > 0 || len(links.replicas) > 0
+}
+
+type replicationService struct {
+	proto.UnimplementedReplicationServiceServer
+	server *ChatServer
+}
+
+func (service *replicationService) Replicate(stream proto.ReplicationService_ReplicateServer) error {
+	return service.server.serveBackup(stream)
+}
+
+// IsPrimary reports whether the server accepts clients, which backups only
+// do once they have been promoted and Raft followers not at all.
+func (server *ChatServer) IsPrimary() bool {
+	if server.consensus != nil {
+		return server.consensus.node.IsLeader()
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	return !server.replication.isBackup
+}
+
+// Backups returns the IDs of the backups following this server.
+func (server *ChatServer) Backups() []string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	var backupIds []string
+	for link := range server.replication.backups {
+		backupIds = append(backupIds, link.id)
+	}
+	sort.Strings(backupIds)
+
+	return backupIds
+}
+
+// rejectOnFollower turns clients away from backups and Raft followers.
+func (server *ChatServer) rejectOnFollower(fullMethod string) error {
+	if !strings.HasPrefix(fullMethod, "/ChatService/") {
+		return nil
+	}
+
+	raftErr := server.rejectOnRaftFollower()
+	if raftErr != nil {
+		return raftErr
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if server.replication.isBackup {
+		return status.Errorf(codes.Unavailable, "Server %s is a backup", server.replication.replicaId)
+	}
+
+	return nil
+}
+
+func (server *ChatServer) followerUnaryInterceptor(ctx context.Context, request any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	rejectErr := server.rejectOnFollower(info.FullMethod)
+	if rejectErr != nil {
+		return nil, rejectErr
+	}
+
+	return handler(ctx, request)
+}
+
+func (server *ChatServer) followerStreamInterceptor(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	rejectErr := server.rejectOnFollower(info.FullMethod)
+	if rejectErr != nil {
+		return rejectErr
+	}
+
+	return handler(srv, stream)
+}
+
+// stampReplication sets the server's Lamport time on an entry, in the int32
+// field as well for backups running an older version.
+func (server *ChatServer) stampReplication(entry *proto.ReplicationEntry) *proto.ReplicationEntry {
+	lamport := server.clock.Now().Lamport
+	entry.LamportTime, entry.Lamport = server.legacyTimestamp(lamport), lamport
+	return entry
+}
+
+// replicate hands a broadcast to every backup.
+func (server *ChatServer) replicate(entry *proto.ReplicationEntry) {
+	if len(server.replication.backups) == 0 {
+		return
+	}
+	server.stampReplication(entry)
+	entry.LastMessageId = server.lastMessageId
+
+	for link := range server.replication.backups {
+		select {
+		case link.queue <- entry:
+		default:
+			server.dropBackup(link, "queue_full")
+		}
+	}
+}
+
+// dropBackup stops streaming to a backup that fell behind. It reconnects
+// and catches up from the history on its own.
+func (server *ChatServer) dropBackup(link *backupLink, reason string) {
+	log.Printf("Backup %s fell behind, dropping it", link.id)
+	server.logEvent(slog.LevelWarn, "backup_dropped", slog.String("backup", link.id), slog.String("reason", reason))
+	server.removeBackup(link)
+	close(link.dropped)
+}
+
+func (server *ChatServer) removeBackup(link *backupLink) {
+	delete(server.replication.backups, link)
+	server.signalAcks()
+}
+
+func (server *ChatServer) signalAcks() {
+	close(server.replication.ackChanged)
+	server.replication.ackChanged = make(chan struct{})
+}
+
+func (server *ChatServer) replicatedUpTo(messageId int64) bool {
+	for link := range server.replication.backups {
+		if link.acked < messageId {
+			return false
+		}
+	}
+
+	return true
+}
+
+// awaitReplication waits until every backup has applied the message with
+// messageId, so that it survives the primary going away. Backups that have
+// not applied it within the ack timeout are dropped, so that one stuck
+// backup cannot hold up every sender.
+func (server *ChatServer) awaitReplication(ctx context.Context, messageId int64) {
+	timeout := time.After(server.replication.ackTimeout)
+	for {
+		server.mutex.Lock()
+		if server.replicatedUpTo(messageId) {
+			server.mutex.Unlock()
+			return
+		}
+		changed := server.replication.ackChanged
+		server.mutex.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return
+		case <-timeout:
+			server.mutex.Lock()
+			for link := range server.replication.backups {
+				if link.acked < messageId {
+					server.dropBackup(link, "ack_timeout")
+				}
+			}
+			server.mutex.Unlock()
+			return
+		}
+	}
+}
+
+// serveBackup sends a backup everything it is missing, then every new
+// broadcast and a heartbeat at every interval.
+func (server *ChatServer) serveBackup(stream proto.ReplicationService_ReplicateServer) error {
+	hello, helloErr := stream.Recv()
+	if helloErr != nil {
+		return helloErr
+	}
+
+	if !server.replication.replicas[hello.ReplicaId] {
+		server.logEvent(slog.LevelWarn, "backup_rejected", slog.String("backup", hello.ReplicaId), peerAttr(stream.Context()))
+		return status.Errorf(codes.PermissionDenied, "Server %s is not a configured replica", hello.ReplicaId)
+	}
+
+	server.mutex.Lock()
+	if server.replication.isBackup || server.shuttingDown {
+		server.mutex.Unlock()
+		return status.Errorf(codes.Unavailable, "Server %s is not the primary", server.replication.replicaId)
+	}
+
+	missed := server.history.since(hello.LastMessageId)
+	link := &backupLink{
+		id:      hello.ReplicaId,
+		queue:   make(chan *proto.ReplicationEntry, replicationQueueLength+len(missed)+1),
+		dropped: make(chan struct{}),
+		acked:   hello.LastMessageId,
+	}
+	knownUsers := make([]string, 0, len(server.mailbox.KnownUsers))
+	for username := range server.mailbox.KnownUsers {
+		knownUsers = append(knownUsers, username)
+	}
+	sort.Strings(knownUsers)
+	for _, message := range missed {
+		link.queue <- server.stampReplication(&proto.ReplicationEntry{LastMessageId: message.Id, Chat: message})
+	}
+	link.queue <- server.stampReplication(&proto.ReplicationEntry{LastMessageId: server.lastMessageId, KnownUsers: knownUsers})
+	server.replication.backups[link] = true
+	log.Printf("LT%d | Backup %s is following, sending %d missed message(s)", server.clock.Now().Lamport, link.id, len(missed))
+	server.logEvent(slog.LevelInfo, "backup_following", slog.String("backup", link.id), slog.Int("missed", len(missed)))
+	server.mutex.Unlock()
+
+	defer func() {
+		server.mutex.Lock()
+		if server.replication.backups[link] {
+			server.removeBackup(link)
+			log.Printf("LT%d | Backup %s stopped following", server.clock.Now().Lamport, link.id)
+		}
+		server.mutex.Unlock()
+	}()
+
+	ackErrs := make(chan error, 1)
+	go func() {
+		for {
+			ack, ackErr := stream.Recv()
+			if ackErr != nil {
+				ackErrs <- ackErr
+				return
+			}
+
+			server.mutex.Lock()
+			link.acked = max(link.acked, ack.LastMessageId)
+			server.signalAcks()
+			server.mutex.Unlock()
+		}
+	}()
+
+	ticker := time.NewTicker(server.replication.heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case entry := <-link.queue:
+			sendErr := stream.Send(entry)
+			if sendErr != nil {
+				return sendErr
+			}
+		case <-ticker.C:
+			server.mutex.Lock()
+			select {
+			case link.queue <- server.stampReplication(&proto.ReplicationEntry{LastMessageId: server.lastMessageId}):
+			default:
+			}
+			server.mutex.Unlock()
+		case <-link.dropped:
+			return status.Error(codes.ResourceExhausted, "Backup fell behind")
+		case ackErr := <-ackErrs:
+			return ackErr
+		case <-server.draining:
+			return nil
+		}
+	}
+}
+
+// applyReplicated copies an entry from the primary into this backup.
+func (server *ChatServer) applyReplicated(entry *proto.ReplicationEntry) int64 {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.mergeClock(clock.Time{Lamport: clock.Widen(entry.Lamport, entry.LamportTime)})
+	server.lastMessageId = max(server.lastMessageId, entry.LastMessageId)
+	if entry.Chat != nil {
+		server.history.append(entry.Chat)
+	}
+	for _, username := range entry.KnownUsers {
+		server.mailbox.rememberUser(username)
+	}
+
+	return server.lastMessageId
+}
+
+// followPrimary runs on a backup until it is promoted or shut down. The
+// further back a backup is in the failover order, the longer it waits, so
+// the first backup still running takes over and the others follow it.
+func (server *ChatServer) followPrimary() {
+	peers := make([]replicationPeer, 0, len(server.replication.primaries))
+	dialOptions := append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, server.replication.dialOptions...)
+	for _, address := range server.replication.primaries {
+		connection, dialErr := grpc.NewClient(address, dialOptions...)
+		if dialErr != nil {
+			log.Printf("Could not dial server %s | %v", address, dialErr)
+			continue
+		}
+		defer connection.Close()
+		peers = append(peers, replicationPeer{address: address, client: proto.NewReplicationServiceClient(connection)})
+	}
+
+	failoverAfter := server.replication.failoverTimeout * time.Duration(len(server.replication.primaries))
+	lastContact := time.Now()
+	for {
+		// After losing the primary, the remaining servers are tried in the
+		// same round, as one of them may have been promoted.
+		for _, primary := range peers {
+			if server.replicateFrom(primary.client, &lastContact) {
+				log.Printf("Lost the primary at %s", primary.address)
+			}
+		}
+
+		if time.Since(lastContact) >= failoverAfter {
+			server.promote()
+			return
+		}
+
+		select {
+		case <-server.draining:
+			return
+		case <-time.After(server.replication.heartbeatInterval):
+		}
+	}
+}
+
+// replicateFrom follows one server until the stream breaks or stays silent
+// for the failover timeout. It reports whether that server was primary.
+func (server *ChatServer) replicateFrom(replicationClient proto.ReplicationServiceClient, lastContact *time.Time) bool {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-server.draining:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	stream, streamErr := replicationClient.Replicate(ctx)
+	if streamErr != nil {
+		return false
+	}
+
+	server.mutex.Lock()
+	hello := &proto.ReplicaAck{ReplicaId: server.replication.replicaId, LastMessageId: server.lastMessageId}
+	server.mutex.Unlock()
+	helloErr := stream.Send(hello)
+	if helloErr != nil {
+		return false
+	}
+
+	watchdog := time.AfterFunc(server.replication.failoverTimeout, cancel)
+	defer watchdog.Stop()
+
+	followed := false
+	for {
+		entry, recvErr := stream.Recv()
+		if recvErr != nil {
+			return followed
+		}
+		watchdog.Reset(server.replication.failoverTimeout)
+		*lastContact = time.Now()
+		followed = true
+
+		appliedId := server.applyReplicated(entry)
+		ackErr := stream.Send(&proto.ReplicaAck{ReplicaId: server.replication.replicaId, LastMessageId: appliedId})
+		if ackErr != nil {
+			return followed
+		}
+	}
+}
+
+// promote makes a backup the primary. Message IDs jump ahead by the most
+// the old primary can have handed out without replicating them, so new
+// messages never reuse an ID clients may have seen from the old primary.
+func (server *ChatServer) promote() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if !server.replication.isBackup || server.shuttingDown {
+		return
+	}
+
+	server.replication.isBackup = false
+	server.lastMessageId += replicationQueueLength
+	server.clock.Tick()
+	log.Printf("LT%d | Lost the primary, promoted to primary", server.clock.Now().Lamport)
+	server.logEvent(slog.LevelWarn, "promoted")
+	server.updateHealth()
+}
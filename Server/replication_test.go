@@ -0,0 +1,90 @@
+package server
+
+import (
+	proto "Chitty-Chat/GRPC"
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// followAsBackup opens a replication stream as the backup replicaId without
+// ever acknowledging anything. It returns the error the primary ends the
+// stream with, or nil once the primary has sent the first entry.
+func followAsBackup(t *testing.T, service proto.ReplicationServiceClient, replicaId string) error {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	stream, streamErr := service.Replicate(ctx)
+	if streamErr != nil {
+		return streamErr
+	}
+	sendErr := stream.Send(&proto.ReplicaAck{ReplicaId: replicaId})
+	if sendErr != nil {
+		return sendErr
+	}
+
+	_, recvErr := stream.Recv()
+	return recvErr
+}
+
+func TestReplicationServiceNeedsConfiguration(t *testing.T) {
+	_, connection := startTestServer(t, ServerConfig{})
+
+	followErr := followAsBackup(t, proto.NewReplicationServiceClient(connection), "backup")
+	if status.Code(followErr) != codes.Unimplemented {
+		t.Errorf("Following a standalone server returned %v, want Unimplemented", followErr)
+	}
+}
+
+func TestReplicationRejectsUnknownBackups(t *testing.T) {
+	server, connection := startTestServer(t, ServerConfig{Replicas: []string{"backup"}})
+
+	followErr := followAsBackup(t, proto.NewReplicationServiceClient(connection), "intruder")
+	if status.Code(followErr) != codes.PermissionDenied {
+		t.Errorf("Following as an unknown backup returned %v, want PermissionDenied", followErr)
+	}
+	if backups := server.Backups(); len(backups) != 0 {
+		t.Errorf("Backups = %v, want none", backups)
+	}
+}
+
+func TestReplicationTimeoutDropsStuckBackup(t *testing.T) {
+	const replicationTimeout = 100 * time.Millisecond
+	server, connection := startTestServer(t, ServerConfig{Replicas: []string{"backup"}, ReplicationTimeout: replicationTimeout})
+
+	followErr := followAsBackup(t, proto.NewReplicationServiceClient(connection), "backup")
+	if followErr != nil {
+		t.Fatalf("Following as a configured backup failed | %v", followErr)
+	}
+	if backups := server.Backups(); len(backups) != 1 {
+		t.Fatalf("Backups = %v, want [backup]", backups)
+	}
+
+	stream := joinTestUser(t, connection, "alice")
+	started := time.Now()
+	_, broadcastErr := proto.NewChatServiceClient(connection).BroadcastMessage(context.Background(), &proto.Chat{Username: "alice", Message: "hello"})
+	if broadcastErr != nil {
+		t.Fatalf("Broadcasting with a stuck backup returned %v, want it confirmed after the timeout", broadcastErr)
+	}
+	if elapsed := time.Since(started); elapsed < replicationTimeout {
+		t.Errorf("Broadcast was confirmed after %v, before the replication timeout of %v", elapsed, replicationTimeout)
+	}
+	if backups := server.Backups(); len(backups) != 0 {
+		t.Errorf("Backups after the timeout = %v, want the stuck backup dropped", backups)
+	}
+
+	message, recvErr := stream.Recv()
+	if recvErr != nil || message.Message != "hello" {
+		t.Fatalf("alice received %v, %v, want her message", message, recvErr)
+	}
+
+	started = time.Now()
+	_, broadcastErr = proto.NewChatServiceClient(connection).BroadcastMessage(context.Background(), &proto.Chat{Username: "alice", Message: "again"})
+	if broadcastErr != nil || time.Since(started) >= replicationTimeout {
+		t.Errorf("Broadcasting without backups returned %v after %v, want it confirmed at once", broadcastErr, time.Since(started))
+	}
+}
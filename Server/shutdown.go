@@ -0,0 +1,112 @@
+package server
+
+import (
+	proto "Chitty-Chat/GRPC"
+	"fmt"
+	"log"
+	"log/slog"
+	"time"
+)
+
+// Shutdown tells every client that the server is going away, stops
+// accepting joins and messages, gives the per-client send queues until the
+// shutdown timeout to drain, persists state and finally stops gRPC.
+func (server *ChatServer) Shutdown() {
+	deadline := time.Now().Add(server.shutdownTimeout)
+
+	server.mutex.Lock()
+	if server.shuttingDown {
+		server.mutex.Unlock()
+		return
+	}
+	server.shuttingDown = true
+	server.health.Shutdown()
+	grpcServer := server.grpcServer
+
+	shutdownMessage := fmt.Sprintf("Server is shutting down at LT%d", server.clock.Now().Lamport)
+	log.Print(shutdownMessage)
+	server.logEvent(slog.LevelInfo, "shutdown")
+	shutdownNotice := &proto.Chat{
+		Username: "Server",
+		Message:  shutdownMessage,
+		Kind:     proto.ChatKind_SHUTDOWN,
+	}
+	if server.consensus == nil {
+		server.broadcastMessage(shutdownNotice)
+	}
+	server.mutex.Unlock()
+
+	// In a cluster the notice takes a message ID like any other broadcast,
+	// so it goes through the Raft log to keep the IDs equal on every server.
+	if server.consensus != nil {
+		server.commitNotice(shutdownNotice, deadline)
+	}
+
+	server.mutex.Lock()
+	close(server.draining)
+	server.mutex.Unlock()
+
+	if !waitUntil(server.activeStreams.Wait, deadline) {
+		log.Printf("Send queues did not drain within %v", server.shutdownTimeout)
+	}
+
+	server.mutex.Lock()
+	server.mailbox.persist()
+	server.moderation.persist()
+	log.Printf("LT%d | Persisted server state", server.clock.Now().Lamport)
+	server.logEvent(slog.LevelInfo, "state_persisted")
+	server.mutex.Unlock()
+
+	if grpcServer != nil && !waitUntil(grpcServer.GracefulStop, deadline) {
+		log.Print("Graceful stop timed out, closing remaining connections")
+		grpcServer.Stop()
+	}
+	server.stopConsensus()
+
+	if server.eventCloser != nil {
+		server.eventCloser.Close()
+	}
+}
+
+func waitUntil(wait func(), deadline time.Time) bool {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(time.Until(deadline)):
+		return false
+	}
+}
+
+// Kill stops the server abruptly, as if its process had died: connections
+// are dropped without telling clients and nothing is persisted.
+func (server *ChatServer) Kill() {
+	server.mutex.Lock()
+	grpcServer := server.grpcServer
+	if server.shuttingDown {
+		server.mutex.Unlock()
+		return
+	}
+	server.shuttingDown = true
+	server.mutex.Unlock()
+
+	if grpcServer != nil {
+		grpcServer.Stop()
+	}
+	server.stopConsensus()
+
+	server.mutex.Lock()
+	log.Printf("LT%d | Server was killed", server.clock.Now().Lamport)
+	server.logEvent(slog.LevelWarn, "killed")
+	close(server.draining)
+	server.mutex.Unlock()
+
+	if server.eventCloser != nil {
+		server.eventCloser.Close()
+	}
+}
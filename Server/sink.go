@@ -0,0 +1,38 @@
+package server
+
+import (
+	proto "Chitty-Chat/GRPC"
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Sink receives the messages for a client that is connected without a
+// gRPC stream. It is called with the server's mutex held, in the order the
+// server sends the messages, and must not call back into the server.
+type Sink func(message *proto.Chat)
+
+// Connect joins the chat like JoinChat, but hands every message for the
+// user to sink instead of streaming it. It returns the Lamport time the
+// server would have sent in the join header. Connect is meant for running
+// the server logic in process, e.g. in a simulation; such users leave with
+// LeaveChat.
+func (server *ChatServer) Connect(ctx context.Context, user *proto.UserRequest, sink Sink) (int64, error) {
+	sinkClient := newClient(user.Username, nil, server)
+	sinkClient.sink = sink
+
+	var headerTimestamp int64
+	joined, joinErr := server.join(ctx, user, sinkClient, func(lamportTime int64) error {
+		headerTimestamp = lamportTime
+		return nil
+	})
+	if joinErr != nil {
+		return 0, joinErr
+	}
+	if !joined {
+		return 0, status.Errorf(codes.AlreadyExists, "User %s has already joined", user.Username)
+	}
+
+	return headerTimestamp, nil
+}
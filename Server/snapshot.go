@@ -0,0 +1,178 @@
+package server
+
+import (
+	proto "Chitty-Chat/GRPC"
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"sort"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+const maxSnapshots = 16
+
+// snapshot is this server's part of a Chandy-Lamport snapshot: its state
+// when it first heard of the snapshot, and the events that arrive on each
+// link after that until the peer's marker does.
+type snapshot struct {
+	local     *proto.LocalSnapshot
+	recording map[*peerLink]bool
+}
+
+type snapshotService struct {
+	proto.UnimplementedSnapshotServiceServer
+	server *ChatServer
+}
+
+func (service *snapshotService) StartSnapshot(ctx context.Context, request *proto.SnapshotRequest) (*proto.LocalSnapshot, error) {
+	server := service.server
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	snapshotId := request.SnapshotId
+	if snapshotId == "" {
+		snapshotId = fmt.Sprintf("%s-%d", server.federation.serverID, time.Now().UnixNano())
+	}
+	if server.federation.snapshots[snapshotId] != nil {
+		return nil, status.Errorf(codes.AlreadyExists, "Snapshot %s was already taken", snapshotId)
+	}
+
+	server.recordSnapshot(snapshotId, nil)
+
+	return server.snapshotCopy(snapshotId), nil
+}
+
+func (service *snapshotService) GetSnapshot(ctx context.Context, request *proto.SnapshotRequest) (*proto.LocalSnapshot, error) {
+	server := service.server
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if server.federation.snapshots[request.SnapshotId] == nil {
+		return nil, status.Errorf(codes.NotFound, "Server %s has not recorded snapshot %s", server.federation.serverID, request.SnapshotId)
+	}
+
+	return server.snapshotCopy(request.SnapshotId), nil
+}
+
+func (server *ChatServer) snapshotCopy(snapshotId string) *proto.LocalSnapshot {
+	return protobuf.Clone(server.federation.snapshots[snapshotId].local).(*proto.LocalSnapshot)
+}
+
+// recordSnapshot records the server's state and the event counts of every
+// link, starts recording the links other than the one the marker came on
+// and sends the marker on all of them.
+func (server *ChatServer) recordSnapshot(snapshotId string, from *peerLink) {
+	links := server.federation
+	lamport := server.clock.Now().Lamport
+	local := &proto.LocalSnapshot{
+		SnapshotId:  snapshotId,
+		ServerId:    links.serverID,
+		LamportTime: server.legacyTimestamp(lamport),
+		Lamport:     lamport,
+		LocalUsers:  server.onlineUsernames(),
+		RemoteUsers: make(map[string]string),
+	}
+	for username, user := range links.remoteUsers {
+		local.RemoteUsers[username] = user.home
+	}
+
+	recorded := &snapshot{local: local, recording: make(map[*peerLink]bool)}
+	for linked := range links.peers {
+		channel := recorded.channel(linked.id)
+		channel.Sent += linked.sent
+		channel.Received += linked.received
+		if linked != from {
+			recorded.recording[linked] = true
+		}
+	}
+	sort.Slice(local.Channels, func(i int, j int) bool { return local.Channels[i].Peer < local.Channels[j].Peer })
+
+	links.snapshots[snapshotId] = recorded
+	links.snapshotOrder = append(links.snapshotOrder, snapshotId)
+	if len(links.snapshotOrder) > maxSnapshots {
+		delete(links.snapshots, links.snapshotOrder[0])
+		links.snapshotOrder = links.snapshotOrder[1:]
+	}
+
+	log.Printf("LT%d | Recorded state for snapshot %s", server.clock.Now().Lamport, snapshotId)
+	server.logEvent(slog.LevelInfo, "snapshot_recorded", slog.String("snapshot", snapshotId))
+
+	for linked := range links.peers {
+		server.sendToPeer(linked, &proto.FederationEvent{Kind: proto.FederationKind_SNAPSHOT_MARKER, Sender: links.serverID, SnapshotId: snapshotId})
+	}
+	server.completeSnapshot(recorded)
+}
+
+func (recorded *snapshot) channel(peerId string) *proto.ChannelState {
+	for _, channel := range recorded.local.Channels {
+		if channel.Peer == peerId {
+			return channel
+		}
+	}
+
+	channel := &proto.ChannelState{Peer: peerId}
+	recorded.local.Channels = append(recorded.local.Channels, channel)
+	return channel
+}
+
+func (server *ChatServer) completeSnapshot(recorded *snapshot) {
+	if len(recorded.recording) > 0 || recorded.local.Complete || recorded.local.Failed {
+		return
+	}
+
+	recorded.local.Complete = true
+	log.Printf("LT%d | Snapshot %s complete", server.clock.Now().Lamport, recorded.local.SnapshotId)
+	server.logEvent(slog.LevelInfo, "snapshot_complete", slog.String("snapshot", recorded.local.SnapshotId))
+}
+
+// receiveMarker records the server's state on the first marker of a
+// snapshot, and stops recording the link the marker came on.
+func (server *ChatServer) receiveMarker(from *peerLink, snapshotId string) {
+	recorded := server.federation.snapshots[snapshotId]
+	if recorded == nil {
+		server.recordSnapshot(snapshotId, from)
+		return
+	}
+
+	delete(recorded.recording, from)
+	server.completeSnapshot(recorded)
+}
+
+func (server *ChatServer) recordInFlight(from *peerLink, event *proto.FederationEvent) {
+	for _, recorded := range server.federation.snapshots {
+		if recorded.recording[from] {
+			channel := recorded.channel(from.id)
+			channel.InFlight = append(channel.InFlight, protobuf.Clone(event).(*proto.FederationEvent))
+		}
+	}
+}
+
+// sendPendingMarkers sends the marker of every snapshot still in progress
+// on a new link, so the peer does not wait for one that was never sent.
+func (server *ChatServer) sendPendingMarkers(linked *peerLink) {
+	for _, snapshotId := range server.federation.snapshotOrder {
+		local := server.federation.snapshots[snapshotId].local
+		if !local.Complete && !local.Failed {
+			server.sendToPeer(linked, &proto.FederationEvent{Kind: proto.FederationKind_SNAPSHOT_MARKER, Sender: server.federation.serverID, SnapshotId: snapshotId})
+		}
+	}
+}
+
+// stopRecording gives up on the markers of a link that went down. The
+// events on that link may not all have been recorded, so every snapshot
+// still recording it failed.
+func (server *ChatServer) stopRecording(linked *peerLink) {
+	for _, recorded := range server.federation.snapshots {
+		if recorded.recording[linked] {
+			log.Printf("Link to server %s went down during snapshot %s, the snapshot failed", linked.id, recorded.local.SnapshotId)
+			server.logEvent(slog.LevelWarn, "snapshot_failed", slog.String("snapshot", recorded.local.SnapshotId), slog.String("peer", linked.id))
+			delete(recorded.recording, linked)
+			recorded.local.Failed = true
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	proto "Chitty-Chat/GRPC"
+	"testing"
+)
+
+func TestSnapshotFailsWhenALinkGoesDown(t *testing.T) {
+	server := newTestServer(t, ServerConfig{DataDirectory: t.TempDir(), ServerID: "a"})
+	linked := &peerLink{id: "b", queue: make(chan *proto.FederationEvent, federationQueueLength)}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.federation.peers[linked] = true
+	server.recordSnapshot("snapshot", nil)
+	delete(server.federation.peers, linked)
+	server.stopRecording(linked)
+
+	local := server.federation.snapshots["snapshot"].local
+	if local.Complete || !local.Failed {
+		t.Errorf("Snapshot is complete: %v, failed: %v after the link went down, want it failed", local.Complete, local.Failed)
+	}
+}
+
+func TestMarkersAreNeverDropped(t *testing.T) {
+	server := newTestServer(t, ServerConfig{DataDirectory: t.TempDir(), ServerID: "a"})
+	linked := &peerLink{id: "b", queue: make(chan *proto.FederationEvent, 1)}
+	queued := &proto.FederationEvent{Kind: proto.FederationKind_RELAYED_CHAT}
+	marker := &proto.FederationEvent{Kind: proto.FederationKind_SNAPSHOT_MARKER, SnapshotId: "snapshot"}
+
+	server.mutex.Lock()
+	server.sendToPeer(linked, queued)
+	server.sendToPeer(linked, marker)
+	server.sendToPeer(linked, &proto.FederationEvent{Kind: proto.FederationKind_RELAYED_CHAT})
+	server.mutex.Unlock()
+
+	if markers := linked.takeMarkers(); len(markers) != 0 {
+		t.Fatalf("Marker was taken before the event queued ahead of it")
+	}
+	if event := <-linked.queue; event != queued {
+		t.Fatalf("Queue held %v, want the event queued first", event)
+	}
+	markers := linked.takeMarkers()
+	if len(markers) != 1 || markers[0] != marker {
+		t.Fatalf("Took %v once the queue was empty, want the marker", markers)
+	}
+	if linked.sent != 1 || len(linked.queue) != 0 {
+		t.Errorf("Link counted %d sent events with %d queued, want the event after the marker dropped", linked.sent, len(linked.queue))
+	}
+}
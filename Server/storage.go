@@ -0,0 +1,204 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// storage persists server state as one JSON document per name inside a
+// data directory. Documents are replaced atomically so that a crash while
+// saving never leaves a half-written file behind. State that only grows,
+// like the Raft log, is kept in journals instead, which are appended to one
+// JSON record per line. Without a directory the documents and journals are
+// only kept in memory. It is safe for concurrent use, which
+// the Raft log needs as it is saved outside the server's lock.
+type storage struct {
+	mutex     sync.Mutex
+	directory string
+	documents map[string][]byte
+}
+
+func newStorage(directory string) (*storage, error) {
+	if directory == "" {
+		return &storage{documents: make(map[string][]byte)}, nil
+	}
+
+	mkdirErr := os.MkdirAll(directory, 0o755)
+	if mkdirErr != nil {
+		return nil, mkdirErr
+	}
+
+	return &storage{directory: directory}, nil
+}
+
+func (store *storage) load(name string, value any) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.documents != nil {
+		data, exists := store.documents[name]
+		if !exists {
+			return nil
+		}
+		return json.Unmarshal(data, value)
+	}
+
+	data, readErr := os.ReadFile(filepath.Join(store.directory, name+".json"))
+	if errors.Is(readErr, fs.ErrNotExist) {
+		return nil
+	}
+	if readErr != nil {
+		return readErr
+	}
+
+	return json.Unmarshal(data, value)
+}
+
+func (store *storage) save(name string, value any) error {
+	data, marshalErr := json.MarshalIndent(value, "", "  ")
+	if marshalErr != nil {
+		return marshalErr
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.documents != nil {
+		store.documents[name] = data
+		return nil
+	}
+
+	temporaryFile, createErr := os.CreateTemp(store.directory, name+"-*.tmp")
+	if createErr != nil {
+		return createErr
+	}
+	defer os.Remove(temporaryFile.Name())
+
+	_, writeErr := temporaryFile.Write(data)
+	if writeErr != nil {
+		temporaryFile.Close()
+		return writeErr
+	}
+
+	syncErr := temporaryFile.Sync()
+	if syncErr != nil {
+		temporaryFile.Close()
+		return syncErr
+	}
+
+	closeErr := temporaryFile.Close()
+	if closeErr != nil {
+		return closeErr
+	}
+
+	return os.Rename(temporaryFile.Name(), filepath.Join(store.directory, name+".json"))
+}
+
+// appendRecords adds values to the end of the named journal and syncs it,
+// so that saving costs as much as the new records and not the journal.
+func (store *storage) appendRecords(name string, values ...any) error {
+	var data []byte
+	for _, value := range values {
+		record, marshalErr := json.Marshal(value)
+		if marshalErr != nil {
+			return marshalErr
+		}
+		data = append(append(data, record...), '\n')
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.documents != nil {
+		store.documents[name] = append(store.documents[name], data...)
+		return nil
+	}
+
+	journalFile, openErr := os.OpenFile(filepath.Join(store.directory, name+".jsonl"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if openErr != nil {
+		return openErr
+	}
+
+	_, writeErr := journalFile.Write(data)
+	if writeErr != nil {
+		journalFile.Close()
+		return writeErr
+	}
+
+	syncErr := journalFile.Sync()
+	if syncErr != nil {
+		journalFile.Close()
+		return syncErr
+	}
+
+	return journalFile.Close()
+}
+
+// loadRecords calls decode with every record of the named journal in the
+// order they were appended. A last record that a crash left half-written is
+// cut off, so that later records are appended after a whole one.
+func (store *storage) loadRecords(name string, decode func(record json.RawMessage) error) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.documents != nil {
+		return decodeRecords(store.documents[name], decode)
+	}
+
+	journalPath := filepath.Join(store.directory, name+".jsonl")
+	data, readErr := os.ReadFile(journalPath)
+	if errors.Is(readErr, fs.ErrNotExist) {
+		return nil
+	}
+	if readErr != nil {
+		return readErr
+	}
+
+	complete := bytes.LastIndexByte(data, '\n') + 1
+	if complete < len(data) {
+		truncateErr := os.Truncate(journalPath, int64(complete))
+		if truncateErr != nil {
+			return truncateErr
+		}
+	}
+
+	return decodeRecords(data[:complete], decode)
+}
+
+func decodeRecords(data []byte, decode func(record json.RawMessage) error) error {
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		decodeErr := decode(line)
+		if decodeErr != nil {
+			return decodeErr
+		}
+	}
+
+	return nil
+}
+
+// check verifies that the data directory can still be written to.
+func (store *storage) check() error {
+	if store.documents != nil {
+		return nil
+	}
+
+	probeFile, createErr := os.CreateTemp(store.directory, ".probe-*")
+	if createErr != nil {
+		return createErr
+	}
+
+	closeErr := probeFile.Close()
+	removeErr := os.Remove(probeFile.Name())
+
+	return errors.Join(closeErr, removeErr)
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const maxUsernameLength = 32
+
+// validateMessage applies the same rules to every gRPC client, counting the
+// length in Unicode code points rather than bytes.
+func (server *ChatServer) validateMessage(message string) error {
+	if !utf8.ValidString(message) {
+		return status.Error(codes.InvalidArgument, "Message is not valid UTF-8")
+	}
+
+	if strings.TrimSpace(message) == "" {
+		return status.Error(codes.InvalidArgument, "Message is empty")
+	}
+
+	for _, character := range message {
+		if unicode.IsControl(character) {
+			return status.Errorf(codes.InvalidArgument, "Message contains the control character %U", character)
+		}
+	}
+
+	length := utf8.RuneCountInString(message)
+	if server.maxMessageLength > 0 && length > server.maxMessageLength {
+		return status.Errorf(codes.InvalidArgument, "Message is too long (%d characters), limit is %d characters", length, server.maxMessageLength)
+	}
+
+	return nil
+}
+
+// validateUsername keeps usernames printable in every notice and usable in
+// commands: valid UTF-8 of at most maxUsernameLength code points, without
+// spaces or control characters, and not the name notices are sent under.
+func validateUsername(username string) error {
+	if !utf8.ValidString(username) {
+		return status.Error(codes.InvalidArgument, "Username is not valid UTF-8")
+	}
+
+	if username == "" {
+		return status.Error(codes.InvalidArgument, "Username is empty")
+	}
+
+	for _, character := range username {
+		if unicode.IsSpace(character) || unicode.IsControl(character) {
+			return status.Errorf(codes.InvalidArgument, "Username contains the character %U, spaces and control characters are not allowed", character)
+		}
+	}
+
+	length := utf8.RuneCountInString(username)
+	if length > maxUsernameLength {
+		return status.Errorf(codes.InvalidArgument, "Username is too long (%d characters), limit is %d characters", length, maxUsernameLength)
+	}
+
+	if strings.EqualFold(username, "Server") {
+		return status.Errorf(codes.InvalidArgument, "Username %s is reserved for server notices", username)
+	}
+
+	return nil
+}
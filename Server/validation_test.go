@@ -0,0 +1,70 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestValidateMessage(t *testing.T) {
+	server := newTestServer(t, ServerConfig{DataDirectory: t.TempDir(), MaxMessageLength: 5})
+
+	tests := []struct {
+		name    string
+		message string
+		valid   bool
+	}{
+		{"plain", "hello", true},
+		{"empty", "", false},
+		{"only spaces", "   ", false},
+		{"invalid UTF-8", "he\xffo", false},
+		{"newline", "hi\nyo", false},
+		{"escape", "\x1b[2J", false},
+		{"five runes in more bytes", "héllö", true},
+		{"six runes", "héllöo", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			validationErr := server.validateMessage(test.message)
+			if test.valid && validationErr != nil {
+				t.Errorf("Message %q was rejected | %v", test.message, validationErr)
+			}
+			if !test.valid && status.Code(validationErr) != codes.InvalidArgument {
+				t.Errorf("Message %q returned %v, want InvalidArgument", test.message, validationErr)
+			}
+		})
+	}
+}
+
+func TestValidateUsername(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		valid    bool
+	}{
+		{"plain", "alice", true},
+		{"non-ASCII", "zoë", true},
+		{"longest", strings.Repeat("ü", maxUsernameLength), true},
+		{"empty", "", false},
+		{"invalid UTF-8", "al\xffce", false},
+		{"space", "alice smith", false},
+		{"tab", "alice\tsmith", false},
+		{"control character", "alice\x07", false},
+		{"too long", strings.Repeat("a", maxUsernameLength+1), false},
+		{"server", "Server", false},
+		{"server in lower case", "server", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			validationErr := validateUsername(test.username)
+			if test.valid && validationErr != nil {
+				t.Errorf("Username %q was rejected | %v", test.username, validationErr)
+			}
+			if !test.valid && status.Code(validationErr) != codes.InvalidArgument {
+				t.Errorf("Username %q returned %v, want InvalidArgument", test.username, validationErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package simulation
+
+import (
+	trace "Chitty-Chat/Trace"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Rules checked by Result.Check on top of the ones of the trace package.
+const (
+	RuleClockCondition = "clock-condition"
+	RuleDeliveryOrder  = "delivery-order"
+	RuleDuplicate      = "duplicate-delivery"
+)
+
+// Check verifies the Lamport invariants of the trace package and, using
+// the vector clocks tracked by the simulator, that every event that
+// happened before another has a smaller Lamport time. Without reordering
+// every client must also receive the broadcasts in the order the server
+// sent them, and no client may receive a broadcast twice.
+func (result *Result) Check() []trace.Violation {
+	violations := result.Trace().Check(false)
+	violations = append(violations, result.checkClockCondition()...)
+	violations = append(violations, result.checkDeliveries()...)
+
+	return violations
+}
+
+// checkClockCondition uses that e happened before f exactly when f's
+// vector clock has seen e, i.e. counts at least as many events of e's
+// process as e's own vector clock does.
+func (result *Result) checkClockCondition() []trace.Violation {
+	var violations []trace.Violation
+
+	processIndex := make(map[string]int)
+	for index, process := range result.Processes {
+		processIndex[process] = index
+	}
+
+	for _, later := range result.Events {
+		var latest *Event
+		for _, earlier := range result.Events {
+			if earlier == later {
+				continue
+			}
+			index := processIndex[earlier.Process]
+			if earlier.Vector[index] > later.Vector[index] {
+				continue
+			}
+			if earlier.Lamport >= later.Lamport && (latest == nil || earlier.Lamport > latest.Lamport) {
+				latest = earlier
+			}
+		}
+
+		if latest != nil {
+			violations = append(violations, trace.Violation{
+				Rule: RuleClockCondition,
+				Message: fmt.Sprintf("%s at LT%d %v happened before %s at LT%d %v",
+					latest.Process, latest.Lamport, latest.Vector, later.Process, later.Lamport, later.Vector),
+				Events: []*trace.Event{&latest.Event, &later.Event},
+			})
+		}
+	}
+
+	return violations
+}
+
+func (result *Result) checkDeliveries() []trace.Violation {
+	var violations []trace.Violation
+
+	lastReceived := make(map[string]*Event)
+	received := make(map[string]bool)
+	for _, event := range result.Events {
+		if event.Kind != trace.Receive || event.Channel != trace.ChannelBroadcast {
+			continue
+		}
+
+		key := event.Process + " " + event.Ident
+		if received[key] {
+			violations = append(violations, trace.Violation{
+				Rule:    RuleDuplicate,
+				Message: fmt.Sprintf("%s received %s twice", event.Process, event.Ident),
+				Events:  []*trace.Event{&event.Event},
+			})
+		}
+		received[key] = true
+
+		previous := lastReceived[event.Process]
+		lastReceived[event.Process] = event
+		if result.Config.Reorder || previous == nil {
+			continue
+		}
+		if messageId(previous.Ident) > messageId(event.Ident) {
+			violations = append(violations, trace.Violation{
+				Rule:    RuleDeliveryOrder,
+				Message: fmt.Sprintf("%s received %s after %s", event.Process, event.Ident, previous.Ident),
+				Events:  []*trace.Event{&previous.Event, &event.Event},
+			})
+		}
+	}
+
+	return violations
+}
+
+func messageId(ident string) int64 {
+	id, _ := strconv.ParseInt(strings.TrimPrefix(ident, "id:"), 10, 64)
+	return id
+}
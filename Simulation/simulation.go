@@ -0,0 +1,395 @@
+// Package simulation runs the ChatServer logic and many virtual clients in a
+// single goroutine. A scheduler seeded with Config.Seed decides when
+// clients act and how long every message spends in transit, reorders and
+// drops messages, so the same seed always produces the same trace.
+package simulation
+
+import (
+	proto "Chitty-Chat/GRPC"
+	server "Chitty-Chat/Server"
+	trace "Chitty-Chat/Trace"
+	"container/heap"
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const serverProcess = "server"
+
+type Config struct {
+	Seed              uint64
+	Clients           int
+	MessagesPerClient int
+
+	// MaxDelay is the longest a message spends in transit and MaxThinkTime
+	// the longest a client waits between two actions, both in virtual
+	// ticks.
+	MaxDelay     int
+	MaxThinkTime int
+	// DropRate is the probability that a message the server streams to a
+	// client is lost.
+	DropRate float64
+	// Reorder lets messages on the same connection overtake each other.
+	// Without it every connection delivers in order, like a gRPC stream.
+	Reorder bool
+
+	Server server.ServerConfig
+}
+
+func (config Config) withDefaults() Config {
+	if config.Clients == 0 {
+		config.Clients = 3
+	}
+	if config.MessagesPerClient == 0 {
+		config.MessagesPerClient = 3
+	}
+	if config.MaxDelay == 0 {
+		config.MaxDelay = 10
+	}
+	if config.MaxThinkTime == 0 {
+		config.MaxThinkTime = 10
+	}
+
+	return config
+}
+
+// Event is one step of a process in the simulation, with the Lamport time
+// the process logged and the vector clock the simulator tracked for it.
+type Event struct {
+	trace.Event
+	Time   int
+	Vector []int
+}
+
+type Result struct {
+	Config    Config
+	Processes []string
+	Events    []*Event
+	// Dropped counts the messages lost in transit and Discarded those that
+	// arrived after their client had left, at the client or at the server.
+	Dropped   int
+	Discarded int
+}
+
+// String renders the trace with one line per event, in the order the
+// events happened.
+func (result *Result) String() string {
+	var builder strings.Builder
+	for _, event := range result.Events {
+		builder.WriteString(event.Source)
+		builder.WriteByte('\n')
+	}
+
+	return builder.String()
+}
+
+// Trace groups the events by process so that they can be checked and
+// drawn with the trace package.
+func (result *Result) Trace() *trace.Trace {
+	events := make(map[string][]*trace.Event)
+	for _, event := range result.Events {
+		events[event.Process] = append(events[event.Process], &event.Event)
+	}
+
+	return trace.Build(result.Processes, events)
+}
+
+type step struct {
+	time     int
+	sequence int
+	run      func()
+}
+
+type schedule []*step
+
+func (steps schedule) Len() int { return len(steps) }
+func (steps schedule) Less(i, j int) bool {
+	if steps[i].time != steps[j].time {
+		return steps[i].time < steps[j].time
+	}
+	return steps[i].sequence < steps[j].sequence
+}
+func (steps schedule) Swap(i, j int)   { steps[i], steps[j] = steps[j], steps[i] }
+func (steps *schedule) Push(value any) { *steps = append(*steps, value.(*step)) }
+func (steps *schedule) Pop() any {
+	old := *steps
+	last := old[len(old)-1]
+	*steps = old[:len(old)-1]
+	return last
+}
+
+type virtualClient struct {
+	index    int
+	username string
+	lamport  int64
+	vector   []int
+	left     bool
+	sent     int
+}
+
+type simulator struct {
+	config Config
+	random *rand.Rand
+	server *server.ChatServer
+
+	now         int
+	sequence    int
+	steps       schedule
+	lastArrival map[string]int
+	nextIdent   int
+
+	clients      []*virtualClient
+	serverVector []int
+	broadcasts   map[int64]*Event
+	outgoing     []outgoing
+
+	result *Result
+}
+
+type outgoing struct {
+	client  *virtualClient
+	message *proto.Chat
+}
+
+// Run simulates config.Clients clients that join, send
+// config.MessagesPerClient messages each and leave again.
+func Run(config Config) (*Result, error) {
+	config = config.withDefaults()
+
+	chatServer, serverErr := server.NewChatServer(config.Server)
+	if serverErr != nil {
+		return nil, serverErr
+	}
+
+	simulation := &simulator{
+		config:       config,
+		random:       rand.New(rand.NewPCG(config.Seed, config.Seed^0x9e3779b97f4a7c15)),
+		server:       chatServer,
+		lastArrival:  make(map[string]int),
+		serverVector: make([]int, config.Clients+1),
+		broadcasts:   make(map[int64]*Event),
+		result:       &Result{Config: config, Processes: []string{serverProcess}},
+	}
+
+	for i := 0; i < config.Clients; i++ {
+		virtual := &virtualClient{index: i + 1, username: fmt.Sprintf("user%d", i), vector: make([]int, config.Clients+1)}
+		simulation.clients = append(simulation.clients, virtual)
+		simulation.result.Processes = append(simulation.result.Processes, virtual.username)
+		simulation.after(simulation.thinkTime(), func() { simulation.join(virtual) })
+	}
+
+	for simulation.steps.Len() > 0 {
+		next := heap.Pop(&simulation.steps).(*step)
+		simulation.now = next.time
+		next.run()
+	}
+
+	return simulation.result, nil
+}
+
+func (simulation *simulator) after(delay int, run func()) {
+	simulation.sequence++
+	heap.Push(&simulation.steps, &step{time: simulation.now + delay, sequence: simulation.sequence, run: run})
+}
+
+// transmit schedules the arrival of a message on the connection from one
+// process to another, keeping the connection in order unless reordering
+// is enabled.
+func (simulation *simulator) transmit(from string, to string, arrive func()) {
+	arrival := simulation.now + 1 + simulation.random.IntN(simulation.config.MaxDelay)
+	link := from + "->" + to
+	if !simulation.config.Reorder {
+		arrival = max(arrival, simulation.lastArrival[link])
+		simulation.lastArrival[link] = arrival
+	}
+
+	simulation.after(arrival-simulation.now, arrive)
+}
+
+func (simulation *simulator) thinkTime() int {
+	return simulation.random.IntN(simulation.config.MaxThinkTime + 1)
+}
+
+func (simulation *simulator) ident() string {
+	simulation.nextIdent++
+	return fmt.Sprintf("sim:%d", simulation.nextIdent)
+}
+
+func (simulation *simulator) record(process string, lamport int64, vector []int, kind trace.Kind, channel string, ident string, label string) *Event {
+	event := &Event{
+		Event: trace.Event{
+			Process: process,
+			Lamport: lamport,
+			Kind:    kind,
+			Label:   label,
+			Channel: channel,
+			Ident:   ident,
+			File:    "simulation",
+			Line:    len(simulation.result.Events) + 1,
+		},
+		Time:   simulation.now,
+		Vector: append([]int(nil), vector...),
+	}
+	event.Source = fmt.Sprintf("t=%d %s LT%d %v %s %s", simulation.now, process, lamport, vector, kind, label)
+	simulation.result.Events = append(simulation.result.Events, event)
+
+	return event
+}
+
+func mergeVector(into []int, from []int) {
+	for i, count := range from {
+		into[i] = max(into[i], count)
+	}
+}
+
+func (virtual *virtualClient) tick() {
+	virtual.lamport++
+	virtual.vector[virtual.index]++
+}
+
+func (virtual *virtualClient) receive(lamport int64, vector []int) {
+	virtual.lamport = max(virtual.lamport, lamport) + 1
+	mergeVector(virtual.vector, vector)
+	virtual.vector[virtual.index]++
+}
+
+func (simulation *simulator) join(virtual *virtualClient) {
+	virtual.tick()
+	ident := simulation.ident()
+	simulation.record(virtual.username, virtual.lamport, virtual.vector, trace.Send, trace.ChannelJoin, ident, "join")
+
+	request := &proto.UserRequest{Username: virtual.username, Lamport: virtual.lamport}
+	vector := append([]int(nil), virtual.vector...)
+	simulation.transmit(virtual.username, serverProcess, func() {
+		simulation.serverReceive(vector)
+
+		headerTimestamp, joinErr := simulation.server.Connect(context.Background(), request, func(message *proto.Chat) {
+			simulation.outgoing = append(simulation.outgoing, outgoing{client: virtual, message: message})
+		})
+		if joinErr != nil {
+			simulation.record(serverProcess, simulation.server.LamportTime(), simulation.serverVector, trace.Receive, trace.ChannelJoin, ident, "reject join "+virtual.username)
+			return
+		}
+
+		// The header is sent as part of receiving the join, so it is not
+		// an event of its own on the server.
+		simulation.record(serverProcess, headerTimestamp, simulation.serverVector, trace.Receive, trace.ChannelJoin, ident, "join "+virtual.username)
+		headerVector := append([]int(nil), simulation.serverVector...)
+		simulation.transmit(serverProcess, virtual.username, func() {
+			virtual.receive(headerTimestamp, headerVector)
+			simulation.record(virtual.username, virtual.lamport, virtual.vector, trace.Receive, "header", "", "joined")
+			simulation.after(simulation.thinkTime(), func() { simulation.act(virtual) })
+		})
+
+		simulation.sendOutgoing()
+	})
+}
+
+// serverReceive accounts for a request arriving at the server before the
+// server handles it.
+func (simulation *simulator) serverReceive(vector []int) {
+	mergeVector(simulation.serverVector, vector)
+	simulation.serverVector[0]++
+}
+
+// act sends the client's next message, or leaves once it has sent all of
+// them.
+func (simulation *simulator) act(virtual *virtualClient) {
+	if virtual.sent == simulation.config.MessagesPerClient {
+		simulation.leave(virtual)
+		return
+	}
+
+	virtual.sent++
+	virtual.tick()
+	ident := simulation.ident()
+	text := fmt.Sprintf("message %d", virtual.sent)
+	simulation.record(virtual.username, virtual.lamport, virtual.vector, trace.Send, trace.ChannelMessage, ident, text)
+
+	chat := &proto.Chat{Username: virtual.username, Message: text, Lamport: virtual.lamport}
+	vector := append([]int(nil), virtual.vector...)
+	simulation.transmit(virtual.username, serverProcess, func() {
+		_, broadcastErr := simulation.server.BroadcastMessage(context.Background(), chat)
+		if status.Code(broadcastErr) == codes.FailedPrecondition {
+			// The message was overtaken by its client's leave, so the server
+			// turns it away without reading its clock.
+			simulation.result.Discarded++
+			return
+		}
+		simulation.serverReceive(vector)
+		simulation.recordServerReceive(trace.ChannelMessage, ident, fmt.Sprintf("%s from %s", text, virtual.username), broadcastErr)
+		simulation.sendOutgoing()
+	})
+
+	simulation.after(simulation.thinkTime(), func() { simulation.act(virtual) })
+}
+
+func (simulation *simulator) leave(virtual *virtualClient) {
+	virtual.tick()
+	virtual.left = true
+	ident := simulation.ident()
+	simulation.record(virtual.username, virtual.lamport, virtual.vector, trace.Send, trace.ChannelLeave, ident, "leave")
+
+	request := &proto.UserRequest{Username: virtual.username, Lamport: virtual.lamport}
+	vector := append([]int(nil), virtual.vector...)
+	simulation.transmit(virtual.username, serverProcess, func() {
+		simulation.serverReceive(vector)
+		_, leaveErr := simulation.server.LeaveChat(context.Background(), request)
+		simulation.recordServerReceive(trace.ChannelLeave, ident, "leave "+virtual.username, leaveErr)
+		simulation.sendOutgoing()
+	})
+}
+
+// recordServerReceive records the server receiving a request. The server
+// ticks its clock once when it receives a request and once more for every
+// broadcast, so the receive happened one tick before the first broadcast.
+func (simulation *simulator) recordServerReceive(channel string, ident string, label string, handlerErr error) {
+	lamport := simulation.server.LamportTime()
+	if len(simulation.outgoing) > 0 {
+		lamport = simulation.outgoing[0].message.Lamport - 1
+	}
+	if handlerErr != nil {
+		label = "reject " + label
+	}
+
+	simulation.record(serverProcess, lamport, simulation.serverVector, trace.Receive, channel, ident, label)
+}
+
+// sendOutgoing records the broadcasts the server made while handling a
+// request and puts every copy on its way to its client.
+func (simulation *simulator) sendOutgoing() {
+	pending := simulation.outgoing
+	simulation.outgoing = nil
+
+	for _, delivery := range pending {
+		message := delivery.message
+		sent, seen := simulation.broadcasts[message.Id]
+		if !seen {
+			simulation.serverVector[0]++
+			sent = simulation.record(serverProcess, message.Lamport, simulation.serverVector, trace.Send, trace.ChannelBroadcast,
+				fmt.Sprintf("id:%d", message.Id), fmt.Sprintf("#%d %s: %s", message.Id, message.Username, message.Message))
+			simulation.broadcasts[message.Id] = sent
+		}
+
+		virtual := delivery.client
+		if simulation.random.Float64() < simulation.config.DropRate {
+			simulation.result.Dropped++
+			continue
+		}
+
+		simulation.transmit(serverProcess, virtual.username, func() {
+			if virtual.left {
+				simulation.result.Discarded++
+				return
+			}
+
+			virtual.receive(message.Lamport, sent.Vector)
+			simulation.record(virtual.username, virtual.lamport, virtual.vector, trace.Receive, trace.ChannelBroadcast,
+				fmt.Sprintf("id:%d", message.Id), fmt.Sprintf("#%d %s: %s", message.Id, message.Username, message.Message))
+		})
+	}
+}
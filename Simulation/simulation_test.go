@@ -0,0 +1,122 @@
+package simulation
+
+import (
+	trace "Chitty-Chat/Trace"
+	"io"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func runSimulation(t *testing.T, config Config) *Result {
+	t.Helper()
+
+	result, runErr := Run(config)
+	if runErr != nil {
+		t.Fatalf("Simulation failed | %v", runErr)
+	}
+
+	return result
+}
+
+func TestSameSeedGivesSameTrace(t *testing.T) {
+	config := Config{Seed: 42, Clients: 5, MessagesPerClient: 4, DropRate: 0.1, Reorder: true}
+
+	first := runSimulation(t, config).String()
+	second := runSimulation(t, config).String()
+	if first != second {
+		t.Fatalf("Traces of the same seed differ:\n%s\n---\n%s", first, second)
+	}
+
+	config.Seed++
+	if runSimulation(t, config).String() == first {
+		t.Error("Traces of different seeds are identical")
+	}
+}
+
+func TestEveryClientTakesPart(t *testing.T) {
+	result := runSimulation(t, Config{Seed: 1, Clients: 4, MessagesPerClient: 2})
+
+	sent := make(map[string]int)
+	for _, event := range result.Events {
+		if event.Channel == trace.ChannelMessage && event.Kind == trace.Send {
+			sent[event.Process]++
+		}
+	}
+	for _, process := range result.Processes[1:] {
+		if sent[process] != 2 {
+			t.Errorf("%s sent %d messages, want 2", process, sent[process])
+		}
+	}
+}
+
+func TestInvariantsHoldForRandomRuns(t *testing.T) {
+	runs := 2000
+	if testing.Short() {
+		runs = 200
+	}
+
+	configs := map[string]Config{
+		"fifo":    {Clients: 4, MessagesPerClient: 3},
+		"reorder": {Clients: 4, MessagesPerClient: 3, Reorder: true},
+		"drops":   {Clients: 6, MessagesPerClient: 2, DropRate: 0.2, MaxDelay: 30},
+	}
+	for name, config := range configs {
+		t.Run(name, func(t *testing.T) {
+			for seed := uint64(1); seed <= uint64(runs); seed++ {
+				config.Seed = seed
+				violations := runSimulation(t, config).Check()
+				if len(violations) > 0 {
+					t.Fatalf("Seed %d broke %d invariant(s), first: %v", seed, len(violations), violations[0])
+				}
+			}
+		})
+	}
+}
+
+func TestCheckFindsBrokenClocks(t *testing.T) {
+	result := runSimulation(t, Config{Seed: 3})
+
+	for _, event := range result.Events {
+		if event.Process != serverProcess && event.Label == "joined" {
+			event.Lamport = 0
+			break
+		}
+	}
+
+	rules := make(map[string]bool)
+	for _, violation := range result.Check() {
+		rules[violation.Rule] = true
+	}
+	if !rules[RuleClockCondition] {
+		t.Errorf("Clock condition violation was not found, got %v", rules)
+	}
+}
+
+func TestCheckFindsOutOfOrderDeliveries(t *testing.T) {
+	result := runSimulation(t, Config{Seed: 3})
+
+	var receives []*Event
+	for _, event := range result.Events {
+		if event.Process == "user0" && event.Channel == trace.ChannelBroadcast {
+			receives = append(receives, event)
+		}
+	}
+	if len(receives) < 2 {
+		t.Fatalf("user0 only received %d broadcasts", len(receives))
+	}
+	receives[0].Ident, receives[1].Ident = receives[1].Ident, receives[0].Ident
+
+	found := false
+	for _, violation := range result.Check() {
+		found = found || violation.Rule == RuleDeliveryOrder
+	}
+	if !found {
+		t.Error("Out of order delivery was not found")
+	}
+}
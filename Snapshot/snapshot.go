@@ -0,0 +1,129 @@
+// Package snapshot takes Chandy-Lamport snapshots of federated Chitty-Chat
+// servers and checks that the recorded states form a consistent cut.
+package snapshot
+
+import (
+	clock "Chitty-Chat/Clock"
+	proto "Chitty-Chat/GRPC"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const pollInterval = 50 * time.Millisecond
+
+// Take starts a snapshot on the first server and waits until every server
+// has recorded its state and the markers of all its links have arrived.
+func Take(ctx context.Context, servers []proto.SnapshotServiceClient) (*proto.GlobalSnapshot, error) {
+	if len(servers) == 0 {
+		return nil, errors.New("no servers to snapshot")
+	}
+
+	initiated, startErr := servers[0].StartSnapshot(ctx, &proto.SnapshotRequest{})
+	if startErr != nil {
+		return nil, fmt.Errorf("starting snapshot: %w", startErr)
+	}
+
+	global := &proto.GlobalSnapshot{SnapshotId: initiated.SnapshotId}
+	for i, snapshotServer := range servers {
+		local, waitErr := waitForCompletion(ctx, snapshotServer, global.SnapshotId)
+		if waitErr != nil {
+			return nil, fmt.Errorf("waiting for server %d to complete the snapshot: %w", i, waitErr)
+		}
+		global.Servers = append(global.Servers, local)
+	}
+
+	return global, nil
+}
+
+// waitForCompletion polls a server until it has completed the snapshot, or
+// fails when the server gave up on it. A server that has not received a
+// marker yet does not know the snapshot.
+func waitForCompletion(ctx context.Context, snapshotServer proto.SnapshotServiceClient, snapshotId string) (*proto.LocalSnapshot, error) {
+	for {
+		local, getErr := snapshotServer.GetSnapshot(ctx, &proto.SnapshotRequest{SnapshotId: snapshotId})
+		if getErr != nil && status.Code(getErr) != codes.NotFound {
+			return nil, getErr
+		}
+		if getErr == nil && local.Failed {
+			return nil, fmt.Errorf("server %s lost a link before its marker arrived", local.ServerId)
+		}
+		if getErr == nil && local.Complete {
+			return local, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Verify checks that the snapshot is a consistent cut: on every channel,
+// what the sender had sent when it recorded its state equals what the
+// receiver had received when it recorded its own plus what it recorded as
+// in flight, and no event in flight was sent after the sender's cut.
+func Verify(global *proto.GlobalSnapshot) error {
+	var problems []error
+	channels := make(map[string]map[string]*proto.ChannelState)
+	clocks := make(map[string]int64)
+	for _, local := range global.Servers {
+		switch {
+		case local.Failed:
+			problems = append(problems, fmt.Errorf("server %s failed the snapshot, a link went down before its marker arrived", local.ServerId))
+		case !local.Complete:
+			problems = append(problems, fmt.Errorf("server %s did not complete the snapshot", local.ServerId))
+		}
+		clocks[local.ServerId] = clock.Widen(local.Lamport, local.LamportTime)
+		channels[local.ServerId] = make(map[string]*proto.ChannelState)
+		for _, channel := range local.Channels {
+			channels[local.ServerId][channel.Peer] = channel
+		}
+	}
+
+	for _, local := range global.Servers {
+		for _, incoming := range local.Channels {
+			sender, isSnapshotted := channels[incoming.Peer]
+			if !isSnapshotted {
+				continue
+			}
+			outgoing, isLinked := sender[local.ServerId]
+			if !isLinked {
+				problems = append(problems, fmt.Errorf("server %s recorded a link from %s that %s did not record", local.ServerId, incoming.Peer, incoming.Peer))
+				continue
+			}
+
+			accounted := incoming.Received + int64(len(incoming.InFlight))
+			if outgoing.Sent != accounted {
+				problems = append(problems, fmt.Errorf("server %s sent %d events to %s before its cut, but %s received %d before its cut and recorded %d in flight",
+					incoming.Peer, outgoing.Sent, local.ServerId, local.ServerId, incoming.Received, len(incoming.InFlight)))
+			}
+			for _, event := range incoming.InFlight {
+				sentAt := clock.Widen(event.Lamport, event.Timestamp)
+				if sentAt > clocks[incoming.Peer] {
+					problems = append(problems, fmt.Errorf("event in flight from %s to %s was sent at LT%d, after the sender's cut at LT%d",
+						incoming.Peer, local.ServerId, sentAt, clocks[incoming.Peer]))
+				}
+			}
+		}
+	}
+
+	return errors.Join(problems...)
+}
+
+// Write saves the snapshot as JSON.
+func Write(path string, global *proto.GlobalSnapshot) error {
+	encoded, encodeErr := protojson.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(global)
+	if encodeErr != nil {
+		return fmt.Errorf("encoding snapshot: %w", encodeErr)
+	}
+
+	return os.WriteFile(path, encoded, 0o644)
+}
@@ -0,0 +1,54 @@
+package snapshot
+
+import (
+	proto "Chitty-Chat/GRPC"
+	"strings"
+	"testing"
+)
+
+func twoServers(sent int64, received int64, inFlight ...*proto.FederationEvent) *proto.GlobalSnapshot {
+	return &proto.GlobalSnapshot{Servers: []*proto.LocalSnapshot{
+		{ServerId: "a", Complete: true, LamportTime: 10, Channels: []*proto.ChannelState{{Peer: "b", Sent: sent}}},
+		{ServerId: "b", Complete: true, LamportTime: 4, Channels: []*proto.ChannelState{{Peer: "a", Received: received, InFlight: inFlight}}},
+	}}
+}
+
+func failedOn(serverId string, global *proto.GlobalSnapshot) *proto.GlobalSnapshot {
+	for _, local := range global.Servers {
+		if local.ServerId == serverId {
+			local.Complete, local.Failed = false, true
+		}
+	}
+
+	return global
+}
+
+func TestVerifyAcceptsEventsInFlight(t *testing.T) {
+	global := twoServers(3, 2, &proto.FederationEvent{Timestamp: 9})
+
+	verifyErr := Verify(global)
+	if verifyErr != nil {
+		t.Fatalf("Consistent snapshot was rejected | %v", verifyErr)
+	}
+}
+
+func TestVerifyRejectsInconsistentCuts(t *testing.T) {
+	tests := map[string]struct {
+		global *proto.GlobalSnapshot
+		want   string
+	}{
+		"received before it was sent": {twoServers(1, 2), "a sent 1 events to b"},
+		"lost in flight":              {twoServers(3, 2), "a sent 3 events to b"},
+		"sent after the cut":          {twoServers(1, 0, &proto.FederationEvent{Timestamp: 11}), "after the sender's cut"},
+		"link went down":              {failedOn("b", twoServers(1, 1)), "server b failed the snapshot"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			verifyErr := Verify(test.global)
+			if verifyErr == nil || !strings.Contains(verifyErr.Error(), test.want) {
+				t.Fatalf("Verify returned %v, want an error containing %q", verifyErr, test.want)
+			}
+		})
+	}
+}
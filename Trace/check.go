@@ -0,0 +1,222 @@
+package trace
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Rules checked by Check.
+const (
+	RuleReceiveAfterSend = "receive-after-send"
+	RuleMonotonic        = "monotonic"
+	RuleCausality        = "causality"
+	RuleStrictReceive    = "strict-receive"
+)
+
+type Violation struct {
+	Rule    string
+	Message string
+	Events  []*Event
+}
+
+func (violation Violation) String() string {
+	return fmt.Sprintf("%s: %s", violation.Rule, violation.Message)
+}
+
+// Check verifies the Lamport clock invariants of the trace: a receive is
+// later than its send, every process's clock strictly increases at its
+// sends and receives and never goes back in between, and the Lamport order
+// agrees with the happened-before relation derived from process order and
+// the paired messages. In strict mode a receive must
+// also be exactly one later than the larger of the process's previous
+// event and the send, which only holds when every clock tick is logged.
+func (result *Trace) Check(strict bool) []Violation {
+	var violations []Violation
+	flagged := make(map[*Event]bool)
+
+	for _, process := range result.Processes {
+		events := result.Events[process]
+		for i := 1; i < len(events); i++ {
+			previous := events[i-1].Lamport
+			if events[i].Lamport < previous || (events[i].Lamport == previous && events[i].Kind != Local) {
+				violations = append(violations, Violation{
+					Rule:    RuleMonotonic,
+					Message: fmt.Sprintf("%s went from LT%d to LT%d", process, events[i-1].Lamport, events[i].Lamport),
+					Events:  []*Event{events[i-1], events[i]},
+				})
+				flagged[events[i]] = true
+			}
+		}
+	}
+
+	sends := make(map[*Event]*Event)
+	for _, message := range result.Messages {
+		sends[message.Receive] = message.Send
+		if message.Receive.Lamport <= message.Send.Lamport {
+			violations = append(violations, Violation{
+				Rule: RuleReceiveAfterSend,
+				Message: fmt.Sprintf("%s received at LT%d what %s sent at LT%d",
+					message.Receive.Process, message.Receive.Lamport, message.Send.Process, message.Send.Lamport),
+				Events: []*Event{message.Send, message.Receive},
+			})
+			flagged[message.Receive] = true
+		}
+	}
+
+	violations = append(violations, result.checkCausality(sends, flagged)...)
+
+	if strict {
+		violations = append(violations, result.checkStrictReceives(sends)...)
+	}
+
+	return violations
+}
+
+// checkCausality computes a vector clock for every event. Events whose
+// clock cannot be computed depend on themselves, which means the logs order
+// a receive before the send it depends on. For every other event it looks
+// for an event that happened before it but has a later Lamport time, or
+// the same time unless the event is a local one that did not tick the
+// clock, and skips events the direct checks already reported.
+func (result *Trace) checkCausality(sends map[*Event]*Event, flagged map[*Event]bool) []Violation {
+	var violations []Violation
+
+	processIndex := make(map[string]int)
+	for index, process := range result.Processes {
+		processIndex[process] = index
+	}
+
+	clocks := make(map[*Event][]int)
+	next := make([]int, len(result.Processes))
+	for progress := true; progress; {
+		progress = false
+		for index, process := range result.Processes {
+			events := result.Events[process]
+			for next[index] < len(events) {
+				event := events[next[index]]
+				send := sends[event]
+				if send != nil && clocks[send] == nil {
+					break
+				}
+
+				clock := make([]int, len(result.Processes))
+				if next[index] > 0 {
+					copy(clock, clocks[events[next[index]-1]])
+				}
+				if send != nil {
+					for i, count := range clocks[send] {
+						clock[i] = max(clock[i], count)
+					}
+				}
+				clock[index] = next[index] + 1
+				clocks[event] = clock
+
+				next[index]++
+				progress = true
+			}
+		}
+	}
+
+	for index, process := range result.Processes {
+		events := result.Events[process]
+		if next[index] < len(events) {
+			stuck := events[next[index]]
+			send := sends[stuck]
+			violations = append(violations, Violation{
+				Rule: RuleCausality,
+				Message: fmt.Sprintf("%s received at LT%d what %s sent at LT%d, but the send depends on the receive",
+					stuck.Process, stuck.Lamport, send.Process, send.Lamport),
+				Events: []*Event{send, stuck},
+			})
+		}
+	}
+
+	// latest[p][i] is the event with the largest Lamport time among the
+	// first i+1 events of process p.
+	latest := make([][]*Event, len(result.Processes))
+	for index, process := range result.Processes {
+		for i, event := range result.Events[process] {
+			if i == 0 || event.Lamport > latest[index][i-1].Lamport {
+				latest[index] = append(latest[index], event)
+			} else {
+				latest[index] = append(latest[index], latest[index][i-1])
+			}
+		}
+	}
+
+	for _, process := range result.Processes {
+		for _, event := range result.Events[process] {
+			clock := clocks[event]
+			if clock == nil || flagged[event] {
+				continue
+			}
+
+			var before *Event
+			for index, count := range clock {
+				if index == processIndex[process] {
+					count--
+				}
+				if count == 0 {
+					continue
+				}
+				candidate := latest[index][count-1]
+				if before == nil || candidate.Lamport > before.Lamport {
+					before = candidate
+				}
+			}
+
+			if before != nil && (before.Lamport > event.Lamport || (before.Lamport == event.Lamport && event.Kind != Local)) {
+				violations = append(violations, Violation{
+					Rule: RuleCausality,
+					Message: fmt.Sprintf("%s at LT%d happened before %s at LT%d",
+						before.Process, before.Lamport, event.Process, event.Lamport),
+					Events: []*Event{before, event},
+				})
+			}
+		}
+	}
+
+	return violations
+}
+
+func (result *Trace) checkStrictReceives(sends map[*Event]*Event) []Violation {
+	var violations []Violation
+
+	for _, process := range result.Processes {
+		events := result.Events[process]
+		for i, event := range events {
+			send := sends[event]
+			if send == nil {
+				continue
+			}
+
+			expected := send.Lamport + 1
+			if i > 0 {
+				expected = max(events[i-1].Lamport, send.Lamport) + 1
+			}
+			if event.Lamport > expected {
+				violations = append(violations, Violation{
+					Rule: RuleStrictReceive,
+					Message: fmt.Sprintf("%s received at LT%d what %s sent at LT%d, expected LT%d",
+						event.Process, event.Lamport, send.Process, send.Lamport, expected),
+					Events: []*Event{send, event},
+				})
+			}
+		}
+	}
+
+	return violations
+}
+
+// SortViolations orders violations by the file and line of their last
+// event, which is the one that broke the rule.
+func SortViolations(violations []Violation) {
+	sort.SliceStable(violations, func(i, j int) bool {
+		first := violations[i].Events[len(violations[i].Events)-1]
+		second := violations[j].Events[len(violations[j].Events)-1]
+		if first.File != second.File {
+			return first.File < second.File
+		}
+		return first.Line < second.Line
+	})
+}
@@ -0,0 +1,138 @@
+package trace
+
+import "testing"
+
+func event(lamport int64, kind Kind, channel string, ident string) *Event {
+	return &Event{Lamport: lamport, Kind: kind, Channel: channel, Ident: ident, From: "alice"}
+}
+
+func checkEvents(t *testing.T, strict bool, server []*Event, alice []*Event) []Violation {
+	t.Helper()
+
+	for _, serverEvent := range server {
+		serverEvent.Process = "server"
+	}
+	for _, aliceEvent := range alice {
+		aliceEvent.Process = "alice"
+	}
+
+	return Build([]string{"server", "alice"}, map[string][]*Event{"server": server, "alice": alice}).Check(strict)
+}
+
+func expectRules(t *testing.T, violations []Violation, rules ...string) {
+	t.Helper()
+
+	if len(violations) != len(rules) {
+		t.Fatalf("Got violations %v, want rules %v", violations, rules)
+	}
+	for i, rule := range rules {
+		if violations[i].Rule != rule {
+			t.Errorf("Violation %d is %v, want rule %s", i, violations[i], rule)
+		}
+	}
+}
+
+func TestCheckConsistentTrace(t *testing.T) {
+	violations := checkEvents(t, true,
+		[]*Event{
+			event(0, Local, "", ""),
+			event(2, Receive, ChannelJoin, "lt:1"),
+			event(3, Send, ChannelBroadcast, "id:1"),
+			event(3, Local, "", ""),
+		},
+		[]*Event{
+			event(1, Send, ChannelJoin, "lt:1"),
+			event(4, Receive, ChannelBroadcast, "id:1"),
+		})
+
+	expectRules(t, violations)
+}
+
+func TestCheckReceiveBeforeSend(t *testing.T) {
+	violations := checkEvents(t, false,
+		[]*Event{event(1, Receive, ChannelMessage, "lt:5")},
+		[]*Event{event(5, Send, ChannelMessage, "lt:5")})
+
+	expectRules(t, violations, RuleReceiveAfterSend)
+	if violations[0].Events[1].Lamport != 1 {
+		t.Errorf("Violation does not point at the receive: %v", violations[0].Events)
+	}
+}
+
+func TestCheckClockGoesBack(t *testing.T) {
+	violations := checkEvents(t, false,
+		[]*Event{event(3, Local, "", ""), event(2, Local, "", ""), event(2, Send, ChannelBroadcast, "id:1")},
+		nil)
+
+	expectRules(t, violations, RuleMonotonic, RuleMonotonic)
+}
+
+func TestCheckIndirectCausality(t *testing.T) {
+	// alice's second message is concurrent with the broadcast at LT9, so a
+	// smaller Lamport time is fine.
+	violations := checkEvents(t, false,
+		[]*Event{
+			event(8, Receive, ChannelMessage, "lt:1"),
+			event(9, Send, ChannelBroadcast, "id:1"),
+			event(10, Receive, ChannelMessage, "lt:4"),
+		},
+		[]*Event{
+			event(1, Send, ChannelMessage, "lt:1"),
+			event(3, Local, "", ""),
+			event(4, Send, ChannelMessage, "lt:4"),
+			event(10, Receive, ChannelBroadcast, "id:1"),
+		})
+
+	expectRules(t, violations)
+
+	// The broadcast happened before alice's local event at LT7 through the
+	// receive that already went wrong.
+	violations = checkEvents(t, false,
+		[]*Event{
+			event(2, Receive, ChannelMessage, "lt:1"),
+			event(9, Send, ChannelBroadcast, "id:1"),
+		},
+		[]*Event{
+			event(1, Send, ChannelMessage, "lt:1"),
+			event(5, Receive, ChannelBroadcast, "id:1"),
+			event(7, Local, "", ""),
+		})
+
+	expectRules(t, violations, RuleReceiveAfterSend, RuleCausality)
+	if violations[1].Events[1].Lamport != 7 {
+		t.Errorf("Causality violation points at %v, want the event at LT7", violations[1].Events[1])
+	}
+}
+
+func TestCheckReceiveLoggedBeforeItsCause(t *testing.T) {
+	violations := checkEvents(t, false,
+		[]*Event{
+			event(2, Receive, ChannelMessage, "lt:3"),
+			event(3, Send, ChannelBroadcast, "id:1"),
+		},
+		[]*Event{
+			event(4, Receive, ChannelBroadcast, "id:1"),
+			event(3, Send, ChannelMessage, "lt:3"),
+		})
+
+	var causality []Violation
+	for _, violation := range violations {
+		if violation.Rule == RuleCausality {
+			causality = append(causality, violation)
+		}
+	}
+	if len(causality) == 0 {
+		t.Fatalf("Cycle was not reported, got %v", violations)
+	}
+}
+
+func TestCheckStrictDoubleIncrement(t *testing.T) {
+	server := []*Event{
+		event(3, Send, ChannelBroadcast, "id:1"),
+		event(5, Receive, ChannelJoin, "lt:1"),
+	}
+	alice := []*Event{event(1, Send, ChannelJoin, "lt:1")}
+
+	expectRules(t, checkEvents(t, false, server, alice))
+	expectRules(t, checkEvents(t, true, server, alice), RuleStrictReceive)
+}
@@ -0,0 +1,217 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var logPrefixPattern = regexp.MustCompile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}(\.\d+)? `)
+var terminalControlPattern = regexp.MustCompile("\033\\[[0-9;]*m|\a")
+
+var (
+	serverStartedPattern   = regexp.MustCompile(`^LT(\d+) \| ChatService server has started$`)
+	serverBroadcastPattern = regexp.MustCompile(`^LT(\d+) \| Broadcasting: '(.*)'$`)
+	serverReceivedPattern  = regexp.MustCompile(`^LT(\d+) \| Message received$`)
+	serverJoinPattern      = regexp.MustCompile(`^User (\S+) join request received at LT(\d+)$`)
+	serverLeavePattern     = regexp.MustCompile(`^User (\S+) leave request received at LT(\d+)$`)
+
+	clientJoinPattern     = regexp.MustCompile(`^LT(\d+) \| Joining chat as (.+)$`)
+	clientSendPattern     = regexp.MustCompile(`^LT(\d+) \| Sending message$`)
+	clientLeavePattern    = regexp.MustCompile(`^LT(\d+) \| Successfully left the chat$`)
+	clientDirectPattern   = regexp.MustCompile(`^LT(\d+) \| \[DM (\S+) -> \S+\] (.*)$`)
+	clientReceivePattern  = regexp.MustCompile(`^LT(\d+) \| ([^:|]+): (.*)$`)
+	genericLamportPattern = regexp.MustCompile(`^LT(\d+) \| (.*)$`)
+)
+
+type jsonEvent struct {
+	Event       string `json:"event"`
+	Lamport     int64  `json:"lamport"`
+	User        string `json:"user"`
+	From        string `json:"from"`
+	MessageId   int64  `json:"message_id"`
+	SentLamport int64  `json:"sent_lamport"`
+}
+
+// Parse reads one process's log. Lines starting with "{" are read as JSON
+// events, everything else as the human-readable log output; lines without a
+// Lamport time are skipped.
+func Parse(reader io.Reader, process string, file string) ([]*Event, error) {
+	parser := &textParser{process: process, file: file}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var events []*Event
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+
+		var event *Event
+		var parseErr error
+		if strings.HasPrefix(line, "{") {
+			event, parseErr = parseJSONLine(line)
+			if parseErr != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNumber, parseErr)
+			}
+		} else {
+			event = parser.parseTextLine(line)
+		}
+
+		if event == nil {
+			continue
+		}
+		event.Process = process
+		event.File = file
+		event.Line = lineNumber
+		event.Source = line
+		events = append(events, event)
+	}
+
+	return events, scanner.Err()
+}
+
+func parseJSONLine(line string) (*Event, error) {
+	var logged jsonEvent
+	unmarshalErr := json.Unmarshal([]byte(line), &logged)
+	if unmarshalErr != nil {
+		return nil, unmarshalErr
+	}
+	if logged.Event == "" {
+		return nil, nil
+	}
+
+	event := &Event{Lamport: logged.Lamport, Label: logged.Event}
+	switch logged.Event {
+	case "join", "message_received", "leave", "acknowledge":
+		channel := map[string]string{"join": ChannelJoin, "message_received": ChannelMessage, "leave": ChannelLeave, "acknowledge": ChannelAcknowledge}[logged.Event]
+		event.Kind, event.Channel, event.From = Receive, channel, logged.User
+		event.Ident = lamportIdent(logged.SentLamport)
+		event.Label = fmt.Sprintf("recv %s %s", channel, logged.User)
+	case "join_sent", "message_sent", "leave_sent", "acknowledge_sent":
+		channel := strings.TrimSuffix(logged.Event, "_sent")
+		event.Kind, event.Channel, event.From = Send, channel, logged.User
+		event.Ident = lamportIdent(logged.Lamport)
+		event.Label = "send " + channel
+	case "broadcast":
+		event.Kind, event.Channel, event.From = Send, ChannelBroadcast, logged.User
+		event.Ident = messageIdent(logged.MessageId)
+		event.Label = fmt.Sprintf("bcast #%d", logged.MessageId)
+	case "chat_received":
+		event.Kind, event.Channel, event.From = Receive, ChannelBroadcast, logged.From
+		event.Ident = messageIdent(logged.MessageId)
+		event.Label = fmt.Sprintf("recv #%d", logged.MessageId)
+	}
+
+	return event, nil
+}
+
+func lamportIdent(lamport int64) string {
+	if lamport == 0 {
+		return ""
+	}
+
+	return "lt:" + strconv.FormatInt(lamport, 10)
+}
+
+func messageIdent(messageId int64) string {
+	if messageId == 0 {
+		return ""
+	}
+
+	return "id:" + strconv.FormatInt(messageId, 10)
+}
+
+func textIdent(from string, message string) string {
+	return "text:" + from + ": " + message
+}
+
+// textParser keeps the state needed to interpret log lines that only make
+// sense together with the lines around them.
+type textParser struct {
+	process         string
+	file            string
+	username        string
+	pendingReceive  *Event
+	isServerProcess bool
+}
+
+func (parser *textParser) parseTextLine(line string) *Event {
+	line = logPrefixPattern.ReplaceAllString(line, "")
+	line = terminalControlPattern.ReplaceAllString(line, "")
+
+	if match := serverStartedPattern.FindStringSubmatch(line); match != nil {
+		parser.isServerProcess = true
+		return &Event{Lamport: parseLamport(match[1]), Kind: Local, Label: "started"}
+	}
+	if match := serverJoinPattern.FindStringSubmatch(line); match != nil {
+		parser.isServerProcess = true
+		return &Event{Lamport: parseLamport(match[2]), Kind: Receive, Channel: ChannelJoin, From: match[1], Label: "recv join " + match[1]}
+	}
+	if match := serverLeavePattern.FindStringSubmatch(line); match != nil {
+		parser.isServerProcess = true
+		return &Event{Lamport: parseLamport(match[2]), Kind: Receive, Channel: ChannelLeave, From: match[1], Label: "recv leave " + match[1]}
+	}
+	if match := serverReceivedPattern.FindStringSubmatch(line); match != nil {
+		parser.isServerProcess = true
+		event := &Event{Lamport: parseLamport(match[1]), Kind: Receive, Channel: ChannelMessage, Label: "recv message"}
+		parser.pendingReceive = event
+		return event
+	}
+	if match := serverBroadcastPattern.FindStringSubmatch(line); match != nil {
+		parser.isServerProcess = true
+		return parser.serverBroadcast(parseLamport(match[1]), match[2])
+	}
+
+	if match := clientJoinPattern.FindStringSubmatch(line); match != nil {
+		parser.username = match[2]
+		return &Event{Lamport: parseLamport(match[1]), Kind: Send, Channel: ChannelJoin, From: parser.username, Label: "send join"}
+	}
+	if match := clientSendPattern.FindStringSubmatch(line); match != nil {
+		lamport := parseLamport(match[1])
+		return &Event{Lamport: lamport, Kind: Send, Channel: ChannelMessage, From: parser.username, Ident: lamportIdent(lamport), Label: "send message"}
+	}
+	if match := clientLeavePattern.FindStringSubmatch(line); match != nil {
+		lamport := parseLamport(match[1])
+		return &Event{Lamport: lamport, Kind: Send, Channel: ChannelLeave, From: parser.username, Ident: lamportIdent(lamport), Label: "send leave"}
+	}
+	if !parser.isServerProcess {
+		if match := clientDirectPattern.FindStringSubmatch(line); match != nil {
+			return &Event{Lamport: parseLamport(match[1]), Kind: Receive, Channel: ChannelBroadcast, From: match[2], Ident: textIdent(match[2], match[3]), Label: "recv DM " + match[2]}
+		}
+		if match := clientReceivePattern.FindStringSubmatch(line); match != nil {
+			return &Event{Lamport: parseLamport(match[1]), Kind: Receive, Channel: ChannelBroadcast, From: match[2], Ident: textIdent(match[2], match[3]), Label: "recv " + match[2]}
+		}
+	}
+
+	if match := genericLamportPattern.FindStringSubmatch(line); match != nil {
+		return &Event{Lamport: parseLamport(match[1]), Kind: Local, Label: match[2]}
+	}
+
+	return nil
+}
+
+// serverBroadcast creates the send event of a broadcast. A broadcast of a
+// user message right after "Message received" also tells us who sent the
+// message that was received.
+func (parser *textParser) serverBroadcast(lamport int64, content string) *Event {
+	from, message, _ := strings.Cut(content, ": ")
+
+	pending := parser.pendingReceive
+	parser.pendingReceive = nil
+	if pending != nil && pending.From == "" && from != "Server" && lamport == pending.Lamport+1 {
+		pending.From = from
+		pending.Label = "recv message " + from
+	}
+
+	return &Event{Lamport: lamport, Kind: Send, Channel: ChannelBroadcast, From: from, Ident: textIdent(from, message), Label: "bcast " + from}
+}
+
+func parseLamport(text string) int64 {
+	lamport, _ := strconv.ParseInt(text, 10, 64)
+	return lamport
+}
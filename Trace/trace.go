@@ -0,0 +1,208 @@
+// Package trace reads the logs written by Chitty-Chat servers and clients,
+// either the human-readable "LT%d | ..." lines or the JSON-lines event logs,
+// and reconstructs which events sent and received which messages.
+package trace
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type Kind int
+
+const (
+	Local Kind = iota
+	Send
+	Receive
+)
+
+func (kind Kind) String() string {
+	switch kind {
+	case Send:
+		return "send"
+	case Receive:
+		return "receive"
+	default:
+		return "local"
+	}
+}
+
+// Channels a message can travel on. Joins, messages, leaves and
+// acknowledgements go from a client to the server, broadcasts from the
+// server to its clients.
+const (
+	ChannelJoin        = "join"
+	ChannelMessage     = "message"
+	ChannelLeave       = "leave"
+	ChannelAcknowledge = "acknowledge"
+	ChannelBroadcast   = "broadcast"
+)
+
+type Event struct {
+	Process string
+	Index   int
+	Lamport int64
+	Kind    Kind
+	Label   string
+
+	// Channel, From and Ident describe the message a send or receive event
+	// belongs to. From is the username a client message originates from and
+	// Ident, when known, identifies the message exactly: the sender's
+	// Lamport time for client messages and the message ID or text for
+	// broadcasts.
+	Channel string
+	From    string
+	Ident   string
+
+	File   string
+	Line   int
+	Source string
+}
+
+func (event *Event) String() string {
+	return fmt.Sprintf("%s:%d: %s", event.File, event.Line, event.Source)
+}
+
+type Message struct {
+	Send    *Event
+	Receive *Event
+}
+
+type Trace struct {
+	Processes []string
+	Events    map[string][]*Event
+	Messages  []Message
+}
+
+// ReadFiles parses every log file, naming each process after the file unless
+// the argument has the form "name=path".
+func ReadFiles(arguments []string) (*Trace, error) {
+	processEvents := make(map[string][]*Event)
+	var processes []string
+
+	for _, argument := range arguments {
+		process, path, hasName := strings.Cut(argument, "=")
+		if !hasName {
+			path = argument
+			process = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		}
+
+		file, openErr := os.Open(path)
+		if openErr != nil {
+			return nil, openErr
+		}
+		events, parseErr := Parse(file, process, path)
+		file.Close()
+		if parseErr != nil {
+			return nil, fmt.Errorf("%s: %w", path, parseErr)
+		}
+
+		if _, seen := processEvents[process]; !seen {
+			processes = append(processes, process)
+		}
+		processEvents[process] = append(processEvents[process], events...)
+	}
+
+	return Build(processes, processEvents), nil
+}
+
+// Build pairs every receive event with the send event of the same message.
+// Receives that carry an Ident are matched exactly, the others with the
+// earliest send on the same channel that this process has not matched yet.
+// Receives without a matching send stay unpaired.
+func Build(processes []string, events map[string][]*Event) *Trace {
+	for _, process := range processes {
+		for index, event := range events[process] {
+			event.Index = index
+		}
+	}
+
+	var sends []*Event
+	for _, process := range processes {
+		for _, event := range events[process] {
+			if event.Kind == Send {
+				sends = append(sends, event)
+			}
+		}
+	}
+
+	result := &Trace{Processes: processes, Events: events}
+	matched := make(map[*Event]map[string]bool)
+	for _, process := range processes {
+		for _, receive := range events[process] {
+			if receive.Kind != Receive {
+				continue
+			}
+
+			send := findSend(sends, receive, matched)
+			if send == nil {
+				continue
+			}
+
+			if matched[send] == nil {
+				matched[send] = make(map[string]bool)
+			}
+			matched[send][receive.Process] = true
+			result.Messages = append(result.Messages, Message{Send: send, Receive: receive})
+		}
+	}
+
+	sort.SliceStable(result.Messages, func(i, j int) bool {
+		return result.Messages[i].Send.Lamport < result.Messages[j].Send.Lamport
+	})
+
+	return result
+}
+
+func findSend(sends []*Event, receive *Event, matched map[*Event]map[string]bool) *Event {
+	var fallback *Event
+	for _, send := range sends {
+		if !canCarry(send, receive, matched) {
+			continue
+		}
+
+		if receive.Ident != "" && send.Ident == receive.Ident {
+			return send
+		}
+		if fallback == nil && (receive.Ident == "" || send.Ident == "") {
+			fallback = send
+		}
+	}
+
+	return fallback
+}
+
+func canCarry(send *Event, receive *Event, matched map[*Event]map[string]bool) bool {
+	if send.Process == receive.Process || send.Channel != receive.Channel {
+		return false
+	}
+	if receive.From != "" && send.From != "" && send.From != receive.From {
+		return false
+	}
+	if matched[send][receive.Process] {
+		return false
+	}
+
+	// Only broadcasts reach more than one process.
+	return receive.Channel == ChannelBroadcast || len(matched[send]) == 0
+}
+
+// LamportTimes returns every distinct Lamport time in the trace in order.
+func (result *Trace) LamportTimes() []int64 {
+	seen := make(map[int64]bool)
+	var times []int64
+	for _, process := range result.Processes {
+		for _, event := range result.Events[process] {
+			if !seen[event.Lamport] {
+				seen[event.Lamport] = true
+				times = append(times, event.Lamport)
+			}
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+
+	return times
+}
@@ -0,0 +1,109 @@
+package trace
+
+import (
+	"strings"
+	"testing"
+)
+
+const serverTextLog = `2026/10/19 15:27:04 LT0 | ChatService server has started
+2026/10/19 15:27:04 User alice join request received at LT2
+2026/10/19 15:27:04 LT3 | Broadcasting: 'Server: User alice join request received at LT2'
+2026/10/19 15:27:05 LT15 | Message received
+2026/10/19 15:27:05 LT16 | Broadcasting: 'alice: hi @bob'
+2026/10/19 15:27:06 User alice leave request received at LT27
+`
+
+const clientTextLog = `2026/10/19 15:27:04 Please enter a username:
+2026/10/19 15:27:04 LT1 | Joining chat as alice
+2026/10/19 15:27:04 LT4 | Server: User alice join request received at LT2
+2026/10/19 15:27:05 LT13 | Sending message
+2026/10/19 15:27:05 ` + "\033[1;33m" + `LT17 | alice: hi @bob` + "\033[0m" + `
+2026/10/19 15:27:06 LT26 | Successfully left the chat
+`
+
+func buildTrace(t *testing.T, logs map[string]string, order ...string) *Trace {
+	t.Helper()
+
+	events := make(map[string][]*Event)
+	for _, process := range order {
+		parsed, parseErr := Parse(strings.NewReader(logs[process]), process, process+".log")
+		if parseErr != nil {
+			t.Fatalf("Parsing %s failed | %v", process, parseErr)
+		}
+		events[process] = parsed
+	}
+
+	return Build(order, events)
+}
+
+func messagePairs(result *Trace) []string {
+	var pairs []string
+	for _, message := range result.Messages {
+		pairs = append(pairs, message.Send.Label+"@"+message.Send.Process+" -> "+message.Receive.Label+"@"+message.Receive.Process)
+	}
+
+	return pairs
+}
+
+func TestTextLogs(t *testing.T) {
+	result := buildTrace(t, map[string]string{"server": serverTextLog, "alice": clientTextLog}, "server", "alice")
+
+	if got := len(result.Events["server"]); got != 6 {
+		t.Errorf("Server has %d events, want 6", got)
+	}
+	if got := len(result.Events["alice"]); got != 5 {
+		t.Errorf("Alice has %d events, want 5", got)
+	}
+
+	want := []string{
+		"send join@alice -> recv join alice@server",
+		"bcast Server@server -> recv Server@alice",
+		"send message@alice -> recv message alice@server",
+		"bcast alice@server -> recv alice@alice",
+		"send leave@alice -> recv leave alice@server",
+	}
+	got := messagePairs(result)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Messages:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestJSONLogs(t *testing.T) {
+	server := `{"time":"2026-10-19T15:27:04Z","level":"INFO","msg":"join","event":"join","lamport":2,"user":"alice","sent_lamport":1}
+{"time":"2026-10-19T15:27:04Z","level":"INFO","msg":"broadcast","event":"broadcast","lamport":3,"user":"Server","message_id":1}
+{"time":"2026-10-19T15:27:05Z","level":"INFO","msg":"message_received","event":"message_received","lamport":15,"user":"alice","sent_lamport":13}
+{"time":"2026-10-19T15:27:05Z","level":"INFO","msg":"broadcast","event":"broadcast","lamport":16,"user":"alice","message_id":2}
+`
+	client := `{"time":"2026-10-19T15:27:04Z","level":"INFO","msg":"join_sent","event":"join_sent","lamport":1,"user":"alice"}
+{"time":"2026-10-19T15:27:05Z","level":"INFO","msg":"message_sent","event":"message_sent","lamport":13,"user":"alice"}
+{"time":"2026-10-19T15:27:05Z","level":"INFO","msg":"chat_received","event":"chat_received","lamport":17,"user":"alice","message_id":2,"from":"alice"}
+{"time":"2026-10-19T15:27:05Z","level":"INFO","msg":"chat_received","event":"chat_received","lamport":18,"user":"alice","message_id":1,"from":"Server"}
+`
+	result := buildTrace(t, map[string]string{"server": server, "alice": client}, "server", "alice")
+
+	want := []string{
+		"send join@alice -> recv join alice@server",
+		"bcast #1@server -> recv #1@alice",
+		"send message@alice -> recv message alice@server",
+		"bcast #2@server -> recv #2@alice",
+	}
+	got := messagePairs(result)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Messages:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestLamportTimes(t *testing.T) {
+	result := buildTrace(t, map[string]string{"server": serverTextLog, "alice": clientTextLog}, "server", "alice")
+
+	got := result.LamportTimes()
+	want := []int64{0, 1, 2, 3, 4, 13, 15, 16, 17, 26, 27}
+	if len(got) != len(want) {
+		t.Fatalf("LamportTimes = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("LamportTimes = %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+// Command chitty-bench loads a Chitty-Chat server with virtual clients and
+// reports join latency, delivery latency percentiles, throughput and
+// dropped messages. Without -addr it benchmarks an in-process server with
+// rate and length limits turned off.
+//
+//	go run ./cmd/chitty-bench -clients 500 -rate 1000 -duration 30s
+//	go run ./cmd/chitty-bench -addr :5050 -clients 50 -rate 20
+package main
+
+import (
+	bench "Chitty-Chat/Bench"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+func main() {
+	config := bench.Config{}
+	flag.StringVar(&config.Address, "addr", "", "address of the server to load (starts an in-process server when empty)")
+	flag.IntVar(&config.Clients, "clients", 10, "number of virtual clients")
+	flag.Float64Var(&config.Rate, "rate", 100, "messages per second sent by all clients together")
+	flag.IntVar(&config.MessageSize, "size", 64, "length of each message in characters")
+	flag.DurationVar(&config.Duration, "duration", 10*time.Second, "how long the clients send messages")
+	flag.IntVar(&config.JoinConcurrency, "join-concurrency", 50, "number of clients joining at the same time")
+	flag.DurationVar(&config.DrainTimeout, "drain-timeout", 5*time.Second, "how long to wait for outstanding deliveries before counting them as dropped")
+	serverLog := flag.Bool("server-log", false, "show the in-process server's log output")
+	flag.Parse()
+
+	if !*serverLog {
+		log.SetOutput(io.Discard)
+	}
+
+	result, runErr := bench.Run(config)
+	if runErr != nil {
+		fmt.Fprintf(os.Stderr, "Could not run benchmark | %v\n", runErr)
+		os.Exit(1)
+	}
+	fmt.Print(result)
+}
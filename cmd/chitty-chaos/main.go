@@ -0,0 +1,60 @@
+// Command chitty-chaos shows or changes the faults a Chitty-Chat server
+// started with -chaos-control injects. Without any -chaos-* flags it prints
+// the current faults; otherwise it replaces them with the given ones.
+//
+//	go run ./cmd/chitty-chaos -chaos-latency 200ms -chaos-drop-rate 0.1
+package main
+
+import (
+	chaos "Chitty-Chat/Chaos"
+	proto "Chitty-Chat/GRPC"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func main() {
+	address := flag.String("addr", ":5050", "address of the server")
+	chaosConfig := chaos.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	changing := false
+	flag.Visit(func(visited *flag.Flag) {
+		if strings.HasPrefix(visited.Name, "chaos-") {
+			changing = true
+		}
+	})
+
+	connection, dialErr := grpc.NewClient(*address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if dialErr != nil {
+		log.Fatalf("Could not connect to %s | %v", *address, dialErr)
+	}
+	defer connection.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	chaosClient := proto.NewChaosServiceClient(connection)
+	var faults *proto.FaultConfig
+	var faultsErr error
+	if changing {
+		faults, faultsErr = chaosClient.SetFaults(ctx, chaos.ToProto(*chaosConfig))
+	} else {
+		faults, faultsErr = chaosClient.GetFaults(ctx, &proto.Empty{})
+	}
+	if faultsErr != nil {
+		log.Fatalf("Could not reach the chaos service | %v", faultsErr)
+	}
+
+	config, convertErr := chaos.FromProto(faults)
+	if convertErr != nil {
+		log.Fatalf("Server returned invalid faults | %v", convertErr)
+	}
+	fmt.Println(config)
+}
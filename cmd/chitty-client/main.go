@@ -0,0 +1,60 @@
+// Command chitty-client is the terminal client for the Chitty-Chat server on
+// port 5050 unless -servers says otherwise.
+package main
+
+import (
+	chaos "Chitty-Chat/Chaos"
+	client "Chitty-Chat/Client"
+	"flag"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+const port = ":5050"
+
+func main() {
+	config := client.ClientConfig{Address: port}
+	flag.BoolVar(&config.SendReadReceipts, "read-receipts", true, "let other users see when you have read their messages")
+	flag.BoolVar(&config.ShowSeen, "show-seen", false, "show who has seen your messages when they are read")
+	flag.BoolVar(&config.Reconnect, "reconnect", false, "rejoin the chat automatically when the server goes away")
+	flag.DurationVar(&config.HeartbeatInterval, "heartbeat-interval", 10*time.Second, "how often to tell the server the client is still alive (0 disables heartbeats)")
+	flag.DurationVar(&config.KeepaliveTime, "keepalive-time", 20*time.Second, "how long the connection may be idle before the client pings the server")
+	flag.BoolVar(&config.RingBell, "bell", true, "ring the terminal bell when someone mentions you")
+	flag.StringVar(&config.EventLogPath, "event-log", "", "file to append a JSON-lines event log to (disabled when empty)")
+	flag.StringVar(&config.Clock, "clock", "lamport", "clock that stamps messages and the time shown with them: lamport, vector or hlc")
+	servers := flag.String("servers", port, "comma-separated server addresses, tried in order when one cannot be reached")
+	chaosConfig := chaos.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	addresses := strings.Split(*servers, ",")
+	config.Address, config.FailoverAddresses = addresses[0], addresses[1:]
+
+	if chaosConfig.Enabled() {
+		injector, chaosErr := chaos.NewInjector(*chaosConfig)
+		if chaosErr != nil {
+			log.Fatalf("Invalid fault injection flags | %v", chaosErr)
+		}
+		config.DialOptions = append(config.DialOptions,
+			grpc.WithChainUnaryInterceptor(injector.UnaryClientInterceptor),
+			grpc.WithChainStreamInterceptor(injector.StreamClientInterceptor),
+		)
+	}
+
+	chatClient, clientErr := client.NewChatClient(config)
+	if clientErr != nil {
+		log.Fatalf("Could not start client | %v", clientErr)
+	}
+
+	runErr := chatClient.Run(os.Stdin)
+	closeErr := chatClient.Close()
+	if runErr != nil {
+		log.Fatalf("Chat client stopped | %v", runErr)
+	}
+	if closeErr != nil {
+		log.Fatalf("Could not close connection | %v", closeErr)
+	}
+}
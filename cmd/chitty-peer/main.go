@@ -0,0 +1,42 @@
+// Command chitty-peer chats without a server: it serves the PeerService on
+// -addr and gossips with the peers it learns about from -seeds.
+//
+//	go run ./cmd/chitty-peer -addr localhost:6060
+//	go run ./cmd/chitty-peer -addr localhost:6061 -seeds localhost:6060
+package main
+
+import (
+	peer "Chitty-Chat/Peer"
+	"flag"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	var config peer.PeerConfig
+	flag.StringVar(&config.Address, "addr", "localhost:6060", "address to listen on, which other peers must be able to reach")
+	flag.StringVar(&config.Username, "username", "", "username to chat as (asked for when empty)")
+	seeds := flag.String("seeds", "", "comma-separated addresses of peers to join through (starts a new chat when empty)")
+	flag.IntVar(&config.Fanout, "fanout", 3, "how many peers each new message is pushed to and how many are asked for missed messages each round")
+	flag.DurationVar(&config.GossipInterval, "gossip-interval", 200*time.Millisecond, "how often to exchange heartbeats and missed messages with other peers")
+	flag.DurationVar(&config.FailureTimeout, "failure-timeout", 5*time.Second, "how long a peer may stay silent before it is no longer gossiped with")
+	flag.IntVar(&config.HistoryLength, "history-length", 1000, "how many of the latest messages to keep for peers that join late")
+	flag.Parse()
+
+	if *seeds != "" {
+		config.Seeds = strings.Split(*seeds, ",")
+	}
+
+	chatPeer, peerErr := peer.NewPeer(config)
+	if peerErr != nil {
+		log.Fatalf("Could not start peer | %v", peerErr)
+	}
+
+	runErr := chatPeer.Run(os.Stdin)
+	chatPeer.Close()
+	if runErr != nil {
+		log.Fatalf("Chat peer stopped | %v", runErr)
+	}
+}
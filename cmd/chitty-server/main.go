@@ -0,0 +1,91 @@
+// Command chitty-server runs the Chitty-Chat server, on port 5050 unless
+// -addr says otherwise.
+package main
+
+import (
+	chaos "Chitty-Chat/Chaos"
+	server "Chitty-Chat/Server"
+	"flag"
+	"log"
+	"strings"
+	"time"
+)
+
+func main() {
+	config := server.ServerConfig{}
+	flag.StringVar(&config.ListenAddress, "addr", ":5050", "address to listen on")
+	flag.StringVar(&config.DataDirectory, "data-dir", "chitty-data", "directory where server state is persisted (kept in memory only when empty)")
+	flag.IntVar(&config.MailboxCapacity, "mailbox-size", 100, "maximum number of messages queued per offline user")
+	flag.DurationVar(&config.MailboxExpiry, "mailbox-expiry", 72*time.Hour, "how long queued messages are kept for offline users (0 keeps them forever)")
+	flag.StringVar(&config.Owner, "owner", "", "username of the chat owner")
+	moderators := flag.String("moderators", "", "comma-separated usernames with the moderator role")
+	flag.Float64Var(&config.MessageRate, "message-rate", 2, "messages per second each user and IP address may send on average (0 disables the limit)")
+	flag.IntVar(&config.MessageBurst, "message-burst", 10, "messages each user and IP address may send in a burst")
+	flag.Float64Var(&config.JoinRate, "join-rate", 0.2, "join attempts per second each user and IP address may make on average (0 disables the limit)")
+	flag.IntVar(&config.JoinBurst, "join-burst", 3, "join attempts each user and IP address may make in a burst")
+	flag.IntVar(&config.MaxMessageLength, "max-message-length", 128, "maximum message length in characters (0 disables the limit)")
+	flag.DurationVar(&config.SlowMode, "slow-mode", 0, "minimum interval between two posts of the same member (0 disables slow mode)")
+	flag.BoolVar(&config.FloorControl, "floor-control", false, "only let the user holding the floor post to the room, see /raise and /yield")
+	flag.DurationVar(&config.FloorGrantTimeout, "floor-grant-timeout", 10*time.Second, "how long a request for the floor waits for users to grant it before going ahead without them")
+	flag.StringVar(&config.Clock, "clock", "lamport", "clock that stamps messages: lamport, vector or hlc")
+	flag.DurationVar(&config.ShutdownTimeout, "shutdown-timeout", 5*time.Second, "how long to wait for clients to receive queued messages when shutting down")
+	flag.BoolVar(&config.EnableReflection, "reflection", false, "register the gRPC server reflection service")
+	flag.DurationVar(&config.HealthCheckInterval, "health-check-interval", 5*time.Second, "how often the storage is checked for the health service")
+	flag.DurationVar(&config.KeepaliveTime, "keepalive-time", 30*time.Second, "how long a connection may be idle before the server pings it")
+	flag.DurationVar(&config.KeepaliveTimeout, "keepalive-timeout", 10*time.Second, "how long the server waits for a keepalive ping to be answered")
+	flag.DurationVar(&config.AwayAfter, "away-after", 5*time.Minute, "mark users as away after this long without posting (0 disables)")
+	flag.DurationVar(&config.EvictAfter, "evict-after", 30*time.Second, "remove users whose heartbeats stop for this long (0 disables)")
+	flag.StringVar(&config.MetricsAddress, "metrics-addr", "", "address to serve Prometheus metrics on, e.g. :9090 (disabled when empty)")
+	flag.StringVar(&config.ServerID, "server-id", "", "name of this server in a federation, or its own address in a Raft cluster (federation is disabled when empty)")
+	peers := flag.String("peers", "", "comma-separated addresses of servers to federate with")
+	flag.StringVar(&config.FederationSecret, "federation-secret", "", "secret shared by federated servers; servers only accept links from peers with the same secret, and none without one")
+	flag.IntVar(&config.HistoryLength, "history-length", 1000, "number of recent messages kept for rejoining clients and new backups")
+	replicaOf := flag.String("replica-of", "", "comma-separated addresses of the servers ahead of this backup in the failover order, primary first (runs as primary when empty)")
+	replicas := flag.String("replicas", "", "comma-separated IDs of the backups allowed to follow this server, their -server-id or else their -addr")
+	flag.DurationVar(&config.ReplicationHeartbeat, "replication-heartbeat", 500*time.Millisecond, "how often the primary tells its backups it is alive")
+	flag.DurationVar(&config.FailoverTimeout, "failover-timeout", 3*time.Second, "how long a backup waits per server ahead of it before promoting itself")
+	flag.DurationVar(&config.ReplicationTimeout, "replication-timeout", 2*time.Second, "how long a message may take to reach a backup before the backup is dropped and the message confirmed without it")
+	raftPeers := flag.String("raft-peers", "", "comma-separated addresses of the other servers of a Raft cluster; -server-id must be this server's own address (Raft is disabled when empty)")
+	flag.DurationVar(&config.RaftElectionTimeout, "raft-election-timeout", 300*time.Millisecond, "how long a Raft follower waits for the leader before starting an election")
+	flag.DurationVar(&config.RaftHeartbeat, "raft-heartbeat", 50*time.Millisecond, "how often the Raft leader contacts its followers")
+	chaosConfig := chaos.RegisterFlags(flag.CommandLine)
+	flag.BoolVar(&config.EnableChaosControl, "chaos-control", false, "register the ChaosService so faults can be changed at runtime")
+	flag.StringVar(&config.EventLogPath, "event-log", "", "file to write the JSON-lines event log to, \"-\" for stdout (disabled when empty)")
+	flag.StringVar(&config.EventLogLevel, "event-log-level", "info", "minimum level of logged events: debug, info, warn or error")
+	flag.Int64Var(&config.EventLogMaxSize, "event-log-max-size", 10<<20, "size in bytes after which the event log is rotated (0 disables rotation)")
+	flag.IntVar(&config.EventLogBackups, "event-log-backups", 3, "number of rotated event log files to keep")
+	flag.Parse()
+
+	if *moderators != "" {
+		config.Moderators = strings.Split(*moderators, ",")
+	}
+	if *peers != "" {
+		config.Peers = strings.Split(*peers, ",")
+	}
+	if *replicaOf != "" {
+		config.ReplicaOf = strings.Split(*replicaOf, ",")
+	}
+	if *replicas != "" {
+		config.Replicas = strings.Split(*replicas, ",")
+	}
+	if *raftPeers != "" {
+		config.RaftPeers = strings.Split(*raftPeers, ",")
+	}
+	if chaosConfig.Enabled() || config.EnableChaosControl {
+		injector, chaosErr := chaos.NewInjector(*chaosConfig)
+		if chaosErr != nil {
+			log.Fatalf("Invalid fault injection flags | %v", chaosErr)
+		}
+		config.Chaos = injector
+	}
+
+	chatServer, serverErr := server.NewChatServer(config)
+	if serverErr != nil {
+		log.Fatalf("Failed to create server | %v", serverErr)
+	}
+
+	startErr := chatServer.StartServer()
+	if startErr != nil {
+		log.Fatalf("Failed to run server | %v", startErr)
+	}
+}
@@ -0,0 +1,65 @@
+// Command chitty-sim runs the chat server and virtual clients in a
+// deterministic simulation. With -runs 1 it prints the trace of one seed;
+// with more runs it checks the Lamport and vector clock invariants for
+// consecutive seeds and reports the seeds that break them.
+//
+//	go run ./cmd/chitty-sim -seed 7 -clients 4 -drop 0.1
+//	go run ./cmd/chitty-sim -runs 5000 -reorder
+package main
+
+import (
+	simulation "Chitty-Chat/Simulation"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+func main() {
+	config := simulation.Config{}
+	flag.Uint64Var(&config.Seed, "seed", 1, "seed of the first run")
+	runs := flag.Int("runs", 1, "number of runs, each with the next seed")
+	flag.IntVar(&config.Clients, "clients", 3, "number of virtual clients")
+	flag.IntVar(&config.MessagesPerClient, "messages", 3, "messages each client sends before leaving")
+	flag.IntVar(&config.MaxDelay, "max-delay", 10, "longest time a message spends in transit, in virtual ticks")
+	flag.IntVar(&config.MaxThinkTime, "max-think", 10, "longest time a client waits between two actions, in virtual ticks")
+	flag.Float64Var(&config.DropRate, "drop", 0, "probability that a message streamed to a client is lost")
+	flag.BoolVar(&config.Reorder, "reorder", false, "let messages on the same connection overtake each other")
+	serverLog := flag.Bool("server-log", false, "show the server's log output")
+	flag.Parse()
+
+	if !*serverLog {
+		log.SetOutput(io.Discard)
+	}
+
+	failedRuns := 0
+	for run := 0; run < *runs; run++ {
+		result, runErr := simulation.Run(config)
+		if runErr != nil {
+			fmt.Fprintf(os.Stderr, "Could not run simulation | %v\n", runErr)
+			os.Exit(2)
+		}
+
+		if *runs == 1 {
+			fmt.Print(result)
+			fmt.Printf("Dropped %d and discarded %d message(s)\n", result.Dropped, result.Discarded)
+		}
+
+		violations := result.Check()
+		if len(violations) > 0 {
+			failedRuns++
+			fmt.Printf("Seed %d: %d violation(s)\n", config.Seed, len(violations))
+			for _, violation := range violations {
+				fmt.Printf("\t%s\n", violation)
+			}
+		}
+
+		config.Seed++
+	}
+
+	fmt.Printf("%d of %d run(s) broke an invariant\n", failedRuns, *runs)
+	if failedRuns > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,68 @@
+// Command chitty-snapshot takes a Chandy-Lamport snapshot of federated
+// Chitty-Chat servers, writes it to a JSON file and checks that it is a
+// consistent cut. The first server starts the snapshot. It exits with
+// status 1 when the snapshot is not consistent.
+//
+//	go run ./cmd/chitty-snapshot -servers localhost:5050,localhost:5051 -out snapshot.json
+package main
+
+import (
+	clock "Chitty-Chat/Clock"
+	proto "Chitty-Chat/GRPC"
+	snapshot "Chitty-Chat/Snapshot"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func main() {
+	servers := flag.String("servers", "localhost:5050", "comma-separated addresses of the federated servers to snapshot")
+	out := flag.String("out", "snapshot.json", "file to write the snapshot to")
+	timeout := flag.Duration("timeout", 10*time.Second, "how long to wait for every server to complete the snapshot")
+	flag.Parse()
+
+	var clients []proto.SnapshotServiceClient
+	for _, address := range strings.Split(*servers, ",") {
+		connection, dialErr := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if dialErr != nil {
+			log.Fatalf("Could not dial %s | %v", address, dialErr)
+		}
+		defer connection.Close()
+		clients = append(clients, proto.NewSnapshotServiceClient(connection))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	global, takeErr := snapshot.Take(ctx, clients)
+	if takeErr != nil {
+		log.Fatalf("Could not take snapshot | %v", takeErr)
+	}
+
+	writeErr := snapshot.Write(*out, global)
+	if writeErr != nil {
+		log.Fatalf("Could not write snapshot | %v", writeErr)
+	}
+
+	for _, local := range global.Servers {
+		inFlight := 0
+		for _, channel := range local.Channels {
+			inFlight += len(channel.InFlight)
+		}
+		fmt.Printf("%s at LT%d: %d local users, %d remote users, %d events in flight to it\n",
+			local.ServerId, clock.Widen(local.Lamport, local.LamportTime), len(local.LocalUsers), len(local.RemoteUsers), inFlight)
+	}
+
+	verifyErr := snapshot.Verify(global)
+	if verifyErr != nil {
+		fmt.Printf("Snapshot %s written to %s is not a consistent cut:\n%v\n", global.SnapshotId, *out, verifyErr)
+		os.Exit(1)
+	}
+	fmt.Printf("Snapshot %s written to %s is a consistent cut\n", global.SnapshotId, *out)
+}
@@ -0,0 +1,53 @@
+// Command lamportcheck verifies the Lamport clock invariants in Chitty-Chat
+// server and client logs and prints every violation with the log lines
+// involved. It exits with status 1 when it finds violations.
+//
+//	go run ./cmd/lamportcheck -strict server.jsonl alice.jsonl bob.jsonl
+package main
+
+import (
+	trace "Chitty-Chat/Trace"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+func main() {
+	strict := flag.Bool("strict", false, "also require every receive to be exactly one later than max(previous event, send)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-strict] [name=]logfile...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	logs, readErr := trace.ReadFiles(flag.Args())
+	if readErr != nil {
+		log.Fatalf("Could not read logs | %v", readErr)
+	}
+
+	violations := logs.Check(*strict)
+	trace.SortViolations(violations)
+	for _, violation := range violations {
+		fmt.Println(violation)
+		for _, event := range violation.Events {
+			fmt.Printf("\t%s\n", event)
+		}
+	}
+
+	eventCount := 0
+	for _, process := range logs.Processes {
+		eventCount += len(logs.Events[process])
+	}
+	fmt.Printf("Checked %d events and %d messages across %d processes: %d violation(s)\n",
+		eventCount, len(logs.Messages), len(logs.Processes), len(violations))
+
+	if len(violations) > 0 {
+		os.Exit(1)
+	}
+}
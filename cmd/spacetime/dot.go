@@ -0,0 +1,86 @@
+package main
+
+import (
+	trace "Chitty-Chat/Trace"
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// writeDOT lays the diagram out left to right with one cluster per process.
+// Events with the same Lamport time share a rank, so the horizontal position
+// of an event is its Lamport time.
+func writeDOT(writer io.Writer, diagram *trace.Trace) error {
+	output := bufio.NewWriter(writer)
+
+	fmt.Fprintln(output, "digraph spacetime {")
+	fmt.Fprintln(output, "  rankdir=LR;")
+	fmt.Fprintln(output, "  newrank=true;")
+	fmt.Fprintln(output, "  node [shape=circle, width=0.3, fixedsize=true, fontsize=9];")
+
+	for laneIndex, process := range diagram.Processes {
+		fmt.Fprintf(output, "  subgraph cluster_%d {\n", laneIndex)
+		fmt.Fprintf(output, "    label=%s;\n", strconv.Quote(process))
+		fmt.Fprintln(output, "    style=dashed;")
+
+		events := diagram.Events[process]
+		for _, event := range events {
+			fmt.Fprintf(output, "    %s [label=%s, xlabel=%s, tooltip=%s, style=filled, fillcolor=%s];\n",
+				nodeName(event), strconv.Quote(strconv.FormatInt(event.Lamport, 10)), strconv.Quote(event.Label),
+				strconv.Quote(event.Source), kindColor(event.Kind))
+		}
+		for i := 1; i < len(events); i++ {
+			fmt.Fprintf(output, "    %s -> %s [color=gray, arrowhead=none, weight=10];\n", nodeName(events[i-1]), nodeName(events[i]))
+		}
+		fmt.Fprintln(output, "  }")
+	}
+
+	ranks := make(map[int64][]*trace.Event)
+	for _, process := range diagram.Processes {
+		for _, event := range diagram.Events[process] {
+			ranks[event.Lamport] = append(ranks[event.Lamport], event)
+		}
+	}
+	for _, lamport := range diagram.LamportTimes() {
+		fmt.Fprint(output, "  { rank=same;")
+		for _, event := range ranks[lamport] {
+			fmt.Fprintf(output, " %s;", nodeName(event))
+		}
+		fmt.Fprintln(output, " }")
+	}
+
+	for _, message := range diagram.Messages {
+		fmt.Fprintf(output, "  %s -> %s [color=%s, constraint=false];\n",
+			nodeName(message.Send), nodeName(message.Receive), messageColor(message))
+	}
+
+	fmt.Fprintln(output, "}")
+
+	return output.Flush()
+}
+
+func nodeName(event *trace.Event) string {
+	return strconv.Quote(fmt.Sprintf("%s#%d", event.Process, event.Index))
+}
+
+func kindColor(kind trace.Kind) string {
+	switch kind {
+	case trace.Send:
+		return "\"#9ecae1\""
+	case trace.Receive:
+		return "\"#a1d99b\""
+	default:
+		return "\"#f0f0f0\""
+	}
+}
+
+// messageColor marks messages that arrive no later than they were sent in
+// Lamport time, as those violate the clock condition.
+func messageColor(message trace.Message) string {
+	if message.Receive.Lamport <= message.Send.Lamport {
+		return "red"
+	}
+
+	return "black"
+}
@@ -0,0 +1,66 @@
+// Command spacetime draws a space-time diagram from Chitty-Chat server and
+// client logs, with one lane per process, an arrow per message and the
+// Lamport time of every event.
+//
+//	go run ./cmd/spacetime -dot run.dot -svg run.svg server.log alice.log bob=bob-events.jsonl
+package main
+
+import (
+	trace "Chitty-Chat/Trace"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+func main() {
+	dotPath := flag.String("dot", "", "file to write the Graphviz DOT diagram to (\"-\" for stdout)")
+	svgPath := flag.String("svg", "", "file to write the SVG diagram to (\"-\" for stdout)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-dot file] [-svg file] [name=]logfile...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *dotPath == "" && *svgPath == "" {
+		*dotPath = "-"
+	}
+
+	diagram, readErr := trace.ReadFiles(flag.Args())
+	if readErr != nil {
+		log.Fatalf("Could not read logs | %v", readErr)
+	}
+
+	writeOutput(*dotPath, diagram, writeDOT)
+	writeOutput(*svgPath, diagram, writeSVG)
+}
+
+func writeOutput(path string, diagram *trace.Trace, write func(io.Writer, *trace.Trace) error) {
+	if path == "" {
+		return
+	}
+
+	if path == "-" {
+		writeErr := write(os.Stdout, diagram)
+		if writeErr != nil {
+			log.Fatalf("Could not write diagram | %v", writeErr)
+		}
+		return
+	}
+
+	file, createErr := os.Create(path)
+	if createErr != nil {
+		log.Fatalf("Could not create %s | %v", path, createErr)
+	}
+
+	writeErr := write(file, diagram)
+	closeErr := file.Close()
+	if writeErr != nil || closeErr != nil {
+		log.Fatalf("Could not write %s | %v", path, writeErr)
+	}
+}
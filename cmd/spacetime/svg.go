@@ -0,0 +1,94 @@
+package main
+
+import (
+	trace "Chitty-Chat/Trace"
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+)
+
+const (
+	laneHeight   = 90
+	columnWidth  = 48
+	leftMargin   = 120
+	topMargin    = 40
+	eventRadius  = 7
+	labelMaxSize = 18
+)
+
+// writeSVG renders the diagram without needing Graphviz: processes are
+// horizontal lanes and every distinct Lamport time gets its own column.
+func writeSVG(writer io.Writer, diagram *trace.Trace) error {
+	output := bufio.NewWriter(writer)
+
+	columns := make(map[int64]int)
+	for column, lamport := range diagram.LamportTimes() {
+		columns[lamport] = column
+	}
+	lanes := make(map[string]int)
+	for lane, process := range diagram.Processes {
+		lanes[process] = lane
+	}
+
+	position := func(event *trace.Event) (int, int) {
+		return leftMargin + columns[event.Lamport]*columnWidth, topMargin + lanes[event.Process]*laneHeight + laneHeight/2
+	}
+
+	width := leftMargin + len(columns)*columnWidth + columnWidth
+	height := topMargin + len(diagram.Processes)*laneHeight + topMargin
+
+	fmt.Fprintf(output, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" font-family=\"sans-serif\" font-size=\"10\">\n", width, height)
+	fmt.Fprintln(output, `  <defs><marker id="arrow" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="6" markerHeight="6" orient="auto-start-reverse"><path d="M 0 0 L 10 5 L 0 10 z"/></marker></defs>`)
+	fmt.Fprintf(output, "  <rect width=\"%d\" height=\"%d\" fill=\"white\"/>\n", width, height)
+
+	for lane, process := range diagram.Processes {
+		y := topMargin + lane*laneHeight + laneHeight/2
+		fmt.Fprintf(output, "  <text x=\"10\" y=\"%d\" font-size=\"13\" font-weight=\"bold\">%s</text>\n", y+4, html.EscapeString(process))
+		fmt.Fprintf(output, "  <line x1=\"%d\" y1=\"%d\" x2=\"%d\" y2=\"%d\" stroke=\"#999\"/>\n", leftMargin-20, y, width-20, y)
+	}
+
+	for _, message := range diagram.Messages {
+		x1, y1 := position(message.Send)
+		x2, y2 := position(message.Receive)
+		color := messageColor(message)
+		fmt.Fprintf(output, "  <line x1=\"%d\" y1=\"%d\" x2=\"%d\" y2=\"%d\" stroke=\"%s\" marker-end=\"url(#arrow)\"/>\n",
+			x1, y1, x2, y2+shorten(y1, y2), color)
+	}
+
+	for _, process := range diagram.Processes {
+		for _, event := range diagram.Events[process] {
+			x, y := position(event)
+			fmt.Fprintf(output, "  <g><title>%s</title>\n", html.EscapeString(event.String()))
+			fmt.Fprintf(output, "    <circle cx=\"%d\" cy=\"%d\" r=\"%d\" fill=%s stroke=\"black\"/>\n", x, y, eventRadius, kindColor(event.Kind))
+			fmt.Fprintf(output, "    <text x=\"%d\" y=\"%d\" text-anchor=\"middle\">%d</text>\n", x, y-eventRadius-4, event.Lamport)
+			fmt.Fprintf(output, "    <text x=\"%d\" y=\"%d\" text-anchor=\"middle\" fill=\"#555\" font-size=\"8\">%s</text>\n", x, y+eventRadius+10, html.EscapeString(truncate(event.Label)))
+			fmt.Fprintln(output, "  </g>")
+		}
+	}
+
+	fmt.Fprintln(output, "</svg>")
+
+	return output.Flush()
+}
+
+// shorten stops an arrow at the edge of the receiving event's circle.
+func shorten(fromY int, toY int) int {
+	switch {
+	case toY > fromY:
+		return -eventRadius
+	case toY < fromY:
+		return eventRadius
+	default:
+		return 0
+	}
+}
+
+func truncate(label string) string {
+	runes := []rune(label)
+	if len(runes) <= labelMaxSize {
+		return label
+	}
+
+	return string(runes[:labelMaxSize-1]) + "…"
+}